@@ -1,24 +1,424 @@
 package config
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds application configuration
 type Config struct {
-	Workers   int
-	QueueSize int
-	Port      string
+	Workers int
+	// HighPriorityWorkers is how many of the workers take high-priority jobs only
+	HighPriorityWorkers int
+	QueueSize           int
+	// RetryQueueSize is how many due retries can wait for a worker; more are dead-lettered
+	RetryQueueSize int
+	Port           string
+
+	// APIKeys are the keys accepted on every endpoint except health checks and
+	// metrics (no authentication when empty). A "tenant:key" entry ties the
+	// key to a tenant.
+	APIKeys []string
+	// DeadLetterLegacyAccess lets clients from before API keys keep reading
+	// GET /dead-letter without one, logged as deprecated
+	DeadLetterLegacyAccess bool
+	// CORSOrigins are the browser origins allowed to call the API; "*" allows any (none when empty)
+	CORSOrigins []string
+
+	// LogLevel is the minimum level logged: "debug", "info", "warn" or "error"
+	LogLevel string
+
+	// Providers lists the email providers to try in order, e.g. "sendgrid,smtp".
+	// When empty, the SMTP relay is used if SMTPHost is set and the simulated sender otherwise.
+	Providers string
+
+	// SMTP relay settings
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPVerifyAttempts is how many times the relay is tried at startup before
+	// the service reports ready (lazy connect on first send when 0)
+	SMTPVerifyAttempts int
+	// SMTPVerifyTimeout bounds each startup connection attempt
+	SMTPVerifyTimeout time.Duration
+	// SMTPGreylistDelay is the least wait before retrying an email the relay greylisted
+	SMTPGreylistDelay time.Duration
+	// SMTPPartialDelivery sends an email to the recipients the relay accepts
+	// when it rejects others, retrying only those; otherwise any rejection
+	// fails the whole email
+	SMTPPartialDelivery bool
+	// SMTPPoolSize is how many relay connections are kept open between sends
+	// (a connection per send when 0)
+	SMTPPoolSize int
+	// SMTPReservedPoolSize is how many connections are set aside for the jobs
+	// matching SMTPReservedPriorities or SMTPReservedTenants (none when 0)
+	SMTPReservedPoolSize int
+	// SMTPReservedPriorities are the job priorities sent through the reserved pool
+	SMTPReservedPriorities []string
+	// SMTPReservedTenants are the tenants whose jobs are sent through the reserved pool
+	SMTPReservedTenants []string
+	// SMTPMinSendInterval is the least time between the starts of two sends on
+	// the same pooled connection (no pacing when zero)
+	SMTPMinSendInterval time.Duration
+
+	// DefaultFrom is the sender address for every provider, used for emails
+	// that don't set their own From
+	DefaultFrom string
+
+	// Hosted provider credentials
+	SendGridAPIKey string
+	MailgunDomain  string
+	MailgunAPIKey  string
+	MailgunBaseURL string
+	SESRegion      string
+	SESAccessKey   string
+	SESSecretKey   string
+
+	// EnqueueTimeout is how long /send-email waits for room in a full queue before giving up (fails fast when zero)
+	EnqueueTimeout time.Duration
+	// SendTimeout bounds a single send attempt (no limit when zero)
+	SendTimeout time.Duration
+	// MaxInFlight caps how many sends run at once across workers, retries and
+	// sync sends (no limit when zero)
+	MaxInFlight int
+	// CircuitBreakerThreshold is how many consecutive failed sends stop sending for a cooldown (disabled when zero)
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown is how long sending stops before a trial send is let through
+	CircuitBreakerCooldown time.Duration
+
+	// ProcessingOrder is "fifo" (default) or "lifo" to process the newest job first
+	ProcessingOrder string
+
+	// QueueBackend is "memory" (default) or "redis" to keep queued jobs in Redis
+	QueueBackend string
+	// RedisURL is the Redis server used by the redis queue backend
+	RedisURL string
+	// RedisQueuePrefix is prepended to the queue's Redis keys; replicas sharing it share jobs
+	RedisQueuePrefix string
+	// RedisFallback is "fail" (default) to refuse jobs while Redis is
+	// unreachable, or "memory" to queue them in memory until it recovers
+	RedisFallback string
+	// QueueVisibilityTimeout is how long a job taken by a replica that stopped
+	// responding stays hidden before it is requeued
+	QueueVisibilityTimeout time.Duration
+
+	// ClockSkewTolerance is how far past its expires_at an email is still
+	// accepted and sent, to allow for clients whose clocks run behind
+	ClockSkewTolerance time.Duration
+
+	// MaxRetries is how many times a failed job is retried before it is dead-lettered
+	MaxRetries int
+	// ShutdownRetryGrace is how long shutdown waits for pending retries before dead-lettering them
+	ShutdownRetryGrace time.Duration
+	// ShutdownTimeout bounds how long the HTTP server and the email service
+	// each take to shut down
+	ShutdownTimeout time.Duration
+	// FirstRetryDelay is the minimum wait before a failed job's first retry
+	FirstRetryDelay time.Duration
+	// RetryBackoff selects the retry delay strategy ("linear", "fixed" or "exponential")
+	RetryBackoff string
+	// RetryFixedDelay is the delay between retries for the fixed strategy
+	RetryFixedDelay time.Duration
+	// RetryBaseDelay is the first delay of the exponential strategy
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps exponential delays (no cap when zero)
+	RetryMaxDelay time.Duration
+	// RetryJitter randomizes exponential delays between zero and the computed delay
+	RetryJitter bool
+
+	// ReadyHighWater is the fraction of queue capacity at which /ready starts failing
+	ReadyHighWater float64
+
+	// CompressMinBytes is the smallest response gzipped for large GET endpoints (disabled when zero)
+	CompressMinBytes int
+
+	// MetricsInterval is how often periodically computed gauges are refreshed
+	MetricsInterval time.Duration
+	// StatusTTL is how long a sent or dead-lettered job's status stays available
+	StatusTTL time.Duration
+	// IdempotencyTTL is how long an Idempotency-Key is remembered
+	IdempotencyTTL time.Duration
+	// IdempotencyMaxKeys caps how many Idempotency-Keys are remembered at once
+	IdempotencyMaxKeys int
+	// IdempotentReplay is what a repeated Idempotency-Key gets: "response", the
+	// original response (default), or "status", the job's current status
+	IdempotentReplay string
+	// MetricsSnapshotFile is where final metric values are written on shutdown (disabled when empty)
+	MetricsSnapshotFile string
+	// MetricsMaxLabelValues caps the distinct tenant and domain label values
+	// per metric; the rest are recorded as "other" (unlimited when zero)
+	MetricsMaxLabelValues int
+
+	// MaxMergeRecipients caps the number of recipients in a single /send-merge request
+	MaxMergeRecipients int
+	// MaxBulkEmails caps the number of emails in a single /send-email/bulk request
+	MaxBulkEmails int
+	// MaxConcurrentBulk caps how many bulk and merge requests run at once (unlimited when 0)
+	MaxConcurrentBulk int
+	// MaxScheduled caps how many emails can wait for their send_at at once
+	// (QueueSize when 0)
+	MaxScheduled int
+	// MaxBodyBytes caps the size of a JSON request body
+	MaxBodyBytes int
+	// MaxAttachmentBytes caps the decoded size of an email's attachments combined
+	MaxAttachmentBytes int
+	// MaxMessageBytes caps the size of the assembled message, headers, body and
+	// encoded attachments included (unlimited when 0)
+	MaxMessageBytes int
+
+	// AllowDomains, when set, are the only recipient domains that may be
+	// emailed; "*.example.com" matches subdomains
+	AllowDomains []string
+	// BlockDomains are recipient domains that are never emailed, even when allowed
+	BlockDomains []string
+
+	// ValidateMX rejects addresses whose domain has no MX record
+	ValidateMX bool
+	// MXLookupTimeout bounds a single MX lookup
+	MXLookupTimeout time.Duration
+	// MXCacheTTL is how long an MX lookup result is remembered
+	MXCacheTTL time.Duration
+	// MaxConcurrentMXLookups caps how many MX lookups run at once (unlimited when 0)
+	MaxConcurrentMXLookups int
+
+	// RejectTrackingPixels rejects bodies containing tracking-pixel-like images
+	RejectTrackingPixels bool
+	// IdentifyingHeaders is what happens to custom headers that fingerprint
+	// the sender, such as X-Mailer: "allow" (default), "strip" or "reject"
+	IdentifyingHeaders string
+	// RedactContent hides subjects and bodies in API responses
+	RedactContent bool
+
+	// SubjectThrottleLimit caps same-subject emails per recipient within the window (disabled when zero)
+	SubjectThrottleLimit int
+	// SubjectThrottleWindow is the sliding window for subject throttling
+	SubjectThrottleWindow time.Duration
+	// SubjectThrottleAction is "drop" or "delay" for over-limit sends
+	SubjectThrottleAction string
+
+	// DedupeWindow is how long an identical email (same recipient, subject and
+	// body) is suppressed after one is queued (disabled when zero)
+	DedupeWindow time.Duration
+	// DedupeMaxKeys caps how many queued emails are remembered for deduplication
+	DedupeMaxKeys int
+
+	// RatePerDomain caps sends per second to each recipient domain (disabled when zero)
+	RatePerDomain float64
+	// GlobalRate caps sends per second across all workers (disabled when zero)
+	GlobalRate float64
+	// TenantRateLimit caps emails submitted per second by each tenant without
+	// an entry in TenantLimitsFile (unlimited when zero)
+	TenantRateLimit float64
+	// TenantLimitsFile is a JSON object of per-tenant rate limits, reread on SIGHUP
+	TenantLimitsFile string
+
+	// VIPRecipients are addresses whose emails always go to the high-priority queue
+	VIPRecipients []string
+	// VIPRecipientsFile lists more VIP addresses, one per line, reread on SIGHUP
+	VIPRecipientsFile string
+
+	// DeadLetterFile is where dead letter jobs are persisted across restarts (disabled when empty)
+	DeadLetterFile string
+	// DeadLetterSweepInterval is how often dead letter jobs are retried automatically (disabled when zero)
+	DeadLetterSweepInterval time.Duration
+	// DeadLetterSweepMaxAttempts caps how many times a single job is swept back into the queue
+	DeadLetterSweepMaxAttempts int
+
+	// DeadLetterExportInterval is how often dead letter jobs are exported to object storage (disabled when zero)
+	DeadLetterExportInterval time.Duration
+	// DeadLetterExportEndpoint is the S3-compatible endpoint URL
+	DeadLetterExportEndpoint string
+	// DeadLetterExportBucket is the bucket exports are written to
+	DeadLetterExportBucket string
+	// DeadLetterExportPrefix is prepended to every exported object key
+	DeadLetterExportPrefix string
+	// DeadLetterExportRegion is the signing region for the object store
+	DeadLetterExportRegion string
+	// DeadLetterExportAccessKey and DeadLetterExportSecretKey authenticate uploads
+	DeadLetterExportAccessKey string
+	DeadLetterExportSecretKey string
+
+	// CallbackTimeout bounds a single job callback request
+	CallbackTimeout time.Duration
+	// CallbackMaxAttempts is how many times a job callback is tried before giving up
+	CallbackMaxAttempts int
+	// CallbackSecret signs job callbacks with HMAC-SHA256 (unsigned when empty)
+	CallbackSecret string
+
+	// HeartbeatInterval is how often a heartbeat email is sent (disabled when zero)
+	HeartbeatInterval time.Duration
+	// HeartbeatRecipient is the monitoring address that receives heartbeat emails
+	HeartbeatRecipient string
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
 	return &Config{
-		Workers:   getEnvInt("WORKERS", 3),
-		QueueSize: getEnvInt("QUEUE_SIZE", 100),
-		Port:      getEnvString("PORT", "8080"),
+		Workers:             getEnvInt("WORKERS", 3),
+		HighPriorityWorkers: getEnvNonNegativeInt("HIGH_PRIORITY_WORKERS", 0),
+		QueueSize:           getEnvInt("QUEUE_SIZE", 100),
+		RetryQueueSize:      getEnvInt("RETRY_QUEUE_SIZE", 50),
+		Port:                getEnvString("PORT", "8080"),
+
+		APIKeys:                getEnvList("API_KEYS"),
+		DeadLetterLegacyAccess: getEnvBool("DEAD_LETTER_LEGACY_ACCESS", false),
+		CORSOrigins:            getEnvList("CORS_ORIGINS"),
+
+		LogLevel: getEnvString("LOG_LEVEL", "info"),
+
+		SMTPHost:     getEnvString("SMTP_HOST", ""),
+		SMTPPort:     getEnvInt("SMTP_PORT", 587),
+		SMTPUsername: getEnvString("SMTP_USERNAME", ""),
+		SMTPPassword: getEnvString("SMTP_PASSWORD", ""),
+
+		SMTPVerifyAttempts: getEnvNonNegativeInt("SMTP_VERIFY_ATTEMPTS", 0),
+		SMTPVerifyTimeout:  getEnvDuration("SMTP_VERIFY_TIMEOUT", 5*time.Second),
+
+		SMTPGreylistDelay:   getEnvDuration("SMTP_GREYLIST_DELAY", 5*time.Minute),
+		SMTPPartialDelivery: getEnvBool("SMTP_PARTIAL_DELIVERY", true),
+
+		SMTPPoolSize:           getEnvNonNegativeInt("SMTP_POOL_SIZE", 0),
+		SMTPReservedPoolSize:   getEnvNonNegativeInt("SMTP_RESERVED_POOL_SIZE", 0),
+		SMTPReservedPriorities: getEnvListDefault("SMTP_RESERVED_PRIORITIES", []string{"high"}),
+		SMTPReservedTenants:    getEnvList("SMTP_RESERVED_TENANTS"),
+		SMTPMinSendInterval:    getEnvDuration("SMTP_MIN_SEND_INTERVAL", 0),
+
+		// SMTP_FROM is the older name for DEFAULT_FROM
+		DefaultFrom: getEnvString("DEFAULT_FROM", getEnvString("SMTP_FROM", "noreply@localhost")),
+
+		Providers:      getEnvString("PROVIDERS", ""),
+		SendGridAPIKey: getEnvString("SENDGRID_API_KEY", ""),
+		MailgunDomain:  getEnvString("MAILGUN_DOMAIN", ""),
+		MailgunAPIKey:  getEnvString("MAILGUN_API_KEY", ""),
+		MailgunBaseURL: getEnvString("MAILGUN_BASE_URL", ""),
+		SESRegion:      getEnvString("SES_REGION", ""),
+		SESAccessKey:   getEnvString("SES_ACCESS_KEY", ""),
+		SESSecretKey:   getEnvString("SES_SECRET_KEY", ""),
+
+		SendTimeout:             getEnvDuration("SEND_TIMEOUT", 30*time.Second),
+		MaxInFlight:             getEnvNonNegativeInt("MAX_INFLIGHT", 0),
+		EnqueueTimeout:          getEnvDuration("ENQUEUE_TIMEOUT", 0),
+		CircuitBreakerThreshold: getEnvNonNegativeInt("CIRCUIT_BREAKER_THRESHOLD", 0),
+		CircuitBreakerCooldown:  getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
+
+		ProcessingOrder: getEnvString("PROCESSING_ORDER", "fifo"),
+
+		QueueBackend:           getEnvString("QUEUE_BACKEND", "memory"),
+		RedisURL:               getEnvString("REDIS_URL", "redis://localhost:6379/0"),
+		RedisQueuePrefix:       getEnvString("REDIS_QUEUE_PREFIX", "email-queue"),
+		RedisFallback:          getEnvString("REDIS_FALLBACK", "fail"),
+		QueueVisibilityTimeout: getEnvDuration("QUEUE_VISIBILITY_TIMEOUT", time.Minute),
+
+		ClockSkewTolerance: getEnvDuration("CLOCK_SKEW_TOLERANCE", 2*time.Second),
+
+		MaxRetries:            getEnvNonNegativeInt("MAX_RETRIES", 3),
+		ShutdownRetryGrace:    getEnvDuration("SHUTDOWN_RETRY_GRACE", 0),
+		ShutdownTimeout:       getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		FirstRetryDelay:       getEnvDuration("FIRST_RETRY_DELAY", 0),
+		RetryBackoff:          getEnvString("RETRY_BACKOFF", "linear"),
+		RetryFixedDelay:       getEnvDuration("RETRY_FIXED_DELAY", 5*time.Second),
+		RetryBaseDelay:        getEnvDuration("RETRY_BASE_DELAY", time.Second),
+		RetryMaxDelay:         getEnvDuration("RETRY_MAX_DELAY", time.Minute),
+		RetryJitter:           getEnvBool("RETRY_JITTER", false),
+		ReadyHighWater:        getEnvNonNegativeFloat("READY_HIGH_WATER", 0.9),
+		CompressMinBytes:      getEnvInt("COMPRESS_MIN_BYTES", 1024),
+		MetricsInterval:       getEnvDuration("METRICS_INTERVAL", time.Second),
+		StatusTTL:             getEnvDuration("STATUS_TTL", time.Hour),
+		IdempotencyTTL:        getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		IdempotencyMaxKeys:    getEnvPositiveInt("IDEMPOTENCY_MAX_KEYS", 10000),
+		IdempotentReplay:      strings.ToLower(getEnvString("IDEMPOTENT_REPLAY", "response")),
+		MetricsSnapshotFile:   getEnvString("METRICS_SNAPSHOT_FILE", ""),
+		MetricsMaxLabelValues: getEnvNonNegativeInt("METRICS_MAX_LABEL_VALUES", 100),
+		MaxMergeRecipients:    getEnvInt("MAX_MERGE_RECIPIENTS", 100),
+		MaxBulkEmails:         getEnvPositiveInt("MAX_BULK_EMAILS", 100),
+		MaxConcurrentBulk:     getEnvNonNegativeInt("MAX_CONCURRENT_BULK", 0),
+		MaxScheduled:          getEnvNonNegativeInt("MAX_SCHEDULED", 0),
+		MaxBodyBytes:          getEnvPositiveInt("MAX_BODY_BYTES", 1<<20),
+		MaxAttachmentBytes:    getEnvNonNegativeInt("MAX_ATTACHMENT_BYTES", 512<<10),
+		MaxMessageBytes:       getEnvNonNegativeInt("MAX_MESSAGE_BYTES", 0),
+
+		AllowDomains: getEnvList("ALLOW_DOMAINS"),
+		BlockDomains: getEnvList("BLOCK_DOMAINS"),
+
+		ValidateMX:             getEnvBool("VALIDATE_MX", false),
+		MXLookupTimeout:        getEnvDuration("MX_LOOKUP_TIMEOUT", 2*time.Second),
+		MXCacheTTL:             getEnvDuration("MX_CACHE_TTL", time.Hour),
+		MaxConcurrentMXLookups: getEnvNonNegativeInt("MAX_CONCURRENT_MX_LOOKUPS", 0),
+
+		RejectTrackingPixels: getEnvBool("REJECT_TRACKING_PIXELS", false),
+		IdentifyingHeaders:   strings.ToLower(getEnvString("IDENTIFYING_HEADERS", "allow")),
+		RedactContent:        getEnvBool("REDACT_CONTENT", false),
+
+		SubjectThrottleLimit:  getEnvInt("SUBJECT_THROTTLE_LIMIT", 0),
+		SubjectThrottleWindow: getEnvDuration("SUBJECT_THROTTLE_WINDOW", time.Hour),
+		SubjectThrottleAction: getEnvString("SUBJECT_THROTTLE_ACTION", "drop"),
+
+		DedupeWindow:  getEnvDuration("DEDUPE_WINDOW", 0),
+		DedupeMaxKeys: getEnvPositiveInt("DEDUPE_MAX_KEYS", 10000),
+
+		RatePerDomain: getEnvNonNegativeFloat("RATE_PER_DOMAIN", 0),
+		GlobalRate:    getEnvNonNegativeFloat("GLOBAL_RATE", 0),
+
+		VIPRecipients:     getEnvList("VIP_RECIPIENTS"),
+		VIPRecipientsFile: getEnvString("VIP_RECIPIENTS_FILE", ""),
+
+		TenantRateLimit:  getEnvNonNegativeFloat("TENANT_RATE_LIMIT", 0),
+		TenantLimitsFile: getEnvString("TENANT_LIMITS_FILE", ""),
+
+		DeadLetterFile:             getEnvString("DLQ_FILE", ""),
+		DeadLetterSweepInterval:    getEnvDuration("DLQ_SWEEP_INTERVAL", 0),
+		DeadLetterSweepMaxAttempts: getEnvInt("DLQ_SWEEP_MAX_ATTEMPTS", 3),
+
+		DeadLetterExportInterval:  getEnvDuration("DLQ_EXPORT_INTERVAL", 0),
+		DeadLetterExportEndpoint:  getEnvString("DLQ_EXPORT_ENDPOINT", "https://s3.amazonaws.com"),
+		DeadLetterExportBucket:    getEnvString("DLQ_EXPORT_BUCKET", ""),
+		DeadLetterExportPrefix:    getEnvString("DLQ_EXPORT_PREFIX", "dead-letter/"),
+		DeadLetterExportRegion:    getEnvString("DLQ_EXPORT_REGION", "us-east-1"),
+		DeadLetterExportAccessKey: getEnvString("DLQ_EXPORT_ACCESS_KEY", ""),
+		DeadLetterExportSecretKey: getEnvString("DLQ_EXPORT_SECRET_KEY", ""),
+
+		HeartbeatInterval:  getEnvDuration("HEARTBEAT_INTERVAL", 0),
+		HeartbeatRecipient: getEnvString("HEARTBEAT_RECIPIENT", ""),
+
+		CallbackTimeout:     getEnvDuration("CALLBACK_TIMEOUT", 10*time.Second),
+		CallbackMaxAttempts: getEnvPositiveInt("CALLBACK_MAX_ATTEMPTS", 3),
+		CallbackSecret:      getEnvString("CALLBACK_SECRET", ""),
+	}
+}
+
+// Validate reports settings the service can't run with
+func (c *Config) Validate() error {
+	if c.Workers < 1 {
+		return fmt.Errorf("WORKERS must be at least 1, got %d", c.Workers)
+	}
+	if c.HighPriorityWorkers >= c.Workers {
+		return fmt.Errorf("HIGH_PRIORITY_WORKERS must be less than WORKERS (%d), got %d", c.Workers, c.HighPriorityWorkers)
+	}
+	if c.QueueSize < 1 {
+		return fmt.Errorf("QUEUE_SIZE must be at least 1, got %d", c.QueueSize)
+	}
+	if c.RetryQueueSize < 1 {
+		return fmt.Errorf("RETRY_QUEUE_SIZE must be at least 1, got %d", c.RetryQueueSize)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("SHUTDOWN_TIMEOUT must be positive, got %s", c.ShutdownTimeout)
+	}
+	switch c.IdentifyingHeaders {
+	case "allow", "strip", "reject":
+	default:
+		return fmt.Errorf("IDENTIFYING_HEADERS must be allow, strip or reject, got %q", c.IdentifyingHeaders)
+	}
+	switch c.IdempotentReplay {
+	case "response", "status":
+	default:
+		return fmt.Errorf("IDEMPOTENT_REPLAY must be response or status, got %q", c.IdempotentReplay)
 	}
+	return nil
 }
 
 // getEnvInt gets an environment variable as an integer with a default value
@@ -31,6 +431,32 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvNonNegativeInt gets an environment variable as an integer, using the default for negative values
+func getEnvNonNegativeInt(key string, defaultValue int) int {
+	if value := getEnvInt(key, defaultValue); value >= 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvPositiveInt gets an environment variable as an integer, using the default for zero or negative values
+func getEnvPositiveInt(key string, defaultValue int) int {
+	if value := getEnvInt(key, defaultValue); value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvNonNegativeFloat gets an environment variable as a float, using the default for invalid or negative values
+func getEnvNonNegativeFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil && floatValue >= 0 && !math.IsInf(floatValue, 0) {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 // getEnvString gets an environment variable as a string with a default value
 func getEnvString(key string, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -38,3 +464,42 @@ func getEnvString(key string, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList gets a comma-separated environment variable as a list, skipping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvListDefault is getEnvList with a default for when the variable lists nothing
+func getEnvListDefault(key string, defaultValue []string) []string {
+	if values := getEnvList(key); len(values) > 0 {
+		return values
+	}
+	return defaultValue
+}
+
+// getEnvBool gets an environment variable as a boolean with a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration gets an environment variable as a duration with a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+			return duration
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,108 @@
+package config
+
+import "testing"
+
+func TestValidateIdentifyingHeaders(t *testing.T) {
+	for _, value := range []string{"", "allow", "Strip", "REJECT"} {
+		t.Setenv("IDENTIFYING_HEADERS", value)
+		if err := LoadConfig().Validate(); err != nil {
+			t.Errorf("IDENTIFYING_HEADERS=%q: %v", value, err)
+		}
+	}
+
+	t.Setenv("IDENTIFYING_HEADERS", "remove")
+	if err := LoadConfig().Validate(); err == nil {
+		t.Error("IDENTIFYING_HEADERS=remove accepted")
+	}
+}
+
+func TestValidateIdempotentReplay(t *testing.T) {
+	for _, value := range []string{"", "response", "STATUS"} {
+		t.Setenv("IDEMPOTENT_REPLAY", value)
+		if err := LoadConfig().Validate(); err != nil {
+			t.Errorf("IDEMPOTENT_REPLAY=%q: %v", value, err)
+		}
+	}
+
+	t.Setenv("IDEMPOTENT_REPLAY", "live")
+	if err := LoadConfig().Validate(); err == nil {
+		t.Error("IDEMPOTENT_REPLAY=live accepted")
+	}
+}
+
+func TestValidateHighPriorityWorkers(t *testing.T) {
+	t.Setenv("WORKERS", "3")
+	t.Setenv("HIGH_PRIORITY_WORKERS", "2")
+	if err := LoadConfig().Validate(); err != nil {
+		t.Errorf("HIGH_PRIORITY_WORKERS=2 with WORKERS=3: %v", err)
+	}
+
+	t.Setenv("HIGH_PRIORITY_WORKERS", "3")
+	if err := LoadConfig().Validate(); err == nil {
+		t.Error("HIGH_PRIORITY_WORKERS=3 with WORKERS=3 accepted; nothing would take other jobs")
+	}
+}
+
+func TestNegativeMaxRetriesFallsBackToDefault(t *testing.T) {
+	t.Setenv("MAX_RETRIES", "-1")
+	if got := LoadConfig().MaxRetries; got != 3 {
+		t.Errorf("MaxRetries with MAX_RETRIES=-1 = %d, want the default 3", got)
+	}
+
+	t.Setenv("MAX_RETRIES", "0")
+	if got := LoadConfig().MaxRetries; got != 0 {
+		t.Errorf("MaxRetries with MAX_RETRIES=0 = %d, want 0", got)
+	}
+}
+
+func TestCircuitBreakerOffByDefault(t *testing.T) {
+	if got := LoadConfig().CircuitBreakerThreshold; got != 0 {
+		t.Errorf("CircuitBreakerThreshold = %d, want 0 so the breaker is off unless configured", got)
+	}
+}
+
+func TestValidateQueueAndWorkerMinimums(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"defaults", nil, false},
+		{"minimums", map[string]string{"WORKERS": "1", "QUEUE_SIZE": "1", "RETRY_QUEUE_SIZE": "1"}, false},
+		{"no workers", map[string]string{"WORKERS": "0"}, true},
+		{"negative workers", map[string]string{"WORKERS": "-2"}, true},
+		{"no queue", map[string]string{"QUEUE_SIZE": "0"}, true},
+		{"negative queue", map[string]string{"QUEUE_SIZE": "-5"}, true},
+		{"no retry queue", map[string]string{"RETRY_QUEUE_SIZE": "0"}, true},
+		// A queue of 1 no longer means a retry queue of 1/2 = 0
+		{"tiny queue keeps retry queue", map[string]string{"QUEUE_SIZE": "1"}, false},
+		{"no shutdown timeout", map[string]string{"SHUTDOWN_TIMEOUT": "0s"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			err := LoadConfig().Validate()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("Validate() = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultFrom(t *testing.T) {
+	if got := LoadConfig().DefaultFrom; got != "noreply@localhost" {
+		t.Errorf("DefaultFrom = %q, want noreply@localhost", got)
+	}
+
+	t.Setenv("SMTP_FROM", "legacy@example.com")
+	if got := LoadConfig().DefaultFrom; got != "legacy@example.com" {
+		t.Errorf("DefaultFrom with SMTP_FROM = %q, want legacy@example.com", got)
+	}
+
+	t.Setenv("DEFAULT_FROM", "noreply@example.com")
+	if got := LoadConfig().DefaultFrom; got != "noreply@example.com" {
+		t.Errorf("DefaultFrom with both set = %q, want DEFAULT_FROM's noreply@example.com", got)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"email-queue-service/models"
+)
+
+// maxAttachmentFilenameLength is the longest attachment filename accepted
+const maxAttachmentFilenameLength = 255
+
+// attachmentsTooLarge is the 413 body for attachments over
+// MAX_ATTACHMENT_BYTES, so clients can see how far over they are
+type attachmentsTooLarge struct {
+	Error      string `json:"error"`
+	LimitBytes int    `json:"limit_bytes"`
+	SizeBytes  int    `json:"size_bytes"`
+}
+
+// decodeAttachments decodes and checks a request's attachments
+func (h *EmailHandler) decodeAttachments(reqs []models.AttachmentRequest) ([]models.Attachment, *requestError) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	if h.maxAttachmentBytes == 0 {
+		return nil, invalidRequest("Attachments are not accepted")
+	}
+
+	attachments := make([]models.Attachment, len(reqs))
+	total := 0
+	for i, req := range reqs {
+		if !validAttachmentFilename(req.Filename) {
+			return nil, invalidRequest(fmt.Sprintf("Attachment %d: invalid filename", i))
+		}
+
+		contentType := "application/octet-stream"
+		if req.ContentType != "" {
+			mediaType, _, err := mime.ParseMediaType(req.ContentType)
+			if err != nil || strings.HasPrefix(mediaType, "multipart/") {
+				return nil, invalidRequest(fmt.Sprintf("Attachment %d: invalid content_type", i))
+			}
+			contentType = mediaType
+		}
+
+		content, err := base64.StdEncoding.DecodeString(req.Content)
+		if err != nil {
+			return nil, invalidRequest(fmt.Sprintf("Attachment %d: content is not valid base64", i))
+		}
+
+		// The decoded size counts, whatever the request's Content-Length said;
+		// decoding is bounded by MAX_BODY_BYTES, so every attachment is measured
+		total += len(content)
+		attachments[i] = models.Attachment{Filename: req.Filename, ContentType: contentType, Content: content}
+	}
+
+	if total > h.maxAttachmentBytes {
+		message := fmt.Sprintf("Attachments too large: %d bytes (max %d bytes in total)", total, h.maxAttachmentBytes)
+		return nil, &requestError{
+			status:  http.StatusRequestEntityTooLarge,
+			message: message,
+			body:    attachmentsTooLarge{Error: message, LimitBytes: h.maxAttachmentBytes, SizeBytes: total},
+		}
+	}
+	return attachments, nil
+}
+
+// validAttachmentFilename reports whether name is a plain file name, without
+// a path or control characters that could break the MIME headers
+func validAttachmentFilename(name string) bool {
+	if name == "" || len(name) > maxAttachmentFilenameLength || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsFunc(name, func(r rune) bool {
+		return r == '/' || r == '\\' || unicode.IsControl(r)
+	})
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// attachmentRequest is a /send-email body with one attachment of size bytes
+func attachmentRequest(size int) string {
+	content := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", size)))
+	return fmt.Sprintf(`{"to": "user@example.com", "subject": "Hi", "body": "Hi", "attachments": [{"filename": "a.txt", "content": %q}]}`, content)
+}
+
+func TestAttachmentSizeLimit(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"MAX_ATTACHMENT_BYTES": "1000"})
+
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", attachmentRequest(1000)); rec.Code != http.StatusAccepted {
+		t.Errorf("attachment at the limit: status %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", attachmentRequest(1001))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("attachment over the limit: status %d, want 413", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body attachmentsTooLarge
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.LimitBytes != 1000 || body.SizeBytes != 1001 || body.Error == "" {
+		t.Errorf("body = %+v, want limit 1000 and size 1001", body)
+	}
+}
+
+func TestAttachmentSizeLimitCountsEveryAttachment(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"MAX_ATTACHMENT_BYTES": "1000"})
+
+	content := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 600)))
+	req := fmt.Sprintf(`{"to": "user@example.com", "subject": "Hi", "body": "Hi", "attachments": [
+		{"filename": "a.txt", "content": %q},
+		{"filename": "b.txt", "content": %q},
+		{"filename": "c.txt", "content": %q}
+	]}`, content, content, content)
+
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", rec.Code)
+	}
+	var body attachmentsTooLarge
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.SizeBytes != 1800 {
+		t.Errorf("size_bytes = %d, want the decoded total 1800", body.SizeBytes)
+	}
+}
+
+func TestAttachmentsRejectedWhenDisabled(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"MAX_ATTACHMENT_BYTES": "0"})
+
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", attachmentRequest(1)); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422", rec.Code)
+	}
+}
+
+func TestSmallAttachmentReachesSender(t *testing.T) {
+	sent := make(chan models.EmailJob, 1)
+	h, _ := newTestHandler(t, senderFunc(func(ctx context.Context, job models.EmailJob) error {
+		sent <- job
+		return nil
+	}), map[string]string{"MAX_ATTACHMENT_BYTES": "1000"})
+
+	content := base64.StdEncoding.EncodeToString([]byte("%PDF-1.4"))
+	req := fmt.Sprintf(`{"to": "user@example.com", "subject": "Receipt", "body": "Attached", "attachments": [
+		{"filename": "receipt.pdf", "content_type": "application/pdf", "content": %q}
+	]}`, content)
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", req); rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	select {
+	case job := <-sent:
+		if len(job.Attachments) != 1 {
+			t.Fatalf("sent %d attachments, want 1", len(job.Attachments))
+		}
+		got := job.Attachments[0]
+		if got.Filename != "receipt.pdf" || got.ContentType != "application/pdf" || string(got.Content) != "%PDF-1.4" {
+			t.Errorf("attachment = %s %s %q, want receipt.pdf application/pdf %q", got.Filename, got.ContentType, got.Content, "%PDF-1.4")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("email never sent")
+	}
+}
+
+func TestMalformedAttachmentRejected(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"MAX_ATTACHMENT_BYTES": "1000"})
+
+	tests := []struct {
+		name       string
+		attachment string
+	}{
+		{"not base64", `{"filename": "a.txt", "content": "not base64!"}`},
+		{"bad padding", `{"filename": "a.txt", "content": "YWJj="}`},
+		{"no filename", `{"content": "YWJj"}`},
+		{"multipart type", `{"filename": "a.txt", "content_type": "multipart/mixed", "content": "YWJj"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := `{"to": "user@example.com", "subject": "Hi", "body": "Hi", "attachments": [` + tt.attachment + `]}`
+			if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", req); rec.Code != http.StatusUnprocessableEntity {
+				t.Errorf("status %d, want 422: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// adminPathPrefix is where the operator endpoints live
+const adminPathPrefix = "/admin/"
+
+// tenantContextKey is the request context key of the caller's tenant
+type tenantContextKey struct{}
+
+// apiKey is an accepted key and the tenant it belongs to, if any
+type apiKey struct {
+	key    string
+	tenant string
+}
+
+// parseAPIKeys splits "tenant:key" entries; a plain key has no tenant
+func parseAPIKeys(entries []string) []apiKey {
+	keys := make([]apiKey, len(entries))
+	for i, entry := range entries {
+		if tenant, key, ok := strings.Cut(entry, ":"); ok {
+			keys[i] = apiKey{key: key, tenant: tenant}
+		} else {
+			keys[i] = apiKey{key: entry}
+		}
+	}
+	return keys
+}
+
+// withTenant returns ctx carrying tenant
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenantFromContext returns the tenant of the API key the request was
+// authenticated with, or "" when it has none
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// requireAdmin responds 403 Forbidden to a request authenticated with a
+// tenant's key, since only operators' keys without a tenant may see across
+// tenants, and reports whether the request may go on
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if tenantFromContext(r.Context()) != "" {
+		http.Error(w, "Forbidden: this endpoint needs an API key without a tenant", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// RequireAPIKey rejects requests that don't carry one of keys, either as
+// "Authorization: Bearer <key>" or in the X-API-Key header, with 401. A
+// "tenant:key" entry ties its key to a tenant, which the request's context
+// then carries, and such a key gets 403 for anything under /admin/, which
+// only operators' keys without a tenant may use. Requests for the public
+// paths, such as health checks, are let through as-is. So are GETs without a
+// key for the legacy paths, which clients from before authentication read
+// anonymously; each is logged as deprecated, and writes to those paths still
+// need a key.
+func RequireAPIKey(entries []string, public []string, legacy []string, next http.Handler) http.Handler {
+	keys := parseAPIKeys(entries)
+	publicPaths := make(map[string]bool, len(public))
+	for _, path := range public {
+		publicPaths[path] = true
+	}
+	legacyPaths := make(map[string]bool, len(legacy))
+	for _, path := range legacy {
+		legacyPaths[path] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := requestAPIKey(r)
+		if key == "" && legacyPaths[r.URL.Path] && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			slog.Warn("Allowed legacy request without an API key; this access is deprecated and will be removed", "event", "legacy_unauthenticated", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tenant, ok := validAPIKey(keys, key)
+		if key == "" || !ok {
+			slog.Warn("Rejected request without a valid API key", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="email-queue-service"`)
+			http.Error(w, "Missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		if tenant != "" {
+			r = r.WithContext(withTenant(r.Context(), tenant))
+		}
+		// Each admin handler checks too; this covers any added without the check
+		if strings.HasPrefix(r.URL.Path, adminPathPrefix) && !requireAdmin(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAPIKey returns the API key a request carries, if any
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// validAPIKey reports whether key is one of keys and returns its tenant,
+// comparing in constant time so a key can't be guessed a byte at a time
+func validAPIKey(keys []apiKey, key string) (string, bool) {
+	tenant, valid := "", false
+	for _, candidate := range keys {
+		if subtle.ConstantTimeCompare([]byte(candidate.key), []byte(key)) == 1 {
+			tenant, valid = candidate.tenant, true
+		}
+	}
+	return tenant, valid
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// tenantOf serves a request through RequireAPIKey and returns the tenant the
+// wrapped handler saw, or the status if it wasn't reached
+func tenantOf(t *testing.T, keys []string, key string) (string, int) {
+	t.Helper()
+
+	var tenant string
+	reached := false
+	handler := RequireAPIKey(keys, []string{"/health"}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, reached = tenantFromContext(r.Context()), true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/email-status", nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !reached {
+		return "", rec.Code
+	}
+	return tenant, rec.Code
+}
+
+func TestRequireAPIKeyCarriesTenant(t *testing.T) {
+	keys := []string{"acme:acme-key", "globex:globex-key", "ops-key"}
+
+	tests := []struct {
+		key        string
+		wantTenant string
+		wantStatus int
+	}{
+		{"acme-key", "acme", http.StatusOK},
+		{"globex-key", "globex", http.StatusOK},
+		{"ops-key", "", http.StatusOK},
+		{"acme:acme-key", "", http.StatusUnauthorized},
+		{"wrong", "", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tenant, status := tenantOf(t, keys, tt.key)
+		if tenant != tt.wantTenant || status != tt.wantStatus {
+			t.Errorf("key %q: tenant %q, status %d; want %q, %d", tt.key, tenant, status, tt.wantTenant, tt.wantStatus)
+		}
+	}
+}
+
+func TestRequireAPIKeyProtectsAllButPublicPaths(t *testing.T) {
+	handler := RequireAPIKey([]string{"secret", "acme:acme-key"}, []string{"/health", "/metrics"}, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"bearer key", http.MethodPost, "/send-email", "Authorization", "Bearer secret", http.StatusOK},
+		{"X-API-Key", http.MethodPost, "/send-email", "X-API-Key", "secret", http.StatusOK},
+		{"invalid key", http.MethodPost, "/send-email", "X-API-Key", "guess", http.StatusUnauthorized},
+		{"wrong scheme", http.MethodPost, "/send-email", "Authorization", "Basic secret", http.StatusUnauthorized},
+		{"missing key", http.MethodPost, "/send-email", "", "", http.StatusUnauthorized},
+		{"admin without a key", http.MethodPut, "/admin/workers", "", "", http.StatusUnauthorized},
+		{"admin with a tenant's key", http.MethodPost, "/admin/pause", "X-API-Key", "acme-key", http.StatusForbidden},
+		{"admin with an operator's key", http.MethodPost, "/admin/pause", "X-API-Key", "secret", http.StatusOK},
+		{"tenant's key outside admin", http.MethodPost, "/send-email", "X-API-Key", "acme-key", http.StatusOK},
+		{"health is public", http.MethodGet, "/health", "", "", http.StatusOK},
+		{"metrics are public", http.MethodGet, "/metrics", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestJobsCarryTheCallersTenant(t *testing.T) {
+	tenants := make(chan string, 1)
+	h, _ := newTestHandler(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		tenants <- job.Tenant
+		return nil
+	}), nil)
+	handler := RequireAPIKey([]string{"acme:acme-key"}, nil, nil, http.HandlerFunc(h.SendEmailHandler))
+
+	req := httptest.NewRequest(http.MethodPost, "/send-email", strings.NewReader(`{"to": "user@example.com", "subject": "Hi", "body": "Hi"}`))
+	req.Header.Set("X-API-Key", "acme-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	select {
+	case tenant := <-tenants:
+		if tenant != "acme" {
+			t.Errorf("job tenant = %q, want acme", tenant)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was never sent")
+	}
+}
+
+func TestTenantOverItsLimitGets429(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, []byte(`{"acme": 1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"TENANT_LIMITS_FILE": path})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/send-email", strings.NewReader(`{"to": "user@example.com", "subject": "Hi", "body": "Hi"}`))
+		req = req.WithContext(withTenant(req.Context(), "acme"))
+		rec := httptest.NewRecorder()
+		h.SendEmailHandler(rec, req)
+		return rec
+	}
+
+	if rec := send(); rec.Code != http.StatusAccepted {
+		t.Fatalf("first status = %d, want 202", rec.Code)
+	}
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "1" {
+		t.Errorf("X-RateLimit-Limit = %q, want 1", got)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "acme") || !strings.Contains(body, "1 emails per second") {
+		t.Errorf("body = %q, want the tenant and its limit", body)
+	}
+}
+
+func TestLegacyDeadLetterAccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		legacy     []string
+		method     string
+		target     string
+		key        string
+		wantStatus int
+	}{
+		{"read without a key", []string{"/dead-letter"}, http.MethodGet, "/dead-letter?limit=10", "", http.StatusOK},
+		{"read with a wrong key", []string{"/dead-letter"}, http.MethodGet, "/dead-letter", "wrong", http.StatusUnauthorized},
+		{"purge without a key", []string{"/dead-letter"}, http.MethodDelete, "/dead-letter", "", http.StatusUnauthorized},
+		{"purge with a key", []string{"/dead-letter"}, http.MethodDelete, "/dead-letter", "ops-key", http.StatusOK},
+		{"retry without a key", []string{"/dead-letter"}, http.MethodPost, "/dead-letter/retry", "", http.StatusUnauthorized},
+		{"admin view without a key", []string{"/dead-letter"}, http.MethodGet, "/admin/dead-letter", "", http.StatusUnauthorized},
+		{"read without a key by default", nil, http.MethodGet, "/dead-letter", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireAPIKey([]string{"ops-key"}, nil, tt.legacy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.key != "" {
+				req.Header.Set(apiKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"email-queue-service/models"
+	"email-queue-service/service"
+)
+
+// bulkRetryAfter is the Retry-After hint, in seconds, sent when too many bulk
+// operations are already running
+const bulkRetryAfter = "1"
+
+// LimitBulk runs next only while fewer than MAX_CONCURRENT_BULK bulk
+// operations are in progress, so concurrent large requests can't exhaust
+// memory. Others get 429 Too Many Requests with a Retry-After hint. It goes
+// outside Idempotent, which reads the whole body before next runs.
+func (h *EmailHandler) LimitBulk(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		release, err := h.emailService.BeginBulk()
+		if errors.Is(err, service.ErrTooManyBulk) {
+			w.Header().Set("Retry-After", bulkRetryAfter)
+			http.Error(w, "Too many bulk requests in progress, try again later", http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+
+		next(w, r)
+	}
+}
+
+// SendBulkHandler handles POST /send-email/bulk requests: an array of
+// /send-email requests, each validated and queued on its own
+func (h *EmailHandler) SendBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	reqs, ok := h.decodeBulkRequests(w, r)
+	if !ok {
+		return
+	}
+
+	if len(reqs) == 0 {
+		http.Error(w, "At least one email is required", http.StatusUnprocessableEntity)
+		return
+	}
+
+	results := make([]models.RecipientResult, len(reqs))
+	accepted := 0
+	for i := range reqs {
+		results[i] = models.RecipientResult{Index: i}
+		if len(reqs[i].To) == 1 {
+			results[i].To = reqs[i].To[0]
+		}
+
+		id, err := h.enqueueBulkEmail(r.Context(), &reqs[i])
+		if err != nil {
+			results[i].Status = "rejected"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		results[i].ID = id
+		results[i].Status = "accepted"
+		accepted++
+	}
+
+	writeEnvelope(w, http.StatusAccepted, results, map[string]int{
+		"accepted": accepted,
+		"rejected": len(results) - accepted,
+	})
+}
+
+// decodeBulkRequests decodes the array of emails in a bulk request one at a
+// time, so an oversized batch is turned away with 413 as soon as it goes past
+// MAX_BULK_EMAILS rather than after all of it was decoded. Other errors are
+// reported like decodeJSON does.
+func (h *EmailHandler) decodeBulkRequests(w http.ResponseWriter, r *http.Request) ([]models.EmailRequest, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	token, err := decoder.Token()
+	if err != nil {
+		writeDecodeError(w, err)
+		return nil, false
+	}
+	if token == nil {
+		// null, treated like an empty array
+		if err := expectEOF(decoder); err != nil {
+			writeDecodeError(w, err)
+			return nil, false
+		}
+		return nil, true
+	}
+	if token != json.Delim('[') {
+		http.Error(w, "Invalid JSON: expected an array of emails", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var reqs []models.EmailRequest
+	for decoder.More() {
+		if len(reqs) == h.maxBulkEmails {
+			http.Error(w, fmt.Sprintf("Too many emails (max %d); stopped reading after %d", h.maxBulkEmails, len(reqs)), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		var req models.EmailRequest
+		if err := decoder.Decode(&req); err != nil {
+			writeDecodeError(w, err)
+			return nil, false
+		}
+		reqs = append(reqs, req)
+	}
+
+	// The closing bracket, then nothing more
+	if _, err := decoder.Token(); err != nil {
+		writeDecodeError(w, err)
+		return nil, false
+	}
+	if err := expectEOF(decoder); err != nil {
+		writeDecodeError(w, err)
+		return nil, false
+	}
+	return reqs, true
+}
+
+// enqueueBulkEmail validates and queues one email of a bulk request,
+// returning its ID
+func (h *EmailHandler) enqueueBulkEmail(ctx context.Context, req *models.EmailRequest) (string, error) {
+	// One result per item only works with one job per item
+	if len(req.To) > 1 {
+		return "", errors.New("bulk emails require a single to address")
+	}
+
+	jobs, mode, reqErr := h.buildJobs(ctx, req, "")
+	if reqErr != nil {
+		return "", errors.New(reqErr.message)
+	}
+	// A bulk request can't wait for every item to be sent. The parsed mode,
+	// since "SYNC" asks for a sync send as much as "sync" does.
+	if mode == models.ModeSync {
+		return "", errors.New("sync mode isn't supported in bulk requests")
+	}
+
+	if err := h.emailService.EnqueueJob(jobs[0]); err != nil {
+		return "", err
+	}
+	return jobs[0].ID, nil
+}
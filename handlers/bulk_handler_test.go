@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+func TestLimitBulkRejectsRequestsOverTheLimit(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"MAX_CONCURRENT_BULK": "1"})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	limited := h.LimitBulk(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- serve(limited, http.MethodPost, "/send-email/bulk", "[]").Code
+	}()
+	<-started
+
+	rec := serve(limited, http.MethodPost, "/send-email/bulk", "[]")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != bulkRetryAfter {
+		t.Errorf("Retry-After = %q, want %q", got, bulkRetryAfter)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusAccepted {
+		t.Errorf("first request status = %d, want 202", code)
+	}
+
+	// The slot is free again once the first request finished
+	go func() { <-started }()
+	if rec := serve(limited, http.MethodPost, "/send-email/bulk", "[]"); rec.Code != http.StatusAccepted {
+		t.Errorf("request after release status = %d, want 202", rec.Code)
+	}
+}
+
+func TestLimitBulkIsUnlimitedByDefault(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	limited := h.LimitBulk(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	done := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			done <- serve(limited, http.MethodPost, "/send-merge", "{}").Code
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		if code := <-done; code != http.StatusAccepted {
+			t.Errorf("status = %d, want 202", code)
+		}
+	}
+}
+
+func TestSendBulkStopsReadingOversizedBatch(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, map[string]string{"MAX_BULK_EMAILS": "2"})
+	// Anything queued stays visible in the queue depth
+	es.Pause()
+
+	item := `{"to":"user@example.com","subject":"Hi","body":"Hi"}`
+	// Whatever follows the item past the cap is never read, so its being
+	// malformed doesn't matter
+	body := "[" + strings.Repeat(item+",", 3) + "this is not JSON"
+
+	rec := serve(h.SendBulkHandler, http.MethodPost, "/send-email/bulk", body)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "stopped reading after 2") {
+		t.Errorf("body = %q, want the count read so far", rec.Body)
+	}
+	if got := es.Stats().QueueDepth; got != 0 {
+		t.Errorf("queue depth = %d, want nothing queued from a rejected batch", got)
+	}
+}
+
+func TestSendBulkDecodeErrors(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	item := `{"to":"user@example.com","subject":"Hi","body":"Hi"}`
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"empty body", "", http.StatusBadRequest},
+		{"not an array", item, http.StatusBadRequest},
+		{"unknown field", `[{"to":"user@example.com","subjct":"Hi","body":"Hi"}]`, http.StatusUnprocessableEntity},
+		{"unterminated array", "[" + item, http.StatusBadRequest},
+		{"trailing data", "[" + item + "] []", http.StatusBadRequest},
+		{"empty array", "[]", http.StatusUnprocessableEntity},
+		{"null", "null", http.StatusUnprocessableEntity},
+		{"valid", "[" + item + "," + item + "]", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(h.SendBulkHandler, http.MethodPost, "/send-email/bulk", tt.body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSendBulkReportsEachItem(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+	es.Pause()
+
+	valid := `{"to":"user@example.com","subject":"Hi","body":"Hi"}`
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus []string
+	}{
+		{"all valid", "[" + valid + "," + valid + "]", []string{"accepted", "accepted"}},
+		{"mixed", "[" + valid + `,{"to":"not-an-email","subject":"Hi","body":"Hi"},` + valid + `,{"to":"user@example.com","body":"Hi"}]`,
+			[]string{"accepted", "rejected", "accepted", "rejected"}},
+		{"sync mode in any case", `[{"to":"user@example.com","subject":"Hi","body":"Hi","mode":"sync"},{"to":"user@example.com","subject":"Hi","body":"Hi","mode":"SYNC"},{"to":"user@example.com","subject":"Hi","body":"Hi","mode":"Async"}]`,
+			[]string{"rejected", "rejected", "accepted"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h.SendBulkHandler, http.MethodPost, "/send-email/bulk", tt.body)
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+			}
+
+			var results []models.RecipientResult
+			decodeData(t, rec, &results)
+			if len(results) != len(tt.wantStatus) {
+				t.Fatalf("results = %+v, want %d", results, len(tt.wantStatus))
+			}
+			for i, result := range results {
+				if result.Index != i || result.Status != tt.wantStatus[i] {
+					t.Errorf("result %d = %+v, want index %d %s", i, result, i, tt.wantStatus[i])
+					continue
+				}
+				if result.Status == "accepted" && result.ID == "" {
+					t.Errorf("result %d accepted without an ID", i)
+				}
+				if result.Status == "rejected" && (result.ID != "" || result.Error == "") {
+					t.Errorf("result %d = %+v, want a reason and no ID", i, result)
+				}
+			}
+		})
+	}
+
+	if got := es.Stats().QueueDepth; got != 5 {
+		t.Errorf("queue depth = %d, want only the 5 valid emails queued", got)
+	}
+}
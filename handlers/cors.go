@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	corsAllowMethods = "GET, POST, DELETE"
+	corsAllowHeaders = "Authorization, Content-Type, X-API-Key, Idempotency-Key, Prefer"
+	// corsExposeHeaders are response headers browser code may read
+	corsExposeHeaders = "Idempotent-Replayed, Preference-Applied"
+	// corsMaxAge is how long, in seconds, browsers may cache a preflight response
+	corsMaxAge = 600
+)
+
+// CORS lets browsers on the allowed origins call the API. An origin of "*"
+// allows any origin. Preflight requests are answered here, before they reach
+// authentication, since browsers send them without credentials. Requests from
+// other origins get no CORS headers, so browsers block them.
+func CORS(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !allowed["*"] && !allowed[origin] {
+			if preflight {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
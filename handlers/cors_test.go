@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest serves a request from origin through CORS allowing origins,
+// reporting whether the wrapped handler was reached
+func corsRequest(origins []string, method, origin string, preflight bool) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	handler := CORS(origins, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(method, "/send-email", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, reached
+}
+
+func TestCORSPreflightFromAllowedOrigin(t *testing.T) {
+	rec, reached := corsRequest([]string{"https://dash.example.com/"}, http.MethodOptions, "https://dash.example.com", true)
+
+	if reached {
+		t.Error("preflight passed on to the wrapped handler")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status %d, want 204", rec.Code)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://dash.example.com",
+		"Access-Control-Allow-Methods": corsAllowMethods,
+		"Access-Control-Allow-Headers": corsAllowHeaders,
+		"Access-Control-Max-Age":       "600",
+		"Vary":                         "Origin",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name       string
+		origins    []string
+		origin     string
+		preflight  bool
+		wantStatus int
+		wantOrigin string
+	}{
+		{"allowed request", []string{"https://dash.example.com"}, "https://dash.example.com", false, http.StatusOK, "https://dash.example.com"},
+		{"disallowed request", []string{"https://dash.example.com"}, "https://evil.example", false, http.StatusOK, ""},
+		{"disallowed preflight", []string{"https://dash.example.com"}, "https://evil.example", true, http.StatusForbidden, ""},
+		{"no origins configured", nil, "https://dash.example.com", false, http.StatusOK, ""},
+		{"wildcard", []string{"*"}, "https://anywhere.example", true, http.StatusNoContent, "https://anywhere.example"},
+		{"same origin", []string{"https://dash.example.com"}, "", false, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodPost
+			if tt.preflight {
+				method = http.MethodOptions
+			}
+			rec, _ := corsRequest(tt.origins, method, tt.origin, tt.preflight)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+		})
+	}
+}
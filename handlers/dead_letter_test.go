@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+	"email-queue-service/service"
+)
+
+// serveAs serves a request authenticated as tenant, "" being a key without one
+func serveAs(handler http.HandlerFunc, tenant, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if tenant != "" {
+		req = req.WithContext(withTenant(req.Context(), tenant))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// deadLetterTenants dead-letters an email for each of tenants and returns the
+// handler once they are all in the dead letter queue
+func deadLetterTenants(t *testing.T, tenants ...string) *EmailHandler {
+	t.Helper()
+
+	rejectAll := senderFunc(func(context.Context, models.EmailJob) error {
+		return service.Permanent(errors.New("550 mailbox unavailable"))
+	})
+	h, es := newTestHandler(t, rejectAll, map[string]string{"MAX_RETRIES": "0"})
+
+	for _, tenant := range tenants {
+		body := `{"to":"` + recipientOf(tenant) + `","subject":"Hi","body":"Hello"}`
+		if rec := serveAs(h.SendEmailHandler, tenant, http.MethodPost, "/send-email", body); rec.Code != http.StatusAccepted {
+			t.Fatalf("send as %q = %d: %s", tenant, rec.Code, rec.Body)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(es.GetDeadLetterJobs()) < len(tenants) {
+		if time.Now().After(deadline) {
+			t.Fatalf("dead letters = %d, want %d", len(es.GetDeadLetterJobs()), len(tenants))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return h
+}
+
+// recipientOf is the recipient deadLetterTenants sends to for tenant
+func recipientOf(tenant string) string {
+	if tenant == "" {
+		return "ops@example.com"
+	}
+	return tenant + "@example.com"
+}
+
+// deadLetterRecipients decodes the recipients of a dead letter response
+func deadLetterRecipients(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var jobs []models.EmailJob
+	decodeData(t, rec, &jobs)
+	recipients := make([]string, len(jobs))
+	for i, job := range jobs {
+		recipients[i] = job.To
+	}
+	return recipients
+}
+
+func TestDeadLetterShowsOnlyTheCallersTenant(t *testing.T) {
+	h := deadLetterTenants(t, "acme", "globex", "acme", "")
+
+	tests := []struct {
+		tenant string
+		want   string
+	}{
+		{"acme", "acme@example.com,acme@example.com"},
+		{"globex", "globex@example.com"},
+		{"", "ops@example.com"},
+		{"initech", ""},
+	}
+	for _, tt := range tests {
+		rec := serveAs(h.DeadLetterHandler, tt.tenant, http.MethodGet, "/dead-letter", "")
+		if got := strings.Join(deadLetterRecipients(t, rec), ","); got != tt.want {
+			t.Errorf("tenant %q sees %q, want %q", tt.tenant, got, tt.want)
+		}
+	}
+
+	rec := serveAs(h.DeadLetterHandler, "globex", http.MethodGet, "/dead-letter?format=csv", "")
+	if body := rec.Body.String(); strings.Contains(body, "acme") || !strings.Contains(body, "globex@example.com") {
+		t.Errorf("globex CSV = %q, want only its own row", body)
+	}
+}
+
+func TestDeadLetterPurgeAndRetryStayWithinTheTenant(t *testing.T) {
+	h := deadLetterTenants(t, "acme", "globex")
+
+	rec := serveAs(h.DeadLetterHandler, "acme", http.MethodDelete, "/dead-letter", "")
+	if !strings.Contains(rec.Body.String(), `"removed":1`) {
+		t.Errorf("acme purge = %s, want 1 removed", rec.Body)
+	}
+	rec = serveAs(h.RetryDeadLetterHandler, "acme", http.MethodPost, "/dead-letter/retry", "")
+	if !strings.Contains(rec.Body.String(), `"requeued":0`) {
+		t.Errorf("acme retry after its purge = %s, want nothing requeued", rec.Body)
+	}
+
+	rec = serveAs(h.DeadLetterHandler, "globex", http.MethodGet, "/dead-letter", "")
+	if got := deadLetterRecipients(t, rec); len(got) != 1 || got[0] != "globex@example.com" {
+		t.Errorf("globex sees %v after acme's purge, want its own entry", got)
+	}
+}
+
+func TestAdminDeadLetterShowsEveryTenant(t *testing.T) {
+	h := deadLetterTenants(t, "acme", "globex", "")
+
+	rec := serveAs(h.AdminDeadLetterHandler, "", http.MethodGet, "/admin/dead-letter", "")
+	if got := deadLetterRecipients(t, rec); len(got) != 3 {
+		t.Errorf("admin sees %v, want every tenant's entry", got)
+	}
+
+	rec = serveAs(h.AdminDeadLetterHandler, "", http.MethodGet, "/admin/dead-letter?tenant=globex", "")
+	if got := deadLetterRecipients(t, rec); len(got) != 1 || got[0] != "globex@example.com" {
+		t.Errorf("admin filtered to globex sees %v", got)
+	}
+
+	if rec := serveAs(h.AdminDeadLetterHandler, "acme", http.MethodGet, "/admin/dead-letter", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("tenant key on the admin view = %d, want 403", rec.Code)
+	}
+}
+
+func TestDeadLetterContentRedaction(t *testing.T) {
+	for _, redact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("redact=%v", redact), func(t *testing.T) {
+			rejectAll := senderFunc(func(context.Context, models.EmailJob) error {
+				return service.Permanent(errors.New("550 mailbox unavailable"))
+			})
+			h, es := newTestHandler(t, rejectAll, map[string]string{
+				"MAX_RETRIES":    "0",
+				"REDACT_CONTENT": strconv.FormatBool(redact),
+			})
+
+			body := `{"to":"user@example.com","subject":"Your results","body":"Private details"}`
+			rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body)
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+			}
+			var sent struct {
+				ID string `json:"id"`
+			}
+			decodeData(t, rec, &sent)
+			waitForStatus(t, es, sent.ID, service.StatusDeadLettered)
+
+			wantSubject, wantBody := "Your results", "Private details"
+			if redact {
+				wantSubject, wantBody = models.RedactedPlaceholder, models.RedactedPlaceholder
+			}
+
+			var jobs []models.EmailJob
+			decodeData(t, serve(h.DeadLetterHandler, http.MethodGet, "/dead-letter", ""), &jobs)
+			if len(jobs) != 1 {
+				t.Fatalf("dead letters = %d, want 1", len(jobs))
+			}
+			if jobs[0].To != "user@example.com" || jobs[0].Subject != wantSubject || jobs[0].Body != wantBody {
+				t.Errorf("dead letter = %q %q %q, want user@example.com %q %q", jobs[0].To, jobs[0].Subject, jobs[0].Body, wantSubject, wantBody)
+			}
+
+			csvBody := serve(h.DeadLetterHandler, http.MethodGet, "/dead-letter?format=csv", "").Body.String()
+			if !strings.Contains(csvBody, "user@example.com,"+wantSubject+",") {
+				t.Errorf("CSV = %q, want the recipient and subject %q", csvBody, wantSubject)
+			}
+
+			// The stored dead letter keeps its content for a retry
+			if stored := es.GetDeadLetterJobs(); stored[0].Subject != "Your results" {
+				t.Errorf("stored subject = %q, want it untouched", stored[0].Subject)
+			}
+		})
+	}
+}
+
+func TestDeadLetterRedactionHidesHeadersAndErrors(t *testing.T) {
+	h, es := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		return service.Permanent(errors.New("550 rejected: Your results"))
+	}), map[string]string{"MAX_RETRIES": "0", "REDACT_CONTENT": "true"})
+	h.SetIDGenerator(func() string { return "job-1" })
+
+	body := `{"to":"user@example.com","subject":"Your results","body":"Private details","reply_to":"patient-42@clinic.example","headers":{"X-Case-Ref":"patient-42"}}`
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusAccepted {
+		t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+	}
+	waitForStatus(t, es, "job-1", service.StatusDeadLettered)
+
+	var jobs []models.EmailJob
+	decodeData(t, serve(h.DeadLetterHandler, http.MethodGet, "/dead-letter", ""), &jobs)
+	if len(jobs) != 1 {
+		t.Fatalf("dead letters = %d, want 1", len(jobs))
+	}
+	job := jobs[0]
+	if job.Headers["X-Case-Ref"] != models.RedactedPlaceholder {
+		t.Errorf("headers = %v, want the name kept and the value redacted", job.Headers)
+	}
+	if job.ReplyTo != models.RedactedPlaceholder {
+		t.Errorf("reply_to = %q, want it redacted", job.ReplyTo)
+	}
+	if job.LastError != models.RedactedPlaceholder || len(job.Attempts) != 1 || job.Attempts[0].Error != models.RedactedPlaceholder {
+		t.Errorf("last_error = %q, attempts = %+v; want the errors redacted", job.LastError, job.Attempts)
+	}
+
+	// The stored dead letter keeps them for a retry
+	if stored := es.GetDeadLetterJobs()[0]; stored.Headers["X-Case-Ref"] != "patient-42" || stored.LastError == models.RedactedPlaceholder {
+		t.Errorf("stored job = %+v, want it untouched", stored)
+	}
+}
+
+func TestEmailStatusRedactsRecipientErrors(t *testing.T) {
+	h, es := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		return &service.PartialDeliveryError{
+			Delivered: []string{"user@example.com"},
+			Rejected:  map[string]error{"patient-42@clinic.example": &textproto.Error{Code: 550, Msg: "5.1.1 patient-42@clinic.example: no such user"}},
+		}
+	}), map[string]string{"MAX_RETRIES": "0", "REDACT_CONTENT": "true"})
+	h.SetIDGenerator(func() string { return "job-1" })
+
+	body := `{"to":["user@example.com","patient-42@clinic.example"],"subject":"Your results","body":"Private details"}`
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusAccepted {
+		t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+	}
+	waitForStatus(t, es, "job-1", service.StatusDeadLettered)
+
+	var record service.JobStatusRecord
+	decodeData(t, serve(h.EmailStatusHandler, http.MethodGet, "/email-status?id=job-1", ""), &record)
+	var rejected []models.RecipientDelivery
+	for _, delivery := range record.Recipients {
+		if delivery.Status == models.DeliveryRejected {
+			rejected = append(rejected, delivery)
+		}
+	}
+	if len(rejected) != 1 || rejected[0].Address != "patient-42@clinic.example" || rejected[0].Error != models.RedactedPlaceholder {
+		t.Errorf("recipients = %+v, want the rejection's address kept and its error redacted", record.Recipients)
+	}
+
+	// The tracked status keeps the relay's reply
+	if stored, _ := es.JobStatus("job-1"); strings.Contains(fmt.Sprint(stored.Recipients), models.RedactedPlaceholder) {
+		t.Errorf("stored recipients = %+v, want them untouched", stored.Recipients)
+	}
+}
+
+func TestDeadLetterShowsFailureReason(t *testing.T) {
+	h, es := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		return service.Permanent(errors.New("550 5.1.1 user unknown"))
+	}), map[string]string{"MAX_RETRIES": "0"})
+	h.SetIDGenerator(func() string { return "job-1" })
+
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"Hi","body":"Hello"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+	}
+	waitForStatus(t, es, "job-1", service.StatusDeadLettered)
+
+	var jobs []struct {
+		ID        string    `json:"id"`
+		LastError string    `json:"last_error"`
+		FailedAt  time.Time `json:"failed_at"`
+	}
+	decodeData(t, serve(h.DeadLetterHandler, http.MethodGet, "/dead-letter", ""), &jobs)
+	if len(jobs) != 1 || jobs[0].LastError != "550 5.1.1 user unknown" || jobs[0].FailedAt.IsZero() {
+		t.Errorf("dead letters = %+v, want job-1 with the sender's error and when it failed", jobs)
+	}
+
+	// Clients can't set the failure fields themselves
+	rec = serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"Hi","body":"Hello","last_error":"none"}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Unknown field") {
+		t.Errorf("send with last_error = %d %q, want 422 for an unknown field", rec.Code, rec.Body)
+	}
+}
+
+func TestDeadLetterShowsAttemptHistory(t *testing.T) {
+	var calls atomic.Int32
+	h, es := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		return fmt.Errorf("421 try again (%d)", calls.Add(1))
+	}), map[string]string{"MAX_RETRIES": "1", "RETRY_BACKOFF": "fixed", "RETRY_FIXED_DELAY": "10ms"})
+	h.SetIDGenerator(func() string { return "job-1" })
+
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"Hi","body":"Hello"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+	}
+	waitForStatus(t, es, "job-1", service.StatusDeadLettered)
+
+	var jobs []struct {
+		ID       string           `json:"id"`
+		Attempts []models.Attempt `json:"attempts"`
+	}
+	decodeData(t, serve(h.DeadLetterHandler, http.MethodGet, "/dead-letter", ""), &jobs)
+	if len(jobs) != 1 || len(jobs[0].Attempts) != 2 {
+		t.Fatalf("dead letters = %+v, want job-1 with two attempts", jobs)
+	}
+	first, second := jobs[0].Attempts[0], jobs[0].Attempts[1]
+	if first.Error != "421 try again (1)" || second.Error != "421 try again (2)" {
+		t.Errorf("attempt errors = %q, %q; want each try's error in order", first.Error, second.Error)
+	}
+	// The retry may go to the worker or to the retry worker, 0
+	if first.WorkerID != 1 || (second.WorkerID != 0 && second.WorkerID != 1) {
+		t.Errorf("attempt workers = %d, %d; want 1 then 0 or 1", first.WorkerID, second.WorkerID)
+	}
+	if first.At.IsZero() || second.At.Before(first.At) {
+		t.Errorf("attempt times = %v, %v; want them in order", first.At, second.At)
+	}
+
+	// Clients can't supply a history of their own
+	rec = serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"Hi","body":"Hello","attempts":[]}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Unknown field") {
+		t.Errorf("send with attempts = %d %q, want 422 for an unknown field", rec.Code, rec.Body)
+	}
+}
+
+func TestDeadLetterCSVExport(t *testing.T) {
+	h, es := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		return service.Permanent(errors.New(`550 5.1.1 "user" unknown, giving up`))
+	}), map[string]string{"MAX_RETRIES": "0"})
+	h.SetIDGenerator(func() string { return "job-1" })
+
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"=Invoice, July","body":"Hello"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+	}
+	waitForStatus(t, es, "job-1", service.StatusDeadLettered)
+
+	rec = serve(h.DeadLetterHandler, http.MethodGet, "/dead-letter?format=csv", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/csv; charset=utf-8", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="dead-letter-`) || !strings.HasSuffix(cd, `.csv"`) {
+		t.Errorf("Content-Disposition = %q, want a dead-letter-*.csv attachment", cd)
+	}
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("rows = %q, want the header and one record", rows)
+	}
+	if got := strings.Join(rows[0], ","); got != "id,to,subject,retries,last_error,failed_at,reason" {
+		t.Errorf("header = %q", got)
+	}
+
+	// Retries counts the failed attempt; the subject can't run as a formula
+	record := rows[1]
+	want := []string{"job-1", "user@example.com", "'=Invoice, July", "1", `550 5.1.1 "user" unknown, giving up`}
+	for i, value := range want {
+		if record[i] != value {
+			t.Errorf("column %s = %q, want %q", rows[0][i], record[i], value)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, record[5]); err != nil {
+		t.Errorf("failed_at = %q, want an RFC 3339 time", record[5])
+	}
+}
@@ -1,93 +1,845 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"email-queue-service/config"
 	"email-queue-service/models"
 	"email-queue-service/service"
 	"email-queue-service/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxSubjectLength is the longest subject accepted, in characters. It
+	// matches the RFC 5322 line length limit.
+	maxSubjectLength = 998
+	// maxEmailBodyBytes is the largest email body accepted
+	maxEmailBodyBytes = 512 << 10
+	// maxCallbackURLLength is the longest callback_url accepted
+	maxCallbackURLLength = 2048
+	// maxCustomHeaders is the most custom headers one email can carry
+	maxCustomHeaders = 20
+	// maxHeaderLength is the longest custom header accepted, name and value
+	// together, in bytes
+	maxHeaderLength = 998
 )
 
+// protectedHeaders are set by the service from other fields and can't be
+// given as custom headers
+var protectedHeaders = map[string]bool{
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+}
+
 // EmailHandler handles email-related HTTP requests
 type EmailHandler struct {
-	emailService *service.EmailService
+	emailService         *service.EmailService
+	maxMergeRecipients   int
+	maxBulkEmails        int
+	maxBodyBytes         int64
+	maxAttachmentBytes   int
+	rejectTrackingPixels bool
+	rejectIdentifying    bool          // reject custom headers that fingerprint the sender
+	clockSkew            time.Duration // how far in the past expires_at may be, for clients whose clocks run behind
+	replayStatus         bool          // answer a repeated Idempotency-Key with the job's current status
+	redactContent        bool
+	domainFilter         *utils.DomainFilter // nil when every domain may be emailed
+	mxChecker            *utils.MXChecker    // nil when MX records aren't checked
+	newID                func() string
 }
 
 // NewEmailHandler creates a new email handler
-func NewEmailHandler(emailService *service.EmailService) *EmailHandler {
-	return &EmailHandler{
-		emailService: emailService,
+func NewEmailHandler(emailService *service.EmailService, cfg *config.Config) *EmailHandler {
+	h := &EmailHandler{
+		emailService:         emailService,
+		maxMergeRecipients:   cfg.MaxMergeRecipients,
+		maxBulkEmails:        cfg.MaxBulkEmails,
+		maxBodyBytes:         int64(cfg.MaxBodyBytes),
+		maxAttachmentBytes:   cfg.MaxAttachmentBytes,
+		rejectTrackingPixels: cfg.RejectTrackingPixels,
+		rejectIdentifying:    cfg.IdentifyingHeaders == "reject",
+		clockSkew:            max(cfg.ClockSkewTolerance, 0),
+		replayStatus:         cfg.IdempotentReplay == "status",
+		redactContent:        cfg.RedactContent,
+		newID:                uuid.NewString,
+	}
+	if len(cfg.AllowDomains) > 0 || len(cfg.BlockDomains) > 0 {
+		h.domainFilter = utils.NewDomainFilter(cfg.AllowDomains, cfg.BlockDomains)
 	}
+	if cfg.ValidateMX {
+		h.mxChecker = utils.NewMXChecker(nil, cfg.MXLookupTimeout, cfg.MXCacheTTL, cfg.MaxConcurrentMXLookups, emailService.MXLookupsInFlight())
+	}
+	return h
+}
+
+// SetMXChecker replaces the MX checker, e.g. with one backed by a resolver
+// that doesn't touch the network. A nil checker turns MX checks off.
+func (h *EmailHandler) SetMXChecker(checker *utils.MXChecker) {
+	h.mxChecker = checker
+}
+
+// SetIDGenerator replaces how job IDs are generated, e.g. with a deterministic sequence
+func (h *EmailHandler) SetIDGenerator(newID func() string) {
+	h.newID = newID
 }
 
 // SendEmailHandler handles POST /send-email requests
 func (h *EmailHandler) SendEmailHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
 
 	var req models.EmailRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !h.decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Validate required fields
-	if req.To == "" || req.Subject == "" || req.Body == "" {
-		http.Error(w, "All fields (to, subject, body) are required", http.StatusUnprocessableEntity)
+	jobs, mode, reqErr := h.buildJobs(r.Context(), &req, r.Header.Get("Prefer"))
+	if reqErr != nil {
+		reqErr.write(w)
 		return
 	}
 
-	// Validate email format
-	if !utils.ValidateEmail(req.To) {
-		http.Error(w, "Invalid email format", http.StatusUnprocessableEntity)
+	if len(jobs) > 1 {
+		h.enqueueFanOut(w, r, jobs)
 		return
 	}
+	job := jobs[0]
+
+	if mode == models.ModeSync {
+		w.Header().Set("Preference-Applied", "respond-sync")
+		if err := h.emailService.SendNow(r.Context(), job); err != nil {
+			if !writeSubmitError(w, err) {
+				http.Error(w, "Delivery failed: "+err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
 
-	// Create job and enqueue
-	job := models.EmailJob{
-		To:      req.To,
-		Subject: req.Subject,
-		Body:    req.Body,
-		Retries: 0,
+		writeEnvelope(w, http.StatusOK, map[string]string{
+			"id":      job.ID,
+			"status":  "sent",
+			"message": "Email sent",
+		}, nil)
+		return
 	}
 
-	if err := h.emailService.EnqueueJob(job); err != nil {
-		http.Error(w, "Queue is full", http.StatusServiceUnavailable)
+	err := h.emailService.EnqueueJobWait(r.Context(), job)
+	var duplicate *service.DuplicateError
+	if errors.As(err, &duplicate) {
+		// Not an error for the client: the email it asked for is on its way,
+		// but as the original job rather than a new one
+		writeEnvelope(w, http.StatusAccepted, map[string]interface{}{
+			"id":           duplicate.OriginalID,
+			"status":       "duplicate",
+			"deduplicated": true,
+			"message":      "Duplicate suppressed, the original email is queued",
+		}, nil)
+		return
+	}
+	if err != nil {
+		if !writeSubmitError(w, err) {
+			http.Error(w, "Queue is full ("+job.Priority+" priority)", http.StatusServiceUnavailable)
+		}
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "accepted",
-		"message": "Email queued for processing",
+	writeEnvelope(w, http.StatusAccepted, map[string]interface{}{
+		"id":           job.ID,
+		"status":       "accepted",
+		"deduplicated": false,
+		"message":      "Email queued for processing",
+	}, nil)
+}
+
+// buildJobs validates a send request and turns it into one job per to
+// address, along with the delivery mode. prefer is the request's Prefer
+// header, if any.
+func (h *EmailHandler) buildJobs(ctx context.Context, req *models.EmailRequest, prefer string) ([]models.EmailJob, string, *requestError) {
+	html, reqErr := h.renderRequestTemplate(req)
+	if reqErr != nil {
+		return nil, "", reqErr
+	}
+
+	// Validate required fields
+	if len(req.To) == 0 || req.Subject == "" || req.Body == "" {
+		return nil, "", invalidRequest("All fields (to, subject, body) are required")
+	}
+
+	if utf8.RuneCountInString(req.Subject) > maxSubjectLength {
+		return nil, "", invalidRequest(fmt.Sprintf("Subject too long (max %d characters)", maxSubjectLength))
+	}
+	if len(req.Body) > maxEmailBodyBytes {
+		return nil, "", invalidRequest(fmt.Sprintf("Body too large (max %d bytes)", maxEmailBodyBytes))
+	}
+
+	// Validate email format
+	if invalid := invalidAddresses(req.To); len(invalid) > 0 {
+		return nil, "", invalidRequest("Invalid email format: " + strings.Join(invalid, ", "))
+	}
+	if invalid := invalidAddresses(req.Cc); len(invalid) > 0 {
+		return nil, "", invalidRequest("Invalid cc address: " + strings.Join(invalid, ", "))
+	}
+	if invalid := invalidAddresses(req.Bcc); len(invalid) > 0 {
+		return nil, "", invalidRequest("Invalid bcc address: " + strings.Join(invalid, ", "))
+	}
+	if req.From != "" && !utils.ValidateEmail(req.From) {
+		return nil, "", invalidRequest("Invalid from address: " + req.From)
+	}
+	if req.ReplyTo != "" && !utils.ValidateEmail(req.ReplyTo) {
+		return nil, "", invalidRequest("Invalid reply_to address: " + req.ReplyTo)
+	}
+	if blocked := h.blockedAddresses(req.To, req.Cc, req.Bcc); len(blocked) > 0 {
+		return nil, "", &requestError{status: http.StatusForbidden, message: "Recipient domain not allowed: " + strings.Join(blocked, ", ")}
+	}
+	if undeliverable := h.undeliverableAddresses(ctx, req.To, req.Cc, req.Bcc); len(undeliverable) > 0 {
+		return nil, "", invalidRequest("No mail server for address: " + strings.Join(undeliverable, ", "))
+	}
+
+	if reqErr := validateHeaders(req.Headers); reqErr != nil {
+		return nil, "", reqErr
+	}
+	if h.rejectIdentifying {
+		for _, name := range sortedHeaderNames(req.Headers) {
+			if utils.IsIdentifyingHeader(name) {
+				return nil, "", invalidRequest(fmt.Sprintf("Header %s is not allowed: it identifies the sender", textproto.CanonicalMIMEHeaderKey(name)))
+			}
+		}
+	}
+
+	if h.rejectTrackingPixels && utils.ContainsTrackingPixel(req.Body) {
+		return nil, "", invalidRequest("Body contains a tracking pixel")
+	}
+
+	attachments, reqErr := h.decodeAttachments(req.Attachments)
+	if reqErr != nil {
+		return nil, "", reqErr
+	}
+
+	mode, ok := deliveryMode(req.Mode, prefer)
+	if !ok {
+		return nil, "", invalidRequest("Invalid mode (expected async or sync)")
+	}
+
+	priority, ok := models.ParsePriority(req.Priority)
+	if !ok {
+		return nil, "", invalidRequest("Invalid priority (expected high, normal or low)")
+	}
+
+	var sendAt time.Time
+	if req.SendAt != "" {
+		var err error
+		if sendAt, err = time.Parse(time.RFC3339, req.SendAt); err != nil {
+			return nil, "", invalidRequest("Invalid send_at (expected an RFC 3339 timestamp)")
+		}
+		if mode == models.ModeSync {
+			return nil, "", invalidRequest("Sync mode can't be combined with send_at")
+		}
+	}
+	var expiresAt *time.Time
+	if req.ExpiresAt != "" {
+		parsed, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return nil, "", invalidRequest("Invalid expires_at (expected an RFC 3339 timestamp)")
+		}
+		now := time.Now()
+		if !parsed.After(now.Add(-h.clockSkew)) {
+			return nil, "", invalidRequest("expires_at must be in the future")
+		}
+		if !parsed.After(now) {
+			slog.Info("Accepting expires_at in the past within the clock skew tolerance", "event", "clock_skew_tolerated", "expires_at", parsed.Format(time.RFC3339))
+		}
+		if !sendAt.IsZero() && !parsed.After(sendAt) {
+			return nil, "", invalidRequest("expires_at must be after send_at")
+		}
+		expiresAt = &parsed
+	}
+
+	if req.CallbackURL != "" {
+		if !validCallbackURL(req.CallbackURL) {
+			return nil, "", invalidRequest(fmt.Sprintf("Invalid callback_url (expected an absolute http or https URL of at most %d characters)", maxCallbackURLLength))
+		}
+		if mode == models.ModeSync {
+			return nil, "", invalidRequest("Sync mode can't be combined with callback_url")
+		}
+	}
+
+	// Copies would otherwise reach cc and bcc recipients once per to address
+	if len(req.To) > 1 && (len(req.Cc) > 0 || len(req.Bcc) > 0) {
+		return nil, "", invalidRequest("cc and bcc require a single to address")
+	}
+	if len(req.To) > 1 && mode == models.ModeSync {
+		return nil, "", invalidRequest("Sync mode requires a single to address")
+	}
+
+	// Create one job per recipient so each is retried and dead-lettered on its own
+	jobs := make([]models.EmailJob, len(req.To))
+	for i, to := range req.To {
+		jobs[i] = models.EmailJob{
+			ID:          h.newID(),
+			To:          to,
+			Cc:          req.Cc,
+			Bcc:         req.Bcc,
+			From:        req.From,
+			ReplyTo:     req.ReplyTo,
+			Headers:     req.Headers,
+			Subject:     req.Subject,
+			Body:        req.Body,
+			HTML:        html,
+			Attachments: attachments,
+			Retries:     0,
+			Priority:    priority,
+			SendAt:      sendAt,
+			ExpiresAt:   expiresAt,
+			CallbackURL: req.CallbackURL,
+			Tenant:      tenantFromContext(ctx),
+		}
+	}
+	return jobs, mode, nil
+}
+
+// enqueueFanOut enqueues one job per recipient and reports each outcome, like /send-merge
+func (h *EmailHandler) enqueueFanOut(w http.ResponseWriter, r *http.Request, jobs []models.EmailJob) {
+	results := make([]models.RecipientResult, len(jobs))
+	accepted := 0
+	for i, job := range jobs {
+		results[i] = models.RecipientResult{Index: i, To: job.To}
+
+		if err := h.emailService.EnqueueJobWait(r.Context(), job); err != nil {
+			results[i].Status = "rejected"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		results[i].ID = job.ID
+		results[i].Status = "accepted"
+		accepted++
+	}
+
+	writeEnvelope(w, http.StatusAccepted, results, map[string]int{
+		"accepted": accepted,
+		"rejected": len(results) - accepted,
 	})
 }
 
-// DeadLetterHandler handles GET /dead-letter requests
+// requestError is a rejected request's status and message
+type requestError struct {
+	status  int
+	message string
+	// body, when set, is sent as JSON instead of message as plain text
+	body interface{}
+}
+
+// write sends the rejection
+func (e *requestError) write(w http.ResponseWriter) {
+	if e.body == nil {
+		http.Error(w, e.message, e.status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(e.body)
+}
+
+// invalidRequest rejects a request with 422 Unprocessable Entity
+func invalidRequest(message string) *requestError {
+	return &requestError{status: http.StatusUnprocessableEntity, message: message}
+}
+
+// errTrailingData is reported when a request body has more after its JSON value
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+// decodeJSON decodes the request body into v, reading at most maxBodyBytes.
+// It responds 413 for a larger body, 400 for an empty body or invalid JSON and
+// 422 for a field v doesn't have, and reports whether decoding succeeded.
+func (h *EmailHandler) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+
+	// Reject misspelled fields rather than silently ignoring them
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil {
+		if err = expectEOF(decoder); err == nil {
+			return true
+		}
+	}
+	writeDecodeError(w, err)
+	return false
+}
+
+// expectEOF checks that decoder has nothing left after the JSON value it
+// decoded, since only one is allowed
+func expectEOF(decoder *json.Decoder) error {
+	_, err := decoder.Token()
+	if err == io.EOF {
+		return nil
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return errTrailingData
+}
+
+// writeDecodeError responds to a request body that failed to decode: 413 for
+// a body over the limit, 400 for an empty body or invalid JSON and 422 for an
+// unknown field
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Request body too large (max %d bytes)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, io.EOF):
+		http.Error(w, "Request body is empty", http.StatusBadRequest)
+	case errors.Is(err, errTrailingData):
+		http.Error(w, "Invalid JSON: unexpected data after the request body", http.StatusBadRequest)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this, only the message
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		http.Error(w, "Unknown field "+field, http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	}
+}
+
+// invalidAddresses returns every malformed address in addrs
+func invalidAddresses(addrs []string) []string {
+	var invalid []string
+	for _, addr := range addrs {
+		if !utils.ValidateEmail(addr) {
+			invalid = append(invalid, addr)
+		}
+	}
+	return invalid
+}
+
+// validateHeaders checks custom headers: each needs a valid name and a value
+// on a single line, and none may replace a header the service sets itself
+func validateHeaders(headers map[string]string) *requestError {
+	if len(headers) > maxCustomHeaders {
+		return invalidRequest(fmt.Sprintf("Too many headers (max %d)", maxCustomHeaders))
+	}
+
+	seen := make(map[string]bool, len(headers))
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			return invalidRequest(fmt.Sprintf("Invalid header name %q", name))
+		}
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if protectedHeaders[canonical] {
+			return invalidRequest(fmt.Sprintf("Header %s can't be overridden", canonical))
+		}
+		if seen[canonical] {
+			return invalidRequest(fmt.Sprintf("Duplicate header %s", canonical))
+		}
+		seen[canonical] = true
+
+		// A line break would let the value inject headers of its own
+		if strings.ContainsAny(value, "\r\n") || !utf8.ValidString(value) {
+			return invalidRequest(fmt.Sprintf("Invalid value for header %s", name))
+		}
+		if len(name)+len(value) > maxHeaderLength {
+			return invalidRequest(fmt.Sprintf("Header %s too long (max %d bytes)", name, maxHeaderLength))
+		}
+	}
+	return nil
+}
+
+// sortedHeaderNames returns the names of headers in order, so errors are reproducible
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validHeaderName reports whether name is a valid RFC 5322 header field name:
+// printable ASCII other than a colon
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < '!' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// validCallbackURL reports whether raw is an absolute http or https URL short
+// enough to accept as a callback
+func validCallbackURL(raw string) bool {
+	if len(raw) > maxCallbackURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil
+}
+
+// undeliverableAddresses returns every address whose domain has no mail
+// exchanger. It returns nothing when MX checks are disabled.
+func (h *EmailHandler) undeliverableAddresses(ctx context.Context, lists ...[]string) []string {
+	if h.mxChecker == nil {
+		return nil
+	}
+
+	var undeliverable []string
+	for _, addrs := range lists {
+		for _, addr := range addrs {
+			if !h.mxChecker.HasMX(ctx, addr) {
+				undeliverable = append(undeliverable, addr)
+			}
+		}
+	}
+	return undeliverable
+}
+
+// blockedAddresses returns every address in lists whose domain the domain
+// filter rejects
+func (h *EmailHandler) blockedAddresses(lists ...[]string) []string {
+	if h.domainFilter == nil {
+		return nil
+	}
+
+	var blocked []string
+	for _, addrs := range lists {
+		for _, addr := range addrs {
+			if !h.domainFilter.Allowed(addr) {
+				blocked = append(blocked, addr)
+			}
+		}
+	}
+	return blocked
+}
+
+// deliveryMode picks the delivery mode from the request body, falling back to
+// the Prefer header and then to async. It reports false for an unknown mode.
+func deliveryMode(mode, prefer string) (string, bool) {
+	switch strings.ToLower(mode) {
+	case models.ModeAsync, models.ModeSync:
+		return strings.ToLower(mode), true
+	case "":
+	default:
+		return "", false
+	}
+
+	for _, preference := range strings.Split(prefer, ",") {
+		switch strings.ToLower(strings.TrimSpace(preference)) {
+		case "respond-sync":
+			return models.ModeSync, true
+		case "respond-async":
+			return models.ModeAsync, true
+		}
+	}
+	return models.ModeAsync, true
+}
+
+// writeSubmitError writes the response for submission errors shared by both
+// delivery modes. It reports false if err is not one of them.
+func writeSubmitError(w http.ResponseWriter, err error) bool {
+	var tenantLimited *service.TenantRateLimitError
+	if errors.As(err, &tenantLimited) {
+		w.Header().Set("Retry-After", "1")
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(tenantLimited.Limit, 'g', -1, 64))
+		http.Error(w, fmt.Sprintf("Rate limit exceeded for tenant %s (limit %g emails per second)", tenantLimited.Tenant, tenantLimited.Limit), http.StatusTooManyRequests)
+		return true
+	}
+
+	switch {
+	case errors.Is(err, service.ErrShuttingDown):
+		http.Error(w, "Service is shutting down", http.StatusServiceUnavailable)
+	case errors.Is(err, service.ErrSubjectThrottled):
+		http.Error(w, "Too many emails with this subject to this recipient", http.StatusTooManyRequests)
+	case errors.Is(err, service.ErrDomainRateLimited):
+		http.Error(w, "Too many emails to this recipient's domain", http.StatusTooManyRequests)
+	case errors.Is(err, service.ErrGlobalRateLimited):
+		http.Error(w, "Send rate limit exceeded", http.StatusTooManyRequests)
+	case errors.Is(err, service.ErrQueueUnavailable):
+		http.Error(w, "Queue unavailable, try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, service.ErrScheduleFull):
+		http.Error(w, "Too many scheduled emails", http.StatusServiceUnavailable)
+	case errors.Is(err, service.ErrCircuitOpen):
+		http.Error(w, "Email provider unavailable, try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, service.ErrPaused):
+		http.Error(w, "Processing is paused, try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, service.ErrSendTimeout):
+		http.Error(w, "Delivery timed out", http.StatusGatewayTimeout)
+	case errors.Is(err, service.ErrMessageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		return false
+	}
+	return true
+}
+
+// DeadLetterHandler handles GET and DELETE /dead-letter requests. Callers
+// only see and purge the dead letters of their own tenant.
 func (h *EmailHandler) DeadLetterHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !requireMethod(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+
+	tenant := tenantFromContext(r.Context())
+	if r.Method == http.MethodDelete {
+		removed := h.emailService.PurgeTenantDeadLetters(tenant)
+		writeEnvelope(w, http.StatusOK, map[string]int{
+			"removed": removed,
+		}, nil)
+		return
+	}
+
+	h.writeDeadLetters(w, r, h.emailService.TenantDeadLetterJobs(tenant))
+}
+
+// AdminDeadLetterHandler handles GET /admin/dead-letter requests: the dead
+// letters of every tenant, or of the one named by ?tenant=, for operators
+func (h *EmailHandler) AdminDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) || !requireAdmin(w, r) {
 		return
 	}
 
 	jobs := h.emailService.GetDeadLetterJobs()
+	if r.URL.Query().Has("tenant") {
+		jobs = h.emailService.TenantDeadLetterJobs(r.URL.Query().Get("tenant"))
+	}
+	h.writeDeadLetters(w, r, jobs)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"count": len(jobs),
-		"jobs":  jobs,
+// writeDeadLetters responds with a page of jobs, or all of them as CSV
+func (h *EmailHandler) writeDeadLetters(w http.ResponseWriter, r *http.Request, jobs []models.EmailJob) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "csv":
+		// Every job, since a spreadsheet import wants every row
+		h.writeDeadLetterCSV(w, jobs)
+		return
+	default:
+		http.Error(w, "Invalid format (expected json or csv)", http.StatusBadRequest)
+		return
+	}
+
+	p := parsePage(r)
+	start, end := p.bounds(len(jobs))
+
+	pageJobs := jobs[start:end]
+	if h.redactContent {
+		pageJobs = redactJobs(pageJobs)
+	}
+
+	writeEnvelope(w, http.StatusOK, pageJobs, p.meta(len(jobs)))
+}
+
+// RetryDeadLetterHandler handles POST /dead-letter/retry requests, requeueing
+// the dead letters of the caller's tenant
+func (h *EmailHandler) RetryDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	requeued, failed := h.emailService.RetryTenantDeadLetters(tenantFromContext(r.Context()))
+	writeEnvelope(w, http.StatusOK, map[string]int{
+		"requeued": requeued,
+		"failed":   failed,
+	}, nil)
+}
+
+// EmailStatusHandler handles GET /email-status?id=<id> requests
+func (h *EmailHandler) EmailStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+
+	status, ok := h.emailService.JobStatus(id)
+	if !ok {
+		http.Error(w, "Unknown job ID", http.StatusNotFound)
+		return
+	}
+	if h.redactContent {
+		status = status.Redacted()
+	}
+
+	writeEnvelope(w, http.StatusOK, status, nil)
+}
+
+// LatencyStatsHandler handles GET /stats/latency requests
+func (h *EmailHandler) LatencyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeEnvelope(w, http.StatusOK, h.emailService.QueueLatency(), nil)
+}
+
+// StatsHandler handles GET /admin/stats requests. The figures cover every
+// tenant's jobs, so only operators see them.
+func (h *EmailHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) || !requireAdmin(w, r) {
+		return
+	}
+
+	writeEnvelope(w, http.StatusOK, h.emailService.Stats(), nil)
+}
+
+// PauseHandler handles POST /admin/pause requests. Pausing stops every
+// tenant's email, so only operators may do it.
+func (h *EmailHandler) PauseHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) || !requireAdmin(w, r) {
+		return
+	}
+
+	h.emailService.Pause()
+	writeEnvelope(w, http.StatusOK, map[string]bool{
+		"paused": true,
+	}, nil)
+}
+
+// ResumeHandler handles POST /admin/resume requests, for operators only like
+// PauseHandler
+func (h *EmailHandler) ResumeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) || !requireAdmin(w, r) {
+		return
+	}
+
+	h.emailService.Resume()
+	writeEnvelope(w, http.StatusOK, map[string]bool{
+		"paused": false,
+	}, nil)
+}
+
+// PanicsHandler handles GET /admin/panics requests. The panics of every
+// tenant's jobs are listed, recipients included, so only operators see them.
+func (h *EmailHandler) PanicsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) || !requireAdmin(w, r) {
+		return
+	}
+
+	panics := h.emailService.GetPanics()
+	writeEnvelope(w, http.StatusOK, panics, map[string]int{
+		"count": len(panics),
 	})
 }
 
-// HealthHandler handles GET /health requests
-func HealthHandler(w http.ResponseWriter, r *http.Request) {
+// WorkersHandler handles GET and POST /admin/workers requests. The workers are
+// shared by every tenant, so only operators may see or resize them.
+func (h *EmailHandler) WorkersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPost) || !requireAdmin(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		writeEnvelope(w, http.StatusOK, h.emailService.WorkerAssignment(), nil)
+		return
+	}
+
+	var req models.WorkerCountRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.emailService.SetWorkerCount(req.Workers); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidWorkerCount):
+			http.Error(w, "Worker count must be at least 1", http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrNoGeneralWorkers):
+			http.Error(w, "Worker count must exceed HIGH_PRIORITY_WORKERS", http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrShuttingDown):
+			http.Error(w, "Service is shutting down", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeEnvelope(w, http.StatusOK, h.emailService.WorkerAssignment(), nil)
+}
+
+// redactJobs returns redacted copies of jobs, leaving the shared snapshot untouched
+func redactJobs(jobs []models.EmailJob) []models.EmailJob {
+	redacted := make([]models.EmailJob, len(jobs))
+	for i, job := range jobs {
+		redacted[i] = job.Redacted()
+	}
+	return redacted
+}
+
+// ReadyHandler handles GET /ready requests. Load balancers use it to stop
+// routing traffic to an overloaded instance.
+func (h *EmailHandler) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	readiness := h.emailService.Readiness()
+
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "healthy",
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// HealthHandler handles GET /health requests
+func (h *EmailHandler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	state, reasons := h.emailService.Health()
+
+	status := http.StatusOK
+	if state == service.HealthUnhealthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	response := map[string]interface{}{
+		"status":  state,
 		"service": "email-queue",
-	})
+	}
+	if len(reasons) > 0 {
+		response["reasons"] = reasons
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
 }
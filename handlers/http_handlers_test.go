@@ -0,0 +1,856 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+	"email-queue-service/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// senderFunc adapts a function to service.EmailSender
+type senderFunc func(ctx context.Context, job models.EmailJob) error
+
+// Send implements service.EmailSender
+func (f senderFunc) Send(ctx context.Context, job models.EmailJob) error {
+	return f(ctx, job)
+}
+
+// acceptAll is a sender that delivers everything
+var acceptAll = senderFunc(func(context.Context, models.EmailJob) error { return nil })
+
+// newTestHandler creates a handler over a started service configured from env,
+// shut down when the test ends
+func newTestHandler(t *testing.T, sender service.EmailSender, env map[string]string) (*EmailHandler, *service.EmailService) {
+	t.Helper()
+
+	t.Setenv("WORKERS", "1")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg := config.LoadConfig()
+	es, err := service.NewEmailService(cfg, sender, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewEmailService: %v", err)
+	}
+	es.Start()
+	t.Cleanup(es.Shutdown)
+
+	return NewEmailHandler(es, cfg), es
+}
+
+// waitForStatus fails the test if job id doesn't reach status within a few seconds
+func waitForStatus(t *testing.T, es *service.EmailService, id string, status service.JobStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		record, ok := es.JobStatus(id)
+		if ok && record.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s status = %+v, want %s", id, record, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// serve sends a request with body to handler and returns the recorded response
+func serve(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// decodeData decodes the data of an envelope response into v
+func decodeData(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		t.Fatalf("decode data %s: %v", envelope.Data, err)
+	}
+}
+
+func TestSendEmailToleratesClockSkewInExpiresAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		tolerance string
+		expiresAt time.Duration // from now
+		want      int
+	}{
+		{"future", "2s", time.Minute, http.StatusAccepted},
+		{"just past within tolerance", "2s", -time.Second, http.StatusAccepted},
+		{"past the tolerance", "2s", -10 * time.Second, http.StatusUnprocessableEntity},
+		{"no tolerance", "0s", -time.Second, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, acceptAll, map[string]string{"CLOCK_SKEW_TOLERANCE": tt.tolerance})
+
+			expiresAt := time.Now().Add(tt.expiresAt).Format(time.RFC3339Nano)
+			body := `{"to":"user@example.com","subject":"Code","body":"123456","expires_at":"` + expiresAt + `"}`
+			if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestScheduledSendsCappedWhileImmediateAccepted(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, map[string]string{"MAX_SCHEDULED": "2"})
+
+	sendAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	scheduled := `{"to":"user@example.com","subject":"Later","body":"Hello","send_at":"` + sendAt + `"}`
+	for i := 0; i < 2; i++ {
+		if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", scheduled); rec.Code != http.StatusAccepted {
+			t.Fatalf("scheduled send %d = %d, want 202: %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", scheduled); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("scheduled send past the cap = %d, want 503", rec.Code)
+	}
+
+	immediate := `{"to":"user@example.com","subject":"Now","body":"Hello"}`
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", immediate); rec.Code != http.StatusAccepted {
+		t.Errorf("immediate send with the schedule full = %d, want 202", rec.Code)
+	}
+	if got := es.Stats().Scheduled; got != 2 {
+		t.Errorf("scheduled = %d, want 2", got)
+	}
+}
+
+func TestWorkersReportsPriorityTiers(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"WORKERS": "3", "HIGH_PRIORITY_WORKERS": "1"})
+
+	rec := serve(h.WorkersHandler, http.MethodGet, "/admin/workers", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/workers = %d, want 200", rec.Code)
+	}
+	var assignment models.WorkerAssignment
+	decodeData(t, rec, &assignment)
+	if want := (models.WorkerAssignment{Workers: 3, HighPriorityOnly: 1, AllPriorities: 2}); assignment != want {
+		t.Errorf("assignment = %+v, want %+v", assignment, want)
+	}
+
+	if rec := serve(h.WorkersHandler, http.MethodPost, "/admin/workers", `{"workers":1}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("POST with no general workers left = %d, want 422", rec.Code)
+	}
+}
+
+func TestWorkersNeedAKeyWithoutATenant(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, map[string]string{"WORKERS": "2"})
+
+	if rec := serveAs(h.WorkersHandler, "acme", http.MethodGet, "/admin/workers", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("GET with a tenant key = %d, want 403", rec.Code)
+	}
+	if rec := serveAs(h.WorkersHandler, "acme", http.MethodPost, "/admin/workers", `{"workers":5}`); rec.Code != http.StatusForbidden {
+		t.Errorf("POST with a tenant key = %d, want 403", rec.Code)
+	}
+	if got := es.WorkerAssignment().Workers; got != 2 {
+		t.Errorf("workers = %d after a tenant's POST, want 2", got)
+	}
+
+	if rec := serveAs(h.WorkersHandler, "", http.MethodPost, "/admin/workers", `{"workers":3}`); rec.Code != http.StatusOK {
+		t.Errorf("POST without a tenant = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSendEmailReportsDeduplication(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"DEDUPE_WINDOW": "1m"})
+
+	type submitted struct {
+		ID           string `json:"id"`
+		Deduplicated bool   `json:"deduplicated"`
+	}
+	submit := func(body string) submitted {
+		t.Helper()
+		rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+		}
+		var resp submitted
+		decodeData(t, rec, &resp)
+		return resp
+	}
+
+	body := `{"to":"user@example.com","subject":"Hello","body":"Hi there"}`
+	first := submit(body)
+	if first.Deduplicated || first.ID == "" {
+		t.Fatalf("fresh submission = %+v, want a new ID and deduplicated false", first)
+	}
+
+	repeat := submit(body)
+	if !repeat.Deduplicated || repeat.ID != first.ID {
+		t.Errorf("repeat = %+v, want deduplicated true with the original ID %s", repeat, first.ID)
+	}
+
+	other := submit(`{"to":"user@example.com","subject":"Hello","body":"Something else"}`)
+	if other.Deduplicated || other.ID == first.ID {
+		t.Errorf("different content = %+v, want a fresh enqueue", other)
+	}
+}
+
+func TestHealthReportsEachState(t *testing.T) {
+	tests := []struct {
+		name   string
+		queued int
+		state  service.HealthState
+		want   int
+	}{
+		{"healthy", 0, service.HealthHealthy, http.StatusOK},
+		{"degraded when nearly full", 4, service.HealthDegraded, http.StatusOK},
+		{"unhealthy when full", 5, service.HealthUnhealthy, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, es := newTestHandler(t, acceptAll, map[string]string{"QUEUE_SIZE": "5"})
+			// Anything queued stays in the queue
+			es.Pause()
+			for i := 0; i < tt.queued; i++ {
+				if err := es.EnqueueJob(models.EmailJob{To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+					t.Fatalf("EnqueueJob: %v", err)
+				}
+			}
+
+			rec := serve(h.HealthHandler, http.MethodGet, "/health", "")
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			var body struct {
+				Status  service.HealthState `json:"status"`
+				Reasons []string            `json:"reasons"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			if body.Status != tt.state {
+				t.Errorf("state = %s, want %s", body.Status, tt.state)
+			}
+			if (tt.state == service.HealthHealthy) != (len(body.Reasons) == 0) {
+				t.Errorf("reasons = %v for a %s service", body.Reasons, tt.state)
+			}
+		})
+	}
+}
+
+func TestSendEmailDeliveryModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		prefer  string
+		fail    bool
+		want    int
+		status  string
+		applied string
+	}{
+		{"async by default", "", "", false, http.StatusAccepted, "accepted", ""},
+		{"sync mode", "sync", "", false, http.StatusOK, "sent", "respond-sync"},
+		{"sync preferred", "", "respond-sync", false, http.StatusOK, "sent", "respond-sync"},
+		{"mode wins over the header", "async", "respond-sync", false, http.StatusAccepted, "accepted", ""},
+		{"sync delivery failure", "sync", "", true, http.StatusBadGateway, "", "respond-sync"},
+		{"unknown mode", "later", "", false, http.StatusUnprocessableEntity, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+				if tt.fail {
+					return service.Permanent(errors.New("550 mailbox unavailable"))
+				}
+				return nil
+			}), nil)
+
+			body := `{"to":"user@example.com","subject":"Hi","body":"Hello","mode":"` + tt.mode + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/send-email", strings.NewReader(body))
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+			rec := httptest.NewRecorder()
+			h.SendEmailHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := rec.Header().Get("Preference-Applied"); got != tt.applied {
+				t.Errorf("Preference-Applied = %q, want %q", got, tt.applied)
+			}
+			if tt.status == "" {
+				return
+			}
+			var data struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			}
+			decodeData(t, rec, &data)
+			if data.ID == "" || data.Status != tt.status {
+				t.Errorf("response = %+v, want an ID and status %s", data, tt.status)
+			}
+		})
+	}
+}
+
+func TestSendEmailIDCarriedIntoDeadLetters(t *testing.T) {
+	rejectAll := senderFunc(func(context.Context, models.EmailJob) error {
+		return service.Permanent(errors.New("550 mailbox unavailable"))
+	})
+	h, es := newTestHandler(t, rejectAll, map[string]string{"MAX_RETRIES": "0"})
+
+	// The default generator gives every email its own ID
+	var first, second struct {
+		ID string `json:"id"`
+	}
+	body := `{"to":"user@example.com","subject":"Hi","body":"Hello"}`
+	decodeData(t, serve(h.SendEmailHandler, http.MethodPost, "/send-email", body), &first)
+	decodeData(t, serve(h.SendEmailHandler, http.MethodPost, "/send-email", body), &second)
+	if first.ID == "" || first.ID == second.ID {
+		t.Errorf("IDs = %q and %q, want two different non-empty IDs", first.ID, second.ID)
+	}
+
+	h.SetIDGenerator(func() string { return "job-1" })
+	var sent struct {
+		ID string `json:"id"`
+	}
+	decodeData(t, serve(h.SendEmailHandler, http.MethodPost, "/send-email", body), &sent)
+	if sent.ID != "job-1" {
+		t.Fatalf("ID = %q, want job-1 from the injected generator", sent.ID)
+	}
+
+	waitForStatus(t, es, "job-1", service.StatusDeadLettered)
+	found := false
+	for _, job := range es.GetDeadLetterJobs() {
+		found = found || job.ID == "job-1"
+	}
+	if !found {
+		t.Error("dead letters don't include job-1")
+	}
+}
+
+func TestEmailStatusAcrossLifecycle(t *testing.T) {
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	var attempts int
+	h, es := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		attempts++
+		if attempts == 1 {
+			close(sending)
+			<-release
+			return errors.New("421 service not available")
+		}
+		return nil
+	}), map[string]string{
+		"RETRY_BACKOFF":     "fixed",
+		"RETRY_FIXED_DELAY": "200ms",
+		"STATUS_TTL":        "300ms",
+	})
+	h.SetIDGenerator(func() string { return "job-1" })
+
+	statusOf := func() (int, service.JobStatus) {
+		rec := serve(h.EmailStatusHandler, http.MethodGet, "/email-status?id=job-1", "")
+		if rec.Code != http.StatusOK {
+			return rec.Code, ""
+		}
+		var record service.JobStatusRecord
+		decodeData(t, rec, &record)
+		return rec.Code, record.Status
+	}
+	waitForResponse := func(want service.JobStatus) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, status := statusOf(); status == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				code, status := statusOf()
+				t.Fatalf("status = %d %q, want %s", code, status, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if code, _ := statusOf(); code != http.StatusNotFound {
+		t.Fatalf("unknown ID = %d, want 404", code)
+	}
+
+	// Held in the queue until processing resumes
+	es.Pause()
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"Hi","body":"Hello"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+	}
+	waitForResponse(service.StatusQueued)
+
+	es.Resume()
+	<-sending
+	waitForResponse(service.StatusProcessing)
+
+	close(release)
+	waitForResponse(service.StatusRetrying)
+	waitForResponse(service.StatusSent)
+
+	// Forgotten once STATUS_TTL has passed
+	time.Sleep(300 * time.Millisecond)
+	if code, _ := statusOf(); code != http.StatusNotFound {
+		t.Errorf("status after STATUS_TTL = %d, want 404", code)
+	}
+}
+
+func TestSendEmailValidatesCcAndBcc(t *testing.T) {
+	tests := []struct {
+		name     string
+		cc, bcc  string
+		want     int
+		mentions string
+	}{
+		{"none", `[]`, `[]`, http.StatusAccepted, ""},
+		{"valid", `["a@example.com","b@example.com"]`, `["c@example.com"]`, http.StatusAccepted, ""},
+		{"one invalid cc among valid", `["a@example.com","not-an-address"]`, `[]`, http.StatusUnprocessableEntity, "Invalid cc address: not-an-address"},
+		{"invalid bcc", `[]`, `["c@"]`, http.StatusUnprocessableEntity, "Invalid bcc address: c@"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := make(chan models.EmailJob, 1)
+			h, _ := newTestHandler(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+				sent <- job
+				return nil
+			}), nil)
+
+			body := `{"to":"user@example.com","cc":` + tt.cc + `,"bcc":` + tt.bcc + `,"subject":"Hi","body":"Hello"}`
+			rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.mentions != "" {
+				if !strings.Contains(rec.Body.String(), tt.mentions) {
+					t.Errorf("body = %q, want it to mention %q", rec.Body, tt.mentions)
+				}
+				return
+			}
+
+			var want struct{ Cc, Bcc []string }
+			json.Unmarshal([]byte(`{"cc":`+tt.cc+`,"bcc":`+tt.bcc+`}`), &want)
+			select {
+			case job := <-sent:
+				if strings.Join(job.Cc, ",") != strings.Join(want.Cc, ",") || strings.Join(job.Bcc, ",") != strings.Join(want.Bcc, ",") {
+					t.Errorf("sent cc %v bcc %v, want %v and %v", job.Cc, job.Bcc, want.Cc, want.Bcc)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("email not sent")
+			}
+		})
+	}
+}
+
+func TestSendEmailAcceptsScalarAndArrayTo(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+	// Queued jobs stay in the queue to be counted
+	es.Pause()
+
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"one@example.com","subject":"Hi","body":"Hello"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("scalar to = %d: %s", rec.Code, rec.Body)
+	}
+	var single struct {
+		ID string `json:"id"`
+	}
+	decodeData(t, rec, &single)
+	if single.ID == "" {
+		t.Error("scalar to returned no ID")
+	}
+
+	rec = serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":["a@example.com","b@example.com"],"subject":"Hi","body":"Hello"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("array to = %d: %s", rec.Code, rec.Body)
+	}
+	var results []models.RecipientResult
+	decodeData(t, rec, &results)
+	if len(results) != 2 || results[0].To != "a@example.com" || results[1].To != "b@example.com" {
+		t.Fatalf("results = %+v, want one per recipient", results)
+	}
+	if results[0].ID == "" || results[0].ID == results[1].ID {
+		t.Errorf("IDs = %q and %q, want one job per recipient", results[0].ID, results[1].ID)
+	}
+	if got := es.Stats().QueueDepth; got != 3 {
+		t.Errorf("queue depth = %d, want 3 jobs", got)
+	}
+
+	rec = serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":["a@example.com","bad","worse@"],"subject":"Hi","body":"Hello"}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "bad, worse@") {
+		t.Errorf("array with invalid addresses = %d %q, want 422 listing them", rec.Code, rec.Body)
+	}
+	if got := es.Stats().QueueDepth; got != 3 {
+		t.Errorf("queue depth = %d after a rejected request, want it unchanged", got)
+	}
+}
+
+func TestSendEmailRejectsUnparseableSendAt(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	for _, sendAt := range []string{"tomorrow", "2024-01-01 09:00", "2024-13-01T09:00:00Z"} {
+		body := `{"to":"user@example.com","subject":"Later","body":"Hello","send_at":"` + sendAt + `"}`
+		if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("send_at %q = %d, want 422", sendAt, rec.Code)
+		}
+	}
+}
+
+func TestSendEmailSizeLimits(t *testing.T) {
+	send := func(h *EmailHandler, subject, body string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(map[string]string{"to": "user@example.com", "subject": subject, "body": body})
+		return serve(h.SendEmailHandler, http.MethodPost, "/send-email", string(payload))
+	}
+
+	small, _ := newTestHandler(t, acceptAll, map[string]string{"MAX_BODY_BYTES": "1024"})
+	if rec := send(small, "Hi", strings.Repeat("a", 2000)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("request over MAX_BODY_BYTES = %d, want 413", rec.Code)
+	}
+	if rec := send(small, "Hi", strings.Repeat("a", 500)); rec.Code != http.StatusAccepted {
+		t.Errorf("request under MAX_BODY_BYTES = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"MAX_BODY_BYTES": "1048576"})
+	// Counted in characters, not bytes
+	if rec := send(h, strings.Repeat("é", maxSubjectLength), "Hello"); rec.Code != http.StatusAccepted {
+		t.Errorf("subject at the limit = %d, want 202: %s", rec.Code, rec.Body)
+	}
+	rec := send(h, strings.Repeat("a", maxSubjectLength+1), "Hello")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Subject too long") {
+		t.Errorf("over-long subject = %d %q, want 422 saying the subject is too long", rec.Code, rec.Body)
+	}
+}
+
+func TestReadyFailsAboveHighWaterMark(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, map[string]string{"QUEUE_SIZE": "10", "READY_HIGH_WATER": "0.2"})
+	// Queued jobs stay queued
+	es.Pause()
+
+	ready := func() (int, service.Readiness) {
+		rec := serve(h.ReadyHandler, http.MethodGet, "/ready", "")
+		var r service.Readiness
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("decode %q: %v", rec.Body, err)
+		}
+		return rec.Code, r
+	}
+
+	// The worker starts in the background
+	deadline := time.Now().Add(5 * time.Second)
+	code, r := ready()
+	for code != http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		code, r = ready()
+	}
+	if code != http.StatusOK || !r.Ready || r.QueueDepth != 0 || r.Workers != 1 {
+		t.Fatalf("empty queue = %d %+v, want 200 with one worker", code, r)
+	}
+	// A fifth of the normal lane's 10 jobs
+	highWater := 2
+
+	for depth := 1; depth <= highWater; depth++ {
+		if err := es.EnqueueJob(models.EmailJob{To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+		code, r = ready()
+		want := http.StatusOK
+		if depth == highWater {
+			want = http.StatusServiceUnavailable
+		}
+		if code != want || r.QueueDepth != depth {
+			t.Fatalf("depth %d = %d %+v, want %d", depth, code, r, want)
+		}
+	}
+	if r.Ready || len(r.Reasons) == 0 {
+		t.Errorf("at the high-water mark = %+v, want not ready with a reason", r)
+	}
+}
+
+func TestReadyFailsWithOneLaneFull(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, map[string]string{"QUEUE_SIZE": "10"})
+	es.Pause()
+
+	// The worker starts in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for !es.Readiness().Ready {
+		if time.Now().After(deadline) {
+			t.Fatalf("empty queue = %+v, want ready", es.Readiness())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Fills the normal lane, a third of the queue
+	for i := 0; i < 10; i++ {
+		if err := es.EnqueueJob(models.EmailJob{To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+
+	rec := serve(h.ReadyHandler, http.MethodGet, "/ready", "")
+	var r service.Readiness
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || r.QueueDepth != 10 || r.QueueCapacity != 30 {
+		t.Fatalf("ready = %d %+v, want 503 with 10 of 30 queued", rec.Code, r)
+	}
+	if len(r.Reasons) != 1 || !strings.Contains(r.Reasons[0], "normal priority queue") {
+		t.Errorf("reasons = %v, want the normal lane above its high-water mark", r.Reasons)
+	}
+}
+
+func TestSendEmailDecodeErrors(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+	es.Pause()
+
+	valid := `{"to":"user@example.com","subject":"Hi","body":"Hi"}`
+	tests := []struct {
+		name     string
+		body     string
+		want     int
+		wantText string
+	}{
+		{"empty body", "", http.StatusBadRequest, "Request body is empty"},
+		{"whitespace only", " \n", http.StatusBadRequest, "Request body is empty"},
+		{"unknown field", `{"to":"user@example.com","subjct":"Hi","body":"Hi"}`, http.StatusUnprocessableEntity, `Unknown field "subjct"`},
+		{"trailing garbage", valid + "garbage", http.StatusBadRequest, "unexpected data after the request body"},
+		{"second object", valid + valid, http.StatusBadRequest, "unexpected data after the request body"},
+		{"malformed", `{"to":`, http.StatusBadRequest, "Invalid JSON"},
+		{"trailing whitespace", valid + "\n", http.StatusAccepted, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantText) {
+				t.Errorf("body = %q, want it to mention %q", rec.Body, tt.wantText)
+			}
+		})
+	}
+
+	if got := es.Stats().QueueDepth; got != 1 {
+		t.Errorf("queue depth = %d, want only the valid request queued", got)
+	}
+}
+
+func TestStatsReflectQueuedAndProcessedJobs(t *testing.T) {
+	h, es := newTestHandler(t, senderFunc(func(ctx context.Context, job models.EmailJob) error {
+		if job.To == "bounce@example.com" {
+			return errors.New("550 mailbox unavailable")
+		}
+		return nil
+	}), map[string]string{"MAX_RETRIES": "0", "QUEUE_SIZE": "10"})
+
+	for _, to := range []string{"a@example.com", "b@example.com", "bounce@example.com"} {
+		body := `{"to": "` + to + `", "subject": "Hi", "body": "Hi"}`
+		if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusAccepted {
+			t.Fatalf("send to %s: status %d: %s", to, rec.Code, rec.Body)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stats := es.Stats(); stats.Processed != 2 || stats.Failed != 1; stats = es.Stats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v, want 2 processed and 1 failed", stats)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Jobs queued while paused stay in the queue
+	es.Pause()
+	for i := 0; i < 2; i++ {
+		if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to": "c@example.com", "subject": "Hi", "body": "Hi"}`); rec.Code != http.StatusAccepted {
+			t.Fatalf("send while paused: status %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	rec := serve(h.StatsHandler, http.MethodGet, "/admin/stats", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	var stats service.Stats
+	decodeData(t, rec, &stats)
+
+	want := service.Stats{QueueDepth: 2, QueueCapacity: 30, DeadLetterJobs: 1, Workers: 1, Paused: true, Processed: 2, Failed: 1}
+	got := stats
+	got.StartedAt, got.UptimeSeconds = time.Time{}, 0
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+	if stats.StartedAt.IsZero() || stats.UptimeSeconds <= 0 {
+		t.Errorf("started_at %v, uptime %v; want the service's start", stats.StartedAt, stats.UptimeSeconds)
+	}
+}
+
+func TestSendEmailValidatesFromAndReplyTo(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+	es.Pause()
+
+	tests := []struct {
+		name   string
+		fields string
+		want   int
+	}{
+		{"neither", ``, http.StatusAccepted},
+		{"both valid", `,"from":"billing@example.com","reply_to":"support@example.com"`, http.StatusAccepted},
+		{"invalid from", `,"from":"billing"`, http.StatusUnprocessableEntity},
+		{"invalid reply_to", `,"reply_to":"support@"`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"to":"user@example.com","subject":"Hi","body":"Hello"` + tt.fields + `}`
+			if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSendEmailCustomHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  string
+		want     int
+		mentions string
+	}{
+		{"permitted", `{"X-Campaign-ID":"spring","List-Unsubscribe":"<mailto:u@example.com>"}`, http.StatusAccepted, ""},
+		{"empty map", `{}`, http.StatusAccepted, ""},
+		{"protected from", `{"From":"ceo@example.com"}`, http.StatusUnprocessableEntity, "Header From can't be overridden"},
+		{"protected in any case", `{"sUBJECT":"Urgent"}`, http.StatusUnprocessableEntity, "Header Subject can't be overridden"},
+		{"protected to", `{"to":"other@example.com"}`, http.StatusUnprocessableEntity, "Header To can't be overridden"},
+		{"header injection", `{"X-Note":"hi\r\nBcc: spy@example.com"}`, http.StatusUnprocessableEntity, "Invalid value"},
+		{"invalid name", `{"X Note":"hi"}`, http.StatusUnprocessableEntity, "Invalid header name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := make(chan models.EmailJob, 1)
+			h, _ := newTestHandler(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+				sent <- job
+				return nil
+			}), nil)
+
+			body := `{"to":"user@example.com","subject":"Hi","body":"Hello","headers":` + tt.headers + `}`
+			rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.mentions != "" {
+				if !strings.Contains(rec.Body.String(), tt.mentions) {
+					t.Errorf("body = %q, want it to mention %q", rec.Body, tt.mentions)
+				}
+				return
+			}
+
+			var want map[string]string
+			json.Unmarshal([]byte(tt.headers), &want)
+			select {
+			case job := <-sent:
+				if len(job.Headers) != len(want) {
+					t.Fatalf("sent headers %v, want %v", job.Headers, want)
+				}
+				for name, value := range want {
+					if job.Headers[name] != value {
+						t.Errorf("sent header %s = %q, want %q", name, job.Headers[name], value)
+					}
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("email not sent")
+			}
+		})
+	}
+}
+
+func TestSendEmailDomainFiltering(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow string
+		block string
+		to    string
+		want  int
+	}{
+		{"blocked", "", "*.bad.example", "user@mx.bad.example", http.StatusForbidden},
+		{"not blocked", "", "*.bad.example", "user@good.example", http.StatusAccepted},
+		{"allow-only rejects unlisted", "example.com", "", "user@gmail.com", http.StatusForbidden},
+		{"allow-only accepts listed", "example.com", "", "user@example.com", http.StatusAccepted},
+		{"block wins over allow", "*.example.com", "spam.example.com", "user@spam.example.com", http.StatusForbidden},
+		{"allowed and not blocked", "*.example.com", "spam.example.com", "user@qa.example.com", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, es := newTestHandler(t, acceptAll, map[string]string{"ALLOW_DOMAINS": tt.allow, "BLOCK_DOMAINS": tt.block})
+			es.Pause()
+
+			rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"`+tt.to+`","subject":"Hi","body":"Hello"}`)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			wantQueued := 0
+			if tt.want == http.StatusAccepted {
+				wantQueued = 1
+			}
+			if got := es.Stats().QueueDepth; got != wantQueued {
+				t.Errorf("queue depth = %d, want %d", got, wantQueued)
+			}
+		})
+	}
+
+	// A blocked cc rejects the whole email
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"ALLOW_DOMAINS": "", "BLOCK_DOMAINS": "bad.example"})
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","cc":["x@bad.example"],"subject":"Hi","body":"Hello"}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "x@bad.example") {
+		t.Errorf("blocked cc = %d %q, want 403 naming it", rec.Code, rec.Body)
+	}
+}
+
+func TestStatsNeedAKeyWithoutATenant(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	if rec := serveAs(h.StatsHandler, "acme", http.MethodGet, "/admin/stats", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("tenant key = %d, want 403", rec.Code)
+	}
+	if rec := serveAs(h.StatsHandler, "", http.MethodGet, "/admin/stats", ""); rec.Code != http.StatusOK {
+		t.Errorf("key without a tenant = %d, want 200", rec.Code)
+	}
+}
+
+func TestPanicsNeedAKeyWithoutATenant(t *testing.T) {
+	h, es := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		panic("provider blew up")
+	}), map[string]string{"MAX_RETRIES": "0"})
+
+	if rec := serveAs(h.SendEmailHandler, "acme", http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"Hi","body":"Hello"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("send = %d: %s", rec.Code, rec.Body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(es.GetPanics()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("panic not recorded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if rec := serveAs(h.PanicsHandler, "globex", http.MethodGet, "/admin/panics", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("tenant key = %d, want 403", rec.Code)
+	}
+
+	var panics []service.PanicRecord
+	decodeData(t, serveAs(h.PanicsHandler, "", http.MethodGet, "/admin/panics", ""), &panics)
+	if len(panics) != 1 || panics[0].To != "user@example.com" {
+		t.Errorf("panics = %+v, want the one for user@example.com", panics)
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"email-queue-service/service"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength keeps clients from using request-sized keys
+	maxIdempotencyKeyLength = 255
+)
+
+// Idempotent makes POST requests carrying an Idempotency-Key header safe to
+// retry. The first successful response for a key is recorded and replayed to
+// repeats of the same request instead of running it again. A failed request
+// doesn't keep the key, so it can be retried. Keys are scoped to the caller's
+// tenant, so two tenants using the same key don't collide.
+func (h *EmailHandler) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("Idempotency-Key too long (max %d characters)", maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		// The body is part of the request's identity, so read it up front
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("Request body too large (max %d bytes)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key = scopedIdempotencyKey(tenantFromContext(r.Context()), key)
+		fingerprint := requestFingerprint(r.URL.Path, body)
+		replay, err := h.emailService.ClaimIdempotencyKey(key, fingerprint)
+		switch {
+		case errors.Is(err, service.ErrIdempotencyConflict):
+			http.Error(w, "Idempotency-Key was already used for a different request", http.StatusConflict)
+			return
+		case errors.Is(err, service.ErrIdempotencyInProgress):
+			http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+			return
+		case replay != nil:
+			w.Header().Set("Idempotent-Replayed", "true")
+			if h.replayStatus && h.writeCurrentStatus(w, replay) {
+				return
+			}
+			w.Header().Set("Content-Type", replay.ContentType)
+			w.WriteHeader(replay.Status)
+			w.Write(replay.Body)
+			return
+		}
+
+		rec := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		if rec.status >= 200 && rec.status < 300 {
+			h.emailService.CompleteIdempotencyKey(key, fingerprint, service.IdempotentResponse{
+				Status:      rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+			return
+		}
+		h.emailService.ReleaseIdempotencyKey(key, fingerprint)
+	}
+}
+
+// scopedIdempotencyKey returns the key a tenant's Idempotency-Key is stored
+// under. Requests without a tenant share the global scope, the bare key; the
+// separator can't appear in a header, so the scopes can't overlap.
+func scopedIdempotencyKey(tenant, key string) string {
+	if tenant == "" {
+		return key
+	}
+	return tenant + "\x00" + key
+}
+
+// writeCurrentStatus answers a repeat of a request that queued a single job
+// with the job's current status instead of the original acceptance, so a
+// retrying client learns the outcome in one call. It writes nothing and
+// returns false for other responses, such as a bulk request's, or once the
+// job's status has been forgotten.
+func (h *EmailHandler) writeCurrentStatus(w http.ResponseWriter, replay *service.IdempotentResponse) bool {
+	var original struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(replay.Body, &original); err != nil || original.Data.ID == "" {
+		return false
+	}
+
+	status, ok := h.emailService.JobStatus(original.Data.ID)
+	if !ok {
+		return false
+	}
+	if h.redactContent {
+		status = status.Redacted()
+	}
+	writeEnvelope(w, replay.Status, status, nil)
+	return true
+}
+
+// requestFingerprint identifies a request by its path and exact body
+func requestFingerprint(path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordingResponseWriter passes a response through while keeping a copy of
+// its status and body
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+// WriteHeader records the status and sends it
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records p and sends it
+func (rw *recordingResponseWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
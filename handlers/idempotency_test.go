@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+	"email-queue-service/service"
+)
+
+// postWithKey sends body to /send-email through the idempotency middleware
+// with an Idempotency-Key
+func postWithKey(h *EmailHandler, key, body string) *httptest.ResponseRecorder {
+	return postWithKeyAs(h, "", key, body)
+}
+
+// postWithKeyAs is postWithKey for a tenant's request
+func postWithKeyAs(h *EmailHandler, tenant, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/send-email", strings.NewReader(body))
+	req = req.WithContext(withTenant(req.Context(), tenant))
+	req.Header.Set(idempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	h.Idempotent(h.SendEmailHandler)(rec, req)
+	return rec
+}
+
+func TestIdempotentRepeatReportsCurrentStatus(t *testing.T) {
+	const body = `{"to":"user@example.com","subject":"Hi","body":"Hello"}`
+	rejectAll := senderFunc(func(context.Context, models.EmailJob) error {
+		return service.Permanent(errors.New("550 mailbox unavailable"))
+	})
+
+	tests := []struct {
+		name   string
+		sender service.EmailSender
+		pause  bool
+		want   service.JobStatus
+	}{
+		{"queued", acceptAll, true, service.StatusQueued},
+		{"sent", acceptAll, false, service.StatusSent},
+		{"dead lettered", rejectAll, false, service.StatusDeadLettered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, es := newTestHandler(t, tt.sender, map[string]string{"IDEMPOTENT_REPLAY": "status"})
+			if tt.pause {
+				es.Pause()
+				t.Cleanup(es.Resume)
+			}
+
+			first := postWithKey(h, "key-1", body)
+			if first.Code != http.StatusAccepted {
+				t.Fatalf("first request = %d, want 202: %s", first.Code, first.Body)
+			}
+			var accepted struct{ ID string }
+			decodeData(t, first, &accepted)
+			waitForStatus(t, es, accepted.ID, tt.want)
+
+			repeat := postWithKey(h, "key-1", body)
+			if repeat.Code != http.StatusAccepted || repeat.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatalf("repeat = %d replayed %q, want a replayed 202", repeat.Code, repeat.Header().Get("Idempotent-Replayed"))
+			}
+			var current service.JobStatusRecord
+			decodeData(t, repeat, &current)
+			if current.ID != accepted.ID || current.Status != tt.want {
+				t.Errorf("repeat reported %s %s, want %s %s", current.ID, current.Status, accepted.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdempotentRepeatReplaysResponseByDefault(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+	const body = `{"to":"user@example.com","subject":"Hi","body":"Hello"}`
+
+	first := postWithKey(h, "key-1", body)
+	var accepted struct{ ID string }
+	decodeData(t, first, &accepted)
+	waitForStatus(t, es, accepted.ID, service.StatusSent)
+
+	repeat := postWithKey(h, "key-1", body)
+	if repeat.Body.String() != first.Body.String() {
+		t.Errorf("repeat = %s, want the original response %s", repeat.Body, first.Body)
+	}
+}
+
+func TestIdempotentStatusReplayKeepsBulkResponse(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"IDEMPOTENT_REPLAY": "status"})
+	const body = `{"to":["a@example.com","b@example.com"],"subject":"Hi","body":"Hello"}`
+
+	first := postWithKey(h, "key-1", body)
+	repeat := postWithKey(h, "key-1", body)
+	if repeat.Code != first.Code || repeat.Body.String() != first.Body.String() {
+		t.Errorf("repeat of a fan-out = %d %s, want the original %d %s", repeat.Code, repeat.Body, first.Code, first.Body)
+	}
+}
+
+func TestIdempotencyKeysAreScopedPerTenant(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+	const body = `{"to":"user@example.com","subject":"Hi","body":"Hello"}`
+
+	queued := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var accepted struct{ ID string }
+		decodeData(t, rec, &accepted)
+		return accepted.ID
+	}
+
+	acme := postWithKeyAs(h, "acme", "key-1", body)
+	globex := postWithKeyAs(h, "globex", "key-1", body)
+	global := postWithKey(h, "key-1", body)
+	if globex.Header().Get("Idempotent-Replayed") != "" || global.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatal("another tenant's key was replayed")
+	}
+	ids := map[string]bool{queued(acme): true, queued(globex): true, queued(global): true}
+	if len(ids) != 3 {
+		t.Errorf("queued IDs = %v, want one email per tenant", ids)
+	}
+
+	repeat := postWithKeyAs(h, "acme", "key-1", body)
+	if repeat.Header().Get("Idempotent-Replayed") != "true" || queued(repeat) != queued(acme) {
+		t.Errorf("repeat within acme = %s, want the original %s replayed", repeat.Body, acme.Body)
+	}
+}
+
+func TestIdempotencyHitConflictAndExpiry(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, map[string]string{"IDEMPOTENCY_TTL": "100ms"})
+	// Queued jobs stay queued to be counted
+	es.Pause()
+	const body = `{"to":"user@example.com","subject":"Hi","body":"Hello"}`
+	idOf := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var accepted struct{ ID string }
+		decodeData(t, rec, &accepted)
+		return accepted.ID
+	}
+
+	first := postWithKey(h, "key-1", body)
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("first request = %d: %s", first.Code, first.Body)
+	}
+
+	hit := postWithKey(h, "key-1", body)
+	if hit.Code != http.StatusAccepted || idOf(hit) != idOf(first) {
+		t.Errorf("repeat = %d %s, want the original 202", hit.Code, hit.Body)
+	}
+	if got := es.Stats().QueueDepth; got != 1 {
+		t.Errorf("queue depth after a repeat = %d, want 1", got)
+	}
+
+	conflict := postWithKey(h, "key-1", `{"to":"other@example.com","subject":"Hi","body":"Hello"}`)
+	if conflict.Code != http.StatusConflict {
+		t.Errorf("same key with another email = %d, want 409", conflict.Code)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	expired := postWithKey(h, "key-1", body)
+	if expired.Code != http.StatusAccepted || expired.Header().Get("Idempotent-Replayed") != "" || idOf(expired) == idOf(first) {
+		t.Errorf("request after IDEMPOTENCY_TTL = %d %s, want a new job", expired.Code, expired.Body)
+	}
+	if got := es.Stats().QueueDepth; got != 2 {
+		t.Errorf("queue depth after the key expired = %d, want 2", got)
+	}
+}
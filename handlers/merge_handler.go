@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"email-queue-service/models"
+)
+
+// SendMergeHandler handles POST /send-merge requests
+func (h *EmailHandler) SendMergeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req models.MergeRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Subject == "" || req.Body == "" || len(req.Recipients) == 0 {
+		http.Error(w, "All fields (subject, body, recipients) are required", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if len(req.Recipients) > h.maxMergeRecipients {
+		http.Error(w, fmt.Sprintf("Too many recipients (max %d)", h.maxMergeRecipients), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Parse templates once and fail on variables a recipient doesn't provide
+	subjectTmpl, err := template.New("subject").Option("missingkey=error").Parse(req.Subject)
+	if err != nil {
+		http.Error(w, "Invalid subject template: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	bodyTmpl, err := template.New("body").Option("missingkey=error").Parse(req.Body)
+	if err != nil {
+		http.Error(w, "Invalid body template: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	results := make([]models.RecipientResult, len(req.Recipients))
+	accepted := 0
+	for i, recipient := range req.Recipients {
+		results[i] = models.RecipientResult{Index: i, To: recipient.To}
+
+		id, err := h.enqueueMergeRecipient(r.Context(), subjectTmpl, bodyTmpl, recipient)
+		if err != nil {
+			results[i].Status = "rejected"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		results[i].ID = id
+		results[i].Status = "accepted"
+		accepted++
+	}
+
+	writeEnvelope(w, http.StatusAccepted, results, map[string]int{
+		"accepted": accepted,
+		"rejected": len(results) - accepted,
+	})
+}
+
+// enqueueMergeRecipient renders the templates for one recipient and enqueues
+// the job, returning its ID. The rendered email goes through the same checks
+// as one sent to /send-email.
+func (h *EmailHandler) enqueueMergeRecipient(ctx context.Context, subjectTmpl, bodyTmpl *template.Template, recipient models.MergeRecipient) (string, error) {
+	subject, err := renderTemplate(subjectTmpl, recipient.Variables)
+	if err != nil {
+		return "", fmt.Errorf("render subject: %w", err)
+	}
+	body, err := renderTemplate(bodyTmpl, recipient.Variables)
+	if err != nil {
+		return "", fmt.Errorf("render body: %w", err)
+	}
+
+	req := models.EmailRequest{
+		To:      models.Recipients{recipient.To},
+		Subject: subject,
+		Body:    body,
+	}
+	jobs, _, reqErr := h.buildJobs(ctx, &req, "")
+	if reqErr != nil {
+		return "", errors.New(reqErr.message)
+	}
+
+	if err := h.emailService.EnqueueJob(jobs[0]); err != nil {
+		return "", err
+	}
+	return jobs[0].ID, nil
+}
+
+// renderTemplate executes tmpl with the given variables
+func renderTemplate(tmpl *template.Template, variables map[string]string) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
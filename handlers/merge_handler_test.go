@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+// mergeBody builds a /send-merge request body
+func mergeBody(t *testing.T, req models.MergeRequest) string {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal merge request: %v", err)
+	}
+	return string(body)
+}
+
+func TestSendMergeRendersPerRecipient(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+
+	rec := serve(h.SendMergeHandler, http.MethodPost, "/send-merge", mergeBody(t, models.MergeRequest{
+		Subject: "Hi {{.name}}",
+		Body:    "Order {{.order}} shipped",
+		Recipients: []models.MergeRecipient{
+			{To: "ada@example.com", Variables: map[string]string{"name": "Ada", "order": "1001"}},
+			{To: "bob@example.com", Variables: map[string]string{"name": "Bob"}},
+			{To: "not-an-address", Variables: map[string]string{"name": "X", "order": "1"}},
+		},
+	}))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	var results []models.RecipientResult
+	decodeData(t, rec, &results)
+	if len(results) != 3 {
+		t.Fatalf("results = %+v, want 3", results)
+	}
+	if results[0].Status != "accepted" || results[0].ID == "" {
+		t.Errorf("ada = %+v, want accepted with an ID", results[0])
+	}
+	if results[1].Status != "rejected" || !strings.Contains(results[1].Error, "order") {
+		t.Errorf("bob = %+v, want rejected for the missing variable", results[1])
+	}
+	if results[2].Status != "rejected" {
+		t.Errorf("invalid address = %+v, want rejected", results[2])
+	}
+
+	waitForStatus(t, es, results[0].ID, "sent")
+}
+
+func TestSendMergeEnforcesLimitsAfterRendering(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	// The body template repeats its variable, so a recipient's email can be
+	// over the limit while the request itself is well within MAX_BODY_BYTES
+	rec := serve(h.SendMergeHandler, http.MethodPost, "/send-merge", mergeBody(t, models.MergeRequest{
+		Subject: "{{.subject}}",
+		Body:    "{{.body}}{{.body}}",
+		Recipients: []models.MergeRecipient{
+			{To: "ok@example.com", Variables: map[string]string{"subject": "Hi", "body": "Hello"}},
+			{To: "long-subject@example.com", Variables: map[string]string{"subject": strings.Repeat("s", maxSubjectLength+1), "body": "Hello"}},
+			{To: "max-subject@example.com", Variables: map[string]string{"subject": strings.Repeat("é", maxSubjectLength), "body": "Hello"}},
+			{To: "big-body@example.com", Variables: map[string]string{"subject": "Hi", "body": strings.Repeat("b", maxEmailBodyBytes/2+1)}},
+		},
+	}))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	var results []models.RecipientResult
+	decodeData(t, rec, &results)
+
+	want := []struct {
+		status string
+		error  string
+	}{
+		{"accepted", ""},
+		{"rejected", fmt.Sprintf("Subject too long (max %d characters)", maxSubjectLength)},
+		{"accepted", ""},
+		{"rejected", fmt.Sprintf("Body too large (max %d bytes)", maxEmailBodyBytes)},
+	}
+	for i, w := range want {
+		if results[i].Status != w.status || results[i].Error != w.error {
+			t.Errorf("recipient %d = %s %q, want %s %q", i, results[i].Status, results[i].Error, w.status, w.error)
+		}
+	}
+}
+
+func TestSendMergeRejectsWhatSendEmailRejects(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{
+		"BLOCK_DOMAINS":          "blocked.example",
+		"REJECT_TRACKING_PIXELS": "true",
+	})
+
+	recipients := []models.MergeRecipient{
+		{To: "user@blocked.example", Variables: map[string]string{"body": "Hello"}},
+		{To: "Ada <ada@example.com>", Variables: map[string]string{"body": "Hello"}},
+		{To: "user@example.com", Variables: map[string]string{"body": `<img src="https://t.example/p.gif" width="1" height="1">`}},
+	}
+	rec := serve(h.SendMergeHandler, http.MethodPost, "/send-merge", mergeBody(t, models.MergeRequest{
+		Subject:    "Hi",
+		Body:       "{{.body}}",
+		Recipients: recipients,
+	}))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	var results []models.RecipientResult
+	decodeData(t, rec, &results)
+	for i, recipient := range recipients {
+		body, err := json.Marshal(models.EmailRequest{To: models.Recipients{recipient.To}, Subject: "Hi", Body: recipient.Variables["body"]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		single := serve(h.SendEmailHandler, http.MethodPost, "/send-email", string(body))
+		if single.Code == http.StatusAccepted {
+			t.Fatalf("/send-email accepted recipient %d", i)
+		}
+		if want := strings.TrimSpace(single.Body.String()); results[i].Status != "rejected" || results[i].Error != want {
+			t.Errorf("recipient %d = %s %q, want rejected %q as from /send-email", i, results[i].Status, results[i].Error, want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// requireMethod reports whether the request uses one of the allowed methods.
+// Otherwise it responds 405 with an Allow header listing them.
+func requireMethod(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	for _, method := range allowed {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWrongMethodListsAllowedMethods(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		allow   string
+	}{
+		{"send email", h.SendEmailHandler, http.MethodGet, "/send-email", "POST"},
+		{"send bulk", h.SendBulkHandler, http.MethodGet, "/send-email/bulk", "POST"},
+		{"dead letters", h.DeadLetterHandler, http.MethodPost, "/dead-letter", "GET, DELETE"},
+		{"retry dead letters", h.RetryDeadLetterHandler, http.MethodGet, "/dead-letter/retry", "POST"},
+		{"email status", h.EmailStatusHandler, http.MethodDelete, "/email-status?id=a", "GET"},
+		{"health", h.HealthHandler, http.MethodPost, "/health", "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handler, tt.method, tt.target, "")
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s = %d, want 405", tt.method, tt.target, rec.Code)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
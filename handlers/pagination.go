@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// page is a window into a listing selected by the limit and offset query parameters
+type page struct {
+	limit  int
+	offset int
+}
+
+// pageMeta describes a page in the response envelope. NextOffset is omitted on
+// the last page.
+type pageMeta struct {
+	Count      int  `json:"count"`
+	Total      int  `json:"total"`
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// parsePage reads limit and offset from the query string. Values that aren't
+// numbers fall back to the defaults and out-of-range ones are clamped, so a
+// bad parameter never fails the request.
+func parsePage(r *http.Request) page {
+	query := r.URL.Query()
+
+	limit := queryInt(query.Get("limit"), defaultPageLimit)
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	offset := queryInt(query.Get("offset"), 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	return page{limit: limit, offset: offset}
+}
+
+// bounds returns the slice indices of the page within total items
+func (p page) bounds(total int) (start, end int) {
+	start = min(p.offset, total)
+	end = min(start+p.limit, total)
+	return start, end
+}
+
+// meta describes the page for a listing of total items
+func (p page) meta(total int) pageMeta {
+	start, end := p.bounds(total)
+	meta := pageMeta{
+		Count:  end - start,
+		Total:  total,
+		Limit:  p.limit,
+		Offset: p.offset,
+	}
+	if end < total {
+		meta.NextOffset = &end
+	}
+	return meta
+}
+
+// queryInt parses a query parameter, returning defaultValue if it is missing or not a number
+func queryInt(value string, defaultValue int) int {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	return defaultValue
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPageBoundaries(t *testing.T) {
+	const total = 120
+	next := func(n int) *int { return &n }
+
+	tests := []struct {
+		query string
+		want  pageMeta
+	}{
+		{"", pageMeta{Count: 50, Total: total, Limit: 50, Offset: 0, NextOffset: next(50)}},
+		{"limit=50&offset=50", pageMeta{Count: 50, Total: total, Limit: 50, Offset: 50, NextOffset: next(100)}},
+		{"limit=50&offset=100", pageMeta{Count: 20, Total: total, Limit: 50, Offset: 100}},
+		{"limit=50&offset=70", pageMeta{Count: 50, Total: total, Limit: 50, Offset: 70}},
+		{"limit=10&offset=119", pageMeta{Count: 1, Total: total, Limit: 10, Offset: 119}},
+		{"offset=120", pageMeta{Count: 0, Total: total, Limit: 50, Offset: 120}},
+		{"offset=500", pageMeta{Count: 0, Total: total, Limit: 50, Offset: 500}},
+		{"limit=1000", pageMeta{Count: total, Total: total, Limit: maxPageLimit, Offset: 0}},
+		{"limit=0", pageMeta{Count: 1, Total: total, Limit: 1, Offset: 0, NextOffset: next(1)}},
+		{"limit=-5&offset=-5", pageMeta{Count: 1, Total: total, Limit: 1, Offset: 0, NextOffset: next(1)}},
+		{"limit=many&offset=some", pageMeta{Count: 50, Total: total, Limit: 50, Offset: 0, NextOffset: next(50)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			p := parsePage(httptest.NewRequest(http.MethodGet, "/dead-letter?"+tt.query, nil))
+			got := p.meta(total)
+
+			gotNext, wantNext := -1, -1
+			if got.NextOffset != nil {
+				gotNext = *got.NextOffset
+			}
+			if tt.want.NextOffset != nil {
+				wantNext = *tt.want.NextOffset
+			}
+			got.NextOffset, tt.want.NextOffset = nil, nil
+			if got != tt.want || gotNext != wantNext {
+				t.Errorf("meta = %+v next %d, want %+v next %d", got, gotNext, tt.want, wantNext)
+			}
+
+			if start, end := p.bounds(total); end-start != tt.want.Count || start > end {
+				t.Errorf("bounds = [%d, %d), want %d items", start, end, tt.want.Count)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+	"email-queue-service/service"
+)
+
+func TestPauseAndResumeNeedAKeyWithoutATenant(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+
+	if rec := serveAs(h.PauseHandler, "acme", http.MethodPost, "/admin/pause", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("pause with a tenant key = %d, want 403", rec.Code)
+	}
+	if es.Paused() {
+		t.Fatal("a tenant's key paused the service")
+	}
+
+	es.Pause()
+	if rec := serveAs(h.ResumeHandler, "acme", http.MethodPost, "/admin/resume", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("resume with a tenant key = %d, want 403", rec.Code)
+	}
+	if !es.Paused() {
+		t.Error("a tenant's key resumed the service")
+	}
+}
+
+func TestSyncSendWhilePaused(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	if rec := serve(h.PauseHandler, http.MethodPost, "/admin/pause", ""); rec.Code != http.StatusOK {
+		t.Fatalf("pause = %d, want 200", rec.Code)
+	}
+
+	body := `{"to":"user@example.com","subject":"Hi","body":"Hello","mode":"sync"}`
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("sync send while paused = %d, want 503", rec.Code)
+	}
+
+	// Queued email is still accepted while paused
+	async := `{"to":"user@example.com","subject":"Hi","body":"Hello"}`
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", async); rec.Code != http.StatusAccepted {
+		t.Fatalf("async send while paused = %d, want 202", rec.Code)
+	}
+
+	if rec := serve(h.ResumeHandler, http.MethodPost, "/admin/resume", ""); rec.Code != http.StatusOK {
+		t.Fatalf("resume = %d, want 200", rec.Code)
+	}
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusOK {
+		t.Fatalf("sync send after resume = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPauseRequiresPost(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	rec := serve(h.PauseHandler, http.MethodGet, "/admin/pause", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET /admin/pause = %d with Allow %q, want 405 with POST", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestJobsAccumulateWhilePausedAndDrainAfterResume(t *testing.T) {
+	var sent atomic.Int32
+	h, _ := newTestHandler(t, senderFunc(func(context.Context, models.EmailJob) error {
+		sent.Add(1)
+		return nil
+	}), nil)
+
+	stats := func() service.Stats {
+		t.Helper()
+		var stats service.Stats
+		decodeData(t, serve(h.StatsHandler, http.MethodGet, "/admin/stats", ""), &stats)
+		return stats
+	}
+
+	if rec := serve(h.PauseHandler, http.MethodPost, "/admin/pause", ""); rec.Code != http.StatusOK {
+		t.Fatalf("pause = %d, want 200", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", `{"to":"user@example.com","subject":"Hi","body":"Hello"}`); rec.Code != http.StatusAccepted {
+			t.Fatalf("send while paused = %d, want 202", rec.Code)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := stats(); !got.Paused || got.QueueDepth != 3 || sent.Load() != 0 {
+		t.Fatalf("while paused: stats %+v, %d sent; want paused with 3 queued and none sent", got, sent.Load())
+	}
+
+	if rec := serve(h.ResumeHandler, http.MethodPost, "/admin/resume", ""); rec.Code != http.StatusOK {
+		t.Fatalf("resume = %d, want 200", rec.Code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	got := stats()
+	for got.Processed != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("after resume: stats %+v, want all 3 processed", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+		got = stats()
+	}
+	if got.Paused || got.QueueDepth != 0 || sent.Load() != 3 {
+		t.Errorf("after resume: stats %+v, %d sent; want unpaused with the queue drained", got, sent.Load())
+	}
+}
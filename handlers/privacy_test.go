@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIdentifyingHeadersRejected(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"IDENTIFYING_HEADERS": "reject"})
+
+	body := `{"to":"user@example.com","subject":"Hi","body":"Hello","headers":{"x-mailer":"Outlook 16.0"}}`
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "X-Mailer") {
+		t.Fatalf("status = %d %q, want 422 naming X-Mailer", rec.Code, rec.Body)
+	}
+
+	bulk := `[{"to":"user@example.com","subject":"Hi","body":"Hello","headers":{"User-Agent":"curl"}}]`
+	rec = serve(h.SendBulkHandler, http.MethodPost, "/send-email/bulk", bulk)
+	if !strings.Contains(rec.Body.String(), "User-Agent") || !strings.Contains(rec.Body.String(), "rejected") {
+		t.Errorf("bulk response = %d %s, want the email rejected", rec.Code, rec.Body)
+	}
+
+	body = `{"to":"user@example.com","subject":"Hi","body":"Hello","headers":{"X-Campaign-ID":"spring"}}`
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusAccepted {
+		t.Errorf("other header: status = %d %q, want 202", rec.Code, rec.Body)
+	}
+}
+
+func TestIdentifyingHeadersAcceptedWhenStripped(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"IDENTIFYING_HEADERS": "strip"})
+
+	body := `{"to":"user@example.com","subject":"Hi","body":"Hello","headers":{"X-Mailer":"Outlook 16.0"}}`
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", body); rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d %q, want 202", rec.Code, rec.Body)
+	}
+}
+
+func TestTrackingPixelsRejectedWhenConfigured(t *testing.T) {
+	const pixel = `{"to":"user@example.com","subject":"Hi","body":"<p>Hello</p><img src=\"https://example.com/open/42.gif\" width=\"1\" height=\"1\">"}`
+
+	h, _ := newTestHandler(t, acceptAll, map[string]string{"REJECT_TRACKING_PIXELS": "true"})
+	rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", pixel)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "tracking pixel") {
+		t.Errorf("status = %d %q, want 422 for the tracking pixel", rec.Code, rec.Body)
+	}
+
+	h, _ = newTestHandler(t, acceptAll, map[string]string{"REJECT_TRACKING_PIXELS": "false"})
+	if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", pixel); rec.Code != http.StatusAccepted {
+		t.Errorf("status without the check = %d %q, want 202", rec.Code, rec.Body)
+	}
+}
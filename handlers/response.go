@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// envelope is the shared shape of every successful JSON response
+type envelope struct {
+	Data interface{} `json:"data"`
+	Meta interface{} `json:"meta,omitempty"`
+}
+
+// writeEnvelope writes data and optional meta as a JSON envelope with the given status
+func writeEnvelope(w http.ResponseWriter, status int, data, meta interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(envelope{Data: data, Meta: meta})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSuccessResponsesShareTheEnvelope(t *testing.T) {
+	h, es := newTestHandler(t, acceptAll, nil)
+	// Queued emails stay queued, so the responses don't race the workers
+	es.Pause()
+
+	email := `{"to":"user@example.com","subject":"Hi","body":"Hi"}`
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		method   string
+		target   string
+		body     string
+		want     int
+		wantMeta bool
+	}{
+		{"send email", h.SendEmailHandler, http.MethodPost, "/send-email", email, http.StatusAccepted, false},
+		{"send bulk", h.SendBulkHandler, http.MethodPost, "/send-email/bulk", "[" + email + "]", http.StatusAccepted, true},
+		{"dead letters", h.DeadLetterHandler, http.MethodGet, "/dead-letter", "", http.StatusOK, true},
+		{"purge dead letters", h.DeadLetterHandler, http.MethodDelete, "/dead-letter", "", http.StatusOK, false},
+		{"stats", h.StatsHandler, http.MethodGet, "/admin/stats", "", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handler, tt.method, tt.target, tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			if data, ok := fields["data"]; !ok || string(data) == "null" {
+				t.Errorf("response %s has no data", rec.Body)
+			}
+			if _, ok := fields["meta"]; ok != tt.wantMeta {
+				t.Errorf("response %s has meta %v, want %v", rec.Body, ok, tt.wantMeta)
+			}
+			for key := range fields {
+				if key != "data" && key != "meta" {
+					t.Errorf("response has %q outside the envelope", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"email-queue-service/models"
+	"email-queue-service/service"
+)
+
+// templateIDPattern is what a template ID may look like
+var templateIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// TemplatesHandler handles GET and POST /templates requests
+func (h *EmailHandler) TemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		templates := h.emailService.Templates()
+		writeEnvelope(w, http.StatusOK, templates, map[string]int{"count": len(templates)})
+		return
+	}
+
+	var req models.EmailTemplate
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.ID == "" || req.Subject == "" || req.Body == "" {
+		http.Error(w, "All fields (id, subject, body) are required", http.StatusUnprocessableEntity)
+		return
+	}
+	if !templateIDPattern.MatchString(req.ID) {
+		http.Error(w, "Invalid template id (1-64 letters, digits, '.', '_' or '-')", http.StatusUnprocessableEntity)
+		return
+	}
+	if len(req.Body) > maxEmailBodyBytes {
+		http.Error(w, fmt.Sprintf("Body too large (max %d bytes)", maxEmailBodyBytes), http.StatusUnprocessableEntity)
+		return
+	}
+
+	saved, err := h.emailService.SaveTemplate(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTemplate):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrTooManyTemplates):
+			http.Error(w, "Too many templates", http.StatusInsufficientStorage)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeEnvelope(w, http.StatusCreated, saved, nil)
+}
+
+// renderRequestTemplate fills in the subject and body of a request that
+// references a template, and reports whether the body is HTML
+func (h *EmailHandler) renderRequestTemplate(req *models.EmailRequest) (bool, *requestError) {
+	if req.TemplateID == "" {
+		return false, nil
+	}
+
+	if req.Subject != "" || req.Body != "" {
+		return false, invalidRequest("subject and body can't be combined with template_id")
+	}
+
+	rendered, err := h.emailService.RenderTemplate(req.TemplateID, req.Data)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTemplateNotFound):
+			return false, &requestError{status: http.StatusNotFound, message: "Template not found: " + req.TemplateID}
+		case errors.Is(err, service.ErrTemplateData):
+			return false, invalidRequest(err.Error())
+		default:
+			return false, &requestError{status: http.StatusInternalServerError, message: err.Error()}
+		}
+	}
+
+	req.Subject = rendered.Subject
+	req.Body = rendered.Body
+	return rendered.HTML, nil
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestSendEmailRendersTemplates(t *testing.T) {
+	sent := make(chan models.EmailJob, 2)
+	h, _ := newTestHandler(t, senderFunc(func(ctx context.Context, job models.EmailJob) error {
+		sent <- job
+		return nil
+	}), nil)
+
+	for _, tmpl := range []string{
+		`{"id": "welcome", "subject": "Hi {{.Name}}", "body": "Welcome to {{.Product}}"}`,
+		`{"id": "welcome-html", "subject": "Hi {{.Name}}", "body": "<p>Welcome {{.Name}}</p>", "html": true}`,
+	} {
+		if rec := serve(h.TemplatesHandler, http.MethodPost, "/templates", tmpl); rec.Code != http.StatusCreated {
+			t.Fatalf("register template: status %d, want 201: %s", rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		request     string
+		wantSubject string
+		wantBody    string
+		wantHTML    bool
+	}{
+		{
+			name:        "text",
+			request:     `{"to": "user@example.com", "template_id": "welcome", "data": {"Name": "Ada", "Product": "Queue"}}`,
+			wantSubject: "Hi Ada",
+			wantBody:    "Welcome to Queue",
+		},
+		{
+			name:        "html escapes values",
+			request:     `{"to": "user@example.com", "template_id": "welcome-html", "data": {"Name": "<b>Ada</b>"}}`,
+			wantSubject: "Hi <b>Ada</b>",
+			wantBody:    "<p>Welcome &lt;b&gt;Ada&lt;/b&gt;</p>",
+			wantHTML:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", tt.request); rec.Code != http.StatusAccepted {
+				t.Fatalf("status %d, want 202: %s", rec.Code, rec.Body)
+			}
+			select {
+			case job := <-sent:
+				if job.Subject != tt.wantSubject || job.Body != tt.wantBody || job.HTML != tt.wantHTML {
+					t.Errorf("sent subject %q body %q html %v, want %q %q %v",
+						job.Subject, job.Body, job.HTML, tt.wantSubject, tt.wantBody, tt.wantHTML)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("templated email never sent")
+			}
+		})
+	}
+}
+
+func TestSendEmailTemplateErrors(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	tmpl := `{"id": "welcome", "subject": "Hi {{.Name}}", "body": "Welcome to {{.Product}}"}`
+	if rec := serve(h.TemplatesHandler, http.MethodPost, "/templates", tmpl); rec.Code != http.StatusCreated {
+		t.Fatalf("register template: status %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		want    int
+	}{
+		{"unknown template", `{"to": "user@example.com", "template_id": "missing", "data": {"Name": "Ada"}}`, http.StatusNotFound},
+		{"missing variable", `{"to": "user@example.com", "template_id": "welcome", "data": {"Name": "Ada"}}`, http.StatusUnprocessableEntity},
+		{"no data", `{"to": "user@example.com", "template_id": "welcome"}`, http.StatusUnprocessableEntity},
+		{"template with body", `{"to": "user@example.com", "template_id": "welcome", "body": "Hi", "data": {"Name": "Ada", "Product": "Queue"}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(h.SendEmailHandler, http.MethodPost, "/send-email", tt.request); rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestTemplatesRejectsUnparseableTemplate(t *testing.T) {
+	h, _ := newTestHandler(t, acceptAll, nil)
+
+	rec := serve(h.TemplatesHandler, http.MethodPost, "/templates", `{"id": "broken", "subject": "Hi {{.Name", "body": "Hi"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422", rec.Code)
+	}
+}
@@ -2,17 +2,17 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"email-queue-service/config"
 	"email-queue-service/handlers"
 	"email-queue-service/service"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -20,31 +20,113 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
+	// Log JSON lines; the standard log package is routed through the same handler
+	var level slog.Level
+	levelErr := level.UnmarshalText([]byte(cfg.LogLevel))
+	logHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(logHandler))
+	if levelErr != nil {
+		fatal("Invalid LOG_LEVEL (expected debug, info, warn or error)", levelErr)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	// Choose how emails are delivered
+	sender, err := service.NewSender(cfg)
+	if err != nil {
+		fatal("Invalid email provider configuration", err)
+	}
+	for _, provider := range sender.Providers {
+		slog.Info("Email provider configured", "provider", provider.Name())
+	}
+
 	// Create email service
-	emailService := service.NewEmailService(cfg.Workers, cfg.QueueSize)
+	emailService, err := service.NewEmailService(cfg, sender, prometheus.DefaultRegisterer)
+	if err != nil {
+		fatal("Failed to create email service", err)
+	}
 	emailService.Start()
 
 	// Create HTTP handler
-	emailHandler := handlers.NewEmailHandler(emailService)
+	emailHandler := handlers.NewEmailHandler(emailService, cfg)
+
+	// Large read endpoints are gzipped when enabled
+	compress := func(next http.HandlerFunc) http.HandlerFunc {
+		if cfg.CompressMinBytes <= 0 {
+			return next
+		}
+		return handlers.Compress(cfg.CompressMinBytes, next)
+	}
 
 	// Setup HTTP routes
 	mux := http.NewServeMux()
-	mux.HandleFunc("/send-email", emailHandler.SendEmailHandler)
-	mux.HandleFunc("/dead-letter", emailHandler.DeadLetterHandler)
-	mux.HandleFunc("/health", handlers.HealthHandler)
+	mux.HandleFunc("/send-email", emailHandler.Idempotent(emailHandler.SendEmailHandler))
+	mux.HandleFunc("/send-email/bulk", emailHandler.LimitBulk(emailHandler.Idempotent(emailHandler.SendBulkHandler)))
+	mux.HandleFunc("/send-merge", emailHandler.LimitBulk(emailHandler.Idempotent(emailHandler.SendMergeHandler)))
+	mux.HandleFunc("/templates", emailHandler.TemplatesHandler)
+	mux.HandleFunc("/email-status", emailHandler.EmailStatusHandler)
+	mux.HandleFunc("/dead-letter", compress(emailHandler.DeadLetterHandler))
+	mux.HandleFunc("/dead-letter/retry", emailHandler.RetryDeadLetterHandler)
+	mux.HandleFunc("/stats/latency", compress(emailHandler.LatencyStatsHandler))
+	mux.HandleFunc("/admin/stats", emailHandler.StatsHandler)
+	mux.HandleFunc("/admin/dead-letter", compress(emailHandler.AdminDeadLetterHandler))
+	mux.HandleFunc("/admin/pause", emailHandler.PauseHandler)
+	mux.HandleFunc("/admin/resume", emailHandler.ResumeHandler)
+	mux.HandleFunc("/admin/panics", emailHandler.PanicsHandler)
+	mux.HandleFunc("/admin/workers", emailHandler.WorkersHandler)
+	mux.HandleFunc("/health", emailHandler.HealthHandler)
+	mux.HandleFunc("/ready", emailHandler.ReadyHandler)
 	mux.Handle("/metrics", promhttp.Handler())
 
+	// Everything but health checks and metrics needs an API key when keys are configured
+	var handler http.Handler = mux
+	if len(cfg.APIKeys) > 0 {
+		var legacy []string
+		if cfg.DeadLetterLegacyAccess {
+			slog.Warn("DEAD_LETTER_LEGACY_ACCESS is set, GET /dead-letter is open to anonymous requests")
+			legacy = []string{"/dead-letter"}
+		}
+		handler = handlers.RequireAPIKey(cfg.APIKeys, []string{"/health", "/ready", "/metrics"}, legacy, mux)
+	} else {
+		slog.Warn("API_KEYS is not set, all endpoints are open to anonymous requests")
+	}
+
+	// CORS goes outside authentication, since browsers send preflights without credentials
+	if len(cfg.CORSOrigins) > 0 {
+		handler = handlers.CORS(cfg.CORSOrigins, handler)
+	}
+
 	// Create HTTP server
 	server := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: mux,
+		Addr:     ":" + cfg.Port,
+		Handler:  handler,
+		ErrorLog: slog.NewLogLogger(logHandler, slog.LevelError),
 	}
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("Server starting on port %s", cfg.Port)
+		slog.Info("Server starting", "port", cfg.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+			fatal("Server failed to start", err)
+		}
+	}()
+
+	// Reload files read at startup on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := emailService.ReloadTenantLimits(); err != nil {
+				slog.Error("Failed to reload tenant limits, keeping the current ones", "error", err)
+			} else {
+				slog.Info("Tenant limits reloaded")
+			}
+			if err := emailService.ReloadVIPRecipients(); err != nil {
+				slog.Error("Failed to reload VIP recipients, keeping the current ones", "error", err)
+			} else {
+				slog.Info("VIP recipients reloaded")
+			}
 		}
 	}()
 
@@ -53,19 +135,34 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutdown signal received")
+	slog.Info("Shutdown signal received")
 
 	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	// Shutdown HTTP server
 	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Error("Server forced to shutdown", "error", err)
 	}
 
 	// Shutdown email service
 	emailService.Shutdown()
 
-	log.Println("Server exited")
+	// Capture final metric values for post-mortem analysis
+	if cfg.MetricsSnapshotFile != "" {
+		if err := emailService.WriteMetricsSnapshot(cfg.MetricsSnapshotFile); err != nil {
+			slog.Error("Failed to write metrics snapshot", "error", err)
+		} else {
+			slog.Info("Metrics snapshot written", "path", cfg.MetricsSnapshotFile)
+		}
+	}
+
+	slog.Info("Server exited")
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -1,16 +1,409 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
 // EmailJob represents an email to be sent
 type EmailJob struct {
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
-	Retries int    `json:"-"`
+	ID      string   `json:"id"`
+	To      string   `json:"to"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+	Retries int      `json:"-"`
+
+	// From overrides the configured default sender address when set
+	From string `json:"from,omitempty"`
+	// ReplyTo is where replies go when set, instead of to From
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Headers are extra message headers, such as List-Unsubscribe
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// HTML marks the body as HTML rather than plain text
+	HTML bool `json:"html,omitempty"`
+	// Attachments are sent alongside the body
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Priority is PriorityHigh, PriorityNormal or PriorityLow
+	Priority string `json:"priority,omitempty"`
+
+	// Heartbeat marks synthetic monitoring jobs generated by the service itself
+	Heartbeat bool `json:"-"`
+	// EnqueuedAt is when the job last entered a queue
+	EnqueuedAt time.Time `json:"-"`
+	// SendAt holds the job back until this time when set
+	SendAt time.Time `json:"-"`
+	// DomainSlotReserved marks a job deferred by the domain rate limit that
+	// already holds its send slot
+	DomainSlotReserved bool `json:"-"`
+	// SweepAttempts counts how many times the dead letter sweeper has requeued the job
+	SweepAttempts int `json:"sweep_attempts,omitempty"`
+
+	// LastError is the error returned by the most recent failed send attempt
+	LastError string `json:"last_error,omitempty"`
+	// FailedAt is when the most recent send attempt failed, or when the job was
+	// dead-lettered if it never failed to send
+	FailedAt time.Time `json:"failed_at"`
+	// Attempts are the job's failed send attempts, oldest first, keeping the
+	// most recent MaxAttemptHistory
+	Attempts []Attempt `json:"attempts,omitempty"`
+
+	// ExpiresAt is when the job goes stale; after that it is dead-lettered
+	// instead of sent
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Reason says why the job was dead-lettered when no send failure caused it,
+	// e.g. ReasonExpired
+	Reason string `json:"reason,omitempty"`
+
+	// CallbackURL is POSTed the outcome once the job is sent or dead-lettered
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// Tenant is the tenant whose API key submitted the job, if any
+	Tenant string `json:"tenant,omitempty"`
+
+	// Deliveries are the outcomes per recipient once a relay accepted the
+	// job for some recipients and rejected it for others; nil until then
+	Deliveries []RecipientDelivery `json:"recipients,omitempty"`
+}
+
+// Recipient delivery statuses for RecipientDelivery.Status
+const (
+	DeliverySent     = "sent"
+	DeliveryRejected = "rejected"
+)
+
+// RecipientDelivery is the outcome for one recipient of a job
+type RecipientDelivery struct {
+	Address string `json:"address"`
+	// Status is DeliverySent or DeliveryRejected
+	Status string `json:"status"`
+	// Error is the relay's rejection of a rejected recipient
+	Error string `json:"error,omitempty"`
+}
+
+// MaxAttemptHistory caps how many failed attempts a job keeps
+const MaxAttemptHistory = 20
+
+// Attempt is a failed send attempt
+type Attempt struct {
+	At time.Time `json:"at"`
+	// WorkerID is the worker that made the attempt; 0 is the retry worker
+	WorkerID int    `json:"worker_id"`
+	Error    string `json:"error"`
+}
+
+// WithAttempt returns the job's attempts with attempt added, dropping the
+// oldest beyond MaxAttemptHistory. The result never shares storage with the
+// job's, so copies of the job made earlier keep their own history.
+func (j EmailJob) WithAttempt(attempt Attempt) []Attempt {
+	kept := j.Attempts
+	if len(kept) >= MaxAttemptHistory {
+		kept = kept[len(kept)-MaxAttemptHistory+1:]
+	}
+	attempts := make([]Attempt, len(kept), len(kept)+1)
+	copy(attempts, kept)
+	return append(attempts, attempt)
+}
+
+const (
+	// ReasonExpired is the dead letter reason of a job that expired before it was sent
+	ReasonExpired = "expired"
+	// ReasonRetryQueueFull is the dead letter reason of a job whose retry came
+	// due while the retry queue was full
+	ReasonRetryQueueFull = "retry_queue_full"
+	// ReasonMessageTooLarge is the dead letter reason of a job whose assembled
+	// message was larger than the configured cap
+	ReasonMessageTooLarge = "message_too_large"
+	// ReasonPanic is the dead letter reason of a job whose processing panicked
+	ReasonPanic = "panic"
+)
+
+// Expired reports whether the job has passed its ExpiresAt
+func (j EmailJob) Expired(now time.Time) bool {
+	return j.ExpiresAt != nil && !now.Before(*j.ExpiresAt)
+}
+
+// Sender returns the job's From address, or defaultFrom if it has none
+func (j EmailJob) Sender(defaultFrom string) string {
+	if j.From != "" {
+		return j.From
+	}
+	return defaultFrom
+}
+
+// Recipients returns every address the job is delivered to, including Bcc
+func (j EmailJob) Recipients() []string {
+	recipients := make([]string, 0, 1+len(j.Cc)+len(j.Bcc))
+	recipients = append(recipients, j.To)
+	recipients = append(recipients, j.Cc...)
+	return append(recipients, j.Bcc...)
+}
+
+// PendingRecipients returns the recipients the job still has to be delivered
+// to: Recipients without those an earlier attempt already delivered to
+func (j EmailJob) PendingRecipients() []string {
+	sent := make(map[string]bool)
+	for _, delivery := range j.Deliveries {
+		if delivery.Status == DeliverySent {
+			sent[delivery.Address] = true
+		}
+	}
+
+	var pending []string
+	for _, recipient := range j.Recipients() {
+		if !sent[recipient] {
+			pending = append(pending, recipient)
+		}
+	}
+	return pending
+}
+
+// WithDeliveries returns the job's deliveries updated with an attempt that
+// delivered to sent and was rejected for the recipients in rejected, mapped
+// to the relay's error. Recipients already delivered to stay sent. Like
+// WithAttempt, the result never shares storage with the job's.
+func (j EmailJob) WithDeliveries(sent []string, rejected map[string]string) []RecipientDelivery {
+	previous := make(map[string]RecipientDelivery, len(j.Deliveries))
+	for _, delivery := range j.Deliveries {
+		previous[delivery.Address] = delivery
+	}
+	delivered := make(map[string]bool, len(sent))
+	for _, recipient := range sent {
+		delivered[recipient] = true
+	}
+
+	recipients := j.Recipients()
+	deliveries := make([]RecipientDelivery, 0, len(recipients))
+	for _, recipient := range recipients {
+		if delivered[recipient] || previous[recipient].Status == DeliverySent {
+			deliveries = append(deliveries, RecipientDelivery{Address: recipient, Status: DeliverySent})
+			continue
+		}
+		deliveries = append(deliveries, RecipientDelivery{Address: recipient, Status: DeliveryRejected, Error: rejected[recipient]})
+	}
+	return deliveries
+}
+
+// ContentType returns the MIME type of a job's body
+func (j EmailJob) ContentType() string {
+	if j.HTML {
+		return "text/html"
+	}
+	return "text/plain"
+}
+
+// RedactedPlaceholder replaces email content hidden by redaction
+const RedactedPlaceholder = "[redacted]"
+
+// Redacted returns a copy of the job with its content hidden, keeping the
+// recipients visible. Headers keep their names and attachments their names
+// and types, but not their values. Errors are hidden too, since relays often
+// quote the message or an address back in them.
+func (j EmailJob) Redacted() EmailJob {
+	j.Subject = RedactedPlaceholder
+	j.Body = RedactedPlaceholder
+	j.ReplyTo = redactedText(j.ReplyTo)
+	j.CallbackURL = redactedText(j.CallbackURL)
+	j.LastError = redactedText(j.LastError)
+	if len(j.Headers) > 0 {
+		headers := make(map[string]string, len(j.Headers))
+		for name := range j.Headers {
+			headers[name] = RedactedPlaceholder
+		}
+		j.Headers = headers
+	}
+	if len(j.Attachments) > 0 {
+		attachments := make([]Attachment, len(j.Attachments))
+		for i, attachment := range j.Attachments {
+			attachments[i] = Attachment{Filename: attachment.Filename, ContentType: attachment.ContentType}
+		}
+		j.Attachments = attachments
+	}
+	if len(j.Attempts) > 0 {
+		attempts := make([]Attempt, len(j.Attempts))
+		for i, attempt := range j.Attempts {
+			attempt.Error = redactedText(attempt.Error)
+			attempts[i] = attempt
+		}
+		j.Attempts = attempts
+	}
+	j.Deliveries = RedactedDeliveries(j.Deliveries)
+	return j
+}
+
+// RedactedDeliveries returns copies of deliveries with the relay's rejections
+// hidden, keeping the addresses and statuses
+func RedactedDeliveries(deliveries []RecipientDelivery) []RecipientDelivery {
+	if len(deliveries) == 0 {
+		return deliveries
+	}
+	redacted := make([]RecipientDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		delivery.Error = redactedText(delivery.Error)
+		redacted[i] = delivery
+	}
+	return redacted
+}
+
+// redactedText returns RedactedPlaceholder in place of text, or "" if there
+// was nothing to hide
+func redactedText(text string) string {
+	if text == "" {
+		return ""
+	}
+	return RedactedPlaceholder
+}
+
+// Attachment is a file sent with an email. Content is base64 in JSON.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content,omitempty"`
+}
+
+// Recipients is a list of addresses that decodes from either a single JSON
+// string or an array of strings
+type Recipients []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *Recipients) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if single == "" {
+			*r = nil
+		} else {
+			*r = Recipients{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("to must be a string or an array of strings")
+	}
+	*r = list
+	return nil
 }
 
 // EmailRequest represents the incoming HTTP request
 type EmailRequest struct {
-	To      string `json:"to"`
+	To      Recipients `json:"to"`
+	Cc      []string   `json:"cc,omitempty"`
+	Bcc     []string   `json:"bcc,omitempty"`
+	Subject string     `json:"subject"`
+	Body    string     `json:"body"`
+
+	// From is an optional sender address; the configured default is used without it
+	From string `json:"from,omitempty"`
+	// ReplyTo is an optional address for replies
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Headers are optional extra message headers; they can't replace the
+	// headers the service sets itself
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Mode is "async" (queue and respond 202) or "sync" (send before responding)
+	Mode string `json:"mode,omitempty"`
+	// Priority is "high", "normal" or "low"; empty means normal
+	Priority string `json:"priority,omitempty"`
+	// SendAt is an optional RFC 3339 time to deliver the email at
+	SendAt string `json:"send_at,omitempty"`
+	// ExpiresAt is an optional RFC 3339 time after which the email is no longer worth sending
+	ExpiresAt string `json:"expires_at,omitempty"`
+
+	// TemplateID renders the subject and body from a registered template
+	// instead of taking them from the request
+	TemplateID string `json:"template_id,omitempty"`
+	// Data holds the template variables
+	Data map[string]string `json:"data,omitempty"`
+
+	// Attachments are files to send with the email
+	Attachments []AttachmentRequest `json:"attachments,omitempty"`
+
+	// CallbackURL is an optional http(s) URL that is POSTed the outcome once
+	// the email is sent or dead-lettered
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// AttachmentRequest is an attachment as submitted, with base64 content
+type AttachmentRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
+}
+
+// EmailTemplate is a registered subject and body with {{.Name}} placeholders
+type EmailTemplate struct {
+	ID      string `json:"id"`
 	Subject string `json:"subject"`
 	Body    string `json:"body"`
+	// HTML marks the body as HTML; substituted values are escaped
+	HTML      bool      `json:"html,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Delivery modes for EmailRequest.Mode
+const (
+	ModeAsync = "async"
+	ModeSync  = "sync"
+)
+
+// Job priorities for EmailRequest.Priority and EmailJob.Priority
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// ParsePriority normalizes a requested priority, defaulting to normal.
+// It reports false for an unknown priority.
+func ParsePriority(priority string) (string, bool) {
+	switch p := strings.ToLower(priority); p {
+	case "":
+		return PriorityNormal, true
+	case PriorityHigh, PriorityNormal, PriorityLow:
+		return p, true
+	default:
+		return "", false
+	}
+}
+
+// MergeRecipient is a single recipient of a mail-merge request
+type MergeRecipient struct {
+	To        string            `json:"to"`
+	Variables map[string]string `json:"variables"`
+}
+
+// MergeRequest represents a templated message fanned out to many recipients
+type MergeRequest struct {
+	Subject    string           `json:"subject"`
+	Body       string           `json:"body"`
+	Recipients []MergeRecipient `json:"recipients"`
+}
+
+// RecipientResult reports the outcome for one recipient of a multi-recipient request
+type RecipientResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	To     string `json:"to"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// WorkerCountRequest sets the number of running workers
+type WorkerCountRequest struct {
+	Workers int `json:"workers"`
+}
+
+// WorkerAssignment is how the running workers are split between priority tiers
+type WorkerAssignment struct {
+	Workers int `json:"workers"`
+	// HighPriorityOnly is how many workers take high-priority jobs only
+	HighPriorityOnly int `json:"high_priority_only"`
+	// AllPriorities is how many workers take jobs of every priority
+	AllPriorities int `json:"all_priorities"`
 }
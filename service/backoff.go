@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"email-queue-service/config"
+)
+
+// BackoffStrategy decides how long to wait before a retry attempt
+type BackoffStrategy interface {
+	// NextDelay returns the delay before the given retry attempt (starting at 1)
+	NextDelay(attempt int) time.Duration
+}
+
+// LinearBackoff waits attempt * Step before each retry
+type LinearBackoff struct {
+	Step time.Duration
+}
+
+// NextDelay implements BackoffStrategy
+func (b LinearBackoff) NextDelay(attempt int) time.Duration {
+	return time.Duration(attempt) * b.Step
+}
+
+// FixedBackoff waits the same Delay before every retry
+type FixedBackoff struct {
+	Delay time.Duration
+}
+
+// NextDelay implements BackoffStrategy
+func (b FixedBackoff) NextDelay(attempt int) time.Duration {
+	return b.Delay
+}
+
+// ExponentialBackoff doubles the delay on every attempt, starting at Base and
+// capped at Max, or growing until it would overflow when Max is zero. With Jitter, the delay is drawn uniformly from [0, delay]
+// ("full jitter") so recovering providers aren't hit by synchronized retries.
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter bool
+}
+
+// NextDelay implements BackoffStrategy
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	limit := time.Duration(math.MaxInt64)
+	if b.Max > 0 {
+		limit = b.Max
+	}
+
+	delay := min(b.Base, limit)
+	for i := 1; i < attempt && delay < limit; i++ {
+		if delay > limit/2 {
+			delay = limit
+			break
+		}
+		delay *= 2
+	}
+
+	if b.Jitter && delay > 0 {
+		// Unsigned so that delay+1 can't overflow
+		delay = time.Duration(rand.Uint64N(uint64(delay) + 1))
+	}
+	return delay
+}
+
+// NewBackoffStrategy builds the retry strategy selected in the configuration
+func NewBackoffStrategy(cfg *config.Config) (BackoffStrategy, error) {
+	switch strings.ToLower(cfg.RetryBackoff) {
+	case "", "linear":
+		return LinearBackoff{Step: time.Second}, nil
+	case "fixed":
+		return FixedBackoff{Delay: cfg.RetryFixedDelay}, nil
+	case "exponential":
+		if cfg.RetryBaseDelay <= 0 {
+			return nil, fmt.Errorf("exponential backoff needs a positive base delay")
+		}
+		return ExponentialBackoff{
+			Base:   cfg.RetryBaseDelay,
+			Max:    cfg.RetryMaxDelay,
+			Jitter: cfg.RetryJitter,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown backoff strategy %q", cfg.RetryBackoff)
+	}
+}
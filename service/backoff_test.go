@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+)
+
+func TestExponentialBackoffNextDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff ExponentialBackoff
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt waits the base", ExponentialBackoff{Base: time.Second, Max: time.Minute}, 1, time.Second},
+		{"doubles each attempt", ExponentialBackoff{Base: time.Second, Max: time.Minute}, 2, 2 * time.Second},
+		{"keeps doubling", ExponentialBackoff{Base: time.Second, Max: time.Minute}, 5, 16 * time.Second},
+		{"capped at max", ExponentialBackoff{Base: time.Second, Max: time.Minute}, 7, time.Minute},
+		{"stays at max", ExponentialBackoff{Base: time.Second, Max: time.Minute}, 50, time.Minute},
+		{"base above max", ExponentialBackoff{Base: 2 * time.Minute, Max: time.Minute}, 1, time.Minute},
+		{"no cap: first attempt", ExponentialBackoff{Base: time.Second}, 1, time.Second},
+		{"no cap: doubles", ExponentialBackoff{Base: time.Second}, 2, 2 * time.Second},
+		{"no cap: grows past a minute", ExponentialBackoff{Base: time.Second}, 10, 512 * time.Second},
+		{"no cap: saturates instead of overflowing", ExponentialBackoff{Base: time.Second}, 100, math.MaxInt64},
+		{"no cap: huge attempt", ExponentialBackoff{Base: time.Second}, math.MaxInt32, math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.NextDelay(tt.attempt); got != tt.want {
+				t.Errorf("NextDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixedBackoffWaitsTheSameForEveryAttempt(t *testing.T) {
+	es := newTestService(t, nil, map[string]string{"RETRY_FIXED_DELAY": "250ms", "FIRST_RETRY_DELAY": "0s"})
+	for attempt := 1; attempt <= 10; attempt++ {
+		if got := es.retryDelay(attempt); got != 250*time.Millisecond {
+			t.Errorf("retryDelay(%d) = %s, want 250ms", attempt, got)
+		}
+	}
+}
+
+func TestExponentialBackoffGrowsMonotonically(t *testing.T) {
+	for _, max := range []time.Duration{0, time.Minute} {
+		b := ExponentialBackoff{Base: 100 * time.Millisecond, Max: max}
+		previous := time.Duration(0)
+		for attempt := 1; attempt <= 100; attempt++ {
+			delay := b.NextDelay(attempt)
+			if delay < previous {
+				t.Fatalf("max %s: NextDelay(%d) = %s, less than %s before it", max, attempt, delay, previous)
+			}
+			previous = delay
+		}
+	}
+}
+
+func TestExponentialBackoffJitterStaysInRange(t *testing.T) {
+	for _, max := range []time.Duration{0, 10 * time.Second} {
+		b := ExponentialBackoff{Base: time.Second, Max: max, Jitter: true}
+		for attempt := 1; attempt <= 100; attempt++ {
+			ceiling := ExponentialBackoff{Base: b.Base, Max: b.Max}.NextDelay(attempt)
+			if delay := b.NextDelay(attempt); delay < 0 || delay > ceiling {
+				t.Fatalf("max %s: NextDelay(%d) = %s, want within [0, %s]", max, attempt, delay, ceiling)
+			}
+		}
+	}
+}
+
+func TestNewBackoffStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    BackoffStrategy
+		wantErr bool
+	}{
+		{"default is linear", config.Config{}, LinearBackoff{Step: time.Second}, false},
+		{"fixed", config.Config{RetryBackoff: "fixed", RetryFixedDelay: 5 * time.Second}, FixedBackoff{Delay: 5 * time.Second}, false},
+		{"exponential", config.Config{RetryBackoff: "Exponential", RetryBaseDelay: time.Second, RetryMaxDelay: time.Minute, RetryJitter: true},
+			ExponentialBackoff{Base: time.Second, Max: time.Minute, Jitter: true}, false},
+		{"exponential needs a base", config.Config{RetryBackoff: "exponential"}, nil, true},
+		{"unknown", config.Config{RetryBackoff: "random"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewBackoffStrategy(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("strategy = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstRetryWaitsAtLeastTheGraceWindow(t *testing.T) {
+	const grace = 150 * time.Millisecond
+
+	var mu sync.Mutex
+	var attempts []time.Time
+	es := startTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 3 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}), map[string]string{"FIRST_RETRY_DELAY": grace.String()})
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "job sent", func() bool {
+		record, ok := es.JobStatus("a")
+		return ok && record.Status == StatusSent
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if first := attempts[1].Sub(attempts[0]); first < grace {
+		t.Errorf("first retry after %s, want at least %s", first, grace)
+	}
+	// Later retries are back on the 10ms backoff
+	if second := attempts[2].Sub(attempts[1]); second >= grace {
+		t.Errorf("second retry after %s, want the backoff's delay", second)
+	}
+
+	if got := newTestService(t, nil, map[string]string{"FIRST_RETRY_DELAY": "0s"}).retryDelay(1); got != 10*time.Millisecond {
+		t.Errorf("first retry delay without a grace window = %s, want the backoff's 10ms", got)
+	}
+}
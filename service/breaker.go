@@ -0,0 +1,132 @@
+package service
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// breakerProbeInterval is how often jobs held back by a half-open breaker
+// check again whether its trial send succeeded
+const breakerProbeInterval = time.Second
+
+// breakerState is the state of a circuitBreaker. The values are what the
+// email_circuit_breaker_state gauge reports.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// String returns the state's name for logs
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops sends after threshold consecutive failures. Once
+// cooldown has passed it half-opens and lets a single trial send through:
+// success closes it again, failure reopens it for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int       // consecutive failures while closed
+	openedAt  time.Time // when the breaker last opened
+	trialAt   time.Time // when the half-open trial send started
+	onChange  func(breakerState)
+}
+
+// newCircuitBreaker creates a closed breaker. onChange is called, with the
+// breaker locked, whenever the state changes.
+func newCircuitBreaker(threshold int, cooldown time.Duration, onChange func(breakerState)) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, onChange: onChange}
+}
+
+// allow reports whether a send may go ahead now. If not, it returns how long
+// to wait before asking again.
+func (b *circuitBreaker) allow(now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openedAt.Add(b.cooldown).Sub(now); wait > 0 {
+			return wait, false
+		}
+		b.setState(breakerHalfOpen)
+		b.trialAt = now
+		return 0, true
+	case breakerHalfOpen:
+		// A trial that hasn't reported back within the cooldown is presumed
+		// lost, so another send gets to try
+		wait := b.trialAt.Add(b.cooldown).Sub(now)
+		if wait <= 0 {
+			b.trialAt = now
+			return 0, true
+		}
+		return min(wait, breakerProbeInterval), false
+	default:
+		return 0, true
+	}
+}
+
+// record reports the outcome of a send that allow let through
+func (b *circuitBreaker) record(success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.trip(now)
+		}
+	case breakerHalfOpen:
+		if success {
+			b.failures = 0
+			b.setState(breakerClosed)
+			return
+		}
+		b.trip(now)
+	}
+	// Sends that started before the breaker opened don't change it
+}
+
+// trip opens the breaker for a cooldown
+func (b *circuitBreaker) trip(now time.Time) {
+	b.openedAt = now
+	b.failures = 0
+	b.setState(breakerOpen)
+}
+
+// setState changes the state and reports the change
+func (b *circuitBreaker) setState(state breakerState) {
+	if state == b.state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	if state == breakerOpen {
+		slog.Warn("Circuit breaker opened, holding back sends", "from", from.String(), "cooldown", b.cooldown.String())
+	} else {
+		slog.Info("Circuit breaker state changed", "from", from.String(), "to", state.String())
+	}
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestOpenBreakerKeepsRetryCount(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+
+	var mu sync.Mutex
+	var retries []int
+	var sentAt []time.Time
+	es := startTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+		retries = append(retries, job.Retries)
+		sentAt = append(sentAt, time.Now())
+		if len(retries) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}), map[string]string{
+		"MAX_RETRIES":               "1",
+		"CIRCUIT_BREAKER_THRESHOLD": "1",
+		"CIRCUIT_BREAKER_COOLDOWN":  cooldown.String(),
+	})
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	// The retry comes due while the breaker is still open, and is parked
+	// until the cooldown ends. Had that cost a retry, the job would have run
+	// out of them and been dead-lettered.
+	waitFor(t, "job sent", func() bool {
+		record, ok := es.JobStatus("a")
+		return ok && record.Status == StatusSent
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(retries) != 2 || retries[0] != 0 || retries[1] != 1 {
+		t.Fatalf("retry counts at each send = %v, want [0 1]", retries)
+	}
+	if gap := sentAt[1].Sub(sentAt[0]); gap < cooldown {
+		t.Errorf("retry sent %s after the failure, before the %s cooldown ended", gap, cooldown)
+	}
+	if dead := es.GetDeadLetterJobs(); len(dead) != 0 {
+		t.Errorf("dead letters = %+v, want none", dead)
+	}
+}
+
+func TestOpenBreakerUsesNoRateLimitSlots(t *testing.T) {
+	sent := 0
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		sent++
+		return nil
+	}), map[string]string{
+		"GLOBAL_RATE":               "0.1",
+		"RATE_PER_DOMAIN":           "0.1",
+		"CIRCUIT_BREAKER_THRESHOLD": "1",
+		"CIRCUIT_BREAKER_COOLDOWN":  "1m",
+	})
+	es.breaker.record(false, time.Now())
+
+	es.processJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}, 1)
+
+	if sent != 0 {
+		t.Fatal("job sent while the breaker was open")
+	}
+	es.globalLimit.mu.Lock()
+	tokens := es.globalLimit.bucket.tokens
+	es.globalLimit.mu.Unlock()
+	if tokens != 1 {
+		t.Errorf("global tokens = %v, want the one token of the burst left", tokens)
+	}
+	es.domainLimit.mu.Lock()
+	bucket := es.domainLimit.buckets["example.com"]
+	es.domainLimit.mu.Unlock()
+	if bucket != nil {
+		t.Errorf("example.com bucket = %+v, want no slot reserved", bucket)
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	const cooldown = time.Minute
+
+	var changes []breakerState
+	b := newCircuitBreaker(3, cooldown, func(state breakerState) { changes = append(changes, state) })
+	now := time.Now()
+
+	// Closed: failures below the threshold, or broken up by a success, don't trip it
+	b.record(false, now)
+	b.record(false, now)
+	b.record(true, now)
+	b.record(false, now)
+	b.record(false, now)
+	if _, ok := b.allow(now); !ok || b.state != breakerClosed {
+		t.Fatalf("state = %s, want closed below the threshold", b.state)
+	}
+
+	// Open: the third consecutive failure trips it until the cooldown ends
+	b.record(false, now)
+	if wait, ok := b.allow(now.Add(time.Second)); ok || wait != cooldown-time.Second {
+		t.Fatalf("allow while open = %s, %v, want to wait out the cooldown", wait, ok)
+	}
+
+	// Half-open: one trial send once the cooldown is over, and no other
+	reopenAt := now.Add(cooldown)
+	if _, ok := b.allow(reopenAt); !ok || b.state != breakerHalfOpen {
+		t.Fatalf("allow after the cooldown = %v in state %s, want a half-open trial", ok, b.state)
+	}
+	if _, ok := b.allow(reopenAt); ok {
+		t.Error("second send allowed during the half-open trial")
+	}
+
+	// A failed trial reopens it, a successful one closes it
+	b.record(false, reopenAt)
+	if b.state != breakerOpen {
+		t.Fatalf("state after a failed trial = %s, want open", b.state)
+	}
+	closeAt := reopenAt.Add(cooldown)
+	if _, ok := b.allow(closeAt); !ok {
+		t.Fatal("no trial after the second cooldown")
+	}
+	b.record(true, closeAt)
+	if _, ok := b.allow(closeAt); !ok || b.state != breakerClosed {
+		t.Fatalf("state after a successful trial = %s, want closed", b.state)
+	}
+
+	want := []breakerState{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("state changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("state changes = %v, want %v", changes, want)
+		}
+	}
+}
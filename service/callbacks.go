@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/models"
+)
+
+const (
+	// callbackQueueSize caps how many callbacks can wait for delivery; more are dropped
+	callbackQueueSize = 1000
+	// callbackWorkers is how many callbacks are delivered at once
+	callbackWorkers = 4
+	// callbackRetryDelay is the wait before the first redelivery; it doubles after each attempt
+	callbackRetryDelay = time.Second
+	// callbackDrainTimeout bounds how long shutdown waits for queued callbacks
+	callbackDrainTimeout = 5 * time.Second
+
+	// CallbackSignatureHeader carries "sha256=" and the hex HMAC-SHA256, keyed
+	// with CALLBACK_SECRET, of the timestamp header, a '.' and the body
+	CallbackSignatureHeader = "X-Signature"
+	// CallbackTimestampHeader is when the callback was sent, in Unix seconds
+	CallbackTimestampHeader = "X-Signature-Timestamp"
+)
+
+// CallbackPayload is POSTed to a job's callback URL once it is sent or dead-lettered
+type CallbackPayload struct {
+	ID     string    `json:"id"`
+	To     string    `json:"to"`
+	Status JobStatus `json:"status"`
+	// Provider is the email provider that delivered a sent job
+	Provider string `json:"provider,omitempty"`
+	// Error is the last send error of a dead-lettered job
+	Error string `json:"error,omitempty"`
+	// Reason says why a job was dead-lettered when no send failure caused it
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// callback is a payload waiting to be delivered
+type callback struct {
+	url     string
+	payload CallbackPayload
+	attempt int // attempts made so far
+}
+
+// callbackDispatcher delivers job callbacks in the background so a slow
+// callback endpoint never holds up a worker
+type callbackDispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	secret      []byte // signs each request; nil when callbacks aren't signed
+	queue       chan callback
+	results     *prometheus.CounterVec
+
+	ctx      context.Context // cancelled once shutdown stops waiting for deliveries
+	cancel   context.CancelFunc
+	stopping chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newCallbackDispatcher creates a dispatcher whose requests time out after
+// timeout and which tries each callback up to maxAttempts times, signing
+// them with secret if it is set
+func newCallbackDispatcher(timeout time.Duration, maxAttempts int, secret string, results *prometheus.CounterVec) *callbackDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &callbackDispatcher{
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		queue:       make(chan callback, callbackQueueSize),
+		results:     results,
+		ctx:         ctx,
+		cancel:      cancel,
+		stopping:    make(chan struct{}),
+	}
+	if secret != "" {
+		d.secret = []byte(secret)
+	}
+	return d
+}
+
+// start runs the delivery workers
+func (d *callbackDispatcher) start() {
+	for range callbackWorkers {
+		d.wg.Add(1)
+		go d.run()
+	}
+}
+
+// stop delivers what is already queued, waiting up to timeout, then abandons
+// the rest. Pending redeliveries are dropped.
+func (d *callbackDispatcher) stop(timeout time.Duration) {
+	close(d.stopping)
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Callbacks still pending at shutdown, abandoning them", "pending", len(d.queue))
+		d.cancel()
+		<-done
+	}
+	d.cancel()
+}
+
+// dispatch queues a callback for job without blocking
+func (d *callbackDispatcher) dispatch(job models.EmailJob, status JobStatus, provider string) {
+	if job.CallbackURL == "" {
+		return
+	}
+
+	payload := CallbackPayload{
+		ID:       job.ID,
+		To:       job.To,
+		Status:   status,
+		Provider: provider,
+		At:       time.Now(),
+	}
+	if status == StatusDeadLettered {
+		payload.Error = job.LastError
+		payload.Reason = job.Reason
+	}
+	d.enqueue(callback{url: job.CallbackURL, payload: payload})
+}
+
+// enqueue queues cb, dropping it if the queue is full or shutdown has started
+func (d *callbackDispatcher) enqueue(cb callback) {
+	select {
+	case <-d.stopping:
+		d.drop(cb, "shutting down")
+		return
+	default:
+	}
+
+	select {
+	case d.queue <- cb:
+	default:
+		d.drop(cb, "callback queue full")
+	}
+}
+
+// drop records a callback that won't be delivered
+func (d *callbackDispatcher) drop(cb callback, reason string) {
+	slog.Warn("Dropping job callback", "event", "callback_dropped", "job_id", cb.payload.ID, "url", cb.url, "reason", reason)
+	d.results.WithLabelValues("dropped").Inc()
+}
+
+// run delivers queued callbacks until shutdown, then drains the queue
+func (d *callbackDispatcher) run() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case cb := <-d.queue:
+			d.deliver(cb)
+		case <-d.stopping:
+			for {
+				select {
+				case cb := <-d.queue:
+					d.deliver(cb)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver makes one attempt at cb and schedules a redelivery if it fails
+func (d *callbackDispatcher) deliver(cb callback) {
+	cb.attempt++
+	err := d.post(cb)
+	if err == nil {
+		slog.Debug("Job callback delivered", "event", "callback_delivered", "job_id", cb.payload.ID, "url", cb.url)
+		d.results.WithLabelValues("delivered").Inc()
+		return
+	}
+
+	if cb.attempt >= d.maxAttempts {
+		slog.Warn("Job callback failed, giving up", "event", "callback_failed", "job_id", cb.payload.ID, "url", cb.url, "attempts", cb.attempt, "error", err)
+		d.results.WithLabelValues("failed").Inc()
+		return
+	}
+
+	delay := callbackRetryDelay << (cb.attempt - 1)
+	slog.Info("Job callback failed, retrying", "event", "callback_retry", "job_id", cb.payload.ID, "url", cb.url, "attempt", cb.attempt, "delay", delay.String(), "error", err)
+	time.AfterFunc(delay, func() {
+		d.enqueue(cb)
+	})
+}
+
+// post sends cb's payload; any response other than 2xx is a failure
+func (d *callbackDispatcher) post(cb callback) error {
+	body, err := json.Marshal(cb.payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, cb.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "email-queue-service")
+	if d.secret != nil {
+		// Signed afresh on each attempt so a redelivery isn't taken for a replay
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(CallbackTimestampHeader, timestamp)
+		req.Header.Set(CallbackSignatureHeader, SignCallback(d.secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SignCallback returns the X-Signature value for a callback body sent at
+// timestamp. Receivers recompute it with the shared secret, compare it with
+// hmac.Equal and reject timestamps too far from their own clock.
+func SignCallback(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
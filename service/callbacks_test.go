@@ -0,0 +1,178 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// receivedCallback is a callback request as the receiver saw it
+type receivedCallback struct {
+	header http.Header
+	body   []byte
+}
+
+// startCallbackReceiver returns the URL of a server that records the
+// callbacks it receives
+func startCallbackReceiver(t *testing.T) (string, <-chan receivedCallback) {
+	t.Helper()
+
+	received := make(chan receivedCallback, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedCallback{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, received
+}
+
+// startTestDispatcher starts a dispatcher signing with secret, stopped when
+// the test ends
+func startTestDispatcher(t *testing.T, secret string) *callbackDispatcher {
+	t.Helper()
+
+	results := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "callbacks"}, []string{"result"})
+	d := newCallbackDispatcher(time.Second, 1, secret, results)
+	d.start()
+	t.Cleanup(func() { d.stop(time.Second) })
+	return d
+}
+
+func TestSignCallback(t *testing.T) {
+	// Computed independently of SignCallback
+	const want = "sha256=788fa36193b43030b3271df193dc9aca3bb83e3703a36313282ecbc45548b967"
+	if got := SignCallback([]byte("whsec_test"), "1700000000", []byte(`{"id":"a"}`)); got != want {
+		t.Errorf("SignCallback = %s, want %s", got, want)
+	}
+}
+
+func TestCallbackSignatureVerifies(t *testing.T) {
+	const secret = "whsec_test"
+	url, received := startCallbackReceiver(t)
+	d := startTestDispatcher(t, secret)
+
+	d.dispatch(models.EmailJob{ID: "a", To: "user@example.com", CallbackURL: url}, StatusSent, "smtp")
+	cb := <-received
+
+	timestamp := cb.header.Get(CallbackTimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("%s = %q, want Unix seconds", CallbackTimestampHeader, timestamp)
+	}
+	if age := time.Since(time.Unix(sent, 0)); age < -time.Second || age > 5*time.Second {
+		t.Errorf("timestamp is %s old, want about now", age)
+	}
+
+	// Verify the way a receiver would
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(cb.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := cb.header.Get(CallbackSignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("%s = %q, want %q", CallbackSignatureHeader, got, want)
+	}
+
+	// A signature doesn't carry over to another timestamp
+	if SignCallback([]byte(secret), strconv.FormatInt(sent+1, 10), cb.body) == want {
+		t.Error("signature doesn't depend on the timestamp")
+	}
+}
+
+func TestCallbackUnsignedWithoutSecret(t *testing.T) {
+	url, received := startCallbackReceiver(t)
+	d := startTestDispatcher(t, "")
+
+	d.dispatch(models.EmailJob{ID: "a", To: "user@example.com", CallbackURL: url}, StatusSent, "smtp")
+	cb := <-received
+	if got := cb.header.Get(CallbackSignatureHeader); got != "" {
+		t.Errorf("%s = %q without a secret, want none", CallbackSignatureHeader, got)
+	}
+}
+
+func TestCallbackPayloadOnSentAndDeadLettered(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int32
+		wantStatus JobStatus
+		wantError  bool
+	}{
+		{"sent", 0, StatusSent, false},
+		{"dead-lettered", 100, StatusDeadLettered, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, received := startCallbackReceiver(t)
+			var attempts atomic.Int32
+			es := startTestService(t, failingSender(tt.failures, &attempts), map[string]string{"MAX_RETRIES": "0"})
+
+			job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi", CallbackURL: url}
+			if err := es.EnqueueJob(job); err != nil {
+				t.Fatalf("EnqueueJob: %v", err)
+			}
+
+			var cb receivedCallback
+			select {
+			case cb = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no callback received")
+			}
+			if ct := cb.header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var payload CallbackPayload
+			if err := json.Unmarshal(cb.body, &payload); err != nil {
+				t.Fatalf("decode payload %s: %v", cb.body, err)
+			}
+			if payload.ID != "a" || payload.To != "user@example.com" || payload.Status != tt.wantStatus {
+				t.Errorf("payload = %+v, want job a to user@example.com %s", payload, tt.wantStatus)
+			}
+			if gotError := payload.Error != ""; gotError != tt.wantError {
+				t.Errorf("payload error = %q, want one: %v", payload.Error, tt.wantError)
+			}
+			if payload.At.IsZero() {
+				t.Error("payload has no timestamp")
+			}
+		})
+	}
+}
+
+func TestCallbackRetriedAfterFailure(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	t.Cleanup(server.Close)
+
+	results := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "callbacks"}, []string{"result"})
+	d := newCallbackDispatcher(time.Second, 2, "", results)
+	d.start()
+	t.Cleanup(func() { d.stop(time.Second) })
+
+	d.dispatch(models.EmailJob{ID: "a", To: "user@example.com", CallbackURL: server.URL}, StatusSent, "smtp")
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not redelivered after a 500")
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("callback requests = %d, want 2", got)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// ErrDuplicate is matched by a DuplicateError
+var ErrDuplicate = errors.New("duplicate email suppressed")
+
+// DuplicateError is returned when an identical email was queued within the
+// dedupe window. OriginalID is the job that was queued first.
+type DuplicateError struct {
+	OriginalID string
+}
+
+// Error implements error
+func (e *DuplicateError) Error() string {
+	return ErrDuplicate.Error() + " (original " + e.OriginalID + ")"
+}
+
+// Is makes errors.Is(err, ErrDuplicate) match
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// dedupeEntry is an email seen within the window
+type dedupeEntry struct {
+	hash      string
+	jobID     string
+	expiresAt time.Time
+}
+
+// dedupeCache remembers the content hashes of recently queued emails. Like
+// idempotencyCache, every entry lives for the same window, so the insertion
+// order is also the expiry order; when the cache is full the oldest entry is
+// forgotten early.
+type dedupeCache struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // of *dedupeEntry, oldest first
+}
+
+// newDedupeCache creates a cache holding up to maxEntries hashes for window each
+func newDedupeCache(window time.Duration, maxEntries int) *dedupeCache {
+	return &dedupeCache{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// claim records hash for jobID. If hash was already seen within the window it
+// returns the ID of the job it was seen for and false instead.
+func (c *dedupeCache) claim(hash, jobID string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+
+	if elem, ok := c.entries[hash]; ok {
+		return elem.Value.(*dedupeEntry).jobID, false
+	}
+
+	for c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Front())
+	}
+
+	entry := &dedupeEntry{hash: hash, jobID: jobID, expiresAt: now.Add(c.window)}
+	c.entries[hash] = c.order.PushBack(entry)
+	return "", true
+}
+
+// release forgets hash if it was claimed for jobID, e.g. because the job
+// couldn't be queued and a retry of it shouldn't be suppressed
+func (c *dedupeCache) release(hash, jobID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[hash]; ok && elem.Value.(*dedupeEntry).jobID == jobID {
+		c.remove(elem)
+	}
+}
+
+// expire drops entries whose window has passed. Callers must hold mu.
+func (c *dedupeCache) expire(now time.Time) {
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		if now.Before(elem.Value.(*dedupeEntry).expiresAt) {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+// remove drops an entry. Callers must hold mu.
+func (c *dedupeCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*dedupeEntry)
+	delete(c.entries, entry.hash)
+}
+
+// dedupeHash identifies an email by its recipient, subject and body. The
+// recipient is normalized like throttleKey; the content is compared exactly.
+func dedupeHash(job models.EmailJob) string {
+	sum := sha256.New()
+	sum.Write([]byte(strings.ToLower(strings.TrimSpace(job.To))))
+	sum.Write([]byte{0})
+	sum.Write([]byte(job.Subject))
+	sum.Write([]byte{0})
+	sum.Write([]byte(job.Body))
+	return hex.EncodeToString(sum.Sum(nil))
+}
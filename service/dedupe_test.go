@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestDedupeCacheWindow(t *testing.T) {
+	c := newDedupeCache(time.Minute, 10)
+	now := time.Now()
+
+	if _, ok := c.claim("hash", "a", now); !ok {
+		t.Fatal("first claim suppressed")
+	}
+	if original, ok := c.claim("hash", "b", now.Add(59*time.Second)); ok || original != "a" {
+		t.Errorf("claim within the window = %q, %v; want suppressed as a duplicate of a", original, ok)
+	}
+	if _, ok := c.claim("hash", "c", now.Add(time.Minute)); !ok {
+		t.Error("claim after the window suppressed")
+	}
+}
+
+func TestDedupeCacheIsBounded(t *testing.T) {
+	c := newDedupeCache(time.Minute, 2)
+	now := time.Now()
+
+	for _, hash := range []string{"one", "two", "three"} {
+		c.claim(hash, hash, now)
+	}
+	if got := c.order.Len(); got != 2 {
+		t.Errorf("entries = %d, want the cap of 2", got)
+	}
+	// The oldest was forgotten early to make room
+	if _, ok := c.claim("one", "again", now); !ok {
+		t.Error("evicted hash still suppressed")
+	}
+	if _, ok := c.claim("three", "again", now); ok {
+		t.Error("recent hash no longer suppressed")
+	}
+}
+
+func TestDedupeCacheRelease(t *testing.T) {
+	c := newDedupeCache(time.Minute, 10)
+	now := time.Now()
+
+	c.claim("hash", "a", now)
+	// Only the job that claimed it can release it
+	c.release("hash", "b")
+	if _, ok := c.claim("hash", "c", now); ok {
+		t.Fatal("released by another job")
+	}
+	c.release("hash", "a")
+	if _, ok := c.claim("hash", "c", now); !ok {
+		t.Error("claim after release suppressed")
+	}
+}
+
+func TestEnqueueJobSuppressesDuplicates(t *testing.T) {
+	// Not started, so queued jobs stay put
+	es := newTestService(t, nil, map[string]string{"DEDUPE_WINDOW": "100ms"})
+
+	job := models.EmailJob{ID: "a", To: "User@Example.com", Subject: "Hi", Body: "Hi"}
+	if err := es.EnqueueJob(job); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+
+	job.ID, job.To = "b", " user@example.com"
+	var dup *DuplicateError
+	if err := es.EnqueueJob(job); !errors.As(err, &dup) || dup.OriginalID != "a" {
+		t.Fatalf("duplicate EnqueueJob = %v, want a DuplicateError for a", err)
+	}
+
+	other := models.EmailJob{ID: "c", To: "user@example.com", Subject: "Hi", Body: "Hello"}
+	if err := es.EnqueueJob(other); err != nil {
+		t.Errorf("different body: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	job.ID = "d"
+	if err := es.EnqueueJob(job); err != nil {
+		t.Errorf("EnqueueJob after the window: %v", err)
+	}
+	if got := es.queueDepth(); got != 3 {
+		t.Errorf("queue depth = %d, want 3", got)
+	}
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// jobRecord is the stored form of a job, in the dead letter file or a Redis
+// queue. It keeps fields that are hidden from the API so a reloaded job
+// behaves like the original. Version is the layout it was written in; see
+// jobRecordVersion.
+type jobRecord struct {
+	models.EmailJob
+	Version    int        `json:"version"`
+	Retries    int        `json:"retries"`
+	Heartbeat  bool       `json:"heartbeat,omitempty"`
+	SendAt     *time.Time `json:"send_at,omitempty"`
+	EnqueuedAt *time.Time `json:"enqueued_at,omitempty"`
+}
+
+// deadLetterStore persists dead letter jobs to a JSON-lines file. Callers
+// serialize access with the service's deadLetterLock.
+type deadLetterStore struct {
+	path string
+	file *os.File // nil once closed
+}
+
+// openDeadLetterStore loads existing jobs from path and opens it for appending
+func openDeadLetterStore(path string) (*deadLetterStore, []models.EmailJob, recordCounts, error) {
+	jobs, counts, err := loadDeadLetterFile(path)
+	if err != nil {
+		return nil, nil, counts, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, counts, fmt.Errorf("open dead letter file: %w", err)
+	}
+
+	return &deadLetterStore{path: path, file: file}, jobs, counts, nil
+}
+
+// loadDeadLetterFile reads jobs from path, upgrading records written in an
+// older layout and skipping lines that can't be decoded
+func loadDeadLetterFile(path string) ([]models.EmailJob, recordCounts, error) {
+	var counts recordCounts
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, counts, nil
+	}
+	if err != nil {
+		return nil, counts, fmt.Errorf("open dead letter file: %w", err)
+	}
+	defer file.Close()
+
+	var jobs []models.EmailJob
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record, migrated, err := decodeJobRecord(scanner.Bytes())
+		if err != nil {
+			// A crash mid-write can leave a partial last line
+			slog.Warn("Skipping unreadable dead letter record", "path", path, "line", line, "error", err)
+			counts.skipped++
+			continue
+		}
+		if migrated {
+			counts.migrated++
+		}
+		jobs = append(jobs, record.job())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, counts, fmt.Errorf("read dead letter file: %w", err)
+	}
+
+	slog.Info("Loaded dead letter jobs", "count", len(jobs), "migrated", counts.migrated, "skipped", counts.skipped, "path", path)
+	return jobs, counts, nil
+}
+
+// newJobRecord wraps a job for persistence
+func newJobRecord(job models.EmailJob) jobRecord {
+	record := jobRecord{EmailJob: job, Version: jobRecordVersion, Retries: job.Retries, Heartbeat: job.Heartbeat}
+	if !job.SendAt.IsZero() {
+		record.SendAt = &job.SendAt
+	}
+	if !job.EnqueuedAt.IsZero() {
+		record.EnqueuedAt = &job.EnqueuedAt
+	}
+	return record
+}
+
+// job unwraps a persisted record
+func (r jobRecord) job() models.EmailJob {
+	job := r.EmailJob
+	job.Retries = r.Retries
+	job.Heartbeat = r.Heartbeat
+	if r.SendAt != nil {
+		job.SendAt = *r.SendAt
+	}
+	if r.EnqueuedAt != nil {
+		job.EnqueuedAt = *r.EnqueuedAt
+	}
+	return job
+}
+
+// append writes jobs as new lines and syncs them to disk
+func (s *deadLetterStore) append(jobs ...models.EmailJob) error {
+	var buf []byte
+	for _, job := range jobs {
+		line, err := json.Marshal(newJobRecord(job))
+		if err != nil {
+			return fmt.Errorf("encode dead letter job: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	file := s.file
+	if file == nil {
+		// A job can still be dead-lettered after shutdown closed the file,
+		// e.g. by a worker that outlived the shutdown timeout
+		reopened, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("reopen dead letter file: %w", err)
+		}
+		defer reopened.Close()
+		file = reopened
+	}
+
+	if _, err := file.Write(buf); err != nil {
+		return fmt.Errorf("write dead letter file: %w", err)
+	}
+	return file.Sync()
+}
+
+// rewrite atomically replaces the file contents with jobs
+func (s *deadLetterStore) rewrite(jobs []models.EmailJob) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create dead letter temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, job := range jobs {
+		if err := encoder.Encode(newJobRecord(job)); err != nil {
+			tmp.Close()
+			return fmt.Errorf("encode dead letter job: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write dead letter temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync dead letter temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close dead letter temp file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace dead letter file: %w", err)
+	}
+
+	if s.file == nil {
+		return nil
+	}
+
+	// Reopen so appends go to the new file
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopen dead letter file: %w", err)
+	}
+	s.file.Close()
+	s.file = file
+	return nil
+}
+
+// close closes the underlying file. Later appends open it for each write.
+func (s *deadLetterStore) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
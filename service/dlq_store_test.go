@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+// persistedIDs returns the IDs of the jobs in the dead letter file at path
+func persistedIDs(t *testing.T, path string) []string {
+	t.Helper()
+
+	jobs, _, err := loadDeadLetterFile(path)
+	if err != nil {
+		t.Fatalf("load dead letter file: %v", err)
+	}
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+func TestDeadLetterStoreAppendsAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	store, _, _, err := openDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.append(models.EmailJob{ID: "a"}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := store.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := store.append(models.EmailJob{ID: "b"}); err != nil {
+		t.Fatalf("append after close: %v", err)
+	}
+	if err := store.close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+
+	if ids := persistedIDs(t, path); len(ids) != 2 || ids[1] != "b" {
+		t.Errorf("persisted %v, want a and b", ids)
+	}
+}
+
+func TestRetryDeadLetteredDuringShutdownIsPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		return errors.New("relay unavailable")
+	}), map[string]string{"DLQ_FILE": path, "RETRY_FIXED_DELAY": "1h"})
+	es.Start()
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "the retry to be scheduled", func() bool {
+		record, ok := es.JobStatus("a")
+		return ok && record.Status == StatusRetrying
+	})
+	es.Shutdown()
+
+	if ids := persistedIDs(t, path); len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("persisted %v, want the pending retry", ids)
+	}
+}
+
+func TestJobDeadLetteredAfterShutdownTimeoutIsPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		// Ignores cancellation, like a send stuck in a hung connection
+		close(sending)
+		<-release
+		return errors.New("relay unavailable")
+	}), map[string]string{"DLQ_FILE": path, "MAX_RETRIES": "0", "SHUTDOWN_TIMEOUT": "50ms"})
+	es.Start()
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	<-sending
+	es.Shutdown()
+
+	close(release)
+	waitFor(t, "the stuck job to be dead-lettered", func() bool {
+		return len(es.GetDeadLetterJobs()) == 1
+	})
+	if ids := persistedIDs(t, path); len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("persisted %v, want the job that outlived shutdown", ids)
+	}
+}
+
+func TestDeadLettersSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	rejectAll := senderFunc(func(context.Context, models.EmailJob) error {
+		return errors.New("relay unavailable")
+	})
+	env := map[string]string{"DLQ_FILE": path, "MAX_RETRIES": "0"}
+
+	es := newTestService(t, rejectAll, env)
+	es.Start()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi " + id, Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob %s: %v", id, err)
+		}
+	}
+	waitFor(t, "every job to be dead-lettered", func() bool {
+		return len(es.GetDeadLetterJobs()) == 3
+	})
+	es.Shutdown()
+
+	restarted := newTestService(t, rejectAll, env)
+	dead := restarted.GetDeadLetterJobs()
+	if len(dead) != 3 {
+		t.Fatalf("dead letters after restart = %d, want 3", len(dead))
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		if dead[i].ID != id || dead[i].Subject != "Hi "+id || dead[i].LastError != "relay unavailable" {
+			t.Errorf("dead letter %d = %s %q %q, want %s with its content and error", i, dead[i].ID, dead[i].Subject, dead[i].LastError, id)
+		}
+	}
+}
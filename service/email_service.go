@@ -1,49 +1,287 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"email-queue-service/config"
 	"email-queue-service/models"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
 )
 
+var (
+	// ErrQueueFull is returned when the job queue has no room for another job
+	ErrQueueFull = errors.New("queue is full")
+	// ErrShuttingDown is returned when a job is submitted after Shutdown has started
+	ErrShuttingDown = errors.New("service shutting down")
+	// ErrSubjectThrottled is returned when a recipient already got too many emails with the same subject
+	ErrSubjectThrottled = errors.New("too many emails with this subject to this recipient")
+	// ErrDomainRateLimited is returned when a synchronous send exceeds the recipient domain's rate limit
+	ErrDomainRateLimited = errors.New("recipient domain rate limit exceeded")
+	// ErrGlobalRateLimited is returned when a synchronous send exceeds the global send rate
+	ErrGlobalRateLimited = errors.New("global send rate exceeded")
+	// ErrSendTimeout is returned when a send takes longer than the send timeout
+	ErrSendTimeout = errors.New("send timed out")
+	// ErrCircuitOpen is returned when a synchronous send is held back by the circuit breaker
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrPaused is returned when a synchronous send is attempted while processing is paused
+	ErrPaused = errors.New("processing is paused")
+	// ErrTooManyBulk is returned when MAX_CONCURRENT_BULK bulk operations are already running
+	ErrTooManyBulk = errors.New("too many bulk operations in progress")
+	// ErrMessageTooLarge is matched by a MessageTooLargeError
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// MessageTooLargeError is the permanent failure of a job whose assembled
+// message is larger than MAX_MESSAGE_BYTES
+type MessageTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+// Error implements error
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("%s: %d bytes (max %d bytes)", ErrMessageTooLarge, e.Size, e.Limit)
+}
+
+// Is makes errors.Is(err, ErrMessageTooLarge) match
+func (e *MessageTooLargeError) Is(target error) bool {
+	return target == ErrMessageTooLarge
+}
+
 // EmailService handles email queue operations
 type EmailService struct {
-	jobQueue       chan models.EmailJob
-	retryQueue     chan models.EmailJob
-	deadLetterLog  []models.EmailJob
-	workers        int
-	queueSize      int
-	wg             sync.WaitGroup
-	shutdown       chan bool
-	deadLetterLock sync.RWMutex
+	jobQueue         jobQueue
+	schedule         *jobSchedule
+	clock            Clock
+	clockSkew        time.Duration // grace past a job's ExpiresAt for clients whose clocks run behind
+	retryQueue       chan models.EmailJob
+	deadLetterLog    []models.EmailJob // append-only; replace the slice, never edit entries in place
+	deadLetterStore  *deadLetterStore  // nil when the dead letter queue isn't persisted
+	sender           EmailSender
+	sendTimeout      time.Duration      // per-send deadline (none when zero)
+	stripIdentifying bool               // drop headers that fingerprint the sender before sending
+	maxMessageBytes  int                // cap on the assembled message; 0 is unlimited
+	defaultFrom      string             // sender of emails without their own From, for sizing messages
+	inflight         chan struct{}      // one token per running send; nil when MAX_INFLIGHT is unlimited
+	bulkSlots        chan struct{}      // one token per running bulk operation; nil when MAX_CONCURRENT_BULK is unlimited
+	sendCtx          context.Context    // cancelled when Shutdown gives up on in-flight sends
+	cancelSends      context.CancelFunc // cancels sendCtx
+	workers          int
+	highWorkers      int // how many of the workers take high-priority jobs only
+	queueSize        int
+	maxRetries       int
+	backoff          BackoffStrategy
+	firstRetry       time.Duration
+	retryGrace       time.Duration // how long Shutdown lets in-flight sends and pending retries finish
+	shutdownTimeout  time.Duration // how long Shutdown waits for anything before giving up on it
+	heartbeat        heartbeatConfig
+	throttle         *subjectThrottle // nil when subject throttling is disabled
+	dedupe           *dedupeCache     // nil when deduplication is disabled
+	domainLimit      *domainLimiter   // nil when per-domain rate limiting is disabled
+	globalLimit      *globalLimiter   // nil when the global send rate is unlimited
+	tenantLimit      *tenantLimiter   // nil when tenants aren't rate limited
+	vips             *vipList         // nil without VIP recipients
+	breaker          *circuitBreaker  // nil when the circuit breaker is disabled
+	sends            atomic.Int64     // send attempts, for the effective send rate
+	processed        atomic.Int64     // jobs sent, for Stats
+	failed           atomic.Int64     // jobs dead-lettered, for Stats
+	startedAt        time.Time
+	latency          *latencyWindow
+	statuses         *statusTracker
+	idempotency      *idempotencyCache
+	templates        *templateRegistry
+	panics           panicLog
+	metricsInterval  time.Duration
+	sweepInterval    time.Duration
+	sweepMaxAttempts int
+	exportInterval   time.Duration
+	uploader         Uploader // nil when dead letter export is disabled
+	redactExports    bool     // export jobs with their content hidden, like the API shows them
+	wg               sync.WaitGroup
+	delayedJobs      sync.WaitGroup // retries and throttled jobs waiting on a timer
+	retriesInFlight  sync.WaitGroup // retries from scheduling until their attempt finishes
+	shutdown         chan bool
+	enqueueStop      chan struct{} // closed when Shutdown starts, releasing enqueues waiting for room
+	enqueueTimeout   time.Duration // how long EnqueueJobWait waits for room in a full queue
+	callbacks        *callbackDispatcher
+	retryDone        chan struct{} // closed once pending retries may no longer run
+	retryStopped     chan struct{} // closed when the retry worker exits
+	shuttingDown     atomic.Bool
+	awaitingSender   atomic.Bool // set until the startup connection check finishes
+	verify           startupVerify
+	queueDegraded    atomic.Bool  // set while jobs are queued in memory because Redis is unreachable
+	enqueueLock      sync.RWMutex // held for writing while the job queue is closed
+	deadLetterLock   sync.RWMutex
+	deadLetterMaint  sync.Mutex      // serializes operations that remove dead letter entries
+	workerLock       sync.Mutex      // guards the worker pool
+	workerStops      []chan struct{} // one per running worker; closing it stops that worker
+	nextWorkerID     int
+	workersAlive     atomic.Int32 // worker goroutines that haven't exited yet
+	pauseLock        sync.Mutex
+	resumed          chan struct{} // closed by Resume; nil while not paused
+	busyLock         sync.Mutex
+	busyWorkers      map[int]busyWorker // worker ID to the job it is processing; guarded by busyLock
+	readyHighWater   float64            // queue fill ratio at which the service stops being ready
 
 	// Prometheus metrics
 	queueLength    prometheus.Gauge
+	workerCount    prometheus.Gauge
+	priorityLength *prometheus.GaugeVec
 	jobsProcessed  prometheus.Counter
 	jobsFailed     prometheus.Counter
 	deadLetterJobs prometheus.Counter
+	deadLetterSize prometheus.Gauge
+	jobsRetried    prometheus.Counter
+	retryOverflow  prometheus.Counter
+	sendsInFlight  prometheus.Gauge
+	bulkInProgress prometheus.Gauge
+	scheduledJobs  prometheus.Gauge
+	oversized      prometheus.Counter
+	mxLookups      prometheus.Gauge
+	jobDuration    prometheus.Histogram
+	queueWait      prometheus.Histogram
+
+	heartbeatSuccess     prometheus.Gauge
+	heartbeatLastSuccess prometheus.Gauge
+	throttledBySubject   prometheus.Counter
+	duplicatesSuppressed prometheus.Counter
+	vipBoosted           prometheus.Counter
+	domainRateLimited    *prometheus.CounterVec
+	tenantRateLimited    *prometheus.CounterVec
+	domainLabels         *labelCap
+	tenantLabels         *labelCap
+	jobsExpired          prometheus.Counter
+	sendRate             prometheus.Gauge
+	breakerState         prometheus.Gauge
+	degradedGauge        prometheus.Gauge
+	callbackResults      *prometheus.CounterVec
+	storedRecords        *prometheus.CounterVec
+	sweepRuns            prometheus.Counter
+	sweepRequeued        prometheus.Counter
+	exportRuns           prometheus.Counter
+	exportFailures       prometheus.Counter
+	exportedJobs         prometheus.Counter
+
+	gatherer prometheus.Gatherer // reads back the registry the metrics were registered with
 }
 
-// NewEmailService creates a new email service
-func NewEmailService(workers, queueSize int) *EmailService {
+// NewEmailService creates a new email service that delivers through sender and
+// registers its metrics with reg, or the global registry when reg is nil
+func NewEmailService(cfg *config.Config, sender EmailSender, reg prometheus.Registerer) (*EmailService, error) {
+	backoff, err := NewBackoffStrategy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lifo, err := isLIFO(cfg.ProcessingOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	useRedis, err := isRedisBackend(cfg.QueueBackend)
+	if err != nil {
+		return nil, err
+	}
+	memoryFallback, err := isMemoryFallback(cfg.RedisFallback)
+	if err != nil {
+		return nil, err
+	}
+	if useRedis && cfg.QueueVisibilityTimeout <= 0 {
+		return nil, fmt.Errorf("queue visibility timeout must be positive, got %s", cfg.QueueVisibilityTimeout)
+	}
+
+	if cfg.MetricsInterval <= 0 {
+		return nil, fmt.Errorf("metrics interval must be positive, got %s", cfg.MetricsInterval)
+	}
+
+	if cfg.StatusTTL <= 0 {
+		return nil, fmt.Errorf("status TTL must be positive, got %s", cfg.StatusTTL)
+	}
+
+	if cfg.ReadyHighWater <= 0 || cfg.ReadyHighWater > 1 {
+		return nil, fmt.Errorf("ready high-water mark must be above 0 and at most 1, got %g", cfg.ReadyHighWater)
+	}
+
+	if cfg.CircuitBreakerThreshold > 0 && cfg.CircuitBreakerCooldown <= 0 {
+		return nil, fmt.Errorf("circuit breaker cooldown must be positive, got %s", cfg.CircuitBreakerCooldown)
+	}
+
+	if cfg.IdempotencyTTL <= 0 {
+		return nil, fmt.Errorf("idempotency TTL must be positive, got %s", cfg.IdempotencyTTL)
+	}
+	if cfg.IdempotencyMaxKeys <= 0 {
+		return nil, fmt.Errorf("idempotency key limit must be positive, got %d", cfg.IdempotencyMaxKeys)
+	}
+
 	service := &EmailService{
-		jobQueue:      make(chan models.EmailJob, queueSize),
-		retryQueue:    make(chan models.EmailJob, queueSize/2), // Smaller retry queue
-		deadLetterLog: make([]models.EmailJob, 0),
-		workers:       workers,
-		queueSize:     queueSize,
-		shutdown:      make(chan bool),
+		jobQueue:         newPriorityQueue(cfg.QueueSize, lifo),
+		startedAt:        time.Now(),
+		clock:            realClock{},
+		clockSkew:        max(cfg.ClockSkewTolerance, 0),
+		retryQueue:       make(chan models.EmailJob, max(cfg.RetryQueueSize, 1)),
+		deadLetterLog:    make([]models.EmailJob, 0),
+		sender:           sender,
+		sendTimeout:      cfg.SendTimeout,
+		stripIdentifying: cfg.IdentifyingHeaders == "strip",
+		maxMessageBytes:  cfg.MaxMessageBytes,
+		defaultFrom:      cfg.DefaultFrom,
+		workers:          cfg.Workers,
+		highWorkers:      cfg.HighPriorityWorkers,
+		queueSize:        cfg.QueueSize,
+		maxRetries:       cfg.MaxRetries,
+		backoff:          backoff,
+		firstRetry:       cfg.FirstRetryDelay,
+		retryGrace:       cfg.ShutdownRetryGrace,
+		shutdownTimeout:  cfg.ShutdownTimeout,
+		busyWorkers:      make(map[int]busyWorker),
+		readyHighWater:   cfg.ReadyHighWater,
+		shutdown:         make(chan bool),
+		enqueueStop:      make(chan struct{}),
+		enqueueTimeout:   cfg.EnqueueTimeout,
+		retryDone:        make(chan struct{}),
+		retryStopped:     make(chan struct{}),
+		latency:          newLatencyWindow(latencyWindowSize),
+		statuses:         newStatusTracker(cfg.StatusTTL),
+		idempotency:      newIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys),
+		templates:        newTemplateRegistry(),
+		metricsInterval:  cfg.MetricsInterval,
+		sweepInterval:    cfg.DeadLetterSweepInterval,
+		sweepMaxAttempts: cfg.DeadLetterSweepMaxAttempts,
+		exportInterval:   cfg.DeadLetterExportInterval,
+		redactExports:    cfg.RedactContent,
+		heartbeat: heartbeatConfig{
+			interval:  cfg.HeartbeatInterval,
+			recipient: cfg.HeartbeatRecipient,
+		},
+		verify: startupVerify{
+			attempts: cfg.SMTPVerifyAttempts,
+			timeout:  cfg.SMTPVerifyTimeout,
+			backoff:  ExponentialBackoff{Base: verifyBaseDelay, Max: verifyMaxDelay},
+		},
 
 		// Initialize Prometheus metrics
 		queueLength: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "email_queue_length",
 			Help: "Current number of jobs in the email queue",
 		}),
+		workerCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_workers",
+			Help: "Current number of running workers",
+		}),
+		priorityLength: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "email_priority_queue_length",
+			Help: "Current number of jobs in each priority queue",
+		}, []string{"priority"}),
 		jobsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "email_jobs_processed_total",
 			Help: "Total number of email jobs processed",
@@ -56,183 +294,1341 @@ func NewEmailService(workers, queueSize int) *EmailService {
 			Name: "email_dead_letter_jobs_total",
 			Help: "Total number of jobs moved to dead letter queue",
 		}),
+		deadLetterSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_dead_letter_current",
+			Help: "Current number of jobs in the dead letter queue",
+		}),
+		jobsRetried: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_jobs_retried_total",
+			Help: "Total number of failed send attempts scheduled for a retry",
+		}),
+		retryOverflow: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_retry_queue_overflow_total",
+			Help: "Total number of due retries dead-lettered because the retry queue was full",
+		}),
+		bulkInProgress: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_bulk_operations_in_progress",
+			Help: "Number of bulk and merge requests currently being processed",
+		}),
+		scheduledJobs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_scheduled_jobs",
+			Help: "Number of emails held until their send_at",
+		}),
+		oversized: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_oversized_total",
+			Help: "Total number of emails failed because their message was larger than MAX_MESSAGE_BYTES",
+		}),
+		mxLookups: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_mx_lookups_in_flight",
+			Help: "Number of MX lookups currently waiting on DNS",
+		}),
+		sendsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_sends_in_flight",
+			Help: "Number of sends currently waiting on the email provider",
+		}),
+		jobDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "email_job_duration_seconds",
+			Help:    "Time taken by each send attempt, successful or not",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "email_queue_wait_seconds",
+			Help:    "Time jobs spent in a queue before a worker picked them up",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 9),
+		}),
+		heartbeatSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_heartbeat_success",
+			Help: "Whether the most recent heartbeat email was delivered (1) or failed (0)",
+		}),
+		heartbeatLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_heartbeat_last_success_timestamp_seconds",
+			Help: "Unix time of the last successfully delivered heartbeat email",
+		}),
+		throttledBySubject: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_throttled_by_subject_total",
+			Help: "Total number of emails dropped or delayed by duplicate-subject throttling",
+		}),
+		duplicatesSuppressed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_duplicates_suppressed_total",
+			Help: "Total number of emails not queued because an identical one was queued within the dedupe window",
+		}),
+		vipBoosted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_vip_boosted_total",
+			Help: "Total number of emails moved to the high-priority queue because of a VIP recipient",
+		}),
+		domainRateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "email_domain_rate_limited_total",
+			Help: "Total number of sends deferred or rejected by the per-domain rate limit",
+		}, []string{"domain"}),
+		domainLabels: newLabelCap("domain", cfg.MetricsMaxLabelValues),
+		tenantLabels: newLabelCap("tenant", cfg.MetricsMaxLabelValues),
+		tenantRateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "email_tenant_rate_limited_total",
+			Help: "Total number of emails rejected by their tenant's rate limit",
+		}, []string{"tenant"}),
+		jobsExpired: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_jobs_expired_total",
+			Help: "Total number of jobs dead-lettered because they expired before they were sent",
+		}),
+		sendRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_send_rate",
+			Help: "Send attempts per second over the last metrics interval",
+		}),
+		breakerState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_circuit_breaker_state",
+			Help: "Circuit breaker state: 0 closed, 1 open, 2 half-open",
+		}),
+		degradedGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "email_queue_degraded",
+			Help: "1 while Redis is unreachable and jobs are queued in memory, 0 otherwise",
+		}),
+		callbackResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "email_callbacks_total",
+			Help: "Total number of job callbacks by result: delivered, failed or dropped",
+		}, []string{"result"}),
+		storedRecords: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "email_stored_records_total",
+			Help: "Total number of stored job records read back that were migrated from an older layout or skipped as unreadable",
+		}, []string{"result"}),
+		sweepRuns: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_dead_letter_sweeps_total",
+			Help: "Total number of automatic dead letter sweeps run",
+		}),
+		sweepRequeued: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_dead_letter_swept_jobs_total",
+			Help: "Total number of dead letter jobs requeued by automatic sweeps",
+		}),
+		exportRuns: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_dead_letter_exports_total",
+			Help: "Total number of successful dead letter exports to object storage",
+		}),
+		exportFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_dead_letter_export_failures_total",
+			Help: "Total number of failed dead letter exports",
+		}),
+		exportedJobs: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "email_dead_letter_exported_jobs_total",
+			Help: "Total number of dead letter jobs exported and pruned locally",
+		}),
+	}
+
+	service.sendCtx, service.cancelSends = context.WithCancel(context.Background())
+
+	if cfg.DeadLetterExportBucket != "" {
+		service.uploader = &S3Uploader{
+			Endpoint:  cfg.DeadLetterExportEndpoint,
+			Bucket:    cfg.DeadLetterExportBucket,
+			Prefix:    cfg.DeadLetterExportPrefix,
+			Region:    cfg.DeadLetterExportRegion,
+			AccessKey: cfg.DeadLetterExportAccessKey,
+			SecretKey: cfg.DeadLetterExportSecretKey,
+			Client:    &http.Client{Timeout: exportUploadTimeout},
+		}
+	}
+
+	if cfg.RatePerDomain > 0 {
+		service.domainLimit = newDomainLimiter(cfg.RatePerDomain)
+	}
+
+	if cfg.GlobalRate > 0 {
+		service.globalLimit = newGlobalLimiter(cfg.GlobalRate)
+	}
+
+	if cfg.TenantRateLimit > 0 || cfg.TenantLimitsFile != "" {
+		service.tenantLimit, err = newTenantLimiter(cfg.TenantLimitsFile, cfg.TenantRateLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.VIPRecipients) > 0 || cfg.VIPRecipientsFile != "" {
+		service.vips, err = newVIPList(cfg.VIPRecipients, cfg.VIPRecipientsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	service.callbacks = newCallbackDispatcher(cfg.CallbackTimeout, cfg.CallbackMaxAttempts, cfg.CallbackSecret, service.callbackResults)
+
+	if cfg.CircuitBreakerThreshold > 0 {
+		service.breaker = newCircuitBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown, func(state breakerState) {
+			service.breakerState.Set(float64(state))
+		})
+	}
+
+	if cfg.SubjectThrottleLimit > 0 {
+		service.throttle, err = newSubjectThrottle(cfg.SubjectThrottleLimit, cfg.SubjectThrottleWindow, cfg.SubjectThrottleAction)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.MaxInFlight > 0 {
+		service.inflight = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	// Only senders that can check their connection are held back from ready
+	if _, ok := sender.(verifier); ok && service.verify.enabled() {
+		service.awaitingSender.Store(true)
+	}
+
+	if cfg.MaxConcurrentBulk > 0 {
+		service.bulkSlots = make(chan struct{}, cfg.MaxConcurrentBulk)
+	}
+
+	maxScheduled := cfg.MaxScheduled
+	if maxScheduled == 0 {
+		maxScheduled = cfg.QueueSize
+	}
+	service.schedule = newJobSchedule(maxScheduled, service.scheduledJobs)
+
+	if cfg.DedupeWindow > 0 {
+		service.dedupe = newDedupeCache(cfg.DedupeWindow, cfg.DedupeMaxKeys)
+	}
+
+	// Opened last so an invalid setting above can't leave the file open
+	if cfg.DeadLetterFile != "" {
+		store, loaded, counts, err := openDeadLetterStore(cfg.DeadLetterFile)
+		if err != nil {
+			return nil, err
+		}
+		service.storedRecords.WithLabelValues("migrated").Add(float64(counts.migrated))
+		service.storedRecords.WithLabelValues("skipped").Add(float64(counts.skipped))
+		service.deadLetterStore = store
+		service.deadLetterLog = append(service.deadLetterLog, loaded...)
+		service.updateDeadLetterSize()
+	}
+
+	// Connected last for the same reason
+	if useRedis {
+		queue, err := newRedisQueue(cfg.RedisURL, cfg.RedisQueuePrefix, cfg.QueueSize, lifo, cfg.QueueVisibilityTimeout, service.storedRecords)
+		unreachable := false
+		if err == nil {
+			if pingErr := queue.ping(); pingErr != nil {
+				if memoryFallback {
+					unreachable = true
+				} else {
+					queue.close()
+					err = pingErr
+				}
+			}
+		}
+		if err != nil {
+			if service.deadLetterStore != nil {
+				service.deadLetterStore.close()
+			}
+			return nil, err
+		}
+
+		if memoryFallback {
+			service.jobQueue = newFallbackQueue(queue, queue.ping, cfg.QueueSize, lifo, unreachable, service.setQueueDegraded)
+		} else {
+			service.jobQueue = queue
+		}
 	}
 
 	// Register metrics
-	prometheus.MustRegister(service.queueLength)
-	prometheus.MustRegister(service.jobsProcessed)
-	prometheus.MustRegister(service.jobsFailed)
-	prometheus.MustRegister(service.deadLetterJobs)
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if err := service.registerMetrics(reg); err != nil {
+		if service.deadLetterStore != nil {
+			service.deadLetterStore.close()
+		}
+		service.jobQueue.close()
+		return nil, err
+	}
+	service.gatherer = prometheus.DefaultGatherer
+	if gatherer, ok := reg.(prometheus.Gatherer); ok {
+		service.gatherer = gatherer
+	}
 
-	return service
+	return service, nil
+}
+
+// setQueueDegraded records whether the Redis queue has fallen back to memory
+func (es *EmailService) setQueueDegraded(degraded bool) {
+	es.queueDegraded.Store(degraded)
+	if degraded {
+		es.degradedGauge.Set(1)
+	} else {
+		es.degradedGauge.Set(0)
+	}
+}
+
+// registerMetrics registers every metric with reg. If one fails, the ones
+// already registered are removed again so the registry is left unchanged.
+func (es *EmailService) registerMetrics(reg prometheus.Registerer) error {
+	collectors := []prometheus.Collector{
+		es.queueLength,
+		es.workerCount,
+		es.priorityLength,
+		es.jobsProcessed,
+		es.jobsFailed,
+		es.deadLetterJobs,
+		es.deadLetterSize,
+		es.jobsRetried,
+		es.retryOverflow,
+		es.sendsInFlight,
+		es.bulkInProgress,
+		es.scheduledJobs,
+		es.mxLookups,
+		es.jobDuration,
+		es.queueWait,
+		es.heartbeatSuccess,
+		es.heartbeatLastSuccess,
+		es.throttledBySubject,
+		es.duplicatesSuppressed,
+		es.vipBoosted,
+		es.domainRateLimited,
+		es.tenantRateLimited,
+		es.jobsExpired,
+		es.oversized,
+		es.sendRate,
+		es.breakerState,
+		es.degradedGauge,
+		es.callbackResults,
+		es.storedRecords,
+		es.sweepRuns,
+		es.sweepRequeued,
+		es.exportRuns,
+		es.exportFailures,
+		es.exportedJobs,
+	}
+	collectors = append(collectors, smtpPoolCollectors(es.sender)...)
+
+	for i, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			for _, registered := range collectors[:i] {
+				reg.Unregister(registered)
+			}
+			return fmt.Errorf("register metrics: %w", err)
+		}
+	}
+	return nil
 }
 
 // Start initializes workers and monitoring
 func (es *EmailService) Start() {
 	// Start workers
-	for i := 0; i < es.workers; i++ {
-		es.wg.Add(1)
-		go es.worker(i + 1)
-	}
+	es.resizeWorkers(es.workers)
 
-	// Start retry worker
-	es.wg.Add(1)
+	// Start callback delivery; it outlives everything that can finish a job
+	es.callbacks.start()
+
+	// Start retry worker; it outlives the other workers during Shutdown
 	go es.retryWorker()
 
+	// Start the scheduler for delayed delivery
+	es.wg.Add(1)
+	go es.scheduleLoop()
+
 	// Start queue length monitoring
 	go es.monitorQueueLength()
 
-	log.Printf("Started %d workers with queue size %d", es.workers, es.queueSize)
+	// Start dead letter sweeper if configured
+	if es.sweepInterval > 0 && es.sweepMaxAttempts > 0 {
+		es.wg.Add(1)
+		go es.sweepLoop()
+	}
+
+	// Start dead letter exporter if configured
+	if es.exportInterval > 0 && es.uploader != nil {
+		es.wg.Add(1)
+		go es.exportLoop()
+	}
+
+	// Check the email provider can be reached if configured
+	if es.awaitingSender.Load() {
+		es.wg.Add(1)
+		go es.verifyLoop()
+	}
+
+	// Start synthetic heartbeat if configured
+	if es.heartbeat.enabled() {
+		es.wg.Add(1)
+		go es.heartbeatLoop()
+	}
+
+	slog.Info("Email service started", "workers", es.workers, "high_priority_workers", es.highWorkers, "queue_size", es.queueSize)
 }
 
-// EnqueueJob adds a job to the queue
+// EnqueueJob adds a job to the queue, failing right away if it is full
 func (es *EmailService) EnqueueJob(job models.EmailJob) error {
-	select {
-	case es.jobQueue <- job:
+	return es.enqueue(context.Background(), job, 0)
+}
+
+// EnqueueJobWait adds a job to the queue. If the queue is full it waits up to
+// the enqueue timeout for room, or until ctx is done, before giving up with
+// ErrQueueFull.
+func (es *EmailService) EnqueueJobWait(ctx context.Context, job models.EmailJob) error {
+	return es.enqueue(ctx, job, es.enqueueTimeout)
+}
+
+// enqueue adds a job to the queue, waiting up to wait for room
+func (es *EmailService) enqueue(ctx context.Context, job models.EmailJob, wait time.Duration) (err error) {
+	es.enqueueLock.RLock()
+	defer es.enqueueLock.RUnlock()
+
+	if es.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+
+	es.boostVIP(&job)
+
+	// The same email again within the window is most likely a repeated call
+	if es.dedupe != nil && !job.Heartbeat {
+		hash := dedupeHash(job)
+		if original, ok := es.dedupe.claim(hash, job.ID, time.Now()); !ok {
+			slog.Info("Duplicate email suppressed", "event", "duplicate", "job_id", job.ID, "original_job_id", original, "recipient", job.To)
+			es.duplicatesSuppressed.Inc()
+			return &DuplicateError{OriginalID: original}
+		}
+		defer func() {
+			// A job that wasn't queued can be submitted again
+			if err != nil {
+				es.dedupe.release(hash, job.ID)
+			}
+		}()
+	}
+
+	// Charged after the dedupe check, and given back if the job isn't
+	// accepted, so only emails that are queued count against the tenant
+	if err := es.takeTenantToken(job); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			es.returnTenantToken(job)
+		}
+	}()
+
+	// Throttling applies once a scheduled job is due
+	if es.scheduled(job) {
+		return es.scheduleJob(job)
+	}
+	return es.admit(ctx, job, wait)
+}
+
+// takeTenantToken charges a submitted job to its tenant's rate limit
+func (es *EmailService) takeTenantToken(job models.EmailJob) error {
+	if es.tenantLimit == nil {
 		return nil
-	default:
-		return fmt.Errorf("queue is full")
 	}
+	limit, ok := es.tenantLimit.take(job.Tenant, time.Now())
+	if ok {
+		return nil
+	}
+	es.tenantRateLimited.WithLabelValues(es.tenantLabels.value(job.Tenant)).Inc()
+	return &TenantRateLimitError{Tenant: job.Tenant, Limit: limit}
 }
 
-// worker processes jobs from the queue
-func (es *EmailService) worker(id int) {
-	defer es.wg.Done()
+// returnTenantToken gives back the token takeTenantToken charged for a job
+// that wasn't accepted after all
+func (es *EmailService) returnTenantToken(job models.EmailJob) {
+	if es.tenantLimit != nil {
+		es.tenantLimit.giveBack(job.Tenant, time.Now())
+	}
+}
+
+// ReloadTenantLimits rereads the tenant limits file. If it can't be read, the
+// limits already loaded stay in force.
+func (es *EmailService) ReloadTenantLimits() error {
+	if es.tenantLimit == nil {
+		return nil
+	}
+	return es.tenantLimit.reload()
+}
+
+// admit applies the subject throttle and queues the job.
+// Callers must hold enqueueLock for reading.
+func (es *EmailService) admit(ctx context.Context, job models.EmailJob, wait time.Duration) error {
+	if es.throttle != nil && !job.Heartbeat {
+		delay, ok := es.throttle.reserve(throttleKey(job.To, job.Subject), time.Now(), true)
+		if !ok {
+			es.throttledBySubject.Inc()
+			return ErrSubjectThrottled
+		}
+		if delay > 0 {
+			es.throttledBySubject.Inc()
+			es.delayEnqueue(job, delay)
+			return nil
+		}
+	}
+
+	return es.pushJobWait(ctx, job, wait)
+}
+
+// pushJob adds a job to the main queue; callers must hold enqueueLock for reading
+func (es *EmailService) pushJob(job models.EmailJob) error {
+	job.EnqueuedAt = time.Now()
+
+	// Recorded up front so a worker picking the job straight up isn't overwritten
+	es.setStatus(job, StatusQueued)
+
+	err := es.jobQueue.push(job)
+	if err != nil {
+		es.statuses.forget(job.ID)
+	}
+	return err
+}
+
+// pushJobWait is pushJob, but if the queue is full it waits up to wait for a
+// worker to make room, giving up early if ctx is done or shutdown starts.
+// Callers must hold enqueueLock for reading.
+func (es *EmailService) pushJobWait(ctx context.Context, job models.EmailJob, wait time.Duration) error {
+	err := es.pushJob(job)
+	if wait <= 0 || !errors.Is(err, ErrQueueFull) {
+		return err
+	}
 
-	log.Printf("Worker %d started", id)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
 
 	for {
+		// Taken before retrying so a pop in between isn't missed
+		freed := es.jobQueue.spaceFreed(job.Priority)
+		if err = es.pushJob(job); !errors.Is(err, ErrQueueFull) {
+			return err
+		}
+
 		select {
-		case job := <-es.jobQueue:
-			es.processJob(job, id)
-		case job := <-es.retryQueue:
-			es.processJob(job, id)
+		case <-freed:
+		case <-timer.C:
+			return err
+		case <-ctx.Done():
+			return err
+		case <-es.enqueueStop:
+			return ErrShuttingDown
+		}
+	}
+}
+
+// delayEnqueue holds a throttled job until its send slot opens, then queues it.
+// Callers must hold enqueueLock for reading so Shutdown can't miss the job.
+func (es *EmailService) delayEnqueue(job models.EmailJob, delay time.Duration) {
+	slog.Info("Subject throttle delaying email", "event", "throttle_delayed", "job_id", job.ID, "recipient", job.To, "delay", delay.String())
+	es.setStatus(job, StatusQueued)
+
+	es.delayedJobs.Add(1)
+	go func() {
+		defer es.delayedJobs.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
 		case <-es.shutdown:
-			log.Printf("Worker %d shutting down", id)
+			slog.Warn("Shutting down with throttled email pending, moving to dead letter queue", "job_id", job.ID, "recipient", job.To)
+			es.moveToDeadLetter(job)
+			return
+		}
+
+		es.enqueueLock.RLock()
+		defer es.enqueueLock.RUnlock()
+
+		if es.shuttingDown.Load() {
+			es.moveToDeadLetter(job)
 			return
 		}
+		if err := es.pushJob(job); err != nil {
+			slog.Error("Failed to enqueue throttled email", "job_id", job.ID, "recipient", job.To, "error", err)
+			es.moveToDeadLetter(job)
+		}
+	}()
+}
+
+// queueDepth returns the number of jobs waiting in the main queue
+func (es *EmailService) queueDepth() int {
+	total := 0
+	for _, length := range es.jobQueue.laneLengths() {
+		total += length
 	}
+	return total
 }
 
-// retryWorker handles retry logic
-func (es *EmailService) retryWorker() {
+// queueCapacity returns how many jobs the main queue can hold across all lanes
+func (es *EmailService) queueCapacity() int {
+	return es.jobQueue.laneCapacity() * len(priorities)
+}
+
+// worker processes jobs from the queue, always taking the highest priority
+// job available, until shutdown or until stop is closed
+func (es *EmailService) worker(id int, stop <-chan struct{}, highOnly bool) {
 	defer es.wg.Done()
 
-	log.Println("Retry worker started")
+	es.workersAlive.Add(1)
+	defer es.workersAlive.Add(-1)
+
+	slog.Debug("Worker started", "worker_id", id)
 
 	for {
 		select {
 		case <-es.shutdown:
-			log.Println("Retry worker shutting down")
+			slog.Debug("Worker shutting down", "worker_id", id)
+			return
+		case <-stop:
+			slog.Debug("Worker stopped", "worker_id", id)
 			return
 		default:
-			// Process any remaining retry jobs during shutdown
+		}
+
+		if resumed := es.pauseSignal(); resumed != nil {
+			select {
+			case <-resumed:
+			case <-es.shutdown:
+				slog.Debug("Worker shutting down", "worker_id", id)
+				return
+			case <-stop:
+				slog.Debug("Worker stopped", "worker_id", id)
+				return
+			}
+			continue
+		}
+
+		if job, ok := es.take(highOnly); ok {
+			es.processJob(job, id)
+			es.jobQueue.ack(job)
+			continue
+		}
+
+		// Nothing waiting, so block until a job might be. Retries may be of
+		// any priority, so they are left to the other workers.
+		ready, retries := es.jobQueue.ready(), es.retryQueue
+		if highOnly {
+			ready, retries = es.jobQueue.readyHigh(), nil
+		}
+		select {
+		case <-ready:
+			// Loop round to take it
+		case job := <-retries:
+			// Processing may have been paused while this worker waited
+			if es.holdRetryWhilePaused(job, es.shutdown, stop) {
+				es.processRetry(job, id)
+			}
+		case <-es.shutdown:
+			slog.Debug("Worker shutting down", "worker_id", id)
+			return
+		case <-stop:
+			slog.Debug("Worker stopped", "worker_id", id)
+			return
+		}
+	}
+}
+
+// take pops the next job a worker may process: a high-priority one only
+// for a worker dedicated to them
+func (es *EmailService) take(highOnly bool) (models.EmailJob, bool) {
+	if highOnly {
+		return es.jobQueue.tryPopHigh()
+	}
+	return es.jobQueue.tryPop()
+}
+
+// retryWorker handles retry logic
+func (es *EmailService) retryWorker() {
+	defer close(es.retryStopped)
+
+	slog.Debug("Retry worker started")
+
+	for {
+		if resumed := es.pauseSignal(); resumed != nil {
 			select {
-			case job := <-es.retryQueue:
-				es.processJob(job, 0) // 0 indicates retry worker
-			case <-time.After(100 * time.Millisecond):
-				// Short timeout to check shutdown frequently
+			case <-resumed:
+			case <-es.retryDone:
+				slog.Debug("Retry worker shutting down")
+				return
+			}
+			continue
+		}
+
+		select {
+		case job := <-es.retryQueue:
+			if es.holdRetryWhilePaused(job, nil, es.retryDone) {
+				es.processRetry(job, 0) // 0 indicates retry worker
 			}
+		case <-es.retryDone:
+			slog.Debug("Retry worker shutting down")
+			return
 		}
 	}
 }
 
-// processJob simulates sending an email
+// processRetry runs a retry attempt and marks the retry as finished
+func (es *EmailService) processRetry(job models.EmailJob, workerID int) {
+	defer es.retriesInFlight.Done()
+	es.processJob(job, workerID)
+}
+
+// processJob sends an email and routes failures into the retry logic
 func (es *EmailService) processJob(job models.EmailJob, workerID int) {
+	es.markBusy(workerID, job)
+	defer es.markIdle(workerID)
+
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Worker %d recovered from panic: %v", workerID, r)
+			slog.Error("Worker recovered from panic", "event", "panic", "worker_id", workerID, "job_id", job.ID, "recipient", job.To, "panic", fmt.Sprint(r))
+			es.recordPanic(job, workerID, r)
 		}
 	}()
 
-	log.Printf("Worker %d processing email to %s: %s", workerID, job.To, job.Subject)
+	// A stale email is worse than none, e.g. an expired one-time code. Just
+	// past expires_at is more likely the client's clock running behind.
+	now := time.Now()
+	if job.Expired(now) && !job.Expired(now.Add(-es.clockSkew)) {
+		slog.Info("Sending email just past its expiry within the clock skew tolerance", "event", "clock_skew_tolerated", "worker_id", workerID, "job_id", job.ID, "recipient", job.To, "expires_at", job.ExpiresAt.Format(time.RFC3339))
+	}
+	if job.Expired(now.Add(-es.clockSkew)) {
+		slog.Warn("Email expired before it was sent, moving to dead letter queue", "event", "expired", "worker_id", workerID, "job_id", job.ID, "recipient", job.To, "expires_at", job.ExpiresAt.Format(time.RFC3339))
+		job.Reason = models.ReasonExpired
+		es.jobsExpired.Inc()
+		es.moveToDeadLetter(job)
+		return
+	}
 
-	// Simulate email sending with potential failure (10% failure rate for demo)
-	time.Sleep(1 * time.Second)
+	// The provider looks down: come back once the breaker may let a send
+	// through, without using up a retry or a rate limit slot
+	if wait, ok := es.allowSend(); !ok {
+		slog.Debug("Deferring email: circuit breaker open", "event", "circuit_open", "worker_id", workerID, "job_id", job.ID, "recipient", job.To, "delay", wait.String())
+		es.scheduleRetry(job, wait)
+		return
+	}
+
+	// Over the domain's rate: come back when the reserved slot is due, without
+	// using up a retry
+	if wait := es.reserveDomainSlot(&job); wait > 0 {
+		slog.Info("Deferring email: domain rate limit", "event", "domain_rate_limited", "worker_id", workerID, "job_id", job.ID, "recipient", job.To, "delay", wait.String())
+		es.domainRateLimited.WithLabelValues(es.domainLabels.value(recipientDomain(job.To))).Inc()
+		es.scheduleRetry(job, wait)
+		return
+	}
+
+	if !es.awaitSendSlot(workerID) {
+		slog.Warn("Shutting down before sending email, moving to dead letter queue", "worker_id", workerID, "job_id", job.ID, "recipient", job.To)
+		es.moveToDeadLetter(job)
+		return
+	}
+
+	if !job.EnqueuedAt.IsZero() {
+		wait := time.Since(job.EnqueuedAt)
+		es.latency.observe(wait)
+		es.queueWait.Observe(wait.Seconds())
+	}
 
-	// Simulate occasional failures for retry demonstration
-	if job.Retries == 0 && len(job.Subject) > 10 && job.Subject[len(job.Subject)-1] == '!' {
-		// Fail jobs ending with '!' on first try
-		es.handleJobFailure(job)
+	slog.Debug("Processing email", "event", "processing", "worker_id", workerID, "job_id", job.ID, "recipient", job.To)
+	es.setStatus(job, StatusProcessing)
+
+	provider, err := es.send(es.sendCtx, job)
+	if err != nil {
+		slog.Warn("Failed to send email", "event", "send_failed", "worker_id", workerID, "job_id", job.ID, "recipient", job.To, "error", err)
+		es.handleJobFailure(job, workerID, err)
 		return
 	}
 
-	log.Printf("Worker %d successfully sent email to %s", workerID, job.To)
+	slog.Info("Email sent", "event", "sent", "worker_id", workerID, "job_id", job.ID, "recipient", job.To, "provider", provider)
+	if job.Deliveries != nil {
+		// The rest of the recipients an earlier attempt left over
+		job.Deliveries = job.WithDeliveries(job.PendingRecipients(), nil)
+		es.statuses.setDeliveries(job.ID, job.Deliveries)
+	}
+	es.statuses.set(job.ID, StatusSent, provider, time.Now())
 	es.jobsProcessed.Inc()
+	es.processed.Add(1)
+	es.callbacks.dispatch(job, StatusSent, provider)
+
+	if job.Heartbeat {
+		es.recordHeartbeat(true)
+	}
+}
+
+// SendNow delivers a job synchronously, bypassing the queue and retries. The
+// send is abandoned if ctx is cancelled.
+func (es *EmailService) SendNow(ctx context.Context, job models.EmailJob) error {
+	if es.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+
+	// Pausing stops every send, not just the queued ones
+	if es.Paused() {
+		return ErrPaused
+	}
+
+	if err := es.takeTenantToken(job); err != nil {
+		return err
+	}
+
+	// A synchronous send can't be held back, so over-limit sends are rejected
+	if es.throttle != nil {
+		if _, ok := es.throttle.reserve(throttleKey(job.To, job.Subject), time.Now(), false); !ok {
+			es.throttledBySubject.Inc()
+			return ErrSubjectThrottled
+		}
+	}
+
+	if es.domainLimit != nil && !es.domainLimit.take(recipientDomain(job.To), time.Now()) {
+		es.domainRateLimited.WithLabelValues(es.domainLabels.value(recipientDomain(job.To))).Inc()
+		return ErrDomainRateLimited
+	}
+
+	if es.globalLimit != nil && !es.globalLimit.take(time.Now()) {
+		return ErrGlobalRateLimited
+	}
+
+	if _, ok := es.allowSend(); !ok {
+		return ErrCircuitOpen
+	}
+
+	provider, err := es.send(ctx, job)
+	if err != nil {
+		slog.Warn("Synchronous send failed", "event", "send_failed", "job_id", job.ID, "recipient", job.To, "error", err)
+		return err
+	}
+
+	slog.Info("Email sent synchronously", "event", "sent", "job_id", job.ID, "recipient", job.To, "provider", provider)
+	es.jobsProcessed.Inc()
+	es.processed.Add(1)
+	return nil
+}
+
+// send delivers job through the sender within the send timeout, recording the
+// attempt and its duration. It returns the provider that accepted the email,
+// if the sender reports one. A send cut short by ctx is never a permanent
+// failure, whatever the provider reported.
+func (es *EmailService) send(ctx context.Context, job models.EmailJob) (string, error) {
+	if es.stripIdentifying {
+		job.Headers = withoutIdentifyingHeaders(job.Headers)
+	}
+
+	// The provider would only reject it, less clearly, after the upload
+	if err := es.checkMessageSize(job); err != nil {
+		return "", err
+	}
+
+	if err := es.acquireSendSlot(ctx); err != nil {
+		return "", fmt.Errorf("send cancelled while waiting for a send slot: %w", err)
+	}
+	defer es.releaseSendSlot()
+
+	es.sends.Add(1)
+	start := time.Now()
+	defer func() {
+		es.jobDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	if es.sendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, es.sendTimeout)
+		defer cancel()
+	}
+
+	var provider string
+	var err error
+	if sender, ok := es.sender.(ProviderSender); ok {
+		provider, err = sender.SendVia(ctx, job)
+	} else {
+		err = es.sender.Send(ctx, job)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// Cancelled by the caller, which says nothing about the provider
+			return "", fmt.Errorf("send cancelled: %v", err)
+		}
+		err = fmt.Errorf("%w after %s: %v", ErrSendTimeout, es.sendTimeout, err)
+	}
+
+	// A permanent failure means the provider is up and answering
+	if es.breaker != nil {
+		es.breaker.record(err == nil || IsPermanent(err), time.Now())
+	}
+	return provider, err
+}
+
+// checkMessageSize fails job permanently if its message, assembled as it
+// would be sent, is larger than MAX_MESSAGE_BYTES
+func (es *EmailService) checkMessageSize(job models.EmailJob) error {
+	if es.maxMessageBytes <= 0 {
+		return nil
+	}
+	size := len(buildMessage(es.defaultFrom, job, time.Now()))
+	if size <= es.maxMessageBytes {
+		return nil
+	}
+	es.oversized.Inc()
+	return Permanent(&MessageTooLargeError{Size: size, Limit: es.maxMessageBytes})
+}
+
+// acquireSendSlot waits until fewer than MAX_INFLIGHT sends are running, or
+// until ctx is done
+func (es *EmailService) acquireSendSlot(ctx context.Context) error {
+	if es.inflight != nil {
+		select {
+		case es.inflight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	es.sendsInFlight.Inc()
+	return nil
+}
+
+// releaseSendSlot frees the slot taken by acquireSendSlot
+func (es *EmailService) releaseSendSlot() {
+	es.sendsInFlight.Dec()
+	if es.inflight != nil {
+		<-es.inflight
+	}
+}
+
+// BeginBulk claims a slot for a bulk operation, failing with ErrTooManyBulk
+// rather than waiting when MAX_CONCURRENT_BULK are already running. The
+// returned function frees the slot.
+func (es *EmailService) BeginBulk() (func(), error) {
+	if es.bulkSlots != nil {
+		select {
+		case es.bulkSlots <- struct{}{}:
+		default:
+			return nil, ErrTooManyBulk
+		}
+	}
+	es.bulkInProgress.Inc()
+
+	return func() {
+		es.bulkInProgress.Dec()
+		if es.bulkSlots != nil {
+			<-es.bulkSlots
+		}
+	}, nil
+}
+
+// MXLookupsInFlight returns the gauge MX checks count their DNS lookups in
+func (es *EmailService) MXLookupsInFlight() prometheus.Gauge {
+	return es.mxLookups
+}
+
+// allowSend asks the circuit breaker whether a send may go ahead now. If not,
+// it returns how long to wait before asking again.
+func (es *EmailService) allowSend() (time.Duration, bool) {
+	if es.breaker == nil {
+		return 0, true
+	}
+	return es.breaker.allow(time.Now())
+}
+
+// reserveDomainSlot reserves a send slot for the job's recipient domain and
+// returns how long until it is due. A job deferred earlier already holds its
+// slot, so it isn't charged twice.
+func (es *EmailService) reserveDomainSlot(job *models.EmailJob) time.Duration {
+	if es.domainLimit == nil {
+		return 0
+	}
+	if job.DomainSlotReserved {
+		job.DomainSlotReserved = false
+		return 0
+	}
+
+	wait := es.domainLimit.reserve(recipientDomain(job.To), time.Now())
+	job.DomainSlotReserved = wait > 0
+	return wait
+}
+
+// awaitSendSlot blocks until the global send rate allows another send. It
+// reports false if the worker has to stop first; the reserved slot is not
+// given back.
+func (es *EmailService) awaitSendSlot(workerID int) bool {
+	if es.globalLimit == nil {
+		return true
+	}
+
+	wait := es.globalLimit.reserve(time.Now())
+	if wait <= 0 {
+		return true
+	}
+
+	// The retry worker keeps sending through the shutdown retry grace period
+	shutdown, retryDone := (<-chan bool)(es.shutdown), (<-chan struct{})(nil)
+	if workerID == 0 {
+		shutdown, retryDone = nil, es.retryDone
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-shutdown:
+		return false
+	case <-retryDone:
+		return false
+	}
 }
 
-// handleJobFailure manages retry logic and dead letter queue
-func (es *EmailService) handleJobFailure(job models.EmailJob) {
+// handleJobFailure records a failed send and manages retry logic and dead letter queue
+func (es *EmailService) handleJobFailure(job models.EmailJob, workerID int, err error) {
 	job.Retries++
+	job.LastError = err.Error()
+	job.FailedAt = time.Now()
+	job.Attempts = job.WithAttempt(models.Attempt{At: job.FailedAt, WorkerID: workerID, Error: job.LastError})
+	es.recordDeliveries(&job, err)
 
-	if job.Retries <= 3 {
-		log.Printf("Job failed, retrying (%d/3): %s", job.Retries, job.To)
+	if IsPermanent(err) {
+		if errors.Is(err, ErrMessageTooLarge) {
+			job.Reason = models.ReasonMessageTooLarge
+		}
+		slog.Warn("Job failed permanently, not retrying", "event", "failed", "job_id", job.ID, "recipient", job.To, "error", job.LastError)
+		es.moveToDeadLetter(job)
+		return
+	}
 
-		// Add delay before retry
-		go func() {
-			time.Sleep(time.Duration(job.Retries) * time.Second)
-			select {
-			case es.retryQueue <- job:
-			default:
-				// If retry queue is full, move to dead letter
-				es.moveToDeadLetter(job)
-			}
-		}()
+	if job.Retries <= es.maxRetries {
+		slog.Info("Scheduling retry", "event", "retry_scheduled", "job_id", job.ID, "recipient", job.To, "attempt", job.Retries, "max_retries", es.maxRetries)
+		es.setStatus(job, StatusRetrying)
+		es.jobsRetried.Inc()
+
+		delay := es.retryDelay(job.Retries)
+		var greylisted *GreylistError
+		if errors.As(err, &greylisted) && greylisted.Delay > delay {
+			// Retrying sooner would only be greylisted again
+			slog.Info("Relay greylisted the email, waiting before the retry", "event", "greylisted", "job_id", job.ID, "recipient", job.To, "delay", greylisted.Delay.String())
+			delay = greylisted.Delay
+		}
+		es.scheduleRetry(job, delay)
 	} else {
-		log.Printf("Job permanently failed after 3 retries: %s", job.To)
+		slog.Warn("Job permanently failed", "event", "failed", "job_id", job.ID, "recipient", job.To, "retries", es.maxRetries, "error", job.LastError)
 		es.moveToDeadLetter(job)
 	}
 }
 
+// scheduleRetry puts job on the retry queue after delay. The wait is tracked so
+// Shutdown can account for it.
+func (es *EmailService) scheduleRetry(job models.EmailJob, delay time.Duration) {
+	es.delayedJobs.Add(1)
+	es.retriesInFlight.Add(1)
+	go func() {
+		defer es.delayedJobs.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-es.retryDone:
+		}
+
+		// A due retry waits out a pause rather than overflow the retry queue
+		if resumed := es.pauseSignal(); resumed != nil {
+			select {
+			case <-resumed:
+			case <-es.retryDone:
+			}
+		}
+
+		// Check again after the timer: select picks at random when both are
+		// ready, and the retry worker may already be gone
+		if es.retriesStopped() {
+			slog.Warn("Shutting down with retry pending, moving to dead letter queue", "job_id", job.ID, "recipient", job.To)
+			es.moveToDeadLetter(job)
+			es.retriesInFlight.Done()
+			return
+		}
+
+		job.EnqueuedAt = time.Now()
+		select {
+		case es.retryQueue <- job:
+		default:
+			// If retry queue is full, move to dead letter
+			slog.Warn("Retry queue is full, moving retry to dead letter queue", "event", "retry_overflow", "job_id", job.ID, "recipient", job.To)
+			job.Reason = models.ReasonRetryQueueFull
+			es.retryOverflow.Inc()
+			es.moveToDeadLetter(job)
+			es.retriesInFlight.Done()
+		}
+	}()
+}
+
+// retriesStopped reports whether Shutdown has stopped running retries
+func (es *EmailService) retriesStopped() bool {
+	select {
+	case <-es.retryDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay returns how long to wait before the given retry attempt
+func (es *EmailService) retryDelay(attempt int) time.Duration {
+	delay := es.backoff.NextDelay(attempt)
+
+	// The first retry always waits at least the configured grace window
+	if attempt == 1 && delay < es.firstRetry {
+		delay = es.firstRetry
+	}
+	return delay
+}
+
 // moveToDeadLetter adds job to dead letter queue
 func (es *EmailService) moveToDeadLetter(job models.EmailJob) {
+	// A requeued job has to wait for a fresh domain slot
+	job.DomainSlotReserved = false
+	if job.FailedAt.IsZero() {
+		job.FailedAt = time.Now()
+	}
+
 	es.deadLetterLock.Lock()
 	defer es.deadLetterLock.Unlock()
 
 	es.deadLetterLog = append(es.deadLetterLog, job)
+	es.updateDeadLetterSize()
+	es.persistDeadLetters(job)
+	es.setStatus(job, StatusDeadLettered)
 	es.jobsFailed.Inc()
+	es.failed.Add(1)
 	es.deadLetterJobs.Inc()
+	es.callbacks.dispatch(job, StatusDeadLettered, "")
+
+	if job.Heartbeat {
+		es.recordHeartbeat(false)
+	}
+
+	slog.Error("Job moved to dead letter queue", "event", "dead_lettered", "job_id", job.ID, "recipient", job.To, "error", job.LastError)
+}
 
-	log.Printf("Job moved to dead letter queue: %s", job.To)
+// updateDeadLetterSize sets the dead letter gauge to the size of the log.
+// Callers must hold deadLetterLock.
+func (es *EmailService) updateDeadLetterSize() {
+	es.deadLetterSize.Set(float64(len(es.deadLetterLog)))
 }
 
-// GetDeadLetterJobs returns copy of dead letter jobs
+// persistDeadLetters appends newly dead-lettered jobs to the dead letter file.
+// Callers must hold deadLetterLock.
+func (es *EmailService) persistDeadLetters(jobs ...models.EmailJob) {
+	if es.deadLetterStore == nil {
+		return
+	}
+	if err := es.deadLetterStore.append(jobs...); err != nil {
+		slog.Error("Failed to persist dead letter jobs", "error", err)
+	}
+}
+
+// persistDeadLetterLog rewrites the dead letter file after entries were removed.
+// Callers must hold deadLetterLock.
+func (es *EmailService) persistDeadLetterLog() {
+	if es.deadLetterStore == nil {
+		return
+	}
+	if err := es.deadLetterStore.rewrite(es.deadLetterLog); err != nil {
+		slog.Error("Failed to rewrite dead letter file", "error", err)
+	}
+}
+
+// GetDeadLetterJobs returns a read-only snapshot of dead letter jobs.
+// The snapshot shares storage with the log instead of copying it: entries are
+// never modified once appended, and capping the capacity means later appends
+// can't write into the caller's view. Callers must not modify the result.
 func (es *EmailService) GetDeadLetterJobs() []models.EmailJob {
 	es.deadLetterLock.RLock()
 	defer es.deadLetterLock.RUnlock()
 
-	jobs := make([]models.EmailJob, len(es.deadLetterLog))
-	copy(jobs, es.deadLetterLog)
+	n := len(es.deadLetterLog)
+	return es.deadLetterLog[:n:n]
+}
+
+// TenantDeadLetterJobs returns the dead letter jobs submitted by tenant, ""
+// meaning jobs without a tenant, so tenants sharing the service only ever
+// see their own
+func (es *EmailService) TenantDeadLetterJobs(tenant string) []models.EmailJob {
+	es.deadLetterLock.RLock()
+	defer es.deadLetterLock.RUnlock()
+
+	jobs := make([]models.EmailJob, 0) // listed as [], not null
+	for _, job := range es.deadLetterLog {
+		if job.Tenant == tenant {
+			jobs = append(jobs, job)
+		}
+	}
 	return jobs
 }
 
 // monitorQueueLength updates Prometheus gauge
 func (es *EmailService) monitorQueueLength() {
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(es.metricsInterval)
 	defer ticker.Stop()
 
+	lastTick, lastSends := time.Now(), es.sends.Load()
 	for {
 		select {
-		case <-ticker.C:
-			es.queueLength.Set(float64(len(es.jobQueue)))
+		case now := <-ticker.C:
+			sends := es.sends.Load()
+			es.sendRate.Set(float64(sends-lastSends) / now.Sub(lastTick).Seconds())
+			lastTick, lastSends = now, sends
+
+			lengths := es.jobQueue.laneLengths()
+			total := 0
+			for i, length := range lengths {
+				es.priorityLength.WithLabelValues(priorities[i]).Set(float64(length))
+				total += length
+			}
+			es.queueLength.Set(float64(total))
 		case <-es.shutdown:
 			return
 		}
 	}
 }
 
-// Shutdown gracefully stops the service
+// Shutdown gracefully stops the service. Once it returns, no goroutine started
+// by the service is left to push to the job or retry queue, and every job that
+// wasn't sent is in the dead letter queue.
 func (es *EmailService) Shutdown() {
-	log.Println("Shutting down email service...")
+	slog.Info("Shutting down email service")
 
-	// Close job queue to prevent new jobs
-	close(es.jobQueue)
+	// Stop accepting new jobs. Enqueues waiting for room give up first so they
+	// release the enqueue lock; holding it ensures no EnqueueJob call is
+	// mid-push once the flag is set.
+	close(es.enqueueStop)
+	es.enqueueLock.Lock()
+	es.shuttingDown.Store(true)
+	es.enqueueLock.Unlock()
 
 	// Signal all workers to stop
 	close(es.shutdown)
 
+	// Nothing is waited for past the shutdown timeout, so a worker stuck in a
+	// send that ignores cancellation can't hold up shutdown forever
+	deadline := time.Now().Add(es.shutdownTimeout)
+
+	// Sends still in flight when the grace period is over are cancelled, so a
+	// hung provider can't hold up shutdown past it
+	grace := min(es.retryGrace, es.shutdownTimeout)
+	graceEnd := time.Now().Add(grace)
+	if grace > 0 {
+		cancelTimer := time.AfterFunc(grace, es.cancelSends)
+		defer cancelTimer.Stop()
+	}
+
 	// Wait for all workers to finish
-	es.wg.Wait()
+	workersDone := make(chan struct{})
+	go func() {
+		es.wg.Wait()
+		close(workersDone)
+	}()
+	stuck := !waitUntil(workersDone, deadline)
+	if stuck {
+		es.cancelSends()
+		es.logStuckWorkers()
+	}
+
+	// Only the retry worker is left, so give pending retries the rest of the
+	// grace period to finish before stopping it
+	es.awaitRetries(time.Until(graceEnd))
+	close(es.retryDone)
+	if !waitUntil(es.retryStopped, deadline) && !stuck {
+		es.logStuckWorkers()
+	}
+	es.cancelSends()
+
+	// Nothing can schedule retries any more, so wait for the pending timers
+	// and dead-letter anything left in the retry queue rather than losing it
+	es.delayedJobs.Wait()
+	es.drainRetryQueue()
+	es.drainJobQueue()
+	es.drainSchedule()
+
+	// No job can finish any more, so deliver the callbacks still queued
+	es.callbacks.stop(min(callbackDrainTimeout, max(time.Until(deadline), 0)))
+
+	if err := es.jobQueue.close(); err != nil {
+		slog.Error("Failed to close job queue", "error", err)
+	}
+	es.closeConnectionPools()
+
+	// Only a worker stuck past the shutdown timeout can still reach the dead
+	// letter queue, and the store reopens the file for it
+	if es.deadLetterStore != nil {
+		es.deadLetterLock.Lock()
+		err := es.deadLetterStore.close()
+		es.deadLetterLock.Unlock()
+		if err != nil {
+			slog.Error("Failed to close dead letter file", "error", err)
+		}
+	}
+
+	slog.Info("Email service shutdown complete")
+}
+
+// WriteMetricsSnapshot writes the current metric values in Prometheus text format to path
+func (es *EmailService) WriteMetricsSnapshot(path string) error {
+	families, err := es.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gather metrics: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create snapshot file: %w", err)
+	}
+	defer file.Close()
+
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(file, family); err != nil {
+			return fmt.Errorf("write metric %s: %w", family.GetName(), err)
+		}
+	}
 
-	log.Println("Email service shutdown complete")
+	return file.Sync()
+}
+
+// awaitRetries waits up to grace for pending retries to finish
+func (es *EmailService) awaitRetries(grace time.Duration) {
+	if grace <= 0 {
+		return
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		es.retriesInFlight.Wait()
+		close(finished)
+	}()
+
+	slog.Info("Waiting for pending retries", "grace", grace.Round(time.Millisecond).String())
+	select {
+	case <-finished:
+		slog.Info("Pending retries finished")
+	case <-time.After(grace):
+		slog.Warn("Retry grace period expired, moving remaining retries to dead letter queue")
+	}
+}
+
+// drainJobQueue dead-letters jobs the workers didn't get to before shutdown.
+// A persistent queue keeps them for the next start or another replica instead.
+func (es *EmailService) drainJobQueue() {
+	if es.jobQueue.persistent() {
+		return
+	}
+
+	for {
+		job, ok := es.jobQueue.tryPop()
+		if !ok {
+			return
+		}
+		slog.Warn("Job not processed before shutdown", "job_id", job.ID, "recipient", job.To)
+		es.moveToDeadLetter(job)
+	}
+}
+
+// drainRetryQueue moves any jobs still waiting in the retry queue to the dead
+// letter queue. It sends nothing, so it doesn't wait out a pause.
+func (es *EmailService) drainRetryQueue() {
+	for {
+		select {
+		case job := <-es.retryQueue:
+			slog.Warn("Retry not processed before shutdown", "job_id", job.ID, "recipient", job.To)
+			es.moveToDeadLetter(job)
+			es.retriesInFlight.Done()
+		default:
+			return
+		}
+	}
 }
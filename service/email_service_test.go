@@ -0,0 +1,599 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// senderFunc adapts a function to EmailSender
+type senderFunc func(ctx context.Context, job models.EmailJob) error
+
+// Send implements EmailSender
+func (f senderFunc) Send(ctx context.Context, job models.EmailJob) error {
+	return f(ctx, job)
+}
+
+// newTestService creates a service configured from env on top of settings
+// that keep tests fast: one worker, no circuit breaker and 10ms retries. The
+// service isn't started.
+func newTestService(t *testing.T, sender EmailSender, env map[string]string) *EmailService {
+	t.Helper()
+
+	defaults := map[string]string{
+		"WORKERS":           "1",
+		"RETRY_BACKOFF":     "fixed",
+		"RETRY_FIXED_DELAY": "10ms",
+		"SHUTDOWN_TIMEOUT":  "5s",
+	}
+	for key, value := range defaults {
+		if _, ok := env[key]; !ok {
+			t.Setenv(key, value)
+		}
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	es, err := NewEmailService(config.LoadConfig(), sender, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewEmailService: %v", err)
+	}
+	return es
+}
+
+// startTestService is newTestService, started and shut down when the test ends
+func startTestService(t *testing.T, sender EmailSender, env map[string]string) *EmailService {
+	t.Helper()
+
+	es := newTestService(t, sender, env)
+	es.Start()
+	t.Cleanup(es.Shutdown)
+	return es
+}
+
+// waitFor fails the test if cond doesn't hold within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// metricValue reads the unlabelled gauge or counter called name from the
+// registry es registered its metrics with
+func metricValue(t *testing.T, es *EmailService, name string) float64 {
+	t.Helper()
+
+	families, err := es.gatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name || len(family.GetMetric()) == 0 {
+			continue
+		}
+		metric := family.GetMetric()[0]
+		if metric.GetGauge() != nil {
+			return metric.GetGauge().GetValue()
+		}
+		return metric.GetCounter().GetValue()
+	}
+	t.Fatalf("metric %s not registered", name)
+	return 0
+}
+
+// fillDeadLetters adds n dead letter jobs directly to the log
+func fillDeadLetters(es *EmailService, n int) {
+	es.deadLetterLock.Lock()
+	defer es.deadLetterLock.Unlock()
+
+	for i := 0; i < n; i++ {
+		es.deadLetterLog = append(es.deadLetterLog, models.EmailJob{ID: "job", To: "user@example.com", Subject: "Hello", Body: "Hello"})
+	}
+}
+
+// benchmarkDeadLetterReads reads the dead letter queue with read while other
+// goroutines keep dead-lettering jobs
+func benchmarkDeadLetterReads(b *testing.B, read func(es *EmailService) []models.EmailJob) {
+	es, err := NewEmailService(config.LoadConfig(), nil, prometheus.NewRegistry())
+	if err != nil {
+		b.Fatalf("NewEmailService: %v", err)
+	}
+	const size = 100000
+	fillDeadLetters(es, size)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					fillDeadLetters(es, 1)
+				}
+
+				// Keep the log from growing without bound; truncating the
+				// capacity leaves existing snapshots untouched
+				es.deadLetterLock.Lock()
+				if len(es.deadLetterLog) > 2*size {
+					es.deadLetterLog = es.deadLetterLog[:size:size]
+				}
+				es.deadLetterLock.Unlock()
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if jobs := read(es); len(jobs) == 0 {
+				b.Fatal("empty dead letter queue")
+			}
+		}
+	})
+}
+
+func BenchmarkGetDeadLetterJobs(b *testing.B) {
+	benchmarkDeadLetterReads(b, (*EmailService).GetDeadLetterJobs)
+}
+
+// BenchmarkGetDeadLetterJobsCopy is the full copy under the read lock that
+// GetDeadLetterJobs replaced, for comparison
+func BenchmarkGetDeadLetterJobsCopy(b *testing.B) {
+	benchmarkDeadLetterReads(b, func(es *EmailService) []models.EmailJob {
+		es.deadLetterLock.RLock()
+		defer es.deadLetterLock.RUnlock()
+
+		jobs := make([]models.EmailJob, len(es.deadLetterLog))
+		copy(jobs, es.deadLetterLog)
+		return jobs
+	})
+}
+
+func TestGetDeadLetterJobsSnapshotIsStable(t *testing.T) {
+	es := newTestService(t, nil, nil)
+	es.moveToDeadLetter(models.EmailJob{ID: "a", To: "a@example.com"})
+
+	snapshot := es.GetDeadLetterJobs()
+	es.moveToDeadLetter(models.EmailJob{ID: "b", To: "b@example.com"})
+
+	if len(snapshot) != 1 || snapshot[0].ID != "a" {
+		t.Fatalf("snapshot = %+v, want only a", snapshot)
+	}
+	// Appending to the snapshot must not write into the log
+	_ = append(snapshot, models.EmailJob{ID: "c"})
+	if jobs := es.GetDeadLetterJobs(); len(jobs) != 2 || jobs[1].ID != "b" {
+		t.Errorf("log = %+v, want a and b", jobs)
+	}
+}
+
+func TestBeginBulkLimitsConcurrentOperations(t *testing.T) {
+	es := newTestService(t, nil, map[string]string{"MAX_CONCURRENT_BULK": "2"})
+
+	first, err := es.BeginBulk()
+	if err != nil {
+		t.Fatalf("first BeginBulk: %v", err)
+	}
+	second, err := es.BeginBulk()
+	if err != nil {
+		t.Fatalf("second BeginBulk: %v", err)
+	}
+	if got := metricValue(t, es, "email_bulk_operations_in_progress"); got != 2 {
+		t.Errorf("email_bulk_operations_in_progress = %v, want 2", got)
+	}
+	if _, err := es.BeginBulk(); !errors.Is(err, ErrTooManyBulk) {
+		t.Fatalf("third BeginBulk error = %v, want ErrTooManyBulk", err)
+	}
+
+	first()
+	third, err := es.BeginBulk()
+	if err != nil {
+		t.Fatalf("BeginBulk after a release: %v", err)
+	}
+	second()
+	third()
+	if got := metricValue(t, es, "email_bulk_operations_in_progress"); got != 0 {
+		t.Errorf("email_bulk_operations_in_progress = %v, want 0", got)
+	}
+}
+
+func TestProcessJobToleratesClockSkewAroundExpiry(t *testing.T) {
+	tests := []struct {
+		name        string
+		tolerance   string
+		expiredBy   time.Duration
+		wantExpired bool
+	}{
+		{"just past within tolerance", "2s", time.Second, false},
+		{"past the tolerance", "2s", 5 * time.Second, true},
+		{"no tolerance", "0s", time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := 0
+			es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+				sent++
+				return nil
+			}), map[string]string{"CLOCK_SKEW_TOLERANCE": tt.tolerance})
+
+			expiresAt := time.Now().Add(-tt.expiredBy)
+			es.processJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Code", Body: "123456", ExpiresAt: &expiresAt}, 1)
+
+			dead := es.GetDeadLetterJobs()
+			if tt.wantExpired {
+				if sent != 0 || len(dead) != 1 || dead[0].Reason != models.ReasonExpired {
+					t.Errorf("sent %d, dead letters %+v; want the job dead-lettered as expired", sent, dead)
+				}
+				return
+			}
+			if sent != 1 || len(dead) != 0 {
+				t.Errorf("sent %d, dead letters %+v; want the job sent", sent, dead)
+			}
+		})
+	}
+}
+
+func TestOversizedMessageFailsPermanently(t *testing.T) {
+	job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Report", Body: "See attached",
+		Attachments: []models.Attachment{{Filename: "report.csv", ContentType: "text/csv", Content: make([]byte, 4096)}}}
+	size := len(buildMessage("noreply@localhost", job, time.Now()))
+
+	tests := []struct {
+		name    string
+		limit   int
+		wantErr bool
+	}{
+		{"at the cap", size, false},
+		{"just over the cap", size - 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := 0
+			es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+				sent++
+				return nil
+			}), map[string]string{"MAX_MESSAGE_BYTES": strconv.Itoa(tt.limit), "DEFAULT_FROM": "noreply@localhost"})
+
+			es.processJob(job, 1)
+
+			dead := es.GetDeadLetterJobs()
+			if !tt.wantErr {
+				if sent != 1 || len(dead) != 0 {
+					t.Errorf("sent %d, dead letters %+v; want the job sent", sent, dead)
+				}
+				return
+			}
+			if sent != 0 {
+				t.Error("oversized message reached the sender")
+			}
+			if len(dead) != 1 || dead[0].Reason != models.ReasonMessageTooLarge || dead[0].Retries != 1 {
+				t.Fatalf("dead letters = %+v, want the job dead-lettered as too large without retries", dead)
+			}
+			if want := fmt.Sprintf("%d bytes (max %d bytes)", size, tt.limit); !strings.Contains(dead[0].LastError, want) {
+				t.Errorf("last error = %q, want it to contain %q", dead[0].LastError, want)
+			}
+			if got := metricValue(t, es, "email_oversized_total"); got != 1 {
+				t.Errorf("email_oversized_total = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestScheduledJobsGauge(t *testing.T) {
+	es := newTestService(t, nil, map[string]string{"MAX_SCHEDULED": "3"})
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		job := models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi", SendAt: time.Now().Add(time.Hour)}
+		err := es.EnqueueJob(job)
+		if id == "d" {
+			if !errors.Is(err, ErrScheduleFull) {
+				t.Errorf("fourth scheduled job error = %v, want ErrScheduleFull", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("EnqueueJob %s: %v", id, err)
+		}
+	}
+	if got := metricValue(t, es, "email_scheduled_jobs"); got != 3 {
+		t.Errorf("email_scheduled_jobs = %v, want 3", got)
+	}
+
+	es.schedule.popDue(time.Now().Add(2 * time.Hour))
+	if got := metricValue(t, es, "email_scheduled_jobs"); got != 0 {
+		t.Errorf("email_scheduled_jobs after the jobs came due = %v, want 0", got)
+	}
+}
+
+func TestMetricsSnapshotHasProcessedCount(t *testing.T) {
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error { return nil }), nil)
+	es.Start()
+	for _, id := range []string{"a", "b"} {
+		if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob %s: %v", id, err)
+		}
+	}
+	waitFor(t, "both jobs sent", func() bool {
+		return metricValue(t, es, "email_jobs_processed_total") == 2
+	})
+	es.Shutdown()
+
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	if err := es.WriteMetricsSnapshot(path); err != nil {
+		t.Fatalf("WriteMetricsSnapshot: %v", err)
+	}
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(snapshot), "\nemail_jobs_processed_total 2\n") {
+		t.Errorf("snapshot lacks the processed count:\n%s", snapshot)
+	}
+
+	if err := es.WriteMetricsSnapshot(filepath.Join(t.TempDir(), "missing", "metrics.prom")); err == nil {
+		t.Error("WriteMetricsSnapshot into a missing directory succeeded")
+	}
+}
+
+func TestShutdownAccountsForPendingRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantSent int
+		wantDead int
+	}{
+		{"dead-lettered without a grace period", map[string]string{"RETRY_FIXED_DELAY": "1h"}, 0, 5},
+		{"sent within the grace period", map[string]string{"RETRY_FIXED_DELAY": "50ms", "SHUTDOWN_RETRY_GRACE": "3s"}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempted := make(map[string]bool)
+			sent := 0
+			es := newTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if !attempted[job.ID] {
+					attempted[job.ID] = true
+					return errors.New("relay unavailable")
+				}
+				sent++
+				return nil
+			}), tt.env)
+			es.Start()
+
+			for i := 0; i < 5; i++ {
+				id := strconv.Itoa(i)
+				if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi " + id, Body: "Hi"}); err != nil {
+					t.Fatalf("EnqueueJob %s: %v", id, err)
+				}
+			}
+			waitFor(t, "every retry to be scheduled", func() bool {
+				for i := 0; i < 5; i++ {
+					if record, ok := es.JobStatus(strconv.Itoa(i)); !ok || record.Status != StatusRetrying {
+						return false
+					}
+				}
+				return true
+			})
+			es.Shutdown()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if dead := es.GetDeadLetterJobs(); sent != tt.wantSent || len(dead) != tt.wantDead {
+				t.Errorf("sent %d, dead-lettered %d; want %d and %d", sent, len(dead), tt.wantSent, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestEnqueueJobAfterShutdown(t *testing.T) {
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error { return nil }), nil)
+	es.Start()
+
+	// Callers racing Shutdown either get their job in or a clear error
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := es.EnqueueJob(models.EmailJob{ID: fmt.Sprintf("%d-%d", i, j), To: "user@example.com", Subject: "Hi", Body: "Hi"})
+				if err != nil && !errors.Is(err, ErrShuttingDown) && !errors.Is(err, ErrQueueFull) {
+					t.Errorf("EnqueueJob during shutdown: %v", err)
+				}
+			}
+		}(i)
+	}
+	es.Shutdown()
+	wg.Wait()
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "late", To: "user@example.com", Subject: "Hi", Body: "Hi"}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("EnqueueJob after Shutdown = %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestQueueLengthGaugeUpdatesAtMetricsInterval(t *testing.T) {
+	const interval = 100 * time.Millisecond
+
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error { return nil }), map[string]string{"METRICS_INTERVAL": interval.String()})
+	// Queued jobs stay queued, so the gauge has something to report
+	es.Pause()
+	started := time.Now()
+	es.Start()
+	t.Cleanup(es.Shutdown)
+
+	for i := 0; i < 3; i++ {
+		if err := es.EnqueueJob(models.EmailJob{ID: strconv.Itoa(i), To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+	if got := metricValue(t, es, "email_queue_length"); got != 0 {
+		t.Errorf("email_queue_length = %v before the first tick, want 0", got)
+	}
+
+	waitFor(t, "the queue length gauge to update", func() bool {
+		return metricValue(t, es, "email_queue_length") == 3
+	})
+	if elapsed := time.Since(started); elapsed < interval {
+		t.Errorf("gauge updated after %s, want no sooner than %s", elapsed, interval)
+	}
+
+	cfg := config.LoadConfig()
+	cfg.MetricsInterval = 0
+	if _, err := NewEmailService(cfg, nil, prometheus.NewRegistry()); err == nil || !strings.Contains(err.Error(), "metrics interval") {
+		t.Errorf("NewEmailService with a zero metrics interval = %v, want it rejected", err)
+	}
+}
+
+func TestShutdownDropsNothingInFlight(t *testing.T) {
+	var attempts atomic.Int32
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		attempts.Add(1)
+		time.Sleep(time.Millisecond)
+		return errors.New("relay unavailable")
+	}), map[string]string{"RETRY_FIXED_DELAY": "5ms", "MAX_RETRIES": "1000"})
+	es.Start()
+
+	const jobs = 20
+	for i := 0; i < jobs; i++ {
+		if err := es.EnqueueJob(models.EmailJob{ID: strconv.Itoa(i), To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+	// Some jobs are queued, one is being sent and others wait to be retried
+	waitFor(t, "retries under way", func() bool { return attempts.Load() > jobs })
+	es.Shutdown()
+
+	seen := make(map[string]int)
+	for _, job := range es.GetDeadLetterJobs() {
+		seen[job.ID]++
+	}
+	for i := 0; i < jobs; i++ {
+		if id := strconv.Itoa(i); seen[id] != 1 {
+			t.Errorf("job %s dead-lettered %d times, want once", id, seen[id])
+		}
+	}
+}
+
+// TestShutdownRacingRetriesStress is most useful under the race detector
+func TestShutdownRacingRetriesStress(t *testing.T) {
+	failing := senderFunc(func(context.Context, models.EmailJob) error {
+		return errors.New("relay unavailable")
+	})
+	for round := 0; round < 20; round++ {
+		es := newTestService(t, failing, map[string]string{"WORKERS": "4", "RETRY_FIXED_DELAY": "1ms", "MAX_RETRIES": "1000"})
+		es.Start()
+
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 25; i++ {
+					es.EnqueueJob(models.EmailJob{ID: fmt.Sprintf("%d-%d-%d", round, g, i), To: "user@example.com", Subject: "Hi", Body: "Hi"})
+				}
+			}(g)
+		}
+		time.Sleep(time.Duration(round%5) * time.Millisecond)
+		es.Shutdown()
+		wg.Wait()
+
+		// Once Shutdown returns nothing is left running to queue a retry
+		before := es.queueDepth()
+		time.Sleep(5 * time.Millisecond)
+		if after := es.queueDepth(); after != before {
+			t.Fatalf("round %d: queue depth went from %d to %d after Shutdown", round, before, after)
+		}
+	}
+}
+
+func TestServicesWithSeparateRegistries(t *testing.T) {
+	cfg := config.LoadConfig()
+	first, err := NewEmailService(cfg, nil, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("first NewEmailService: %v", err)
+	}
+	second, err := NewEmailService(cfg, nil, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("second NewEmailService: %v", err)
+	}
+	if first.queueLength == second.queueLength {
+		t.Error("services share their collectors")
+	}
+
+	// Registering twice with one registry is an error, not a panic
+	shared := prometheus.NewRegistry()
+	if _, err := NewEmailService(cfg, nil, shared); err != nil {
+		t.Fatalf("NewEmailService: %v", err)
+	}
+	if _, err := NewEmailService(cfg, nil, shared); err == nil {
+		t.Error("second service on the same registry didn't report an error")
+	}
+}
+
+// histogramCount returns the number of observations of the unlabelled histogram name
+func histogramCount(t *testing.T, es *EmailService, name string) uint64 {
+	t.Helper()
+
+	families, err := es.gatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name && len(family.GetMetric()) > 0 {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	t.Fatalf("metric %s not registered", name)
+	return 0
+}
+
+func TestLatencyHistogramsCountEveryJob(t *testing.T) {
+	var attempts atomic.Int32
+	es := startTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		if job.ID == "flaky" && attempts.Add(1) == 1 {
+			return errors.New("421 service not available")
+		}
+		return nil
+	}), nil)
+
+	for _, id := range []string{"a", "b", "flaky"} {
+		if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+	waitFor(t, "every job sent", func() bool {
+		record, ok := es.JobStatus("flaky")
+		return ok && record.Status == StatusSent
+	})
+
+	// One observation per send attempt, including the failed one
+	if got := histogramCount(t, es, "email_job_duration_seconds"); got != 4 {
+		t.Errorf("email_job_duration_seconds count = %d, want 4", got)
+	}
+	if got := histogramCount(t, es, "email_queue_wait_seconds"); got < 3 {
+		t.Errorf("email_queue_wait_seconds count = %d, want at least one per job", got)
+	}
+}
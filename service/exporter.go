@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// Uploader stores an exported object under the given key
+type Uploader interface {
+	Upload(ctx context.Context, key string, body []byte) error
+}
+
+// exportUploadTimeout bounds a single dead letter export upload
+const exportUploadTimeout = 2 * time.Minute
+
+// exportLoop periodically exports dead letter jobs until shutdown
+func (es *EmailService) exportLoop() {
+	defer es.wg.Done()
+
+	ticker := time.NewTicker(es.exportInterval)
+	defer ticker.Stop()
+
+	slog.Info("Dead letter exporter started", "interval", es.exportInterval.String())
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := es.exportDeadLetters(); err != nil {
+				slog.Error("Dead letter export failed, entries kept for next cycle", "error", err)
+			}
+		case <-es.shutdown:
+			slog.Debug("Dead letter exporter shutting down")
+			return
+		}
+	}
+}
+
+// exportDeadLetters uploads the current dead letter jobs as JSON lines and,
+// once the upload succeeds, removes them from the log. With REDACT_CONTENT
+// the uploaded jobs have their content hidden, so the export can't be used to
+// read emails the API won't show.
+func (es *EmailService) exportDeadLetters() error {
+	es.deadLetterMaint.Lock()
+	defer es.deadLetterMaint.Unlock()
+
+	// Only appends can happen while we hold the maintenance lock, so the
+	// first len(jobs) entries are still exactly these jobs after the upload
+	jobs := es.GetDeadLetterJobs()
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, job := range jobs {
+		if es.redactExports {
+			job = job.Redacted()
+		}
+		if err := encoder.Encode(job); err != nil {
+			es.exportFailures.Inc()
+			return fmt.Errorf("encode job for %s: %w", job.To, err)
+		}
+	}
+
+	key := fmt.Sprintf("dead-letter-%s.jsonl", time.Now().UTC().Format("20060102T150405.000000000Z"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportUploadTimeout)
+	defer cancel()
+
+	if err := es.uploader.Upload(ctx, key, body.Bytes()); err != nil {
+		es.exportFailures.Inc()
+		return err
+	}
+
+	es.deadLetterLock.Lock()
+	es.deadLetterLog = append(make([]models.EmailJob, 0), es.deadLetterLog[len(jobs):]...)
+	es.updateDeadLetterSize()
+	es.persistDeadLetterLog()
+	es.deadLetterLock.Unlock()
+
+	es.exportRuns.Inc()
+	es.exportedJobs.Add(float64(len(jobs)))
+	slog.Info("Dead letter jobs exported", "count", len(jobs), "key", key)
+	return nil
+}
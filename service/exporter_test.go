@@ -0,0 +1,137 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+// recordingUploader keeps every object uploaded to it, or fails with err
+type recordingUploader struct {
+	mu      sync.Mutex
+	err     error
+	objects map[string][]byte
+}
+
+// Upload implements Uploader
+func (u *recordingUploader) Upload(_ context.Context, key string, body []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.err != nil {
+		return u.err
+	}
+	if u.objects == nil {
+		u.objects = make(map[string][]byte)
+	}
+	u.objects[key] = body
+	return nil
+}
+
+// exportedJobs decodes every job uploaded so far
+func (u *recordingUploader) exportedJobs(t *testing.T) []models.EmailJob {
+	t.Helper()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var jobs []models.EmailJob
+	for key, body := range u.objects {
+		if !strings.HasPrefix(key, "dead-letter-") || !strings.HasSuffix(key, ".jsonl") {
+			t.Errorf("unexpected object key %q", key)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var job models.EmailJob
+			if err := json.Unmarshal(scanner.Bytes(), &job); err != nil {
+				t.Fatalf("decode exported line %q: %v", scanner.Text(), err)
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+func TestExportDeadLetters(t *testing.T) {
+	es := newTestService(t, nil, nil)
+	uploader := &recordingUploader{}
+	es.uploader = uploader
+
+	es.moveToDeadLetter(models.EmailJob{ID: "a", To: "a@example.com", Subject: "Reset code", Body: "123456"})
+	es.moveToDeadLetter(models.EmailJob{ID: "b", To: "b@example.com", Subject: "Welcome", Body: "Hello"})
+
+	if err := es.exportDeadLetters(); err != nil {
+		t.Fatalf("exportDeadLetters: %v", err)
+	}
+
+	jobs := uploader.exportedJobs(t)
+	if len(jobs) != 2 || jobs[0].ID != "a" || jobs[1].ID != "b" {
+		t.Fatalf("exported %+v, want jobs a and b", jobs)
+	}
+	if jobs[0].Subject != "Reset code" || jobs[0].Body != "123456" {
+		t.Errorf("exported content = %q / %q, want it unredacted", jobs[0].Subject, jobs[0].Body)
+	}
+	if n := len(es.GetDeadLetterJobs()); n != 0 {
+		t.Errorf("%d jobs left after export, want 0", n)
+	}
+}
+
+func TestExportDeadLettersRedactsContent(t *testing.T) {
+	es := newTestService(t, nil, map[string]string{"REDACT_CONTENT": "true"})
+	uploader := &recordingUploader{}
+	es.uploader = uploader
+
+	es.moveToDeadLetter(models.EmailJob{
+		ID:          "a",
+		To:          "a@example.com",
+		Subject:     "Reset code",
+		Body:        "123456",
+		Attachments: []models.Attachment{{Filename: "invoice.pdf", ContentType: "application/pdf", Content: []byte("%PDF")}},
+	})
+
+	if err := es.exportDeadLetters(); err != nil {
+		t.Fatalf("exportDeadLetters: %v", err)
+	}
+
+	jobs := uploader.exportedJobs(t)
+	if len(jobs) != 1 {
+		t.Fatalf("exported %d jobs, want 1", len(jobs))
+	}
+	job := jobs[0]
+	if job.Subject != models.RedactedPlaceholder || job.Body != models.RedactedPlaceholder {
+		t.Errorf("exported content = %q / %q, want it redacted", job.Subject, job.Body)
+	}
+	if job.To != "a@example.com" {
+		t.Errorf("exported recipient = %q, want it kept", job.To)
+	}
+	if len(job.Attachments) != 1 || job.Attachments[0].Filename != "invoice.pdf" || job.Attachments[0].Content != nil {
+		t.Errorf("exported attachments = %+v, want the name without content", job.Attachments)
+	}
+
+	for _, body := range uploader.objects {
+		if bytes.Contains(body, []byte("123456")) || bytes.Contains(body, []byte("Reset code")) {
+			t.Errorf("uploaded payload contains email content: %s", body)
+		}
+	}
+}
+
+func TestExportDeadLettersKeepsJobsWhenUploadFails(t *testing.T) {
+	es := newTestService(t, nil, nil)
+	es.uploader = &recordingUploader{err: errors.New("bucket unreachable")}
+
+	es.moveToDeadLetter(models.EmailJob{ID: "a", To: "a@example.com"})
+
+	if err := es.exportDeadLetters(); err == nil {
+		t.Fatal("exportDeadLetters succeeded with a failing uploader")
+	}
+	if n := len(es.GetDeadLetterJobs()); n != 1 {
+		t.Errorf("%d jobs left after a failed export, want 1", n)
+	}
+}
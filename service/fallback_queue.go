@@ -0,0 +1,233 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// redisResyncInterval is how often a degraded queue checks whether Redis is
+// back and moves the jobs it holds in memory there
+const redisResyncInterval = time.Second
+
+// isMemoryFallback parses the configured Redis fallback ("fail" or "memory")
+func isMemoryFallback(fallback string) (bool, error) {
+	switch strings.ToLower(fallback) {
+	case "", "fail":
+		return false, nil
+	case "memory":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown Redis fallback %q", fallback)
+	}
+}
+
+// fallbackQueue is a jobQueue that keeps jobs in primary, normally Redis, and
+// switches to an in-memory queue while primary can't be reached. Once probe
+// succeeds again, the jobs held in memory are moved back to primary. Jobs in
+// memory are lost if the process dies, which is the price of staying up.
+type fallbackQueue struct {
+	primary  jobQueue
+	probe    func() error // checks whether primary can be reached
+	memory   *priorityQueue
+	onChange func(degraded bool)
+
+	mu       sync.Mutex
+	degraded bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newFallbackQueue wraps primary, falling back to a memory queue whose lanes
+// each hold capacity jobs. It starts degraded when degraded is true, e.g.
+// because primary couldn't be reached at startup.
+func newFallbackQueue(primary jobQueue, probe func() error, capacity int, lifo, degraded bool, onChange func(bool)) *fallbackQueue {
+	q := &fallbackQueue{
+		primary:  primary,
+		probe:    probe,
+		memory:   newPriorityQueue(capacity, lifo),
+		onChange: onChange,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if degraded {
+		q.mu.Lock()
+		q.degrade(errors.New("unreachable at startup"))
+		q.mu.Unlock()
+	}
+
+	go q.resyncLoop()
+	return q
+}
+
+// push implements jobQueue
+func (q *fallbackQueue) push(job models.EmailJob) error {
+	if !q.isDegraded() {
+		err := q.primary.push(job)
+		if !errors.Is(err, ErrQueueUnavailable) {
+			return err
+		}
+		q.mu.Lock()
+		q.degrade(err)
+		q.mu.Unlock()
+	}
+
+	// Held so resync can't leave degraded mode with this job still in memory
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.memory.push(job)
+}
+
+// tryPop implements jobQueue. While degraded only the memory queue is used,
+// so workers don't wait on a server that isn't answering.
+func (q *fallbackQueue) tryPop() (models.EmailJob, bool) {
+	if job, ok := q.memory.tryPop(); ok {
+		return job, true
+	}
+	if q.isDegraded() {
+		return models.EmailJob{}, false
+	}
+	return q.primary.tryPop()
+}
+
+// tryPopHigh implements jobQueue like tryPop
+func (q *fallbackQueue) tryPopHigh() (models.EmailJob, bool) {
+	if job, ok := q.memory.tryPopHigh(); ok {
+		return job, true
+	}
+	if q.isDegraded() {
+		return models.EmailJob{}, false
+	}
+	return q.primary.tryPopHigh()
+}
+
+// ready implements jobQueue. Wakeups from primary are forwarded into the
+// memory queue's, so it covers both.
+func (q *fallbackQueue) ready() <-chan struct{} {
+	return q.memory.ready()
+}
+
+// readyHigh implements jobQueue like ready
+func (q *fallbackQueue) readyHigh() <-chan struct{} {
+	return q.memory.readyHigh()
+}
+
+// ack implements jobQueue; primary ignores jobs it didn't hand out
+func (q *fallbackQueue) ack(job models.EmailJob) {
+	q.primary.ack(job)
+}
+
+// spaceFreed implements jobQueue
+func (q *fallbackQueue) spaceFreed(priority string) <-chan struct{} {
+	if q.isDegraded() {
+		return q.memory.spaceFreed(priority)
+	}
+	return q.primary.spaceFreed(priority)
+}
+
+// laneLengths implements jobQueue, counting jobs in both queues
+func (q *fallbackQueue) laneLengths() []int {
+	lengths := q.primary.laneLengths()
+	for i, length := range q.memory.laneLengths() {
+		lengths[i] += length
+	}
+	return lengths
+}
+
+// laneCapacity implements jobQueue
+func (q *fallbackQueue) laneCapacity() int {
+	return q.primary.laneCapacity()
+}
+
+// persistent implements jobQueue. While jobs are held in memory they don't
+// outlive the process, so shutdown has to deal with them; tryPop then only
+// hands out those, leaving primary's jobs where they are.
+func (q *fallbackQueue) persistent() bool {
+	return !q.isDegraded()
+}
+
+// close implements jobQueue
+func (q *fallbackQueue) close() error {
+	close(q.stop)
+	<-q.done
+	return q.primary.close()
+}
+
+// isDegraded reports whether jobs currently go to the memory queue
+func (q *fallbackQueue) isDegraded() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.degraded
+}
+
+// degrade switches new jobs to the memory queue. Callers must hold mu.
+func (q *fallbackQueue) degrade(cause error) {
+	if q.degraded {
+		return
+	}
+	q.degraded = true
+	slog.Error("Redis unreachable, queuing jobs in memory until it recovers; they are lost if the process dies", "event", "queue_degraded", "error", cause)
+	q.onChange(true)
+}
+
+// resyncLoop forwards primary's wakeups and, while degraded, moves jobs back
+// to primary once it can be reached
+func (q *fallbackQueue) resyncLoop() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(redisResyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.primary.ready():
+			q.memory.signal()
+		case <-q.primary.readyHigh():
+			q.memory.signalHigh()
+		case <-ticker.C:
+			if q.isDegraded() {
+				q.resync()
+			}
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// resync moves the jobs held in memory to primary if it can be reached, and
+// leaves degraded mode once they are all there
+func (q *fallbackQueue) resync() {
+	if err := q.probe(); err != nil {
+		return
+	}
+
+	// Held throughout so no push can take the room a job being moved leaves
+	// behind, and none can land in memory after it was emptied
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	moved := 0
+	for {
+		job, ok := q.memory.tryPop()
+		if !ok {
+			break
+		}
+		if err := q.primary.push(job); err != nil {
+			// Still full or unreachable: keep it for the next attempt
+			q.memory.push(job)
+			slog.Warn("Redis reachable but jobs held in memory could not all be moved back yet", "moved", moved, "error", err)
+			return
+		}
+		moved++
+	}
+
+	q.degraded = false
+	slog.Info("Redis reachable again, jobs held in memory moved back", "event", "queue_recovered", "moved", moved)
+	q.onChange(false)
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// flakyQueue is an in-memory jobQueue standing in for Redis that can be
+// taken down
+type flakyQueue struct {
+	*priorityQueue
+	down atomic.Bool
+}
+
+func newFlakyQueue(capacity int) *flakyQueue {
+	return &flakyQueue{priorityQueue: newPriorityQueue(capacity, false)}
+}
+
+func (q *flakyQueue) push(job models.EmailJob) error {
+	if q.down.Load() {
+		return fmt.Errorf("%w: connection refused", ErrQueueUnavailable)
+	}
+	return q.priorityQueue.push(job)
+}
+
+func (q *flakyQueue) tryPop() (models.EmailJob, bool) {
+	if q.down.Load() {
+		return models.EmailJob{}, false
+	}
+	return q.priorityQueue.tryPop()
+}
+
+func (q *flakyQueue) tryPopHigh() (models.EmailJob, bool) {
+	if q.down.Load() {
+		return models.EmailJob{}, false
+	}
+	return q.priorityQueue.tryPopHigh()
+}
+
+func (q *flakyQueue) persistent() bool { return true }
+
+func (q *flakyQueue) probe() error {
+	if q.down.Load() {
+		return ErrQueueUnavailable
+	}
+	return nil
+}
+
+// degradedLog records the changes a fallbackQueue reports
+type degradedLog struct {
+	mu      sync.Mutex
+	changes []bool
+}
+
+func (l *degradedLog) record(degraded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.changes = append(l.changes, degraded)
+}
+
+func (l *degradedLog) get() []bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]bool(nil), l.changes...)
+}
+
+func newTestFallbackQueue(t *testing.T, primary *flakyQueue, degraded bool) (*fallbackQueue, *degradedLog) {
+	t.Helper()
+
+	changes := &degradedLog{}
+	q := newFallbackQueue(primary, primary.probe, 10, false, degraded, changes.record)
+	t.Cleanup(func() { q.close() })
+	return q, changes
+}
+
+func TestFallbackQueueUsesPrimaryWhileReachable(t *testing.T) {
+	primary := newFlakyQueue(10)
+	q, changes := newTestFallbackQueue(t, primary, false)
+
+	if err := q.push(models.EmailJob{ID: "a"}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if got := primary.len(); got != 1 {
+		t.Errorf("primary holds %d jobs, want 1", got)
+	}
+	if !q.persistent() {
+		t.Error("persistent() = false while Redis is reachable")
+	}
+	if job, ok := q.tryPop(); !ok || job.ID != "a" {
+		t.Errorf("tryPop = %q, %v; want a", job.ID, ok)
+	}
+	if got := changes.get(); len(got) != 0 {
+		t.Errorf("degraded changes = %v, want none", got)
+	}
+}
+
+func TestFallbackQueueDegradesWhenPrimaryIsUnreachable(t *testing.T) {
+	primary := newFlakyQueue(10)
+	if err := primary.push(models.EmailJob{ID: "in-redis"}); err != nil {
+		t.Fatal(err)
+	}
+	q, changes := newTestFallbackQueue(t, primary, false)
+
+	primary.down.Store(true)
+	for _, id := range []string{"a", "b"} {
+		if err := q.push(models.EmailJob{ID: id}); err != nil {
+			t.Fatalf("push %s: %v", id, err)
+		}
+	}
+
+	if got := changes.get(); len(got) != 1 || !got[0] {
+		t.Errorf("degraded changes = %v, want [true]", got)
+	}
+	if q.persistent() {
+		t.Error("persistent() = true while jobs are held in memory")
+	}
+	if got := q.memory.len(); got != 2 {
+		t.Errorf("memory holds %d jobs, want 2", got)
+	}
+	if got := q.laneLengths()[priorityLane(models.PriorityNormal)]; got != 3 {
+		t.Errorf("normal lane length = %d, want 3 across both queues", got)
+	}
+
+	// Only memory is used while degraded, even if Redis answers pops
+	primary.down.Store(false)
+	for _, want := range []string{"a", "b"} {
+		if job, ok := q.tryPop(); !ok || job.ID != want {
+			t.Fatalf("tryPop = %q, %v; want %s", job.ID, ok, want)
+		}
+	}
+	if job, ok := q.tryPop(); ok {
+		t.Errorf("tryPop = %q while degraded, want the Redis job left alone", job.ID)
+	}
+}
+
+func TestFallbackQueueResyncsWhenPrimaryRecovers(t *testing.T) {
+	primary := newFlakyQueue(10)
+	primary.down.Store(true)
+	q, changes := newTestFallbackQueue(t, primary, true)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := q.push(models.EmailJob{ID: id}); err != nil {
+			t.Fatalf("push %s: %v", id, err)
+		}
+	}
+
+	// Still down: nothing moves
+	q.resync()
+	if !q.isDegraded() || q.memory.len() != 3 {
+		t.Fatalf("degraded = %v with %d jobs in memory, want true with 3", q.isDegraded(), q.memory.len())
+	}
+
+	primary.down.Store(false)
+	q.resync()
+
+	if q.isDegraded() {
+		t.Error("still degraded after Redis recovered")
+	}
+	if got := changes.get(); len(got) != 2 || !got[0] || got[1] {
+		t.Errorf("degraded changes = %v, want [true false]", got)
+	}
+	if got := q.memory.len(); got != 0 {
+		t.Errorf("memory holds %d jobs after resync, want 0", got)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if job, ok := primary.tryPop(); !ok || job.ID != want {
+			t.Fatalf("primary tryPop = %q, %v; want %s", job.ID, ok, want)
+		}
+	}
+}
+
+func TestFallbackQueueResyncKeepsJobsPrimaryCantTake(t *testing.T) {
+	primary := newFlakyQueue(2)
+	primary.down.Store(true)
+	q, changes := newTestFallbackQueue(t, primary, true)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := q.push(models.EmailJob{ID: id}); err != nil {
+			t.Fatalf("push %s: %v", id, err)
+		}
+	}
+
+	primary.down.Store(false)
+	q.resync()
+
+	if !q.isDegraded() {
+		t.Error("left degraded mode with a job still in memory")
+	}
+	if got := changes.get(); len(got) != 1 {
+		t.Errorf("degraded changes = %v, want only [true]", got)
+	}
+	if got := primary.len(); got != 2 {
+		t.Errorf("primary holds %d jobs, want 2", got)
+	}
+	if job, ok := q.memory.tryPop(); !ok || job.ID != "c" {
+		t.Errorf("memory holds %q, %v; want c", job.ID, ok)
+	}
+}
+
+func TestFallbackQueueDoesNotDegradeWhenPrimaryIsFull(t *testing.T) {
+	primary := newFlakyQueue(1)
+	q, changes := newTestFallbackQueue(t, primary, false)
+
+	if err := q.push(models.EmailJob{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(models.EmailJob{ID: "b"}); err == nil {
+		t.Error("push into a full Redis queue succeeded")
+	}
+	if q.isDegraded() || len(changes.get()) != 0 {
+		t.Error("a full queue degraded to memory")
+	}
+}
+
+func TestIsMemoryFallback(t *testing.T) {
+	tests := []struct {
+		fallback string
+		want     bool
+		wantErr  bool
+	}{
+		{"", false, false},
+		{"fail", false, false},
+		{"memory", true, false},
+		{"MEMORY", true, false},
+		{"disk", false, true},
+	}
+	for _, tt := range tests {
+		got, err := isMemoryFallback(tt.fallback)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("isMemoryFallback(%q) = %v, %v; want %v, error %v", tt.fallback, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// redisDownEnv points the Redis backend at a port nothing listens on
+var redisDownEnv = map[string]string{
+	"QUEUE_BACKEND": "redis",
+	"REDIS_URL":     "redis://127.0.0.1:1/0",
+}
+
+func TestUnreachableRedisFailsStartupByDefault(t *testing.T) {
+	for key, value := range redisDownEnv {
+		t.Setenv(key, value)
+	}
+	t.Setenv("REDIS_FALLBACK", "fail")
+
+	if _, err := NewEmailService(config.LoadConfig(), nil, prometheus.NewRegistry()); err == nil {
+		t.Fatal("NewEmailService succeeded with Redis unreachable")
+	}
+}
+
+func TestUnreachableRedisFallsBackToMemory(t *testing.T) {
+	sent := make(chan string, 1)
+	env := map[string]string{"REDIS_FALLBACK": "memory"}
+	for key, value := range redisDownEnv {
+		env[key] = value
+	}
+	es := startTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		sent <- job.ID
+		return nil
+	}), env)
+
+	if state, reasons := es.Health(); state != HealthDegraded {
+		t.Errorf("health = %s %v, want degraded", state, reasons)
+	}
+	if got := metricValue(t, es, "email_queue_degraded"); got != 1 {
+		t.Errorf("email_queue_degraded = %v, want 1", got)
+	}
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	select {
+	case id := <-sent:
+		if id != "a" {
+			t.Errorf("sent %q, want a", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job queued in memory was never sent")
+	}
+}
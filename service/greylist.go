@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ErrGreylisted is matched by a GreylistError
+var ErrGreylisted = errors.New("greylisted")
+
+// GreylistError is a temporary rejection by a relay that greylists unknown
+// senders: it accepts the same email again only after a delay, so retrying
+// sooner just gets it rejected again
+type GreylistError struct {
+	// Delay is the least time to wait before the retry
+	Delay time.Duration
+	Err   error
+}
+
+// Error implements error
+func (e *GreylistError) Error() string {
+	return fmt.Sprintf("%s, retry after %s: %v", ErrGreylisted, e.Delay, e.Err)
+}
+
+// Unwrap returns the relay's rejection
+func (e *GreylistError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrGreylisted) match
+func (e *GreylistError) Is(target error) bool {
+	return target == ErrGreylisted
+}
+
+// greylistMarkers are what relays put in a greylisting reply, e.g. "450 4.2.0
+// Recipient address rejected: Greylisted, see http://postgrey.schweikert.ch"
+var greylistMarkers = []string{"greylist", "graylist", "grey-list", "gray-list"}
+
+// isGreylisting reports whether reply is a 4xx rejection saying the sender
+// was greylisted
+func isGreylisting(reply *textproto.Error) bool {
+	if reply.Code < 400 || reply.Code > 499 {
+		return false
+	}
+	msg := strings.ToLower(reply.Msg)
+	for _, marker := range greylistMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// greylistDelay returns the longest delay asked for by a greylisting
+// rejection among errs, and whether there was one
+func greylistDelay(errs []error) (time.Duration, bool) {
+	var delay time.Duration
+	found := false
+	for _, err := range errs {
+		var greylisted *GreylistError
+		if errors.As(err, &greylisted) {
+			delay = max(delay, greylisted.Delay)
+			found = true
+		}
+	}
+	return delay, found
+}
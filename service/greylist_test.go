@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestIsGreylisting(t *testing.T) {
+	tests := []struct {
+		reply *textproto.Error
+		want  bool
+	}{
+		{&textproto.Error{Code: 450, Msg: "4.2.0 Recipient greylisted, please try again later"}, true},
+		{&textproto.Error{Code: 451, Msg: "4.7.1 Graylisting in action"}, true},
+		{&textproto.Error{Code: 421, Msg: "4.7.0 Try again later, GREY-LISTED"}, true},
+		{&textproto.Error{Code: 452, Msg: "4.2.2 Mailbox full"}, false},
+		{&textproto.Error{Code: 550, Msg: "5.7.1 Greylisted forever"}, false},
+	}
+	for _, tt := range tests {
+		if got := isGreylisting(tt.reply); got != tt.want {
+			t.Errorf("isGreylisting(%d %s) = %v, want %v", tt.reply.Code, tt.reply.Msg, got, tt.want)
+		}
+	}
+}
+
+func TestSMTPSenderReportsGreylisting(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	server.greylist.Store(1)
+	sender := server.sender()
+	sender.GreylistDelay = 5 * time.Minute
+
+	job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hello", Body: "Hi there"}
+	err := sender.Send(context.Background(), job)
+	if !errors.Is(err, ErrGreylisted) {
+		t.Fatalf("Send = %v, want ErrGreylisted", err)
+	}
+	if IsPermanent(err) {
+		t.Error("greylisting should be retryable")
+	}
+	var greylisted *GreylistError
+	if !errors.As(err, &greylisted) || greylisted.Delay != 5*time.Minute {
+		t.Errorf("Send = %v, want a GreylistError with a 5m delay", err)
+	}
+
+	if err := sender.Send(context.Background(), job); err != nil {
+		t.Fatalf("second Send: %v", err)
+	}
+	if got := server.received(); got != 1 {
+		t.Errorf("received = %d, want 1", got)
+	}
+}
+
+func TestGreylistedJobWaitsForGreylistDelay(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	server.greylist.Store(1)
+	smtpSender := server.sender()
+	smtpSender.GreylistDelay = 300 * time.Millisecond
+	sender := &CompositeSender{Providers: []Provider{smtpSender}}
+
+	es := startTestService(t, sender, nil)
+
+	job := models.EmailJob{ID: "greylisted", To: "user@example.com", Subject: "Hello", Body: "Hi there"}
+	if err := es.EnqueueJob(job); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "greylisted attempt", func() bool {
+		record, ok := es.JobStatus(job.ID)
+		return ok && record.Status == StatusRetrying
+	})
+
+	// RETRY_FIXED_DELAY is 10ms, so only the greylisting delay holds it back
+	time.Sleep(150 * time.Millisecond)
+	if got := server.received(); got != 0 {
+		t.Fatalf("received = %d before the greylisting delay, want 0", got)
+	}
+	waitFor(t, "sent after the greylisting delay", func() bool { return server.received() == 1 })
+}
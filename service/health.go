@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+
+	"email-queue-service/models"
+)
+
+// HealthState describes how well the service is able to do its work
+type HealthState string
+
+const (
+	// HealthHealthy means the service is accepting and processing work normally
+	HealthHealthy HealthState = "healthy"
+	// HealthDegraded means the service still accepts work but is under strain
+	HealthDegraded HealthState = "degraded"
+	// HealthUnhealthy means the service cannot accept new work
+	HealthUnhealthy HealthState = "unhealthy"
+)
+
+const (
+	// queueDegradedRatio is the main queue fill level considered degraded
+	queueDegradedRatio = 0.8
+	// retryDegradedRatio is the retry queue fill level that signals an elevated failure rate
+	retryDegradedRatio = 0.5
+)
+
+// Readiness reports whether the service should be sent new traffic
+type Readiness struct {
+	Ready         bool     `json:"ready"`
+	QueueDepth    int      `json:"queue_depth"`
+	QueueCapacity int      `json:"queue_capacity"`
+	Workers       int      `json:"workers"`
+	Reasons       []string `json:"reasons,omitempty"`
+}
+
+// Readiness reports the service not ready while it is shutting down, while
+// any priority's queue is at or above the high-water mark, while no worker is
+// running to drain it, or while the startup check of the email provider's
+// connection is still retrying. Lanes fill separately, so one can be full
+// while the queue as a whole is far from it.
+func (es *EmailService) Readiness() Readiness {
+	r := Readiness{
+		QueueDepth:    es.queueDepth(),
+		QueueCapacity: es.queueCapacity(),
+		Workers:       int(es.workersAlive.Load()),
+	}
+
+	if es.shuttingDown.Load() {
+		r.Reasons = append(r.Reasons, "service is shutting down")
+	}
+	capacity := es.jobQueue.laneCapacity()
+	highWater := float64(capacity) * es.readyHighWater
+	for i, queued := range es.jobQueue.laneLengths() {
+		if float64(queued) >= highWater {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("%s priority queue is above its high-water mark (%d/%d)", priorities[i], queued, capacity))
+		}
+	}
+	if r.Workers == 0 {
+		r.Reasons = append(r.Reasons, "no workers are running")
+	}
+	if es.awaitingSender.Load() {
+		r.Reasons = append(r.Reasons, "email provider connection not verified yet")
+	}
+
+	r.Ready = len(r.Reasons) == 0
+	return r
+}
+
+// Health computes the current health state and the reasons behind it
+func (es *EmailService) Health() (HealthState, []string) {
+	if es.shuttingDown.Load() {
+		return HealthUnhealthy, []string{"service is shutting down"}
+	}
+
+	var reasons []string
+	capacity := es.jobQueue.laneCapacity()
+	for i, queued := range es.jobQueue.laneLengths() {
+		switch {
+		case queued >= capacity && priorities[i] != models.PriorityLow:
+			return HealthUnhealthy, []string{fmt.Sprintf("%s priority queue is full (%d/%d)", priorities[i], queued, capacity)}
+		case queued >= capacity:
+			// Low priority work backing up doesn't stop urgent email getting through
+			reasons = append(reasons, fmt.Sprintf("%s priority queue is full (%d/%d)", priorities[i], queued, capacity))
+		case float64(queued) >= float64(capacity)*queueDegradedRatio:
+			reasons = append(reasons, fmt.Sprintf("%s priority queue is nearly full (%d/%d)", priorities[i], queued, capacity))
+		}
+	}
+
+	if es.queueDegraded.Load() {
+		reasons = append(reasons, "Redis is unreachable, jobs are queued in memory")
+	}
+
+	retrying, retryCapacity := len(es.retryQueue), cap(es.retryQueue)
+	if retryCapacity > 0 && float64(retrying) >= float64(retryCapacity)*retryDegradedRatio {
+		reasons = append(reasons, fmt.Sprintf("retry backlog is elevated (%d/%d)", retrying, retryCapacity))
+	}
+
+	if len(reasons) > 0 {
+		return HealthDegraded, reasons
+	}
+	return HealthHealthy, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"log/slog"
+	"time"
+
+	"email-queue-service/models"
+
+	"github.com/google/uuid"
+)
+
+// heartbeatConfig controls the periodic synthetic monitoring email
+type heartbeatConfig struct {
+	interval  time.Duration
+	recipient string
+}
+
+// enabled reports whether heartbeats should be sent
+func (hc heartbeatConfig) enabled() bool {
+	return hc.interval > 0 && hc.recipient != ""
+}
+
+// heartbeatLoop enqueues a heartbeat email on every interval until shutdown
+func (es *EmailService) heartbeatLoop() {
+	defer es.wg.Done()
+
+	ticker := time.NewTicker(es.heartbeat.interval)
+	defer ticker.Stop()
+
+	slog.Info("Heartbeat started", "interval", es.heartbeat.interval.String(), "recipient", es.heartbeat.recipient)
+
+	for {
+		select {
+		case <-ticker.C:
+			es.sendHeartbeat()
+		case <-es.shutdown:
+			slog.Debug("Heartbeat shutting down")
+			return
+		}
+	}
+}
+
+// sendHeartbeat enqueues a single heartbeat email
+func (es *EmailService) sendHeartbeat() {
+	job := models.EmailJob{
+		ID:        uuid.NewString(),
+		To:        es.heartbeat.recipient,
+		Subject:   "Email queue heartbeat",
+		Body:      "Heartbeat sent at " + time.Now().UTC().Format(time.RFC3339),
+		Heartbeat: true,
+	}
+
+	if err := es.EnqueueJob(job); err != nil {
+		slog.Error("Failed to enqueue heartbeat", "error", err)
+		es.recordHeartbeat(false)
+	}
+}
+
+// recordHeartbeat updates the heartbeat gauges with a delivery outcome
+func (es *EmailService) recordHeartbeat(success bool) {
+	if !success {
+		es.heartbeatSuccess.Set(0)
+		return
+	}
+
+	es.heartbeatSuccess.Set(1)
+	es.heartbeatLastSuccess.Set(float64(time.Now().Unix()))
+}
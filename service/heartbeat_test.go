@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestHeartbeatEnqueuedOnSchedule(t *testing.T) {
+	const interval = 40 * time.Millisecond
+
+	var mu sync.Mutex
+	var sent []time.Time
+	started := time.Now()
+	es := startTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		if !job.Heartbeat || job.To != "monitor@example.com" {
+			t.Errorf("unexpected job %+v", job)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, time.Now())
+		return nil
+	}), map[string]string{
+		"HEARTBEAT_INTERVAL":  interval.String(),
+		"HEARTBEAT_RECIPIENT": "monitor@example.com",
+	})
+
+	waitFor(t, "three heartbeats", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) >= 3
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The third heartbeat can't be sent before the third tick
+	if elapsed := sent[2].Sub(started); elapsed < 3*interval {
+		t.Errorf("third heartbeat sent after %s, want at least %s", elapsed, 3*interval)
+	}
+	if got := metricValue(t, es, "email_heartbeat_success"); got != 1 {
+		t.Errorf("email_heartbeat_success = %v, want 1", got)
+	}
+	if got := metricValue(t, es, "email_heartbeat_last_success_timestamp_seconds"); got < float64(started.Unix()) {
+		t.Errorf("email_heartbeat_last_success_timestamp_seconds = %v, want at least %d", got, started.Unix())
+	}
+}
+
+func TestHeartbeatDisabledByDefault(t *testing.T) {
+	sent := make(chan models.EmailJob, 1)
+	startTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		sent <- job
+		return nil
+	}), map[string]string{"HEARTBEAT_RECIPIENT": "monitor@example.com"})
+
+	select {
+	case job := <-sent:
+		t.Errorf("heartbeat sent without HEARTBEAT_INTERVAL: %+v", job)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
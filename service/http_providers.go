@@ -0,0 +1,266 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// providerRequestTimeout bounds a single API call to a hosted provider
+const providerRequestTimeout = 30 * time.Second
+
+// providerClient is shared by the hosted providers
+var providerClient = &http.Client{Timeout: providerRequestTimeout}
+
+// SendGridProvider delivers through the SendGrid v3 mail send API
+type SendGridProvider struct {
+	APIKey  string
+	From    string
+	BaseURL string // defaults to https://api.sendgrid.com
+}
+
+// Send implements Provider
+func (p *SendGridProvider) Send(ctx context.Context, job models.EmailJob) error {
+	type address struct {
+		Email string `json:"email"`
+	}
+	addresses := func(addrs []string) []address {
+		list := make([]address, len(addrs))
+		for i, addr := range addrs {
+			list[i] = address{Email: addr}
+		}
+		return list
+	}
+
+	personalization := map[string][]address{"to": addresses([]string{job.To})}
+	if len(job.Cc) > 0 {
+		personalization["cc"] = addresses(job.Cc)
+	}
+	if len(job.Bcc) > 0 {
+		personalization["bcc"] = addresses(job.Bcc)
+	}
+
+	message := map[string]interface{}{
+		"personalizations": []map[string][]address{personalization},
+		"from":             address{Email: job.Sender(p.From)},
+		"subject":          job.Subject,
+		"content":          []map[string]string{{"type": job.ContentType(), "value": job.Body}},
+	}
+	if job.ReplyTo != "" {
+		message["reply_to"] = address{Email: job.ReplyTo}
+	}
+	if len(job.Headers) > 0 {
+		message["headers"] = job.Headers
+	}
+	if len(job.Attachments) > 0 {
+		attachments := make([]map[string]string, len(job.Attachments))
+		for i, attachment := range job.Attachments {
+			attachments[i] = map[string]string{
+				"content":     base64.StdEncoding.EncodeToString(attachment.Content),
+				"type":        attachment.ContentType,
+				"filename":    attachment.Filename,
+				"disposition": "attachment",
+			}
+		}
+		message["attachments"] = attachments
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return Permanent(fmt.Errorf("encode sendgrid request: %w", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, withDefault(p.BaseURL, "https://api.sendgrid.com")+"/v3/mail/send", bytes.NewReader(payload))
+	if err != nil {
+		return Permanent(fmt.Errorf("build sendgrid request: %w", err))
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	return doProviderRequest(req, job)
+}
+
+// Name implements Provider
+func (p *SendGridProvider) Name() string {
+	return "sendgrid"
+}
+
+// MailgunProvider delivers through the Mailgun messages API
+type MailgunProvider struct {
+	Domain  string
+	APIKey  string
+	From    string
+	BaseURL string // defaults to https://api.mailgun.net; use https://api.eu.mailgun.net for EU domains
+}
+
+// Send implements Provider. The message is posted as multipart/form-data so
+// attachments can go along as files.
+func (p *MailgunProvider) Send(ctx context.Context, job models.EmailJob) error {
+	// Writes to a bytes.Buffer can't fail
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("from", job.Sender(p.From))
+	if job.ReplyTo != "" {
+		form.WriteField("h:Reply-To", job.ReplyTo)
+	}
+	for _, name := range sortedKeys(job.Headers) {
+		form.WriteField("h:"+name, job.Headers[name])
+	}
+	form.WriteField("to", job.To)
+	if len(job.Cc) > 0 {
+		form.WriteField("cc", strings.Join(job.Cc, ","))
+	}
+	if len(job.Bcc) > 0 {
+		form.WriteField("bcc", strings.Join(job.Bcc, ","))
+	}
+	form.WriteField("subject", job.Subject)
+	if job.HTML {
+		form.WriteField("html", job.Body)
+	} else {
+		form.WriteField("text", job.Body)
+	}
+	for _, attachment := range job.Attachments {
+		part, _ := form.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {mime.FormatMediaType("form-data", map[string]string{"name": "attachment", "filename": attachment.Filename})},
+			"Content-Type":        {attachment.ContentType},
+		})
+		part.Write(attachment.Content)
+	}
+	form.Close()
+
+	endpoint := withDefault(p.BaseURL, "https://api.mailgun.net") + "/v3/" + url.PathEscape(p.Domain) + "/messages"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return Permanent(fmt.Errorf("build mailgun request: %w", err))
+	}
+	req.SetBasicAuth("api", p.APIKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	return doProviderRequest(req, job)
+}
+
+// Name implements Provider
+func (p *MailgunProvider) Name() string {
+	return "mailgun"
+}
+
+// SESProvider delivers through the Amazon SES v2 API
+type SESProvider struct {
+	Region    string
+	AccessKey string
+	SecretKey string
+	From      string
+	Endpoint  string // defaults to https://email.<region>.amazonaws.com
+}
+
+// Send implements Provider. A job with attachments or custom headers is sent
+// as a raw MIME message, since simple content can't carry them.
+func (p *SESProvider) Send(ctx context.Context, job models.EmailJob) error {
+	type content struct {
+		Data    string `json:"Data"`
+		Charset string `json:"Charset"`
+	}
+	destination := map[string][]string{"ToAddresses": {job.To}}
+	if len(job.Cc) > 0 {
+		destination["CcAddresses"] = job.Cc
+	}
+	if len(job.Bcc) > 0 {
+		destination["BccAddresses"] = job.Bcc
+	}
+
+	bodyPart := "Text"
+	if job.HTML {
+		bodyPart = "Html"
+	}
+
+	message := map[string]interface{}{
+		"Simple": map[string]interface{}{
+			"Subject": content{Data: job.Subject, Charset: "UTF-8"},
+			"Body":    map[string]content{bodyPart: {Data: job.Body, Charset: "UTF-8"}},
+		},
+	}
+	if len(job.Attachments) > 0 || len(job.Headers) > 0 {
+		// []byte is base64-encoded by encoding/json, as SES expects
+		message = map[string]interface{}{
+			"Raw": map[string][]byte{"Data": buildMessage(p.From, job, time.Now())},
+		}
+	}
+
+	request := map[string]interface{}{
+		"FromEmailAddress": job.Sender(p.From),
+		"Destination":      destination,
+		"Content":          message,
+	}
+	if job.ReplyTo != "" {
+		request["ReplyToAddresses"] = []string{job.ReplyTo}
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return Permanent(fmt.Errorf("encode ses request: %w", err))
+	}
+
+	endpoint := withDefault(p.Endpoint, "https://email."+p.Region+".amazonaws.com")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v2/email/outbound-emails", bytes.NewReader(payload))
+	if err != nil {
+		return Permanent(fmt.Errorf("build ses request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	creds := awsCredentials{Region: p.Region, AccessKey: p.AccessKey, SecretKey: p.SecretKey}
+	creds.signV4(req, "ses", payload, time.Now().UTC())
+
+	return doProviderRequest(req, job)
+}
+
+// Name implements Provider
+func (p *SESProvider) Name() string {
+	return "ses"
+}
+
+// doProviderRequest sends a provider API request and classifies the outcome.
+// Rate limiting, timeouts and server errors are worth retrying; other client
+// errors mean the request itself was rejected, so they are permanent.
+func doProviderRequest(req *http.Request, job models.EmailJob) error {
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send to %s: %w", job.To, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("send to %s: unexpected status %s: %s", job.To, resp.Status, bytes.TrimSpace(detail))
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode >= 500:
+		return err
+	default:
+		return Permanent(err)
+	}
+}
+
+// withDefault returns value, or fallback when value is empty
+func withDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return strings.TrimRight(value, "/")
+}
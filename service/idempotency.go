@@ -0,0 +1,154 @@
+package service
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrIdempotencyConflict is returned when an idempotency key is reused for a different request
+	ErrIdempotencyConflict = errors.New("idempotency key was used for a different request")
+	// ErrIdempotencyInProgress is returned when the first request with an idempotency key hasn't finished yet
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// IdempotentResponse is the response recorded for an idempotency key and
+// replayed to repeats of the same request
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// idempotencyEntry is a claimed key. response is nil until the first request
+// with the key completes.
+type idempotencyEntry struct {
+	key         string
+	fingerprint string
+	response    *IdempotentResponse
+	expiresAt   time.Time
+}
+
+// idempotencyCache remembers recently used idempotency keys. Every key lives
+// for the same ttl, so the insertion order is also the expiry order; when the
+// cache is full the oldest key is evicted early.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxKeys int
+	entries map[string]*list.Element
+	order   *list.List // of *idempotencyEntry, oldest first
+}
+
+// newIdempotencyCache creates a cache holding up to maxKeys keys for ttl each
+func newIdempotencyCache(ttl time.Duration, maxKeys int) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// claim looks up key for a request identified by fingerprint. It returns the
+// recorded response for a completed repeat, or claims the key and returns nil
+// if the key is new, in which case the caller must complete or release it.
+func (c *idempotencyCache) claim(key, fingerprint string, now time.Time) (*IdempotentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*idempotencyEntry)
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, ErrIdempotencyConflict
+		case entry.response == nil:
+			return nil, ErrIdempotencyInProgress
+		default:
+			return entry.response, nil
+		}
+	}
+
+	for c.order.Len() >= c.maxKeys {
+		c.remove(c.order.Front())
+	}
+
+	entry := &idempotencyEntry{key: key, fingerprint: fingerprint, expiresAt: now.Add(c.ttl)}
+	c.entries[key] = c.order.PushBack(entry)
+	return nil, nil
+}
+
+// complete records the response for a key claimed by the request with fingerprint
+func (c *idempotencyCache) complete(key, fingerprint string, response IdempotentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry := c.pending(key, fingerprint); entry != nil {
+		entry.response = &response
+	}
+}
+
+// release forgets a key claimed by the request with fingerprint so it can be
+// used again, e.g. after the request failed
+func (c *idempotencyCache) release(key, fingerprint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending(key, fingerprint) != nil {
+		c.remove(c.entries[key])
+	}
+}
+
+// pending returns the in-progress entry for key and fingerprint, if any.
+// Callers must hold mu.
+func (c *idempotencyCache) pending(key, fingerprint string) *idempotencyEntry {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*idempotencyEntry)
+	if entry.fingerprint != fingerprint || entry.response != nil {
+		return nil
+	}
+	return entry
+}
+
+// expire drops keys whose ttl has passed. Callers must hold mu.
+func (c *idempotencyCache) expire(now time.Time) {
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		if now.Before(elem.Value.(*idempotencyEntry).expiresAt) {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+// remove drops an entry. Callers must hold mu.
+func (c *idempotencyCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*idempotencyEntry)
+	delete(c.entries, entry.key)
+}
+
+// ClaimIdempotencyKey looks up key for a request identified by fingerprint.
+// A repeat of a completed request returns its recorded response. A new key is
+// claimed and nil is returned; the caller must then call
+// CompleteIdempotencyKey or ReleaseIdempotencyKey. Reusing a key for a
+// different request returns ErrIdempotencyConflict.
+func (es *EmailService) ClaimIdempotencyKey(key, fingerprint string) (*IdempotentResponse, error) {
+	return es.idempotency.claim(key, fingerprint, time.Now())
+}
+
+// CompleteIdempotencyKey records the response to replay for repeats of a claimed key
+func (es *EmailService) CompleteIdempotencyKey(key, fingerprint string, response IdempotentResponse) {
+	es.idempotency.complete(key, fingerprint, response)
+}
+
+// ReleaseIdempotencyKey forgets a claimed key, e.g. because the request failed
+// and the client should be able to retry it
+func (es *EmailService) ReleaseIdempotencyKey(key, fingerprint string) {
+	es.idempotency.release(key, fingerprint)
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// otherLabel is what label values past the cap are recorded as
+const otherLabel = "other"
+
+// labelCap bounds the distinct values of a metric label, such as tenant or
+// recipient domain, that can otherwise grow without limit. The first max
+// values seen are kept; the rest are recorded as "other".
+type labelCap struct {
+	label string
+	max   int // unlimited when zero
+
+	mu     sync.Mutex
+	seen   map[string]bool
+	capped bool // whether the cap has been hit, so it's logged once
+}
+
+func newLabelCap(label string, max int) *labelCap {
+	return &labelCap{label: label, max: max, seen: make(map[string]bool)}
+}
+
+// value returns the label value to record for v
+func (c *labelCap) value(v string) string {
+	if c.max == 0 {
+		return v
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen[v] {
+		return v
+	}
+	if len(c.seen) < c.max {
+		c.seen[v] = true
+		return v
+	}
+	if !c.capped {
+		c.capped = true
+		slog.Warn("Metric label cap reached, recording further values as other", "event", "label_cap_reached", "label", c.label, "max", c.max, "value", v)
+	}
+	return otherLabel
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelCapBucketsOverflowAsOther(t *testing.T) {
+	c := newLabelCap("tenant", 2)
+	for _, v := range []string{"acme", "globex", "acme"} {
+		if got := c.value(v); got != v {
+			t.Errorf("value(%q) = %q within the cap", v, got)
+		}
+	}
+	if got := c.value("initech"); got != otherLabel {
+		t.Errorf("value past the cap = %q, want %q", got, otherLabel)
+	}
+	// Values seen before the cap keep their label
+	if got := c.value("globex"); got != "globex" {
+		t.Errorf("value(globex) after the cap = %q", got)
+	}
+
+	if got := newLabelCap("tenant", 0).value("initech"); got != "initech" {
+		t.Errorf("unlimited cap value = %q", got)
+	}
+}
+
+func TestTenantRateLimitedMetricCapsTenants(t *testing.T) {
+	path := writeTenantLimits(t, `{}`)
+	es := newTestService(t, nil, map[string]string{
+		"TENANT_LIMITS_FILE":       path,
+		"TENANT_RATE_LIMIT":        "0.001",
+		"METRICS_MAX_LABEL_VALUES": "1",
+	})
+
+	for i, tenant := range []string{"acme", "acme", "globex", "globex", "initech", "initech"} {
+		job := models.EmailJob{ID: string(rune('a' + i)), To: "user@example.com", Subject: "Hi", Body: "Hi", Tenant: tenant}
+		es.EnqueueJob(job)
+	}
+
+	if got := testutil.ToFloat64(es.tenantRateLimited.WithLabelValues("acme")); got != 1 {
+		t.Errorf("acme rate limited = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(es.tenantRateLimited.WithLabelValues(otherLabel)); got != 2 {
+		t.Errorf("other rate limited = %v, want 2", got)
+	}
+	if got := testutil.CollectAndCount(es.tenantRateLimited); got != 2 {
+		t.Errorf("tenant series = %d, want 2", got)
+	}
+}
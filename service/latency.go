@@ -0,0 +1,85 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindowSize is how many recent queue-wait samples are kept for percentiles
+const latencyWindowSize = 1024
+
+// LatencyStats summarizes recent queue-wait durations
+type LatencyStats struct {
+	P50     float64 `json:"p50_seconds"`
+	P95     float64 `json:"p95_seconds"`
+	P99     float64 `json:"p99_seconds"`
+	Samples int     `json:"samples"`
+}
+
+// latencyWindow keeps the most recent samples in a fixed-size ring buffer
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// newLatencyWindow creates a window holding up to size samples
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+// observe records a sample, overwriting the oldest once the window is full
+func (lw *latencyWindow) observe(d time.Duration) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.samples[lw.next] = d
+	lw.next++
+	if lw.next == len(lw.samples) {
+		lw.next = 0
+		lw.full = true
+	}
+}
+
+// stats computes percentiles over the samples currently in the window
+func (lw *latencyWindow) stats() LatencyStats {
+	lw.mu.Lock()
+	n := lw.next
+	if lw.full {
+		n = len(lw.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, lw.samples[:n])
+	lw.mu.Unlock()
+
+	if n == 0 {
+		return LatencyStats{}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return LatencyStats{
+		P50:     percentile(sorted, 0.50).Seconds(),
+		P95:     percentile(sorted, 0.95).Seconds(),
+		P99:     percentile(sorted, 0.99).Seconds(),
+		Samples: n,
+	}
+}
+
+// percentile returns the nearest-rank percentile of ascending samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// QueueLatency returns percentiles of how long recent jobs waited in a queue
+func (es *EmailService) QueueLatency() LatencyStats {
+	return es.latency.stats()
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestLatencyWindowPercentiles(t *testing.T) {
+	lw := newLatencyWindow(latencyWindowSize)
+	if got := lw.stats(); got != (LatencyStats{}) {
+		t.Errorf("empty window stats = %+v, want zero", got)
+	}
+
+	// 1ms to 100ms in random order
+	for _, i := range rand.Perm(100) {
+		lw.observe(time.Duration(i+1) * time.Millisecond)
+	}
+
+	want := LatencyStats{P50: 0.050, P95: 0.095, P99: 0.099, Samples: 100}
+	if got := lw.stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestLatencyWindowKeepsRecentSamples(t *testing.T) {
+	lw := newLatencyWindow(10)
+	for i := 1; i <= 100; i++ {
+		lw.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	// Only 91ms to 100ms are left
+	want := LatencyStats{P50: 0.095, P95: 0.100, P99: 0.100, Samples: 10}
+	if got := lw.stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+// captureLogs sends slog's default logger to a JSON buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// logEvent returns the first JSON log line with the given event
+func logEvent(t *testing.T, logs *bytes.Buffer, event string) map[string]interface{} {
+	t.Helper()
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+		if entry["event"] == event {
+			return entry
+		}
+	}
+	t.Fatalf("no %q event logged in:\n%s", event, logs)
+	return nil
+}
+
+func TestSendLogsAreStructured(t *testing.T) {
+	es := newTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		if job.ID == "bad" {
+			return errors.New("421 service not available")
+		}
+		return nil
+	}), nil)
+	logs := captureLogs(t)
+
+	es.processJob(models.EmailJob{ID: "good", To: "good@example.com", Subject: "Hi", Body: "Hi"}, 7)
+	es.processJob(models.EmailJob{ID: "bad", To: "bad@example.com", Subject: "Hi", Body: "Hi"}, 7)
+
+	tests := []struct {
+		event string
+		want  map[string]interface{}
+	}{
+		{"sent", map[string]interface{}{"level": "INFO", "worker_id": 7.0, "job_id": "good", "recipient": "good@example.com"}},
+		{"send_failed", map[string]interface{}{"level": "WARN", "worker_id": 7.0, "job_id": "bad", "recipient": "bad@example.com", "error": "421 service not available"}},
+	}
+	for _, tt := range tests {
+		entry := logEvent(t, logs, tt.event)
+		for key, want := range tt.want {
+			if entry[key] != want {
+				t.Errorf("%s log %s = %v, want %v", tt.event, key, entry[key], want)
+			}
+		}
+		if _, ok := entry["time"]; !ok || entry["msg"] == "" {
+			t.Errorf("%s log %v is missing its time or message", tt.event, entry)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"email-queue-service/models"
+	"email-queue-service/utils"
+)
+
+// base64LineLength is the longest line of base64 in a MIME part (RFC 2045)
+const base64LineLength = 76
+
+// buildMessage renders the RFC 5322 message for a job, using from as the
+// sender unless the job sets its own. Bcc
+// recipients only appear in the SMTP envelope, never in the headers. A job
+// with attachments becomes a multipart/mixed message with the body as its
+// first part.
+func buildMessage(from string, job models.EmailJob, now time.Time) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", job.Sender(from))
+	if job.ReplyTo != "" {
+		fmt.Fprintf(&msg, "Reply-To: %s\r\n", job.ReplyTo)
+	}
+	fmt.Fprintf(&msg, "To: %s\r\n", job.To)
+	if len(job.Cc) > 0 {
+		fmt.Fprintf(&msg, "Cc: %s\r\n", strings.Join(job.Cc, ", "))
+	}
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", job.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	for _, name := range sortedKeys(job.Headers) {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, mime.QEncoding.Encode("utf-8", job.Headers[name]))
+	}
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	bodyType := mime.FormatMediaType(job.ContentType(), map[string]string{"charset": "UTF-8"})
+	if len(job.Attachments) == 0 {
+		fmt.Fprintf(&msg, "Content-Type: %s\r\n", bodyType)
+		msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+		msg.WriteString("\r\n")
+		msg.WriteString(job.Body)
+		return msg.Bytes()
+	}
+
+	parts := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "Content-Type: %s\r\n", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": parts.Boundary()}))
+	msg.WriteString("\r\n")
+
+	// Writes to a bytes.Buffer can't fail
+	body, _ := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {bodyType},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	io.WriteString(body, job.Body)
+
+	for _, attachment := range job.Attachments {
+		part, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		writeBase64Lines(part, attachment.Content)
+	}
+	parts.Close()
+
+	return msg.Bytes()
+}
+
+// withoutIdentifyingHeaders returns headers minus any that fingerprint the
+// sender, copying only when there is something to drop
+func withoutIdentifyingHeaders(headers map[string]string) map[string]string {
+	var kept map[string]string
+	for name := range headers {
+		if !utils.IsIdentifyingHeader(name) {
+			continue
+		}
+		if kept == nil {
+			kept = maps.Clone(headers)
+		}
+		delete(kept, name)
+	}
+	if kept == nil {
+		return headers
+	}
+	return kept
+}
+
+// sortedKeys returns the keys of m in order, so messages are reproducible
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeBase64Lines writes data base64-encoded, broken into lines
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > base64LineLength {
+		io.WriteString(w, encoded[:base64LineLength]+"\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestBuildMessageHeaders(t *testing.T) {
+	job := models.EmailJob{
+		To:      "user@example.com",
+		Cc:      []string{"cc@example.com"},
+		Bcc:     []string{"hidden@example.com"},
+		ReplyTo: "support@example.com",
+		Subject: "Café",
+		Body:    "Hello",
+		Headers: map[string]string{"X-Campaign-Id": "spring", "List-Unsubscribe": "<mailto:u@example.com>"},
+	}
+	msg := string(buildMessage("noreply@example.com", job, time.Date(2025, 7, 28, 10, 0, 0, 0, time.UTC)))
+
+	for _, want := range []string{
+		"From: noreply@example.com\r\n",
+		"Reply-To: support@example.com\r\n",
+		"To: user@example.com\r\n",
+		"Cc: cc@example.com\r\n",
+		"Subject: =?utf-8?q?Caf=C3=A9?=\r\n",
+		"Date: Mon, 28 Jul 2025 10:00:00 +0000\r\n",
+		"List-Unsubscribe: <mailto:u@example.com>\r\nX-Campaign-Id: spring\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\nHello",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "hidden@example.com") {
+		t.Errorf("message leaks the bcc recipient:\n%s", msg)
+	}
+}
+
+func TestBuildMessageWithAttachments(t *testing.T) {
+	job := models.EmailJob{
+		To:          "user@example.com",
+		Subject:     "Receipt",
+		Body:        "Attached",
+		Attachments: []models.Attachment{{Filename: "receipt.pdf", ContentType: "application/pdf", Content: []byte("%PDF-1.4")}},
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(buildMessage("noreply@example.com", job, time.Now())))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q, want multipart/mixed", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+
+	body, err := parts.NextPart()
+	if err != nil {
+		t.Fatalf("body part: %v", err)
+	}
+	if text, _ := io.ReadAll(body); string(text) != "Attached" {
+		t.Errorf("body = %q, want %q", text, "Attached")
+	}
+
+	attachment, err := parts.NextPart()
+	if err != nil {
+		t.Fatalf("attachment part: %v", err)
+	}
+	if attachment.FileName() != "receipt.pdf" {
+		t.Errorf("filename = %q, want receipt.pdf", attachment.FileName())
+	}
+	if ct := attachment.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/pdf") {
+		t.Errorf("attachment Content-Type = %q, want application/pdf", ct)
+	}
+	encoded, _ := io.ReadAll(attachment)
+	content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || string(content) != "%PDF-1.4" {
+		t.Errorf("attachment content = %q (%v), want %q", content, err, "%PDF-1.4")
+	}
+
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("after the attachment: %v, want io.EOF", err)
+	}
+}
+
+func TestBuildMessageAddsNoIdentifyingHeaders(t *testing.T) {
+	msg := string(buildMessage("noreply@example.com", models.EmailJob{To: "user@example.com", Subject: "Hi", Body: "Hello"}, time.Now()))
+
+	for _, name := range []string{"X-Mailer", "User-Agent", "X-Originating-IP", "X-Sender-IP", "X-MimeOLE", "X-Newsreader"} {
+		if strings.Contains(strings.ToLower(msg), strings.ToLower(name)+":") {
+			t.Errorf("message has %s:\n%s", name, msg)
+		}
+	}
+}
+
+func TestStripIdentifyingHeaders(t *testing.T) {
+	for _, mode := range []string{"allow", "strip"} {
+		t.Run(mode, func(t *testing.T) {
+			var mu sync.Mutex
+			var messages []string
+			sender := senderFunc(func(_ context.Context, job models.EmailJob) error {
+				mu.Lock()
+				defer mu.Unlock()
+				messages = append(messages, string(buildMessage("noreply@example.com", job, time.Now())))
+				return nil
+			})
+			es := startTestService(t, sender, map[string]string{"IDENTIFYING_HEADERS": mode})
+
+			headers := map[string]string{
+				"X-Mailer":         "Outlook 16.0",
+				"user-agent":       "Thunderbird/115",
+				"X-Originating-IP": "[203.0.113.7]",
+				"X-Campaign-Id":    "spring",
+			}
+			if err := es.EnqueueJob(models.EmailJob{ID: "queued", To: "user@example.com", Subject: "Hi", Body: "Hello", Headers: headers}); err != nil {
+				t.Fatalf("EnqueueJob: %v", err)
+			}
+			if err := es.SendNow(context.Background(), models.EmailJob{ID: "sync", To: "user@example.com", Subject: "Hi", Body: "Hello", Headers: headers}); err != nil {
+				t.Fatalf("SendNow: %v", err)
+			}
+			waitFor(t, "queued email sent", func() bool {
+				status, ok := es.JobStatus("queued")
+				return ok && status.Status == StatusSent
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, msg := range messages {
+				for _, name := range []string{"X-Mailer:", "user-agent:", "X-Originating-IP:"} {
+					if got := strings.Contains(msg, name); got != (mode == "allow") {
+						t.Errorf("%s in message = %v with %s:\n%s", name, got, mode, msg)
+					}
+				}
+				if !strings.Contains(msg, "X-Campaign-Id: spring") {
+					t.Errorf("other custom header dropped:\n%s", msg)
+				}
+			}
+			if len(headers) != 4 {
+				t.Errorf("caller's headers modified: %v", headers)
+			}
+		})
+	}
+}
+
+func TestWithoutIdentifyingHeadersKeepsMapWhenNothingToDrop(t *testing.T) {
+	headers := map[string]string{"X-Campaign-Id": "spring"}
+	if got := withoutIdentifyingHeaders(headers); len(got) != 1 || got["X-Campaign-Id"] != "spring" {
+		t.Errorf("withoutIdentifyingHeaders = %v, want the headers unchanged", got)
+	}
+	if got := withoutIdentifyingHeaders(nil); got != nil {
+		t.Errorf("withoutIdentifyingHeaders(nil) = %v, want nil", got)
+	}
+}
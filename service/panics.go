@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// panicLogSize is how many recent recovered panics are kept
+const panicLogSize = 100
+
+// PanicRecord describes a panic recovered while processing a job
+type PanicRecord struct {
+	Time     time.Time `json:"time"`
+	WorkerID int       `json:"worker_id"`
+	JobID    string    `json:"job_id"`
+	To       string    `json:"to"`
+	Value    string    `json:"panic"`
+}
+
+// panicLog is a bounded log of recent recovered panics
+type panicLog struct {
+	mu      sync.Mutex
+	records []PanicRecord
+}
+
+// add records a panic, dropping the oldest once the log is full
+func (pl *panicLog) add(record PanicRecord) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	if len(pl.records) == panicLogSize {
+		copy(pl.records, pl.records[1:])
+		pl.records = pl.records[:panicLogSize-1]
+	}
+	pl.records = append(pl.records, record)
+}
+
+// list returns a copy of the recorded panics, oldest first
+func (pl *panicLog) list() []PanicRecord {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	records := make([]PanicRecord, len(pl.records))
+	copy(records, pl.records)
+	return records
+}
+
+// recordPanic adds a recovered panic for job to the panic log and counts it as
+// a failed attempt. The job is retried like any other failure, since the panic
+// may have been down to something transient, but a job that keeps panicking
+// runs out of retries and goes to the dead letter queue.
+func (es *EmailService) recordPanic(job models.EmailJob, workerID int, value interface{}) {
+	es.panics.add(PanicRecord{
+		Time:     time.Now(),
+		WorkerID: workerID,
+		JobID:    job.ID,
+		To:       job.To,
+		Value:    fmt.Sprint(value),
+	})
+
+	job.Retries++
+	job.LastError = fmt.Sprintf("panic: %v", value)
+	job.FailedAt = time.Now()
+	job.Attempts = job.WithAttempt(models.Attempt{At: job.FailedAt, WorkerID: workerID, Error: job.LastError})
+
+	if job.Retries <= es.maxRetries {
+		slog.Info("Scheduling retry after panic", "event", "retry_scheduled", "job_id", job.ID, "recipient", job.To, "attempt", job.Retries, "max_retries", es.maxRetries)
+		es.setStatus(job, StatusRetrying)
+		es.jobsRetried.Inc()
+		es.scheduleRetry(job, es.retryDelay(job.Retries))
+		return
+	}
+
+	slog.Warn("Job kept panicking, moving to dead letter queue", "event", "failed", "job_id", job.ID, "recipient", job.To, "retries", es.maxRetries)
+	job.Reason = models.ReasonPanic
+	es.moveToDeadLetter(job)
+}
+
+// GetPanics returns the most recent panics recovered while processing jobs
+func (es *EmailService) GetPanics() []PanicRecord {
+	return es.panics.list()
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+func TestPanickingSendIsRetriedThenDeadLettered(t *testing.T) {
+	sender := senderFunc(func(ctx context.Context, job models.EmailJob) error {
+		if job.ID == "bad" {
+			panic("template blew up")
+		}
+		return nil
+	})
+	es := startTestService(t, sender, map[string]string{"MAX_RETRIES": "2"})
+
+	for _, id := range []string{"bad", "good"} {
+		if err := es.EnqueueJob(models.EmailJob{ID: id, To: id + "@example.com"}); err != nil {
+			t.Fatalf("EnqueueJob(%s): %v", id, err)
+		}
+	}
+	waitFor(t, "good job sent", func() bool {
+		status, ok := es.JobStatus("good")
+		return ok && status.Status == StatusSent
+	})
+	waitFor(t, "bad job dead-lettered", func() bool { return len(es.GetDeadLetterJobs()) == 1 })
+
+	dead := es.GetDeadLetterJobs()
+	if len(dead) != 1 {
+		t.Fatalf("dead letter jobs = %d, want 1", len(dead))
+	}
+	if dead[0].ID != "bad" || dead[0].Reason != models.ReasonPanic {
+		t.Errorf("dead letter job = %s with reason %q, want bad with %q", dead[0].ID, dead[0].Reason, models.ReasonPanic)
+	}
+	if !strings.Contains(dead[0].LastError, "template blew up") {
+		t.Errorf("last error = %q, want the panic value", dead[0].LastError)
+	}
+	if len(dead[0].Attempts) != 3 {
+		t.Errorf("attempts = %d, want the first try and both retries", len(dead[0].Attempts))
+	}
+
+	status, ok := es.JobStatus("bad")
+	if !ok || status.Status != StatusDeadLettered {
+		t.Errorf("status = %+v, %v, want dead_lettered", status, ok)
+	}
+
+	panics := es.GetPanics()
+	if len(panics) != 3 || panics[0].JobID != "bad" {
+		t.Errorf("panics = %+v, want one for each of bad's attempts", panics)
+	}
+}
+
+func TestPanicOnceThenSent(t *testing.T) {
+	var calls atomic.Int32
+	sender := senderFunc(func(context.Context, models.EmailJob) error {
+		if calls.Add(1) == 1 {
+			panic("provider client not ready")
+		}
+		return nil
+	})
+	es := startTestService(t, sender, nil)
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "job", To: "user@example.com"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "job sent", func() bool {
+		status, ok := es.JobStatus("job")
+		return ok && status.Status == StatusSent
+	})
+
+	if dead := es.GetDeadLetterJobs(); len(dead) != 0 {
+		t.Errorf("dead letters = %+v, want none", dead)
+	}
+	if panics := es.GetPanics(); len(panics) != 1 {
+		t.Errorf("panics = %d, want 1", len(panics))
+	}
+}
+
+func TestPanickingRetryIsDeadLettered(t *testing.T) {
+	var calls atomic.Int32
+	sender := senderFunc(func(context.Context, models.EmailJob) error {
+		if calls.Add(1) > 1 {
+			panic("nil provider")
+		}
+		return context.DeadlineExceeded
+	})
+	es := startTestService(t, sender, map[string]string{"MAX_RETRIES": "1"})
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "job", To: "user@example.com"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "dead letter", func() bool { return len(es.GetDeadLetterJobs()) == 1 })
+
+	job := es.GetDeadLetterJobs()[0]
+	if job.Reason != models.ReasonPanic {
+		t.Errorf("reason = %q, want %q", job.Reason, models.ReasonPanic)
+	}
+	if len(job.Attempts) != 2 {
+		t.Errorf("attempts = %d, want the failure and the panic", len(job.Attempts))
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"email-queue-service/models"
+)
+
+// ErrPartialDelivery is matched by a PartialDeliveryError
+var ErrPartialDelivery = errors.New("relay rejected some recipients")
+
+// PartialDeliveryError is returned when a relay accepted an email for some of
+// its recipients and rejected it for others. Only the rejected recipients
+// are retried, so the others don't get the email twice.
+type PartialDeliveryError struct {
+	// Delivered are the recipients the relay accepted the email for
+	Delivered []string
+	// Rejected maps each rejected recipient to the relay's reply
+	Rejected map[string]error
+}
+
+// Error implements error
+func (e *PartialDeliveryError) Error() string {
+	rejected := make([]string, 0, len(e.Rejected))
+	for recipient, err := range e.Rejected {
+		rejected = append(rejected, fmt.Sprintf("%s: %v", recipient, err))
+	}
+	sort.Strings(rejected)
+	return fmt.Sprintf("%s (%d of %d): %s", ErrPartialDelivery, len(e.Rejected), len(e.Rejected)+len(e.Delivered), strings.Join(rejected, "; "))
+}
+
+// Is makes errors.Is(err, ErrPartialDelivery) match
+func (e *PartialDeliveryError) Is(target error) bool {
+	return target == ErrPartialDelivery
+}
+
+// rejections returns the relay's reply to each rejected recipient as text
+func (e *PartialDeliveryError) rejections() map[string]string {
+	rejections := make(map[string]string, len(e.Rejected))
+	for recipient, err := range e.Rejected {
+		rejections[recipient] = err.Error()
+	}
+	return rejections
+}
+
+// permanent reports whether every rejection was a 5xx reply, which retrying
+// won't change
+func (e *PartialDeliveryError) permanent() bool {
+	for _, err := range e.Rejected {
+		var reply *textproto.Error
+		if !errors.As(err, &reply) || reply.Code < 500 {
+			return false
+		}
+	}
+	return true
+}
+
+// greylisted reports whether any rejection was greylisting
+func (e *PartialDeliveryError) greylisted() bool {
+	for _, err := range e.Rejected {
+		var reply *textproto.Error
+		if errors.As(err, &reply) && isGreylisting(reply) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDeliveries notes on job which recipients an attempt that failed with
+// err delivered to, if it delivered to any, and reports them in its status
+func (es *EmailService) recordDeliveries(job *models.EmailJob, err error) {
+	var partial *PartialDeliveryError
+	if !errors.As(err, &partial) {
+		return
+	}
+
+	job.Deliveries = job.WithDeliveries(partial.Delivered, partial.rejections())
+	es.statuses.setDeliveries(job.ID, job.Deliveries)
+	slog.Warn("Relay rejected some recipients, only those are retried", "event", "partial_delivery", "job_id", job.ID, "recipient", job.To, "delivered", len(partial.Delivered), "rejected", len(partial.Rejected))
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+// groupJob is a job with three recipients
+func groupJob(id string) models.EmailJob {
+	return models.EmailJob{
+		ID:      id,
+		To:      "ada@example.com",
+		Cc:      []string{"bob@example.com"},
+		Bcc:     []string{"cy@example.com"},
+		Subject: "Hello",
+		Body:    "Hi all",
+	}
+}
+
+func TestSMTPSenderDeliversToAcceptedRecipients(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	server.rejectOnce("bob@example.com", "550 5.1.1 mailbox unavailable")
+
+	err := server.sender().Send(context.Background(), groupJob("a"))
+	var partial *PartialDeliveryError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Send = %v, want a PartialDeliveryError", err)
+	}
+	if !IsPermanent(err) {
+		t.Error("a partial delivery whose rejections are all 5xx should be permanent")
+	}
+	if want := []string{"ada@example.com", "cy@example.com"}; !reflect.DeepEqual(partial.Delivered, want) {
+		t.Errorf("Delivered = %v, want %v", partial.Delivered, want)
+	}
+	if _, ok := partial.Rejected["bob@example.com"]; !ok || len(partial.Rejected) != 1 {
+		t.Errorf("Rejected = %v, want bob@example.com only", partial.Rejected)
+	}
+	if got := server.received(); got != 1 {
+		t.Errorf("received = %d, want the email sent to the accepted recipients", got)
+	}
+}
+
+func TestSMTPSenderFailsWhenEveryRecipientIsRejected(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	server.rejectOnce("user@example.com", "550 5.1.1 mailbox unavailable")
+
+	job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hello", Body: "Hi"}
+	err := server.sender().Send(context.Background(), job)
+	if err == nil || errors.Is(err, ErrPartialDelivery) || !IsPermanent(err) {
+		t.Fatalf("Send = %v, want a permanent failure that isn't a partial delivery", err)
+	}
+	if got := server.received(); got != 0 {
+		t.Errorf("received = %d, want nothing sent", got)
+	}
+}
+
+func TestSMTPSenderWithoutPartialDeliveryFailsTheWholeEmail(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	server.rejectOnce("bob@example.com", "550 5.1.1 mailbox unavailable")
+	sender := server.sender()
+	sender.PartialDelivery = false
+
+	err := sender.Send(context.Background(), groupJob("a"))
+	if err == nil || errors.Is(err, ErrPartialDelivery) || !IsPermanent(err) {
+		t.Fatalf("Send = %v, want the rejection as a permanent failure", err)
+	}
+	if got := server.received(); got != 0 {
+		t.Errorf("received = %d, want nothing sent", got)
+	}
+}
+
+func TestPartialDeliveryRetriesOnlyRejectedRecipients(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	server.rejectOnce("bob@example.com", "452 4.2.2 mailbox full")
+	es := startTestService(t, &CompositeSender{Providers: []Provider{server.sender()}}, nil)
+
+	if err := es.EnqueueJob(groupJob("group")); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "the job to be sent", func() bool {
+		record, ok := es.JobStatus("group")
+		return ok && record.Status == StatusSent
+	})
+
+	want := []string{"ada@example.com", "cy@example.com", "bob@example.com"}
+	if got := server.acceptedRecipients(); !reflect.DeepEqual(got, want) {
+		t.Errorf("accepted recipients = %v, want %v with nobody sent to twice", got, want)
+	}
+	if got := server.received(); got != 2 {
+		t.Errorf("received = %d, want 2", got)
+	}
+
+	record, _ := es.JobStatus("group")
+	for _, delivery := range record.Recipients {
+		if delivery.Status != models.DeliverySent {
+			t.Errorf("recipient %s = %s, want sent", delivery.Address, delivery.Status)
+		}
+	}
+	if len(record.Recipients) != 3 {
+		t.Errorf("status recipients = %+v, want all three", record.Recipients)
+	}
+}
+
+func TestPartialDeliveryDeadLettersRejectedRecipients(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	server.rejectOnce("cy@example.com", "550 5.1.1 mailbox unavailable")
+	es := startTestService(t, &CompositeSender{Providers: []Provider{server.sender()}}, nil)
+
+	if err := es.EnqueueJob(groupJob("group")); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "the job to be dead-lettered", func() bool { return len(es.GetDeadLetterJobs()) == 1 })
+
+	want := []models.RecipientDelivery{
+		{Address: "ada@example.com", Status: models.DeliverySent},
+		{Address: "bob@example.com", Status: models.DeliverySent},
+		{Address: "cy@example.com", Status: models.DeliveryRejected, Error: `550 "5.1.1 mailbox unavailable"`},
+	}
+	if got := es.GetDeadLetterJobs()[0].Deliveries; !reflect.DeepEqual(got, want) {
+		t.Errorf("dead letter deliveries = %+v, want %+v", got, want)
+	}
+	if record, _ := es.JobStatus("group"); !reflect.DeepEqual(record.Recipients, want) {
+		t.Errorf("status recipients = %+v, want %+v", record.Recipients, want)
+	}
+	if got := es.GetDeadLetterJobs()[0].PendingRecipients(); !reflect.DeepEqual(got, []string{"cy@example.com"}) {
+		t.Errorf("pending recipients = %v, want only the rejected one", got)
+	}
+}
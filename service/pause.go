@@ -0,0 +1,78 @@
+package service
+
+import (
+	"log/slog"
+
+	"email-queue-service/models"
+)
+
+// Pause stops workers from taking new jobs until Resume. Jobs are still
+// accepted and wait in the queue; sends already running finish. Retries that
+// come due while paused wait for Resume too, and synchronous sends are
+// rejected with ErrPaused.
+func (es *EmailService) Pause() {
+	es.pauseLock.Lock()
+	defer es.pauseLock.Unlock()
+
+	if es.resumed != nil {
+		return
+	}
+	es.resumed = make(chan struct{})
+	slog.Info("Processing paused", "event", "paused", "queue_depth", es.queueDepth())
+}
+
+// Resume lets workers take jobs again after Pause
+func (es *EmailService) Resume() {
+	es.pauseLock.Lock()
+	defer es.pauseLock.Unlock()
+
+	if es.resumed == nil {
+		return
+	}
+	close(es.resumed)
+	es.resumed = nil
+	slog.Info("Processing resumed", "event", "resumed", "queue_depth", es.queueDepth())
+}
+
+// Paused reports whether processing is paused
+func (es *EmailService) Paused() bool {
+	return es.pauseSignal() != nil
+}
+
+// pauseSignal returns a channel closed on Resume while processing is paused,
+// or nil while it isn't
+func (es *EmailService) pauseSignal() <-chan struct{} {
+	es.pauseLock.Lock()
+	defer es.pauseLock.Unlock()
+
+	return es.resumed
+}
+
+// holdRetryWhilePaused waits for Resume before a retry taken from the retry
+// queue runs, in case processing was paused while the taker was waiting for
+// it. It reports false if shutdown or stop closes first, after handing the
+// retry back to the retry queue for whoever runs next.
+func (es *EmailService) holdRetryWhilePaused(job models.EmailJob, shutdown <-chan bool, stop <-chan struct{}) bool {
+	resumed := es.pauseSignal()
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-shutdown:
+	case <-stop:
+	}
+
+	select {
+	case es.retryQueue <- job:
+	default:
+		slog.Warn("Retry queue is full, moving retry to dead letter queue", "event", "retry_overflow", "job_id", job.ID, "recipient", job.To)
+		job.Reason = models.ReasonRetryQueueFull
+		es.retryOverflow.Inc()
+		es.moveToDeadLetter(job)
+		es.retriesInFlight.Done()
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestPausedJobsAccumulateAndDrainOnResume(t *testing.T) {
+	var sent atomic.Int32
+	sender := senderFunc(func(context.Context, models.EmailJob) error {
+		sent.Add(1)
+		return nil
+	})
+	es := startTestService(t, sender, map[string]string{"WORKERS": "3"})
+
+	es.Pause()
+	if !es.Paused() || !es.Stats().Paused {
+		t.Fatal("service not reported paused")
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := es.EnqueueJob(models.EmailJob{ID: string(rune('a' + i)), To: "user@example.com"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := sent.Load(); n != 0 {
+		t.Fatalf("sent %d emails while paused", n)
+	}
+	if depth := es.Stats().QueueDepth; depth != 5 {
+		t.Fatalf("queue depth = %d, want 5", depth)
+	}
+
+	es.Resume()
+	waitFor(t, "backlog to drain", func() bool { return sent.Load() == 5 })
+	if es.Paused() {
+		t.Error("service still reported paused")
+	}
+}
+
+func TestSendNowRejectedWhilePaused(t *testing.T) {
+	var sent atomic.Int32
+	sender := senderFunc(func(context.Context, models.EmailJob) error {
+		sent.Add(1)
+		return nil
+	})
+	es := startTestService(t, sender, nil)
+
+	es.Pause()
+	err := es.SendNow(context.Background(), models.EmailJob{ID: "sync", To: "user@example.com"})
+	if !errors.Is(err, ErrPaused) {
+		t.Fatalf("SendNow while paused = %v, want ErrPaused", err)
+	}
+	if sent.Load() != 0 {
+		t.Fatal("email sent while paused")
+	}
+
+	es.Resume()
+	if err := es.SendNow(context.Background(), models.EmailJob{ID: "sync", To: "user@example.com"}); err != nil {
+		t.Fatalf("SendNow after resume: %v", err)
+	}
+}
+
+func TestRetryWaitsForResume(t *testing.T) {
+	var attempts atomic.Int32
+	sender := senderFunc(func(context.Context, models.EmailJob) error {
+		if attempts.Add(1) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	// Two workers, so both an idle worker and the retry worker compete for the retry
+	es := startTestService(t, sender, map[string]string{"WORKERS": "2", "RETRY_FIXED_DELAY": "30ms"})
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "job", To: "user@example.com"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "first attempt", func() bool { return attempts.Load() == 1 })
+	es.Pause()
+
+	time.Sleep(100 * time.Millisecond)
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("attempts while paused = %d, want 1", n)
+	}
+
+	es.Resume()
+	waitFor(t, "retry after resume", func() bool {
+		status, ok := es.JobStatus("job")
+		return ok && status.Status == StatusSent
+	})
+	if len(es.GetDeadLetterJobs()) != 0 {
+		t.Error("retry dead-lettered")
+	}
+}
+
+func TestShutdownWhilePausedDeadLettersQueuedJobs(t *testing.T) {
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error { return nil }), nil)
+	es.Start()
+
+	es.Pause()
+	for _, id := range []string{"a", "b"} {
+		if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		es.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown hung while paused")
+	}
+
+	if n := len(es.GetDeadLetterJobs()); n != 2 {
+		t.Errorf("dead letter jobs = %d, want 2", n)
+	}
+}
+
+func TestQueuedRetryHeldWhilePaused(t *testing.T) {
+	var sent atomic.Int32
+	sender := senderFunc(func(context.Context, models.EmailJob) error {
+		sent.Add(1)
+		return nil
+	})
+	es := startTestService(t, sender, map[string]string{"WORKERS": "2"})
+
+	// Workers and the retry worker are idle, waiting on the retry queue, when
+	// a retry that came due just before the pause arrives
+	time.Sleep(20 * time.Millisecond)
+	es.Pause()
+	es.retriesInFlight.Add(1)
+	es.retryQueue <- models.EmailJob{ID: "retry", To: "user@example.com", Retries: 1}
+
+	time.Sleep(50 * time.Millisecond)
+	if sent.Load() != 0 {
+		t.Fatal("retry sent while paused")
+	}
+
+	es.Resume()
+	waitFor(t, "retry after resume", func() bool { return sent.Load() == 1 })
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"email-queue-service/models"
+)
+
+// priorities lists the job priorities in the order workers drain them
+var priorities = []string{models.PriorityHigh, models.PriorityNormal, models.PriorityLow}
+
+// priorityLane returns the index into priorities for a job's priority.
+// Jobs without a recognised priority are treated as normal.
+func priorityLane(priority string) int {
+	for i, p := range priorities {
+		if p == priority {
+			return i
+		}
+	}
+	return 1
+}
+
+// isLIFO parses the configured processing order ("fifo" or "lifo")
+func isLIFO(order string) (bool, error) {
+	switch strings.ToLower(order) {
+	case "", "fifo":
+		return false, nil
+	case "lifo":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown processing order %q", order)
+	}
+}
+
+// isRedisBackend parses the configured queue backend ("memory" or "redis")
+func isRedisBackend(backend string) (bool, error) {
+	switch strings.ToLower(backend) {
+	case "", "memory":
+		return false, nil
+	case "redis":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown queue backend %q", backend)
+	}
+}
+
+// jobQueue is where submitted jobs wait for a worker. The in-memory
+// priorityQueue is the default; redisQueue shares jobs between replicas and
+// keeps them across restarts.
+type jobQueue interface {
+	// push adds a job to the lane for its priority, failing with ErrQueueFull
+	// when the lane is full
+	push(job models.EmailJob) error
+	// tryPop takes the next job, highest priority first, without blocking
+	tryPop() (models.EmailJob, bool)
+	// tryPopHigh takes the next high-priority job, without blocking
+	tryPopHigh() (models.EmailJob, bool)
+	// ready receives when jobs may be waiting. It is only a hint: tryPop can
+	// still come back empty.
+	ready() <-chan struct{}
+	// readyHigh is ready for high-priority jobs only, so a worker taking
+	// nothing else doesn't swallow the wakeup for a job it leaves behind
+	readyHigh() <-chan struct{}
+	// ack reports that a job taken with tryPop has been dealt with, whether it
+	// was sent, rescheduled or dead-lettered
+	ack(job models.EmailJob)
+	// spaceFreed returns a channel that is closed once the lane for priority
+	// may have room again
+	spaceFreed(priority string) <-chan struct{}
+	// laneLengths returns how many jobs wait in each lane, indexed like priorities
+	laneLengths() []int
+	// laneCapacity returns how many jobs each lane can hold
+	laneCapacity() int
+	// persistent reports whether jobs left in the queue outlive the process,
+	// in which case shutdown leaves them there
+	persistent() bool
+	// close releases the queue's resources
+	close() error
+}
+
+// jobBuffer is a bounded queue that hands out jobs oldest-first, or newest-first
+// in LIFO mode
+type jobBuffer struct {
+	mu    sync.Mutex
+	jobs  []models.EmailJob // ring buffer of capacity slots
+	head  int
+	count int
+	lifo  bool
+	freed chan struct{} // closed by the next pop; nil until someone waits for room
+}
+
+// newJobBuffer creates a buffer holding at most capacity jobs
+func newJobBuffer(capacity int, lifo bool) *jobBuffer {
+	return &jobBuffer{
+		jobs: make([]models.EmailJob, capacity),
+		lifo: lifo,
+	}
+}
+
+// push adds a job to the buffer
+func (b *jobBuffer) push(job models.EmailJob) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.count == len(b.jobs) {
+		return ErrQueueFull
+	}
+	b.jobs[(b.head+b.count)%len(b.jobs)] = job
+	b.count++
+	return nil
+}
+
+// tryPop removes the next job, if there is one
+func (b *jobBuffer) tryPop() (models.EmailJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.count == 0 {
+		return models.EmailJob{}, false
+	}
+
+	i := b.head
+	if b.lifo {
+		i = (b.head + b.count - 1) % len(b.jobs)
+	} else {
+		b.head = (b.head + 1) % len(b.jobs)
+	}
+	job := b.jobs[i]
+	b.jobs[i] = models.EmailJob{}
+	b.count--
+
+	if b.freed != nil {
+		close(b.freed)
+		b.freed = nil
+	}
+	return job, true
+}
+
+// spaceFreed returns a channel that is closed the next time a job is popped
+func (b *jobBuffer) spaceFreed() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.freed == nil {
+		b.freed = make(chan struct{})
+	}
+	return b.freed
+}
+
+// len returns the number of jobs in the buffer
+func (b *jobBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+// priorityQueue is the in-memory jobQueue: one buffer per priority, indexed
+// like priorities. Every push adds a token to wake, and every high-priority
+// one to wakeHigh too, so there are always at least as many tokens as queued
+// jobs and an idle worker never misses one.
+type priorityQueue struct {
+	lanes    []*jobBuffer
+	capacity int
+	wake     chan struct{}
+	wakeHigh chan struct{}
+}
+
+// newPriorityQueue creates a queue whose lanes each hold capacity jobs
+func newPriorityQueue(capacity int, lifo bool) *priorityQueue {
+	lanes := make([]*jobBuffer, len(priorities))
+	for i := range lanes {
+		lanes[i] = newJobBuffer(capacity, lifo)
+	}
+	return &priorityQueue{
+		lanes:    lanes,
+		capacity: capacity,
+		wake:     make(chan struct{}, capacity*len(lanes)),
+		wakeHigh: make(chan struct{}, capacity),
+	}
+}
+
+// push implements jobQueue
+func (q *priorityQueue) push(job models.EmailJob) error {
+	lane := priorityLane(job.Priority)
+	if err := q.lanes[lane].push(job); err != nil {
+		return fmt.Errorf("%s priority %w", priorities[lane], err)
+	}
+
+	// A full wake buffer already holds a token for every queued job
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	if lane == 0 {
+		q.signalHigh()
+	}
+	return nil
+}
+
+// tryPop implements jobQueue
+func (q *priorityQueue) tryPop() (models.EmailJob, bool) {
+	for _, lane := range q.lanes {
+		if job, ok := lane.tryPop(); ok {
+			return job, true
+		}
+	}
+	return models.EmailJob{}, false
+}
+
+// tryPopHigh implements jobQueue
+func (q *priorityQueue) tryPopHigh() (models.EmailJob, bool) {
+	return q.lanes[0].tryPop()
+}
+
+// ready implements jobQueue
+func (q *priorityQueue) ready() <-chan struct{} {
+	return q.wake
+}
+
+// readyHigh implements jobQueue
+func (q *priorityQueue) readyHigh() <-chan struct{} {
+	return q.wakeHigh
+}
+
+// signal wakes an idle worker without queuing a job, e.g. for a job that
+// arrived some other way
+func (q *priorityQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// signalHigh is signal for a high-priority job
+func (q *priorityQueue) signalHigh() {
+	select {
+	case q.wakeHigh <- struct{}{}:
+	default:
+	}
+}
+
+// len returns the number of jobs waiting across all lanes
+func (q *priorityQueue) len() int {
+	total := 0
+	for _, lane := range q.lanes {
+		total += lane.len()
+	}
+	return total
+}
+
+// ack implements jobQueue; a popped job is already gone from memory
+func (q *priorityQueue) ack(models.EmailJob) {}
+
+// spaceFreed implements jobQueue
+func (q *priorityQueue) spaceFreed(priority string) <-chan struct{} {
+	return q.lanes[priorityLane(priority)].spaceFreed()
+}
+
+// laneLengths implements jobQueue
+func (q *priorityQueue) laneLengths() []int {
+	lengths := make([]int, len(q.lanes))
+	for i, lane := range q.lanes {
+		lengths[i] = lane.len()
+	}
+	return lengths
+}
+
+// laneCapacity implements jobQueue
+func (q *priorityQueue) laneCapacity() int {
+	return q.capacity
+}
+
+// persistent implements jobQueue
+func (q *priorityQueue) persistent() bool {
+	return false
+}
+
+// close implements jobQueue
+func (q *priorityQueue) close() error {
+	return nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestPriorityQueueOrder(t *testing.T) {
+	for _, lifo := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lifo=%v", lifo), func(t *testing.T) {
+			q := newPriorityQueue(10, lifo)
+			for _, job := range []models.EmailJob{
+				{ID: "low", Priority: models.PriorityLow},
+				{ID: "normal-1", Priority: models.PriorityNormal},
+				{ID: "high", Priority: models.PriorityHigh},
+				{ID: "normal-2"},
+			} {
+				if err := q.push(job); err != nil {
+					t.Fatalf("push %s: %v", job.ID, err)
+				}
+			}
+
+			want := []string{"high", "normal-1", "normal-2", "low"}
+			if lifo {
+				want = []string{"high", "normal-2", "normal-1", "low"}
+			}
+			for _, id := range want {
+				job, ok := q.tryPop()
+				if !ok || job.ID != id {
+					t.Fatalf("tryPop = %s, %v, want %s", job.ID, ok, id)
+				}
+			}
+			if _, ok := q.tryPop(); ok {
+				t.Error("tryPop on an empty queue returned a job")
+			}
+		})
+	}
+}
+
+func TestPriorityQueueLanesFillSeparately(t *testing.T) {
+	q := newPriorityQueue(1, false)
+	if err := q.push(models.EmailJob{Priority: models.PriorityLow}); err != nil {
+		t.Fatalf("push low: %v", err)
+	}
+	if err := q.push(models.EmailJob{Priority: models.PriorityLow}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("second low push = %v, want ErrQueueFull", err)
+	}
+	if err := q.push(models.EmailJob{Priority: models.PriorityHigh}); err != nil {
+		t.Fatalf("high push with the low lane full: %v", err)
+	}
+	if lengths := q.laneLengths(); lengths[0] != 1 || lengths[1] != 0 || lengths[2] != 1 {
+		t.Errorf("lane lengths = %v, want [1 0 1]", lengths)
+	}
+}
+
+func TestJobBufferSpaceFreed(t *testing.T) {
+	b := newJobBuffer(1, false)
+	b.push(models.EmailJob{ID: "a"})
+
+	freed := b.spaceFreed()
+	select {
+	case <-freed:
+		t.Fatal("space freed before a pop")
+	default:
+	}
+
+	b.tryPop()
+	select {
+	case <-freed:
+	default:
+		t.Fatal("space not freed after a pop")
+	}
+}
+
+func TestIsLIFO(t *testing.T) {
+	for order, want := range map[string]bool{"": false, "fifo": false, "LIFO": true} {
+		if got, err := isLIFO(order); err != nil || got != want {
+			t.Errorf("isLIFO(%q) = %v, %v, want %v", order, got, err, want)
+		}
+	}
+	if _, err := isLIFO("random"); err == nil {
+		t.Error("isLIFO accepted an unknown order")
+	}
+}
+
+func BenchmarkJobBuffer(b *testing.B) {
+	for _, lifo := range []bool{false, true} {
+		b.Run(fmt.Sprintf("lifo=%v", lifo), func(b *testing.B) {
+			buf := newJobBuffer(1024, lifo)
+			job := models.EmailJob{ID: "job", To: "user@example.com"}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.push(job)
+				buf.tryPop()
+			}
+		})
+	}
+}
+
+func BenchmarkPriorityQueueParallel(b *testing.B) {
+	q := newPriorityQueue(4096, false)
+	jobs := []models.EmailJob{
+		{ID: "high", Priority: models.PriorityHigh},
+		{ID: "normal", Priority: models.PriorityNormal},
+		{ID: "low", Priority: models.PriorityLow},
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			q.push(jobs[i%len(jobs)])
+			q.tryPop()
+			i++
+		}
+	})
+}
+
+func TestHighPriorityJobProcessedFirst(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	es := newTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, job.ID)
+		return nil
+	}), nil)
+	// Everything is queued before the worker takes anything
+	es.Pause()
+	es.Start()
+	t.Cleanup(es.Shutdown)
+
+	for i := 0; i < 20; i++ {
+		if err := es.EnqueueJob(models.EmailJob{ID: fmt.Sprintf("normal-%d", i), To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+	if err := es.EnqueueJob(models.EmailJob{ID: "urgent", To: "user@example.com", Subject: "Hi", Body: "Hi", Priority: models.PriorityHigh}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	es.Resume()
+
+	waitFor(t, "every job sent", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == 21
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if sent[0] != "urgent" {
+		t.Errorf("first sent = %s, want the high priority job", sent[0])
+	}
+}
+
+func TestEnqueueJobWaitForRoom(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		resume  bool
+		wantErr error
+		minWait time.Duration
+		maxWait time.Duration
+	}{
+		{"fails fast without a timeout", "0s", false, ErrQueueFull, 0, 50 * time.Millisecond},
+		{"gives up after the timeout", "100ms", false, ErrQueueFull, 100 * time.Millisecond, 2 * time.Second},
+		{"succeeds once a worker makes room", "5s", true, nil, 0, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := startTestService(t, senderFunc(func(context.Context, models.EmailJob) error { return nil }),
+				map[string]string{"QUEUE_SIZE": "1", "ENQUEUE_TIMEOUT": tt.timeout})
+			es.Pause()
+			if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com"}); err != nil {
+				t.Fatalf("EnqueueJob: %v", err)
+			}
+
+			if tt.resume {
+				time.AfterFunc(50*time.Millisecond, es.Resume)
+			}
+			start := time.Now()
+			err := es.EnqueueJobWait(context.Background(), models.EmailJob{ID: "b", To: "user@example.com"})
+			waited := time.Since(start)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnqueueJobWait = %v, want %v", err, tt.wantErr)
+			}
+			if waited < tt.minWait || waited > tt.maxWait {
+				t.Errorf("waited %v, want between %v and %v", waited, tt.minWait, tt.maxWait)
+			}
+		})
+	}
+}
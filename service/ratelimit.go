@@ -0,0 +1,160 @@
+package service
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// domainBucketIdleSweep is how often idle per-domain buckets are dropped
+const domainBucketIdleSweep = time.Minute
+
+// tokenBucket allows rate events per second with bursts of up to burst events
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket. The burst is one second's worth of
+// tokens, and at least one.
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// refill adds the tokens earned since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// take consumes a token only if one is available now
+func (b *tokenBucket) take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// giveBack returns a token taken with take, up to the burst
+func (b *tokenBucket) giveBack(now time.Time) {
+	b.refill(now)
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// reserve takes a token, borrowing against future refills if none is left,
+// and returns how long until that token is earned. Successive reservations
+// are spaced out at the bucket's rate.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled completely, i.e. is idle
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// globalLimiter shares one token bucket between every sender
+type globalLimiter struct {
+	mu     sync.Mutex
+	bucket *tokenBucket
+}
+
+// newGlobalLimiter creates a limiter allowing rate sends per second in total
+func newGlobalLimiter(rate float64) *globalLimiter {
+	return &globalLimiter{bucket: newTokenBucket(rate, time.Now())}
+}
+
+// take consumes a token only if one is available now
+func (l *globalLimiter) take(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bucket.take(now)
+}
+
+// reserve takes a token and returns how long until it is earned
+func (l *globalLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bucket.reserve(now)
+}
+
+// domainLimiter keeps a token bucket per recipient domain
+type domainLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newDomainLimiter creates a limiter allowing rate sends per second to each domain
+func newDomainLimiter(rate float64) *domainLimiter {
+	return &domainLimiter{
+		rate:    rate,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// take consumes a token for domain only if one is available now
+func (l *domainLimiter) take(domain string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bucket(domain, now).take(now)
+}
+
+// reserve takes a token for domain and returns how long until it is earned
+func (l *domainLimiter) reserve(domain string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bucket(domain, now).reserve(now)
+}
+
+// bucket returns the bucket for domain, creating it if needed.
+// Callers must hold mu.
+func (l *domainLimiter) bucket(domain string, now time.Time) *tokenBucket {
+	l.sweep(now)
+
+	bucket, ok := l.buckets[domain]
+	if !ok {
+		bucket = newTokenBucket(l.rate, now)
+		l.buckets[domain] = bucket
+	}
+	return bucket
+}
+
+// sweep drops buckets that have refilled completely, at most once per interval.
+// A dropped bucket is recreated full, so this doesn't change any limits.
+func (l *domainLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < domainBucketIdleSweep {
+		return
+	}
+	l.lastSweep = now
+
+	for domain, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, domain)
+		}
+	}
+}
+
+// recipientDomain returns the lower-cased domain of an email address
+func recipientDomain(addr string) string {
+	return strings.ToLower(addr[strings.LastIndex(addr, "@")+1:])
+}
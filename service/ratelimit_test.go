@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestDomainLimitsAreIndependent(t *testing.T) {
+	l := newDomainLimiter(2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if !l.take("a.example", now) {
+			t.Fatalf("a.example send %d limited within its burst", i+1)
+		}
+	}
+	if l.take("a.example", now) {
+		t.Error("a.example not limited past its burst")
+	}
+
+	// Another domain has its own bucket, and domains are case-insensitive
+	for i := 0; i < 2; i++ {
+		if !l.take(recipientDomain("user@B.example"), now) {
+			t.Fatalf("b.example send %d limited by a.example's traffic", i+1)
+		}
+	}
+	if l.take("b.example", now) {
+		t.Error("b.example not limited past its burst")
+	}
+
+	// Half a second earns one more token at 2 per second
+	later := now.Add(500 * time.Millisecond)
+	if !l.take("a.example", later) {
+		t.Error("a.example still limited after refilling")
+	}
+	if l.take("a.example", later) {
+		t.Error("a.example got more than it refilled")
+	}
+}
+
+func TestDomainLimiterDropsIdleBuckets(t *testing.T) {
+	l := newDomainLimiter(1)
+	now := time.Now()
+	l.take("a.example", now)
+	l.take("b.example", now)
+
+	// A sweep long after both refilled drops them
+	l.take("c.example", now.Add(2*domainBucketIdleSweep))
+	if _, ok := l.buckets["a.example"]; ok || len(l.buckets) != 1 {
+		t.Errorf("buckets after the sweep = %d, want only c.example's", len(l.buckets))
+	}
+}
+
+func TestGlobalRateBoundsThroughput(t *testing.T) {
+	const rate = 20
+
+	var mu sync.Mutex
+	var sent []time.Time
+	started := time.Now()
+	es := startTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, time.Now())
+		return nil
+	}), map[string]string{"GLOBAL_RATE": strconv.Itoa(rate), "WORKERS": "4"})
+
+	const jobs = 40
+	for i := 0; i < jobs; i++ {
+		if err := es.EnqueueJob(models.EmailJob{ID: strconv.Itoa(i), To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+	waitFor(t, "every job sent", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == jobs
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	// After a one second burst, each send waits for the next token
+	for i, at := range sent {
+		earliest := time.Duration(i+1-rate) * time.Second / rate
+		if elapsed := at.Sub(started); elapsed < earliest-10*time.Millisecond {
+			t.Fatalf("send %d after %s, want no sooner than %s at %d per second", i+1, elapsed, earliest, rate)
+		}
+	}
+}
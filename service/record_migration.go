@@ -0,0 +1,71 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// jobRecordVersion is the layout of the job records written now, in the dead
+// letter file or a Redis queue. When the layout changes, bump it and add a
+// migration from the previous version to jobRecordMigrations.
+const jobRecordVersion = 1
+
+// jobRecordMigrations upgrade a record, decoded as its raw fields, from the
+// version at their index to the next one. Version 0 is records written before
+// they carried a version, which have the same layout as version 1.
+var jobRecordMigrations = []func(fields map[string]json.RawMessage) error{
+	0: func(map[string]json.RawMessage) error { return nil },
+}
+
+// errNewerRecord is returned for a record written by a newer version of the
+// service, whose layout can't be known
+var errNewerRecord = errors.New("job record written by a newer version")
+
+// recordCounts counts the stored records that were migrated from an older
+// version or skipped because they couldn't be read
+type recordCounts struct {
+	migrated int
+	skipped  int
+}
+
+// decodeJobRecord decodes a stored job record, upgrading it first if it was
+// written in an older layout. migrated reports whether it was.
+func decodeJobRecord(data []byte) (record jobRecord, migrated bool, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return jobRecord{}, false, err
+	}
+
+	version := 0
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return jobRecord{}, false, fmt.Errorf("invalid job record version: %w", err)
+		}
+	}
+	switch {
+	case version > jobRecordVersion:
+		return jobRecord{}, false, fmt.Errorf("%w: version %d, this one reads up to %d", errNewerRecord, version, jobRecordVersion)
+	case version < 0:
+		return jobRecord{}, false, fmt.Errorf("invalid job record version %d", version)
+	case version == jobRecordVersion:
+		err := json.Unmarshal(data, &record)
+		return record, false, err
+	}
+
+	for ; version < jobRecordVersion; version++ {
+		if err := jobRecordMigrations[version](fields); err != nil {
+			return jobRecord{}, false, fmt.Errorf("migrate job record from version %d: %w", version, err)
+		}
+	}
+	fields["version"] = json.RawMessage(fmt.Sprint(jobRecordVersion))
+
+	upgraded, err := json.Marshal(fields)
+	if err != nil {
+		return jobRecord{}, false, err
+	}
+	if err := json.Unmarshal(upgraded, &record); err != nil {
+		return jobRecord{}, false, err
+	}
+	return record, true, nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// unversionedDeadLetters is a dead letter file as written before records
+// carried a version: one job with stored-only fields, one truncated by a
+// crash, and one from a newer version of the service
+const unversionedDeadLetters = `{"id":"a","to":"user@example.com","subject":"Hi","body":"Hello","failed_at":"2026-01-02T03:04:05Z","last_error":"550 rejected","retries":3,"send_at":"2026-01-01T00:00:00Z","enqueued_at":"2026-01-01T00:00:01Z"}
+{"id":"b","to":"user@exa
+{"version":99,"id":"c","to":"user@example.com"}
+`
+
+func TestDecodeJobRecordMigratesUnversionedRecords(t *testing.T) {
+	record, migrated, err := decodeJobRecord([]byte(`{"id":"a","to":"user@example.com","retries":2,"heartbeat":true}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !migrated || record.Version != jobRecordVersion {
+		t.Errorf("migrated = %v, version = %d; want upgraded to %d", migrated, record.Version, jobRecordVersion)
+	}
+	if job := record.job(); job.ID != "a" || job.Retries != 2 || !job.Heartbeat {
+		t.Errorf("job = %+v, want its stored fields kept", job)
+	}
+
+	current, err := json.Marshal(newJobRecord(models.EmailJob{ID: "b", Retries: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, migrated, err := decodeJobRecord(current); err != nil || migrated || record.job().Retries != 1 {
+		t.Errorf("current record = %+v, %v, %v; want it read as is", record, migrated, err)
+	}
+
+	if _, _, err := decodeJobRecord([]byte(`{"version":99,"id":"c"}`)); !errors.Is(err, errNewerRecord) {
+		t.Errorf("newer record error = %v, want errNewerRecord", err)
+	}
+}
+
+func TestDeadLetterFileFromOlderVersionLoads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	if err := os.WriteFile(path, []byte(unversionedDeadLetters), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	es := newTestService(t, nil, map[string]string{"DLQ_FILE": path})
+
+	dead := es.GetDeadLetterJobs()
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %+v, want the one readable job", dead)
+	}
+	job := dead[0]
+	if job.ID != "a" || job.Retries != 3 || job.LastError != "550 rejected" || !job.SendAt.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("job = %+v, want the stored job", job)
+	}
+
+	if got := testutil.ToFloat64(es.storedRecords.WithLabelValues("migrated")); got != 1 {
+		t.Errorf("migrated records = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(es.storedRecords.WithLabelValues("skipped")); got != 2 {
+		t.Errorf("skipped records = %v, want 2", got)
+	}
+}
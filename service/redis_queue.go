@@ -0,0 +1,427 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+
+	"email-queue-service/models"
+)
+
+const (
+	// redisPollInterval is how often the queue checks Redis for jobs pushed
+	// by other replicas and for in-flight jobs to requeue
+	redisPollInterval = 250 * time.Millisecond
+	// redisOpTimeout bounds a single Redis command
+	redisOpTimeout = 5 * time.Second
+	// redisWakeBuffer caps how many idle workers one poll can wake
+	redisWakeBuffer = 64
+	// redisReapBatch caps how many expired in-flight jobs one poll requeues
+	redisReapBatch = 100
+)
+
+// ErrQueueUnavailable is returned when the queue backend can't be reached
+var ErrQueueUnavailable = errors.New("queue unavailable")
+
+var (
+	// redisPushScript appends a job to a lane unless the lane is full
+	redisPushScript = redis.NewScript(`
+if redis.call('LLEN', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('RPUSH', KEYS[1], ARGV[2])
+return 1
+`)
+
+	// redisPopScript takes the next job from the first non-empty lane and
+	// records it as in flight until the given deadline
+	redisPopScript = redis.NewScript(`
+local inflight = KEYS[#KEYS]
+for i = 1, #KEYS - 1 do
+	local payload
+	if ARGV[1] == '1' then
+		payload = redis.call('RPOP', KEYS[i])
+	else
+		payload = redis.call('LPOP', KEYS[i])
+	end
+	if payload then
+		redis.call('ZADD', inflight, ARGV[2], payload)
+		return payload
+	end
+end
+return false
+`)
+
+	// redisRequeueScript puts an in-flight job back where it is taken next,
+	// unless another replica got there first
+	redisRequeueScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if ARGV[2] == '1' then
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+else
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+end
+return 1
+`)
+)
+
+// redisQueue is a jobQueue kept in Redis, so queued jobs survive restarts and
+// are shared by every replica using the same key prefix. Each priority is a
+// list. A popped job moves to a sorted set of in-flight jobs scored by a
+// visibility deadline, which the replica holding it keeps pushing back. If
+// the replica dies, the deadline passes and the job goes back to the front
+// of its list for another worker.
+type redisQueue struct {
+	client      *redis.Client
+	laneKeys    []string // indexed like priorities
+	inflightKey string
+	capacity    int
+	lifo        bool
+	visibility  time.Duration
+	records     *prometheus.CounterVec // stored records migrated or skipped
+
+	mu       sync.Mutex
+	inflight map[string]string // job ID to the payload it was popped as
+	lengths  []int             // lane lengths as of the last poll
+
+	wake     chan struct{}
+	wakeHigh chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newRedisQueue creates a queue on the Redis server at url and starts polling
+// it. Each lane holds at most capacity jobs. It doesn't check the server can
+// be reached; call ping for that. Records read back in an older layout or
+// dropped as unreadable are counted in records.
+func newRedisQueue(url, prefix string, capacity int, lifo bool, visibility time.Duration, records *prometheus.CounterVec) (*redisQueue, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	q := &redisQueue{
+		client:      client,
+		laneKeys:    make([]string, len(priorities)),
+		inflightKey: prefix + ":inflight",
+		capacity:    capacity,
+		lifo:        lifo,
+		visibility:  visibility,
+		records:     records,
+		inflight:    make(map[string]string),
+		lengths:     make([]int, len(priorities)),
+		wake:        make(chan struct{}, redisWakeBuffer),
+		wakeHigh:    make(chan struct{}, redisWakeBuffer),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for i, priority := range priorities {
+		q.laneKeys[i] = prefix + ":queue:" + priority
+	}
+
+	go q.pollLoop()
+	return q, nil
+}
+
+// ping checks that the Redis server can be reached
+func (q *redisQueue) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	if err := q.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("connect to Redis: %w", err)
+	}
+	return nil
+}
+
+// push implements jobQueue
+func (q *redisQueue) push(job models.EmailJob) error {
+	payload, err := json.Marshal(newJobRecord(job))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	lane := priorityLane(job.Priority)
+	pushed, err := redisPushScript.Run(ctx, q.client, []string{q.laneKeys[lane]}, q.capacity, payload).Int()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrQueueUnavailable, err)
+	}
+	if pushed == 0 {
+		return fmt.Errorf("%s priority %w", priorities[lane], ErrQueueFull)
+	}
+
+	q.signal(q.wake, 1)
+	if lane == 0 {
+		q.signal(q.wakeHigh, 1)
+	}
+	return nil
+}
+
+// tryPop implements jobQueue
+func (q *redisQueue) tryPop() (models.EmailJob, bool) {
+	return q.pop(q.laneKeys)
+}
+
+// tryPopHigh implements jobQueue
+func (q *redisQueue) tryPopHigh() (models.EmailJob, bool) {
+	return q.pop(q.laneKeys[:1])
+}
+
+// pop takes the next job from the first non-empty lane of laneKeys
+func (q *redisQueue) pop(laneKeys []string) (models.EmailJob, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	keys := append(append([]string(nil), laneKeys...), q.inflightKey)
+	deadline := time.Now().Add(q.visibility).UnixMilli()
+	payload, err := redisPopScript.Run(ctx, q.client, keys, q.lifoArg(), deadline).Text()
+	if errors.Is(err, redis.Nil) {
+		return models.EmailJob{}, false
+	}
+	if err != nil {
+		slog.Error("Failed to take job from Redis queue", "error", err)
+		return models.EmailJob{}, false
+	}
+
+	record, err := q.decode(payload)
+	if err != nil {
+		// Requeueing it would only fail again
+		slog.Error("Dropping unreadable job from Redis queue", "error", err)
+		q.client.ZRem(ctx, q.inflightKey, payload)
+		return models.EmailJob{}, false
+	}
+
+	job := record.job()
+	q.mu.Lock()
+	q.inflight[job.ID] = payload
+	q.mu.Unlock()
+	return job, true
+}
+
+// ready implements jobQueue
+func (q *redisQueue) ready() <-chan struct{} {
+	return q.wake
+}
+
+// readyHigh implements jobQueue
+func (q *redisQueue) readyHigh() <-chan struct{} {
+	return q.wakeHigh
+}
+
+// ack implements jobQueue
+func (q *redisQueue) ack(job models.EmailJob) {
+	q.mu.Lock()
+	payload, ok := q.inflight[job.ID]
+	delete(q.inflight, job.ID)
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	if err := q.client.ZRem(ctx, q.inflightKey, payload).Err(); err != nil {
+		// The job will be sent again once its visibility deadline passes
+		slog.Error("Failed to acknowledge job in Redis queue", "job_id", job.ID, "error", err)
+	}
+}
+
+// spaceFreed implements jobQueue. Other replicas pop without telling us, so
+// this is just the next poll.
+func (q *redisQueue) spaceFreed(string) <-chan struct{} {
+	freed := make(chan struct{})
+	time.AfterFunc(redisPollInterval, func() {
+		close(freed)
+	})
+	return freed
+}
+
+// laneLengths implements jobQueue with the lengths from the last poll
+func (q *redisQueue) laneLengths() []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]int(nil), q.lengths...)
+}
+
+// laneCapacity implements jobQueue
+func (q *redisQueue) laneCapacity() int {
+	return q.capacity
+}
+
+// persistent implements jobQueue
+func (q *redisQueue) persistent() bool {
+	return true
+}
+
+// close implements jobQueue. Jobs still in flight are left to be requeued
+// once their visibility deadline passes.
+func (q *redisQueue) close() error {
+	close(q.stop)
+	<-q.done
+	return q.client.Close()
+}
+
+// pollLoop keeps in-flight jobs visible to this replica only, requeues jobs
+// other replicas abandoned, and wakes workers for jobs pushed elsewhere
+func (q *redisQueue) pollLoop() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(redisPollInterval)
+	defer ticker.Stop()
+
+	// Deadlines are pushed back well before they can pass
+	extendEvery := max(q.visibility/3, redisPollInterval)
+	lastExtended := time.Now()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if now.Sub(lastExtended) >= extendEvery {
+				q.extendInflight(now)
+				lastExtended = now
+			}
+			q.reap(now)
+			q.poll()
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// poll refreshes the lane lengths and wakes a worker for each waiting job
+func (q *redisQueue) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	pipe := q.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(q.laneKeys))
+	for i, key := range q.laneKeys {
+		cmds[i] = pipe.LLen(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Warn("Failed to read Redis queue lengths", "error", err)
+		return
+	}
+
+	lengths := make([]int, len(cmds))
+	total := 0
+	for i, cmd := range cmds {
+		lengths[i] = int(cmd.Val())
+		total += lengths[i]
+	}
+
+	q.mu.Lock()
+	q.lengths = lengths
+	q.mu.Unlock()
+
+	q.signal(q.wake, total)
+	q.signal(q.wakeHigh, lengths[0])
+}
+
+// signal wakes up to n idle workers waiting on wake
+func (q *redisQueue) signal(wake chan struct{}, n int) {
+	for range n {
+		select {
+		case wake <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// extendInflight pushes back the visibility deadline of every job this
+// replica is working on
+func (q *redisQueue) extendInflight(now time.Time) {
+	q.mu.Lock()
+	payloads := make([]string, 0, len(q.inflight))
+	for _, payload := range q.inflight {
+		payloads = append(payloads, payload)
+	}
+	q.mu.Unlock()
+	if len(payloads) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	deadline := float64(now.Add(q.visibility).UnixMilli())
+	members := make([]redis.Z, len(payloads))
+	for i, payload := range payloads {
+		members[i] = redis.Z{Score: deadline, Member: payload}
+	}
+	// XX only updates, so a job acked in the meantime isn't brought back
+	if err := q.client.ZAddXX(ctx, q.inflightKey, members...).Err(); err != nil {
+		slog.Warn("Failed to extend in-flight jobs in Redis queue", "count", len(payloads), "error", err)
+	}
+}
+
+// reap requeues in-flight jobs whose visibility deadline has passed
+func (q *redisQueue) reap(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	expired, err := q.client.ZRangeByScore(ctx, q.inflightKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprint(now.UnixMilli()),
+		Count: redisReapBatch,
+	}).Result()
+	if err != nil {
+		slog.Warn("Failed to check Redis queue for abandoned jobs", "error", err)
+		return
+	}
+
+	for _, payload := range expired {
+		// Only counted when dropped: a requeued job is counted when taken again
+		record, _, err := decodeJobRecord([]byte(payload))
+		if err != nil {
+			q.records.WithLabelValues("skipped").Inc()
+			slog.Error("Dropping unreadable in-flight job from Redis queue", "error", err)
+			q.client.ZRem(ctx, q.inflightKey, payload)
+			continue
+		}
+
+		lane := q.laneKeys[priorityLane(record.Priority)]
+		requeued, err := redisRequeueScript.Run(ctx, q.client, []string{q.inflightKey, lane}, payload, q.lifoArg()).Int()
+		if err != nil {
+			slog.Warn("Failed to requeue abandoned job", "job_id", record.ID, "error", err)
+			continue
+		}
+		if requeued == 1 {
+			slog.Warn("Requeued job abandoned by its worker", "event", "requeued", "job_id", record.ID, "recipient", record.To)
+		}
+	}
+}
+
+// lifoArg passes the processing order to the Lua scripts
+func (q *redisQueue) lifoArg() string {
+	if q.lifo {
+		return "1"
+	}
+	return "0"
+}
+
+// decode reads a stored job, upgrading it if it was written in an older
+// layout, and counts it if it was upgraded or can't be read
+func (q *redisQueue) decode(payload string) (jobRecord, error) {
+	record, migrated, err := decodeJobRecord([]byte(payload))
+	switch {
+	case err != nil:
+		q.records.WithLabelValues("skipped").Inc()
+	case migrated:
+		q.records.WithLabelValues("migrated").Inc()
+	}
+	return record, err
+}
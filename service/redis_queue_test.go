@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/models"
+)
+
+// openTestRedisQueue opens a queue on server holding capacity jobs per lane.
+// The caller closes it.
+func openTestRedisQueue(t *testing.T, server *miniredis.Miniredis, capacity int, visibility time.Duration) *redisQueue {
+	t.Helper()
+
+	records := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "records"}, []string{"result"})
+	q, err := newRedisQueue("redis://"+server.Addr(), "test", capacity, false, visibility, records)
+	if err != nil {
+		t.Fatalf("newRedisQueue: %v", err)
+	}
+	if err := q.ping(); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	return q
+}
+
+func TestRedisQueuePriorityAndCapacity(t *testing.T) {
+	server := miniredis.RunT(t)
+	q := openTestRedisQueue(t, server, 2, time.Minute)
+	defer q.close()
+
+	for _, job := range []models.EmailJob{
+		{ID: "low", Priority: models.PriorityLow},
+		{ID: "normal-1", Priority: models.PriorityNormal},
+		{ID: "normal-2", Priority: models.PriorityNormal},
+		{ID: "high", Priority: models.PriorityHigh},
+	} {
+		if err := q.push(job); err != nil {
+			t.Fatalf("push %s: %v", job.ID, err)
+		}
+	}
+	if err := q.push(models.EmailJob{ID: "normal-3", Priority: models.PriorityNormal}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("push into a full lane = %v, want ErrQueueFull", err)
+	}
+
+	for _, want := range []string{"high", "normal-1", "normal-2", "low"} {
+		job, ok := q.tryPop()
+		if !ok || job.ID != want {
+			t.Fatalf("tryPop = %q, %v; want %q", job.ID, ok, want)
+		}
+		q.ack(job)
+	}
+	if job, ok := q.tryPop(); ok {
+		t.Errorf("tryPop on an empty queue = %q", job.ID)
+	}
+	if inflight, _ := server.ZMembers("test:inflight"); len(inflight) != 0 {
+		t.Errorf("in-flight jobs after acking all = %d, want 0", len(inflight))
+	}
+}
+
+func TestRedisQueueSurvivesRestart(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	first := openTestRedisQueue(t, server, 10, time.Minute)
+	if err := first.push(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi"}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	first.close()
+
+	second := openTestRedisQueue(t, server, 10, time.Minute)
+	defer second.close()
+	job, ok := second.tryPop()
+	if !ok || job.ID != "a" || job.To != "user@example.com" || job.Subject != "Hi" {
+		t.Errorf("tryPop after a restart = %+v, %v; want job a", job, ok)
+	}
+}
+
+func TestRedisQueueRequeuesAbandonedJob(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	// A replica takes the job and dies without acking it
+	dead := openTestRedisQueue(t, server, 10, 100*time.Millisecond)
+	if err := dead.push(models.EmailJob{ID: "a", To: "user@example.com"}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if _, ok := dead.tryPop(); !ok {
+		t.Fatal("tryPop found nothing")
+	}
+	dead.close()
+
+	survivor := openTestRedisQueue(t, server, 10, 100*time.Millisecond)
+	defer survivor.close()
+	if job, ok := survivor.tryPop(); ok {
+		t.Fatalf("took in-flight job %q before its visibility deadline", job.ID)
+	}
+
+	waitFor(t, "abandoned job requeued", func() bool {
+		job, ok := survivor.tryPop()
+		if ok && job.ID != "a" {
+			t.Fatalf("tryPop = %q, want a", job.ID)
+		}
+		return ok
+	})
+}
+
+func TestRedisQueueKeepsJobVisibleWhileWorkedOn(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	// The visibility timeout is shorter than the work, but the holder keeps
+	// extending it
+	holder := openTestRedisQueue(t, server, 10, time.Second)
+	defer holder.close()
+	other := openTestRedisQueue(t, server, 10, time.Second)
+	defer other.close()
+
+	if err := holder.push(models.EmailJob{ID: "a", To: "user@example.com"}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	job, ok := holder.tryPop()
+	if !ok {
+		t.Fatal("tryPop found nothing")
+	}
+
+	time.Sleep(2 * time.Second)
+	if stolen, ok := other.tryPop(); ok {
+		t.Fatalf("another replica took %q while it was being worked on", stolen.ID)
+	}
+	holder.ack(job)
+}
+
+func TestServiceSendsThroughRedisQueue(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	var sent atomic.Int32
+	es := startTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		sent.Add(1)
+		return nil
+	}), map[string]string{
+		"QUEUE_BACKEND": "redis",
+		"REDIS_URL":     "redis://" + server.Addr(),
+	})
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+	waitFor(t, "jobs sent", func() bool { return sent.Load() == 3 })
+	waitFor(t, "jobs acked", func() bool {
+		inflight, _ := server.ZMembers("email-queue:inflight")
+		return len(inflight) == 0
+	})
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// failingSender fails the first failures sends and delivers the rest
+func failingSender(failures int32, attempts *atomic.Int32) senderFunc {
+	return func(context.Context, models.EmailJob) error {
+		if attempts.Add(1) <= failures {
+			return errors.New("421 service not available")
+		}
+		return nil
+	}
+}
+
+func TestSenderErrorsAreRetried(t *testing.T) {
+	var attempts atomic.Int32
+	es := startTestService(t, failingSender(2, &attempts), nil)
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "job sent", func() bool {
+		record, ok := es.JobStatus("a")
+		return ok && record.Status == StatusSent
+	})
+
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+	if got := metricValue(t, es, "email_jobs_retried_total"); got != 2 {
+		t.Errorf("email_jobs_retried_total = %v, want 2", got)
+	}
+	if dead := es.GetDeadLetterJobs(); len(dead) != 0 {
+		t.Errorf("dead letters = %d, want none", len(dead))
+	}
+}
+
+func TestSenderErrorDeadLettersOnceRetriesRunOut(t *testing.T) {
+	var attempts atomic.Int32
+	es := startTestService(t, failingSender(100, &attempts), nil)
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "job dead-lettered", func() bool {
+		return len(es.GetDeadLetterJobs()) == 1
+	})
+
+	// The default of 3 retries after the first attempt
+	if got := attempts.Load(); got != 4 {
+		t.Errorf("attempts = %d, want 4", got)
+	}
+	if dead := es.GetDeadLetterJobs()[0]; dead.ID != "a" || dead.LastError != "421 service not available" {
+		t.Errorf("dead letter = %s with error %q, want a with the sender's error", dead.ID, dead.LastError)
+	}
+}
+
+func TestMaxRetriesBoundsAttempts(t *testing.T) {
+	tests := []struct {
+		maxRetries   string
+		wantAttempts int32
+	}{
+		{"0", 1},
+		{"1", 2},
+	}
+	for _, tt := range tests {
+		t.Run("MAX_RETRIES="+tt.maxRetries, func(t *testing.T) {
+			var attempts atomic.Int32
+			es := startTestService(t, failingSender(100, &attempts), map[string]string{"MAX_RETRIES": tt.maxRetries})
+
+			if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+				t.Fatalf("EnqueueJob: %v", err)
+			}
+			waitFor(t, "job dead-lettered", func() bool {
+				return len(es.GetDeadLetterJobs()) == 1
+			})
+
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if dead := es.GetDeadLetterJobs()[0]; len(dead.Attempts) != int(tt.wantAttempts) {
+				t.Errorf("dead letter records %d attempts, want %d", len(dead.Attempts), tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestSendTimeoutIsRetried(t *testing.T) {
+	var attempts atomic.Int32
+	retried := make(chan models.EmailJob, 1)
+	es := startTestService(t, senderFunc(func(ctx context.Context, job models.EmailJob) error {
+		if attempts.Add(1) == 1 {
+			// Hangs until the send timeout cancels it
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		retried <- job
+		return nil
+	}), map[string]string{"SEND_TIMEOUT": "50ms"})
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	select {
+	case job := <-retried:
+		if !strings.Contains(job.LastError, ErrSendTimeout.Error()) {
+			t.Errorf("retried job's last error = %q, want a send timeout", job.LastError)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out send not retried")
+	}
+	waitFor(t, "job sent", func() bool {
+		record, ok := es.JobStatus("a")
+		return ok && record.Status == StatusSent
+	})
+}
+
+func TestShutdownCancelsInFlightSendAfterGrace(t *testing.T) {
+	sending := make(chan struct{})
+	cancelled := make(chan struct{})
+	es := newTestService(t, senderFunc(func(ctx context.Context, job models.EmailJob) error {
+		close(sending)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}), map[string]string{"SEND_TIMEOUT": "1h", "SHUTDOWN_RETRY_GRACE": "50ms"})
+	es.Start()
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	<-sending
+	started := time.Now()
+	es.Shutdown()
+
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("in-flight send not cancelled by Shutdown")
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("send cancelled after %s, want the 50ms grace period first", elapsed)
+	}
+	if dead := es.GetDeadLetterJobs(); len(dead) != 1 {
+		t.Errorf("dead letters = %d, want the cancelled job", len(dead))
+	}
+}
+
+func TestRetriedJobExpires(t *testing.T) {
+	var attempts atomic.Int32
+	es := startTestService(t, failingSender(1000, &attempts), map[string]string{
+		"MAX_RETRIES":          "1000",
+		"RETRY_FIXED_DELAY":    "20ms",
+		"CLOCK_SKEW_TOLERANCE": "0s",
+	})
+
+	expiresAt := time.Now().Add(200 * time.Millisecond)
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Code", Body: "123456", ExpiresAt: &expiresAt}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "job dead-lettered", func() bool {
+		return len(es.GetDeadLetterJobs()) == 1
+	})
+
+	dead := es.GetDeadLetterJobs()[0]
+	if dead.Reason != models.ReasonExpired {
+		t.Errorf("reason = %q, want %q", dead.Reason, models.ReasonExpired)
+	}
+	if got := attempts.Load(); got < 2 {
+		t.Errorf("attempts = %d, want the job retried before it expired", got)
+	}
+	if got := metricValue(t, es, "email_jobs_expired_total"); got != 1 {
+		t.Errorf("email_jobs_expired_total = %v, want 1", got)
+	}
+	if got := metricValue(t, es, "email_jobs_processed_total"); got != 0 {
+		t.Errorf("email_jobs_processed_total = %v, want 0", got)
+	}
+}
+
+func TestRetryQueueSizeBoundsWaitingRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         string
+		wantRetained int
+	}{
+		{"tiny retry queue overflows", "1", 1},
+		{"larger retry queue keeps them", "10", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Not started, so nothing takes retries off the retry queue
+			es := newTestService(t, nil, map[string]string{"RETRY_QUEUE_SIZE": tt.size})
+			for _, id := range []string{"a", "b", "c", "d", "e"} {
+				es.scheduleRetry(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi"}, 0)
+			}
+			overflow := 5 - tt.wantRetained
+			waitFor(t, "due retries placed", func() bool {
+				return len(es.retryQueue)+len(es.GetDeadLetterJobs()) == 5
+			})
+
+			if got := len(es.retryQueue); got != tt.wantRetained {
+				t.Errorf("retries waiting = %d, want %d", got, tt.wantRetained)
+			}
+			dead := es.GetDeadLetterJobs()
+			if len(dead) != overflow {
+				t.Fatalf("dead letters = %d, want %d", len(dead), overflow)
+			}
+			for _, job := range dead {
+				if job.Reason != models.ReasonRetryQueueFull {
+					t.Errorf("job %s reason = %q, want %q", job.ID, job.Reason, models.ReasonRetryQueueFull)
+				}
+			}
+			if got := metricValue(t, es, "email_retry_queue_overflow_total"); got != float64(overflow) {
+				t.Errorf("email_retry_queue_overflow_total = %v, want %d", got, overflow)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Uploader uploads objects to an S3-compatible store using path-style
+// URLs and AWS Signature Version 4
+type S3Uploader struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// Upload implements Uploader with a single signed PUT
+func (u *S3Uploader) Upload(ctx context.Context, key string, body []byte) error {
+	objectURL, err := url.Parse(strings.TrimRight(u.Endpoint, "/"))
+	if err != nil {
+		return fmt.Errorf("parse endpoint: %w", err)
+	}
+	objectURL.Path = "/" + u.Bucket + "/" + u.Prefix + key
+	objectURL.RawPath = escapePath(objectURL.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	u.sign(req, body, time.Now().UTC())
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload %s: unexpected status %s: %s", key, resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// sign adds SigV4 authentication headers to req
+func (u *S3Uploader) sign(req *http.Request, body []byte, now time.Time) {
+	creds := awsCredentials{Region: u.Region, AccessKey: u.AccessKey, SecretKey: u.SecretKey}
+	creds.signV4(req, "s3", body, now)
+}
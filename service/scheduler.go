@@ -0,0 +1,200 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrScheduleFull is returned when too many emails are already scheduled
+var ErrScheduleFull = errors.New("too many scheduled emails")
+
+// Clock tells the time for scheduled delivery. Tests can replace it to
+// control when scheduled jobs become due.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// jobHeap is a min-heap of jobs ordered by SendAt
+type jobHeap []models.EmailJob
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].SendAt.Before(h[j].SendAt) }
+func (h jobHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x any)        { *h = append(*h, x.(models.EmailJob)) }
+func (h *jobHeap) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = models.EmailJob{}
+	*h = old[:len(old)-1]
+	return job
+}
+
+// jobSchedule holds jobs until their SendAt. A single scheduler goroutine
+// waits on the earliest job instead of one timer per job.
+type jobSchedule struct {
+	mu       sync.Mutex
+	jobs     jobHeap
+	capacity int
+	changed  chan struct{}    // signalled when a new earliest job arrives
+	size     prometheus.Gauge // the number of scheduled jobs
+}
+
+// newJobSchedule creates a schedule holding at most capacity jobs, keeping
+// size set to how many it holds
+func newJobSchedule(capacity int, size prometheus.Gauge) *jobSchedule {
+	return &jobSchedule{
+		capacity: capacity,
+		changed:  make(chan struct{}, 1),
+		size:     size,
+	}
+}
+
+// add holds a job until its SendAt
+func (s *jobSchedule) add(job models.EmailJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.jobs) >= s.capacity {
+		return ErrScheduleFull
+	}
+	earliest := len(s.jobs) == 0 || job.SendAt.Before(s.jobs[0].SendAt)
+	heap.Push(&s.jobs, job)
+	s.size.Set(float64(len(s.jobs)))
+
+	// Wake the scheduler if this job is now the first one due
+	if earliest {
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// next returns when the earliest scheduled job is due
+func (s *jobSchedule) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.jobs) == 0 {
+		return time.Time{}, false
+	}
+	return s.jobs[0].SendAt, true
+}
+
+// popDue removes and returns every job due at or before now, earliest first
+func (s *jobSchedule) popDue(now time.Time) []models.EmailJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []models.EmailJob
+	for len(s.jobs) > 0 && !s.jobs[0].SendAt.After(now) {
+		due = append(due, heap.Pop(&s.jobs).(models.EmailJob))
+	}
+	s.size.Set(float64(len(s.jobs)))
+	return due
+}
+
+// drain removes and returns every scheduled job
+func (s *jobSchedule) drain() []models.EmailJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := s.jobs
+	s.jobs = nil
+	s.size.Set(0)
+	return jobs
+}
+
+// len returns the number of scheduled jobs
+func (s *jobSchedule) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+// SetClock replaces the clock used for scheduled delivery. Call it before Start.
+func (es *EmailService) SetClock(clock Clock) {
+	es.clock = clock
+}
+
+// scheduleLoop moves scheduled jobs into the queue as they become due
+func (es *EmailService) scheduleLoop() {
+	defer es.wg.Done()
+
+	for {
+		var due <-chan time.Time
+		if at, ok := es.schedule.next(); ok {
+			due = es.clock.After(at.Sub(es.clock.Now()))
+		}
+
+		select {
+		case <-due:
+			es.releaseDueJobs()
+		case <-es.schedule.changed:
+			// A job due sooner arrived; recompute the wait
+		case <-es.shutdown:
+			return
+		}
+	}
+}
+
+// scheduled reports whether job should be held for a later send time
+func (es *EmailService) scheduled(job models.EmailJob) bool {
+	return job.SendAt.After(es.clock.Now())
+}
+
+// scheduleJob holds a job until its send time
+func (es *EmailService) scheduleJob(job models.EmailJob) error {
+	if err := es.schedule.add(job); err != nil {
+		return err
+	}
+	es.setStatus(job, StatusScheduled)
+	slog.Info("Email scheduled", "event", "scheduled", "job_id", job.ID, "recipient", job.To, "send_at", job.SendAt.Format(time.RFC3339))
+	return nil
+}
+
+// releaseDueJobs queues every scheduled job whose time has come
+func (es *EmailService) releaseDueJobs() {
+	es.enqueueLock.RLock()
+	defer es.enqueueLock.RUnlock()
+
+	for _, job := range es.schedule.popDue(es.clock.Now()) {
+		if es.shuttingDown.Load() {
+			es.moveToDeadLetter(job)
+			continue
+		}
+
+		switch err := es.admit(context.Background(), job, 0); {
+		case errors.Is(err, ErrSubjectThrottled):
+			slog.Warn("Scheduled email dropped by subject throttle", "event", "throttle_dropped", "job_id", job.ID, "recipient", job.To)
+			es.statuses.forget(job.ID)
+		case err != nil:
+			slog.Error("Failed to enqueue scheduled email", "job_id", job.ID, "recipient", job.To, "error", err)
+			es.moveToDeadLetter(job)
+		}
+	}
+}
+
+// drainSchedule dead-letters jobs still waiting for their send time
+func (es *EmailService) drainSchedule() {
+	for _, job := range es.schedule.drain() {
+		slog.Warn("Shutting down with scheduled email pending, moving to dead letter queue", "job_id", job.ID, "recipient", job.To)
+		es.moveToDeadLetter(job)
+	}
+}
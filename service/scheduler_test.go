@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// fakeClock is a Clock that only moves when advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeTimer
+}
+
+// fakeTimer is a pending After call
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)}
+}
+
+// Now implements Clock
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements Clock
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the timers that come due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+func TestScheduledJobNotSentEarly(t *testing.T) {
+	sent := make(chan models.EmailJob, 1)
+	es := newTestService(t, senderFunc(func(_ context.Context, job models.EmailJob) error {
+		sent <- job
+		return nil
+	}), nil)
+	clock := newFakeClock()
+	es.SetClock(clock)
+	es.Start()
+	t.Cleanup(es.Shutdown)
+
+	job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi", SendAt: clock.Now().Add(2 * time.Second)}
+	if err := es.EnqueueJob(job); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "the job to be scheduled", func() bool {
+		record, ok := es.JobStatus("a")
+		return ok && record.Status == StatusScheduled
+	})
+
+	clock.Advance(2*time.Second - time.Millisecond)
+	select {
+	case <-sent:
+		t.Fatal("job sent before its send time")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case got := <-sent:
+		if got.ID != "a" {
+			t.Errorf("sent %s, want a", got.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job not sent once due")
+	}
+}
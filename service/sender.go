@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+)
+
+// EmailSender delivers a single email. Send should give up once ctx is done.
+type EmailSender interface {
+	Send(ctx context.Context, job models.EmailJob) error
+}
+
+// ProviderSender is an EmailSender that delivers through one of several
+// providers and reports which one accepted the email
+type ProviderSender interface {
+	EmailSender
+	SendVia(ctx context.Context, job models.EmailJob) (provider string, err error)
+}
+
+// Provider is a single way of delivering email, such as an SMTP relay or a
+// hosted email API
+type Provider interface {
+	Send(ctx context.Context, job models.EmailJob) error
+	Name() string
+}
+
+// PermanentError marks a send failure that retrying won't fix, such as a
+// rejected recipient
+type PermanentError struct {
+	Err error
+}
+
+// Error implements error
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err is a send failure that retrying won't fix
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// recipientTracker is a provider that sends to a job's pending recipients
+// only, leaving out those an earlier attempt already delivered to
+type recipientTracker interface {
+	sendsToPendingOnly()
+}
+
+// CompositeSender tries its providers in order until one accepts the email
+type CompositeSender struct {
+	Providers []Provider
+}
+
+// Send implements EmailSender
+func (s *CompositeSender) Send(ctx context.Context, job models.EmailJob) error {
+	_, err := s.SendVia(ctx, job)
+	return err
+}
+
+// SendVia implements ProviderSender. Any failure moves on to the next
+// provider. The combined error is permanent only if every provider failed
+// permanently, since one provider's outage says nothing about the others. If
+// any provider greylisted the email, it is a GreylistError, since the retry
+// goes to that provider first again. Once ctx is done the remaining providers
+// are skipped. A provider that delivered to some recipients ends the attempt,
+// since the next one would send to them again, and a job that was partly
+// delivered before skips providers that can't leave those recipients out.
+func (s *CompositeSender) SendVia(ctx context.Context, job models.EmailJob) (string, error) {
+	var failures []string
+	var errs []error
+	permanent := true
+	skipped := false
+
+	for _, provider := range s.Providers {
+		if ctx.Err() != nil {
+			failures = append(failures, ctx.Err().Error())
+			permanent = false
+			break
+		}
+
+		if _, ok := provider.(recipientTracker); !ok && job.Deliveries != nil {
+			skipped = true
+			continue
+		}
+
+		err := provider.Send(ctx, job)
+		if err == nil {
+			return provider.Name(), nil
+		}
+		if errors.Is(err, ErrPartialDelivery) {
+			return "", err
+		}
+
+		slog.Warn("Provider failed to send email", "provider", provider.Name(), "job_id", job.ID, "recipient", job.To, "error", err)
+		failures = append(failures, fmt.Sprintf("%s: %v", provider.Name(), err))
+		errs = append(errs, err)
+		permanent = permanent && IsPermanent(err)
+	}
+
+	if len(failures) == 0 && skipped {
+		return "", Permanent(errors.New("no email provider can leave out the recipients already delivered to"))
+	}
+	if len(failures) == 0 {
+		return "", errors.New("no email providers configured")
+	}
+
+	err := errors.New(strings.Join(failures, "; "))
+	if permanent {
+		return "", Permanent(err)
+	}
+	if delay, ok := greylistDelay(errs); ok {
+		return "", &GreylistError{Delay: delay, Err: err}
+	}
+	return "", err
+}
+
+// NewSender builds a CompositeSender for the providers listed in cfg.Providers
+func NewSender(cfg *config.Config) (*CompositeSender, error) {
+	names := strings.Split(cfg.Providers, ",")
+	if strings.TrimSpace(cfg.Providers) == "" {
+		names = []string{"simulated"}
+		if cfg.SMTPHost != "" {
+			names = []string{"smtp"}
+		}
+	}
+
+	sender := &CompositeSender{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			return nil, fmt.Errorf("provider %q is listed more than once", name)
+		}
+		seen[name] = true
+
+		provider, err := newProvider(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		sender.Providers = append(sender.Providers, provider)
+	}
+	return sender, nil
+}
+
+// newProvider creates the named provider, checking that it is configured
+func newProvider(name string, cfg *config.Config) (Provider, error) {
+	switch name {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("provider smtp requires SMTP_HOST")
+		}
+		pools, err := newSMTPPools(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &SMTPSender{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.DefaultFrom,
+
+			GreylistDelay:   cfg.SMTPGreylistDelay,
+			PartialDelivery: cfg.SMTPPartialDelivery,
+			pools:           pools,
+		}, nil
+	case "sendgrid":
+		if cfg.SendGridAPIKey == "" {
+			return nil, errors.New("provider sendgrid requires SENDGRID_API_KEY")
+		}
+		return &SendGridProvider{APIKey: cfg.SendGridAPIKey, From: cfg.DefaultFrom}, nil
+	case "mailgun":
+		if cfg.MailgunDomain == "" || cfg.MailgunAPIKey == "" {
+			return nil, errors.New("provider mailgun requires MAILGUN_DOMAIN and MAILGUN_API_KEY")
+		}
+		return &MailgunProvider{
+			Domain:  cfg.MailgunDomain,
+			APIKey:  cfg.MailgunAPIKey,
+			From:    cfg.DefaultFrom,
+			BaseURL: cfg.MailgunBaseURL,
+		}, nil
+	case "ses":
+		if cfg.SESRegion == "" || cfg.SESAccessKey == "" || cfg.SESSecretKey == "" {
+			return nil, errors.New("provider ses requires SES_REGION, SES_ACCESS_KEY and SES_SECRET_KEY")
+		}
+		return &SESProvider{
+			Region:    cfg.SESRegion,
+			AccessKey: cfg.SESAccessKey,
+			SecretKey: cfg.SESSecretKey,
+			From:      cfg.DefaultFrom,
+		}, nil
+	case "simulated":
+		return SimulatedSender{Latency: time.Second}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q (expected smtp, sendgrid, mailgun, ses or simulated)", name)
+	}
+}
+
+// SimulatedSender pretends to deliver emails; it is used when no provider is
+// configured. Subjects longer than 10 characters ending in '!' fail on the
+// first attempt so retries can be demonstrated.
+type SimulatedSender struct {
+	Latency time.Duration
+}
+
+// Send implements Provider
+func (s SimulatedSender) Send(ctx context.Context, job models.EmailJob) error {
+	timer := time.NewTimer(s.Latency)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if job.Retries == 0 && len(job.Subject) > 10 && strings.HasSuffix(job.Subject, "!") {
+		return fmt.Errorf("simulated delivery failure")
+	}
+	return nil
+}
+
+// Name implements Provider
+func (s SimulatedSender) Name() string {
+	return "simulated"
+}
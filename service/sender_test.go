@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+// mockProvider is a Provider that returns err and counts its sends
+type mockProvider struct {
+	name  string
+	err   error
+	sends int
+}
+
+// Send implements Provider
+func (p *mockProvider) Send(context.Context, models.EmailJob) error {
+	p.sends++
+	return p.err
+}
+
+// Name implements Provider
+func (p *mockProvider) Name() string {
+	return p.name
+}
+
+func TestCompositeSenderFailsOver(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second error
+		wantProvider  string
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{"first succeeds", nil, nil, "primary", false, false},
+		{"second succeeds", errors.New("connection refused"), nil, "backup", false, false},
+		{"both fail", errors.New("connection refused"), Permanent(errors.New("550 rejected")), "", true, false},
+		{"both fail permanently", Permanent(errors.New("550 rejected")), Permanent(errors.New("550 rejected")), "", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &mockProvider{name: "primary", err: tt.first}
+			backup := &mockProvider{name: "backup", err: tt.second}
+			sender := &CompositeSender{Providers: []Provider{primary, backup}}
+
+			provider, err := sender.SendVia(context.Background(), models.EmailJob{ID: "a", To: "user@example.com"})
+			if provider != tt.wantProvider || (err != nil) != tt.wantErr || IsPermanent(err) != tt.wantPermanent {
+				t.Errorf("SendVia = %q, %v (permanent %v), want %q, error %v (permanent %v)",
+					provider, err, IsPermanent(err), tt.wantProvider, tt.wantErr, tt.wantPermanent)
+			}
+
+			wantBackupSends := 1
+			if tt.first == nil {
+				wantBackupSends = 0
+			}
+			if primary.sends != 1 || backup.sends != wantBackupSends {
+				t.Errorf("sends = %d and %d, want 1 and %d", primary.sends, backup.sends, wantBackupSends)
+			}
+		})
+	}
+}
+
+func TestJobStatusRecordsSendingProvider(t *testing.T) {
+	es := startTestService(t, &CompositeSender{Providers: []Provider{
+		&mockProvider{name: "primary", err: errors.New("connection refused")},
+		&mockProvider{name: "backup"},
+	}}, nil)
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	waitFor(t, "job sent", func() bool {
+		record, ok := es.JobStatus("a")
+		return ok && record.Status == StatusSent
+	})
+	if record, _ := es.JobStatus("a"); record.Provider != "backup" {
+		t.Errorf("provider = %q, want backup", record.Provider)
+	}
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// smtpIdleTimeout is how long a pooled connection may sit unused before it
+// is closed rather than reused, since relays drop idle connections
+const smtpIdleTimeout = 30 * time.Second
+
+// smtpConn is an open connection to the relay, past STARTTLS and AUTH
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+	lastSend time.Time // when the last email on it started; zero before the first
+}
+
+// usable reports whether a connection taken from the pool still answers,
+// resetting it for the next email
+func (c *smtpConn) usable(ctx context.Context) bool {
+	if time.Since(c.lastUsed) >= smtpIdleTimeout {
+		return false
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	err := c.client.Reset()
+	return stop() && err == nil
+}
+
+// smtpPool keeps up to a fixed number of relay connections open between
+// sends. A send waits for a free connection once all of them are in use, and
+// for minInterval to pass since the connection's last send.
+type smtpPool struct {
+	name        string
+	slots       chan struct{} // one token per connection in use
+	inUse       atomic.Int32
+	minInterval time.Duration // no pacing when zero
+	paced       atomic.Int64  // nanoseconds spent waiting for minInterval
+
+	mu     sync.Mutex
+	idle   []*smtpConn
+	closed bool
+}
+
+// newSMTPPool creates a pool of size connections, opened as they are needed,
+// each sending at most one email per minInterval
+func newSMTPPool(name string, size int, minInterval time.Duration) *smtpPool {
+	return &smtpPool{name: name, slots: make(chan struct{}, size), minInterval: minInterval}
+}
+
+// get waits for a free connection, reusing an idle one if it still works and
+// dialing otherwise. The connection must be handed back with put.
+func (p *smtpPool) get(ctx context.Context, dial func(context.Context) (*smtpConn, error)) (*smtpConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	p.inUse.Add(1)
+
+	for {
+		c := p.takeIdle()
+		if c == nil {
+			break
+		}
+		if c.usable(ctx) {
+			return c, nil
+		}
+		c.client.Close()
+	}
+
+	c, err := dial(ctx)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// pace waits until c may send again, so the relay sees no more than one
+// email per minInterval on it, and marks the send as started
+func (p *smtpPool) pace(ctx context.Context, c *smtpConn) error {
+	if wait := time.Until(c.lastSend.Add(p.minInterval)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		start := time.Now()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			p.paced.Add(int64(time.Since(start)))
+			return ctx.Err()
+		}
+		p.paced.Add(int64(time.Since(start)))
+	}
+	c.lastSend = time.Now()
+	return nil
+}
+
+// takeIdle removes the most recently used idle connection from the pool
+func (p *smtpPool) takeIdle() *smtpConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) == 0 {
+		return nil
+	}
+	c := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return c
+}
+
+// put hands a connection back, keeping it for the next send unless it broke
+// or the pool was closed
+func (p *smtpPool) put(c *smtpConn, healthy bool) {
+	defer p.release()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !healthy || p.closed {
+		c.client.Close()
+		return
+	}
+	c.lastUsed = time.Now()
+	p.idle = append(p.idle, c)
+}
+
+// release frees the slot taken by get
+func (p *smtpPool) release() {
+	p.inUse.Add(-1)
+	<-p.slots
+}
+
+// close says goodbye on the idle connections. Connections still in use are
+// closed as they are handed back.
+func (p *smtpPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.client.Quit()
+		c.client.Close()
+	}
+}
+
+// smtpPools are an SMTP sender's connection pools: a shared one, and one
+// reserved for critical mail so it isn't stuck behind bulk traffic
+type smtpPools struct {
+	shared             *smtpPool // nil when other jobs dial per send
+	reserved           *smtpPool // nil without a reserved pool
+	reservedPriorities map[string]bool
+	reservedTenants    map[string]bool
+}
+
+// newSMTPPools creates the pools configured in cfg, or returns nil when
+// connections aren't pooled
+func newSMTPPools(cfg *config.Config) (*smtpPools, error) {
+	if cfg.SMTPPoolSize == 0 && cfg.SMTPReservedPoolSize == 0 {
+		return nil, nil
+	}
+
+	pools := &smtpPools{
+		reservedPriorities: make(map[string]bool),
+		reservedTenants:    make(map[string]bool),
+	}
+	if cfg.SMTPPoolSize > 0 {
+		pools.shared = newSMTPPool("shared", cfg.SMTPPoolSize, cfg.SMTPMinSendInterval)
+	}
+	if cfg.SMTPReservedPoolSize > 0 {
+		pools.reserved = newSMTPPool("reserved", cfg.SMTPReservedPoolSize, cfg.SMTPMinSendInterval)
+	}
+	for _, priority := range cfg.SMTPReservedPriorities {
+		parsed, ok := models.ParsePriority(priority)
+		if !ok {
+			return nil, fmt.Errorf("SMTP_RESERVED_PRIORITIES: unknown priority %q (expected high, normal or low)", priority)
+		}
+		pools.reservedPriorities[parsed] = true
+	}
+	for _, tenant := range cfg.SMTPReservedTenants {
+		pools.reservedTenants[tenant] = true
+	}
+	return pools, nil
+}
+
+// forJob picks the pool job is sent through: the reserved pool for a
+// reserved priority or tenant, the shared pool otherwise. Nil means dialing
+// a connection for this send only.
+func (p *smtpPools) forJob(job models.EmailJob) *smtpPool {
+	if p == nil {
+		return nil
+	}
+	if p.reserved != nil {
+		priority, _ := models.ParsePriority(job.Priority)
+		if p.reservedPriorities[priority] || (job.Tenant != "" && p.reservedTenants[job.Tenant]) {
+			return p.reserved
+		}
+	}
+	return p.shared
+}
+
+// all returns the pools that exist
+func (p *smtpPools) all() []*smtpPool {
+	if p == nil {
+		return nil
+	}
+	var pools []*smtpPool
+	for _, pool := range []*smtpPool{p.shared, p.reserved} {
+		if pool != nil {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// pooledSender is a sender or provider holding open SMTP connections
+type pooledSender interface {
+	connectionPools() []*smtpPool
+}
+
+// connectionPools implements pooledSender
+func (s *CompositeSender) connectionPools() []*smtpPool {
+	var pools []*smtpPool
+	for _, provider := range s.Providers {
+		if pooled, ok := provider.(pooledSender); ok {
+			pools = append(pools, pooled.connectionPools()...)
+		}
+	}
+	return pools
+}
+
+// smtpPoolCollectors returns gauges of how many connections of each of
+// sender's pools are in use, out of how many
+func smtpPoolCollectors(sender EmailSender) []prometheus.Collector {
+	pooled, ok := sender.(pooledSender)
+	if !ok {
+		return nil
+	}
+
+	var collectors []prometheus.Collector
+	for _, pool := range pooled.connectionPools() {
+		labels := prometheus.Labels{"pool": pool.name}
+		collectors = append(collectors,
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "email_smtp_pool_connections_in_use",
+				Help:        "Number of connections of the SMTP connection pool currently sending",
+				ConstLabels: labels,
+			}, func() float64 { return float64(pool.inUse.Load()) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "email_smtp_pool_capacity",
+				Help:        "Number of connections the SMTP connection pool may open",
+				ConstLabels: labels,
+			}, func() float64 { return float64(cap(pool.slots)) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "email_smtp_pacing_seconds_total",
+				Help:        "Total time sends waited for SMTP_MIN_SEND_INTERVAL on a connection of the SMTP connection pool",
+				ConstLabels: labels,
+			}, func() float64 { return time.Duration(pool.paced.Load()).Seconds() }),
+		)
+	}
+	return collectors
+}
+
+// closeConnectionPools closes the idle SMTP connections held by the sender
+func (es *EmailService) closeConnectionPools() {
+	pooled, ok := es.sender.(pooledSender)
+	if !ok {
+		return
+	}
+	for _, pool := range pooled.connectionPools() {
+		pool.close()
+	}
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+)
+
+// pooledSMTPSender returns a sender for server using the pools in cfg
+func pooledSMTPSender(t *testing.T, server *fakeSMTPServer, cfg *config.Config) *SMTPSender {
+	t.Helper()
+
+	pools, err := newSMTPPools(cfg)
+	if err != nil {
+		t.Fatalf("newSMTPPools: %v", err)
+	}
+	sender := server.sender()
+	sender.pools = pools
+	t.Cleanup(func() {
+		for _, pool := range pools.all() {
+			pool.close()
+		}
+	})
+	return sender
+}
+
+// holdConnection takes a connection from pool until the test ends
+func holdConnection(t *testing.T, sender *SMTPSender, pool *smtpPool) {
+	t.Helper()
+
+	addr := net.JoinHostPort(sender.Host, strconv.Itoa(sender.Port))
+	c, err := pool.get(context.Background(), func(ctx context.Context) (*smtpConn, error) {
+		return sender.dial(ctx, addr, nil)
+	})
+	if err != nil {
+		t.Fatalf("take a %s connection: %v", pool.name, err)
+	}
+	t.Cleanup(func() { pool.put(c, true) })
+}
+
+func TestPooledSMTPSenderReusesConnections(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	sender := pooledSMTPSender(t, server, &config.Config{SMTPPoolSize: 2})
+
+	for _, id := range []string{"a", "b", "c"} {
+		job := models.EmailJob{ID: id, To: "user@example.com", Subject: "Hello", Body: "Hi"}
+		if err := sender.Send(context.Background(), job); err != nil {
+			t.Fatalf("Send %s: %v", id, err)
+		}
+	}
+	if got := server.received(); got != 3 {
+		t.Errorf("relay received %d emails, want 3", got)
+	}
+	if got := server.conns.Load(); got != 1 {
+		t.Errorf("relay saw %d connections, want 1 reused for every send", got)
+	}
+	if got := sender.pools.shared.inUse.Load(); got != 0 {
+		t.Errorf("%d connections still in use after the sends", got)
+	}
+}
+
+func TestPooledSMTPSenderReplacesIdleConnection(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	sender := pooledSMTPSender(t, server, &config.Config{SMTPPoolSize: 1})
+	job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hello", Body: "Hi"}
+
+	if err := sender.Send(context.Background(), job); err != nil {
+		t.Fatalf("first Send: %v", err)
+	}
+	sender.pools.shared.idle[0].lastUsed = time.Now().Add(-smtpIdleTimeout)
+	if err := sender.Send(context.Background(), job); err != nil {
+		t.Fatalf("second Send: %v", err)
+	}
+	if got := server.conns.Load(); got != 2 {
+		t.Errorf("relay saw %d connections, want a new one after the idle timeout", got)
+	}
+}
+
+func TestReservedPoolIsNotBlockedBySharedTraffic(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	sender := pooledSMTPSender(t, server, &config.Config{
+		SMTPPoolSize:           1,
+		SMTPReservedPoolSize:   1,
+		SMTPReservedPriorities: []string{"high"},
+		SMTPReservedTenants:    []string{"acme"},
+	})
+	holdConnection(t, sender, sender.pools.shared)
+
+	tests := []struct {
+		name    string
+		job     models.EmailJob
+		blocked bool
+	}{
+		{"high priority", models.EmailJob{ID: "a", Priority: models.PriorityHigh}, false},
+		{"reserved tenant", models.EmailJob{ID: "b", Tenant: "acme"}, false},
+		{"normal priority", models.EmailJob{ID: "c", Priority: models.PriorityNormal}, true},
+		{"other tenant", models.EmailJob{ID: "d", Tenant: "globex"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			tt.job.To, tt.job.Subject, tt.job.Body = "user@example.com", "Hello", "Hi"
+			err := sender.Send(ctx, tt.job)
+			if tt.blocked && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Send error = %v, want to wait for the busy shared pool", err)
+			}
+			if !tt.blocked && err != nil {
+				t.Errorf("Send: %v, want the reserved pool", err)
+			}
+		})
+	}
+}
+
+func TestPooledConnectionPacesSends(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	// Allows for the time between pacing and MAIL differing between sends
+	const slack = 10 * time.Millisecond
+
+	server := startFakeSMTPServer(t, "")
+	sender := pooledSMTPSender(t, server, &config.Config{SMTPPoolSize: 1, SMTPMinSendInterval: interval})
+	es := newTestService(t, &CompositeSender{Providers: []Provider{sender}}, nil)
+
+	for _, id := range []string{"a", "b", "c"} {
+		job := models.EmailJob{ID: id, To: "user@example.com", Subject: "Hello", Body: "Hi"}
+		if err := sender.Send(context.Background(), job); err != nil {
+			t.Fatalf("Send %s: %v", id, err)
+		}
+	}
+
+	if got := server.conns.Load(); got != 1 {
+		t.Fatalf("relay saw %d connections, want every send on one", got)
+	}
+	times := server.mailTimes()
+	if len(times) != 3 {
+		t.Fatalf("relay saw %d emails, want 3", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < interval-slack {
+			t.Errorf("send %d came %s after the one before, want at least %s", i+1, gap, interval)
+		}
+	}
+	if got := metricValue(t, es, "email_smtp_pacing_seconds_total"); got < (interval - slack).Seconds() {
+		t.Errorf("email_smtp_pacing_seconds_total = %v, want the time spent waiting", got)
+	}
+}
+
+func TestSMTPPoolMetrics(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	sender := pooledSMTPSender(t, server, &config.Config{SMTPPoolSize: 3})
+	es := newTestService(t, &CompositeSender{Providers: []Provider{sender}}, nil)
+
+	holdConnection(t, sender, sender.pools.shared)
+	if got := metricValue(t, es, "email_smtp_pool_connections_in_use"); got != 1 {
+		t.Errorf("email_smtp_pool_connections_in_use = %v, want 1", got)
+	}
+	if got := metricValue(t, es, "email_smtp_pool_capacity"); got != 3 {
+		t.Errorf("email_smtp_pool_capacity = %v, want 3", got)
+	}
+}
+
+func TestNewSMTPPools(t *testing.T) {
+	if pools, err := newSMTPPools(&config.Config{}); pools != nil || err != nil {
+		t.Errorf("newSMTPPools without pool sizes = %v, %v; want nil", pools, err)
+	}
+
+	pools, err := newSMTPPools(&config.Config{SMTPPoolSize: 2, SMTPReservedPoolSize: 1, SMTPReservedPriorities: []string{"HIGH"}})
+	if err != nil {
+		t.Fatalf("newSMTPPools: %v", err)
+	}
+	if got := pools.forJob(models.EmailJob{Priority: models.PriorityHigh}); got != pools.reserved {
+		t.Error("high priority job not given the reserved pool")
+	}
+	if got := pools.forJob(models.EmailJob{}); got != pools.shared {
+		t.Error("job without a priority not given the shared pool")
+	}
+
+	if _, err := newSMTPPools(&config.Config{SMTPReservedPoolSize: 1, SMTPReservedPriorities: []string{"urgent"}}); err == nil {
+		t.Error("newSMTPPools accepted an unknown priority")
+	}
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// SMTPSender delivers emails through an SMTP relay using net/smtp
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// GreylistDelay is the least wait before retrying an email the relay
+	// greylisted
+	GreylistDelay time.Duration
+	// PartialDelivery sends an email to the recipients the relay accepts when
+	// it rejects others; otherwise the first rejection fails the whole email
+	PartialDelivery bool
+
+	pools *smtpPools // nil when every send dials its own connection
+}
+
+// Send implements Provider. A 5xx reply from the relay is a permanent failure,
+// and a greylisting 4xx one a GreylistError. Only the job's pending recipients
+// are sent to. With PartialDelivery, if the relay rejects some of them but not
+// all, the email goes to the others and Send returns a PartialDeliveryError,
+// permanent if every rejection was.
+func (s *SMTPSender) Send(ctx context.Context, job models.EmailJob) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	msg := buildMessage(s.From, job, time.Now())
+	to := job.PendingRecipients()
+	var rejected map[string]error
+	var err error
+	if pool := s.pools.forJob(job); pool != nil {
+		rejected, err = s.sendPooled(ctx, pool, addr, auth, to, msg)
+	} else {
+		rejected, err = s.sendMail(ctx, addr, auth, to, msg)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// The relay didn't fail; the connection was closed under it
+			return fmt.Errorf("smtp send to %s: %w", job.To, ctx.Err())
+		}
+		err = fmt.Errorf("smtp send to %s: %w", job.To, err)
+
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return Permanent(err)
+		}
+		if reply != nil && isGreylisting(reply) {
+			return &GreylistError{Delay: s.GreylistDelay, Err: err}
+		}
+		return err
+	}
+	if len(rejected) > 0 {
+		return s.partialDelivery(job, to, rejected)
+	}
+	return nil
+}
+
+// partialDelivery is the error for an email the relay took for to but the
+// recipients in rejected
+func (s *SMTPSender) partialDelivery(job models.EmailJob, to []string, rejected map[string]error) error {
+	partial := &PartialDeliveryError{Rejected: rejected}
+	for _, recipient := range to {
+		if rejected[recipient] == nil {
+			partial.Delivered = append(partial.Delivered, recipient)
+		}
+	}
+
+	err := fmt.Errorf("smtp send to %s: %w", job.To, partial)
+	switch {
+	case partial.permanent():
+		return Permanent(err)
+	case partial.greylisted():
+		return &GreylistError{Delay: s.GreylistDelay, Err: err}
+	default:
+		return err
+	}
+}
+
+// sendsToPendingOnly implements recipientTracker
+func (s *SMTPSender) sendsToPendingOnly() {}
+
+// Verify implements verifier: it connects to the relay, reads its greeting
+// and says EHLO, without sending anything
+func (s *SMTPSender) Verify(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	// Quit says EHLO first, so the relay has to answer both
+	return client.Quit()
+}
+
+// sendMail works like smtp.SendMail but stops when ctx is done: the
+// connection is closed, which aborts whatever command is in progress. It
+// returns the recipients the relay rejected, as deliver does.
+func (s *SMTPSender) sendMail(ctx context.Context, addr string, auth smtp.Auth, to []string, msg []byte) (map[string]error, error) {
+	c, err := s.dial(ctx, addr, auth)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	defer stop()
+	defer c.client.Close()
+
+	rejected, err := deliver(c.client, s.From, to, msg, s.PartialDelivery)
+	if err != nil {
+		return nil, err
+	}
+	return rejected, c.client.Quit()
+}
+
+// sendPooled is sendMail over a connection from pool, which is kept open for
+// later sends unless ctx closed it or the relay rejected something. It waits
+// out the pool's minimum interval between sends on the connection first.
+func (s *SMTPSender) sendPooled(ctx context.Context, pool *smtpPool, addr string, auth smtp.Auth, to []string, msg []byte) (map[string]error, error) {
+	c, err := pool.get(ctx, func(ctx context.Context) (*smtpConn, error) {
+		return s.dial(ctx, addr, auth)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.pace(ctx, c); err != nil {
+		pool.put(c, true)
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	rejected, err := deliver(c.client, s.From, to, msg, s.PartialDelivery)
+	pool.put(c, stop() && err == nil)
+	return rejected, err
+}
+
+// dial connects to the relay, upgrading to TLS when it offers STARTTLS and
+// authenticating with auth, if set. It gives up once ctx is done.
+func (s *SMTPSender) dial(ctx context.Context, addr string, auth smtp.Auth) (*smtpConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if ctx.Err() != nil {
+		client.Close()
+		return nil, ctx.Err()
+	}
+	return &smtpConn{conn: conn, client: client}, nil
+}
+
+// deliver sends one email over an open connection. Bounces go to from, the
+// configured sender, even when the job sets its own From. With partial set,
+// the email still goes out when the relay rejects some recipients, which are
+// returned with its reply to each; when it rejects all of them, or any
+// without partial, the first reply is the error.
+func deliver(client *smtp.Client, from string, to []string, msg []byte, partial bool) (map[string]error, error) {
+	if err := client.Mail(from); err != nil {
+		return nil, err
+	}
+	var rejected map[string]error
+	var firstRejection error
+	for _, rcpt := range to {
+		err := client.Rcpt(rcpt)
+		var reply *textproto.Error
+		if errors.As(err, &reply) && partial {
+			if rejected == nil {
+				rejected, firstRejection = make(map[string]error), err
+			}
+			rejected[rcpt] = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(rejected) == len(to) {
+		return nil, firstRejection
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return nil, err
+	}
+	return rejected, w.Close()
+}
+
+// connectionPools implements pooledSender
+func (s *SMTPSender) connectionPools() []*smtpPool {
+	return s.pools.all()
+}
+
+// Name implements Provider
+func (s *SMTPSender) Name() string {
+	return "smtp"
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// fakeSMTPServer is a minimal SMTP relay that accepts every email
+type fakeSMTPServer struct {
+	listener net.Listener
+	conns    atomic.Int32 // connections accepted
+	greylist atomic.Int32 // recipients still to greylist
+
+	mu       sync.Mutex
+	messages []string          // DATA of each email received
+	mailAt   []time.Time       // when each MAIL command arrived
+	accepted []string          // every RCPT address accepted
+	rejects  map[string]string // replies to reject an RCPT address with, each used once
+}
+
+// startFakeSMTPServer serves SMTP on addr, or a free port when addr is empty,
+// until the test ends
+func startFakeSMTPServer(t *testing.T, addr string) *fakeSMTPServer {
+	t.Helper()
+
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeSMTPServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// serve speaks just enough SMTP to net/smtp
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case strings.HasPrefix(command, "MAIL"):
+			s.mu.Lock()
+			s.mailAt = append(s.mailAt, time.Now())
+			s.mu.Unlock()
+			reply("250 ok")
+		case strings.HasPrefix(command, "RCPT") && s.greylist.Add(-1) >= 0:
+			reply("450 4.2.0 Recipient greylisted, please try again later")
+		case strings.HasPrefix(command, "RCPT"):
+			reply(s.rcpt(line))
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// rcpt answers an RCPT command, rejecting the address if asked to
+func (s *fakeSMTPServer) rcpt(line string) string {
+	addr := strings.TrimSpace(line)
+	if start, end := strings.Index(addr, "<"), strings.LastIndex(addr, ">"); start >= 0 && end > start {
+		addr = addr[start+1 : end]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reply, ok := s.rejects[addr]; ok {
+		delete(s.rejects, addr)
+		return reply
+	}
+	s.accepted = append(s.accepted, addr)
+	return "250 ok"
+}
+
+// rejectOnce makes the server answer the next RCPT for addr with reply
+func (s *fakeSMTPServer) rejectOnce(addr, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rejects == nil {
+		s.rejects = make(map[string]string)
+	}
+	s.rejects[addr] = reply
+}
+
+// acceptedRecipients returns every RCPT address the server accepted
+func (s *fakeSMTPServer) acceptedRecipients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.accepted...)
+}
+
+// mailTimes returns when each email's MAIL command arrived
+func (s *fakeSMTPServer) mailTimes() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.mailAt...)
+}
+
+// received returns the number of emails the server accepted
+func (s *fakeSMTPServer) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+// sender returns an SMTPSender pointed at the server
+func (s *fakeSMTPServer) sender() *SMTPSender {
+	return smtpSenderFor(s.listener.Addr().String())
+}
+
+// smtpSenderFor returns an SMTPSender pointed at addr
+func smtpSenderFor(addr string) *SMTPSender {
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	return &SMTPSender{Host: host, Port: portNumber, From: "noreply@example.com", PartialDelivery: true}
+}
+
+// freeAddr returns a local address nothing is listening on yet
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// fastVerify retries quickly so tests don't wait on the real backoff
+func fastVerify(attempts int) startupVerify {
+	return startupVerify{attempts: attempts, timeout: time.Second, backoff: FixedBackoff{Delay: 20 * time.Millisecond}}
+}
+
+func TestSMTPSenderSendsThroughRelay(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+
+	job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hello", Body: "Hi there"}
+	if err := server.sender().Send(context.Background(), job); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := server.received(); got != 1 {
+		t.Fatalf("relay received %d emails, want 1", got)
+	}
+	if msg := server.messages[0]; !strings.Contains(msg, "Subject: Hello") || !strings.Contains(msg, "Hi there") {
+		t.Errorf("message = %q, want the subject and body", msg)
+	}
+}
+
+func TestSMTPSenderFromDefaultAndOverride(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	sender := server.sender()
+
+	tests := []struct {
+		name        string
+		from        string
+		replyTo     string
+		wantFrom    string
+		wantReplyTo string
+	}{
+		{"default", "", "", "noreply@example.com", ""},
+		{"override", "billing@example.com", "support@example.com", "billing@example.com", "support@example.com"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := models.EmailJob{ID: "a", To: "user@example.com", From: tt.from, ReplyTo: tt.replyTo, Subject: "Hello", Body: "Hi"}
+			if err := sender.Send(context.Background(), job); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			msg := server.messages[i]
+			if !strings.Contains(msg, "From: "+tt.wantFrom+"\r\n") {
+				t.Errorf("message doesn't come from %s:\n%s", tt.wantFrom, msg)
+			}
+			if gotReplyTo := strings.Contains(msg, "Reply-To:"); gotReplyTo != (tt.wantReplyTo != "") {
+				t.Errorf("Reply-To present = %v, want %q:\n%s", gotReplyTo, tt.wantReplyTo, msg)
+			} else if tt.wantReplyTo != "" && !strings.Contains(msg, "Reply-To: "+tt.wantReplyTo+"\r\n") {
+				t.Errorf("message doesn't reply to %s:\n%s", tt.wantReplyTo, msg)
+			}
+		})
+	}
+}
+
+func TestSMTPSenderVerify(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	if err := server.sender().Verify(context.Background()); err != nil {
+		t.Errorf("Verify against a running relay: %v", err)
+	}
+	if got := server.received(); got != 0 {
+		t.Errorf("Verify sent %d emails, want none", got)
+	}
+
+	if err := smtpSenderFor(freeAddr(t)).Verify(context.Background()); err == nil {
+		t.Error("Verify succeeded with nothing listening")
+	}
+}
+
+func TestVerifyWithRetryWaitsForLateServer(t *testing.T) {
+	addr := freeAddr(t)
+	result := make(chan error, 1)
+	go func() {
+		result <- verifyWithRetry(context.Background(), smtpSenderFor(addr), fastVerify(50))
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	server := startFakeSMTPServer(t, addr)
+
+	if err := <-result; err != nil {
+		t.Fatalf("verifyWithRetry: %v", err)
+	}
+	if got := server.conns.Load(); got != 1 {
+		t.Errorf("relay saw %d connections after coming up, want 1", got)
+	}
+}
+
+func TestVerifyWithRetryGivesUp(t *testing.T) {
+	attempts := 0
+	v := verifierFunc(func(context.Context) error {
+		attempts++
+		return net.ErrClosed
+	})
+
+	if err := verifyWithRetry(context.Background(), v, fastVerify(3)); err == nil {
+		t.Fatal("verifyWithRetry succeeded against a failing server")
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestVerifyWithRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	v := verifierFunc(func(context.Context) error {
+		cancel()
+		return net.ErrClosed
+	})
+
+	if err := verifyWithRetry(ctx, v, fastVerify(100)); err != context.Canceled {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+}
+
+func TestReadinessWaitsForLateSMTPServer(t *testing.T) {
+	addr := freeAddr(t)
+	es := newTestService(t, smtpSenderFor(addr), map[string]string{"SMTP_VERIFY_ATTEMPTS": "100"})
+	es.verify = fastVerify(100)
+	es.Start()
+	t.Cleanup(es.Shutdown)
+
+	readiness := es.Readiness()
+	if readiness.Ready {
+		t.Fatal("ready before the relay was reachable")
+	}
+	if !containsReason(readiness.Reasons, "email provider connection not verified yet") {
+		t.Errorf("reasons = %v, want the provider check", readiness.Reasons)
+	}
+
+	startFakeSMTPServer(t, addr)
+	waitFor(t, "readiness", func() bool { return es.Readiness().Ready })
+}
+
+func TestReadinessDoesNotWaitWithoutVerification(t *testing.T) {
+	es := startTestService(t, smtpSenderFor(freeAddr(t)), nil)
+	waitFor(t, "readiness without SMTP_VERIFY_ATTEMPTS", func() bool { return es.Readiness().Ready })
+}
+
+func TestCompositeSenderVerify(t *testing.T) {
+	server := startFakeSMTPServer(t, "")
+	down := smtpSenderFor(freeAddr(t))
+
+	tests := []struct {
+		name      string
+		providers []Provider
+		wantErr   bool
+	}{
+		{"nothing to check", []Provider{SimulatedSender{}}, false},
+		{"one reachable", []Provider{down, server.sender()}, false},
+		{"none reachable", []Provider{down}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&CompositeSender{Providers: tt.providers}).Verify(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// verifierFunc adapts a function to verifier
+type verifierFunc func(ctx context.Context) error
+
+// Verify implements verifier
+func (f verifierFunc) Verify(ctx context.Context) error {
+	return f(ctx)
+}
+
+// containsReason reports whether reasons includes reason
+func containsReason(reasons []string, reason string) bool {
+	for _, r := range reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package service
+
+import "time"
+
+// Stats is a point-in-time summary of the service's queues and counters
+type Stats struct {
+	QueueDepth      int `json:"queue_depth"`
+	QueueCapacity   int `json:"queue_capacity"`
+	RetryQueueDepth int `json:"retry_queue_depth"`
+	Scheduled       int `json:"scheduled"`
+	DeadLetterJobs  int `json:"dead_letter_jobs"`
+	Workers         int `json:"workers"`
+	// Paused is whether processing is paused by Pause
+	Paused bool `json:"paused"`
+	// Processed and Failed count jobs sent and dead-lettered since startup
+	Processed     int64     `json:"processed"`
+	Failed        int64     `json:"failed"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+}
+
+// Stats gathers the current queue depths, worker count and job counters.
+// Each figure is read safely on its own, so they may be a moment apart.
+func (es *EmailService) Stats() Stats {
+	es.deadLetterLock.RLock()
+	deadLetters := len(es.deadLetterLog)
+	es.deadLetterLock.RUnlock()
+
+	return Stats{
+		QueueDepth:      es.queueDepth(),
+		QueueCapacity:   es.queueCapacity(),
+		RetryQueueDepth: len(es.retryQueue),
+		Scheduled:       es.schedule.len(),
+		DeadLetterJobs:  deadLetters,
+		Workers:         es.WorkerCount(),
+		Paused:          es.Paused(),
+		Processed:       es.processed.Load(),
+		Failed:          es.failed.Load(),
+		StartedAt:       es.startedAt,
+		UptimeSeconds:   time.Since(es.startedAt).Seconds(),
+	}
+}
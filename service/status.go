@@ -0,0 +1,154 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// JobStatus is where a job currently is in its lifecycle
+type JobStatus string
+
+const (
+	// StatusScheduled means the job is held until its send time
+	StatusScheduled JobStatus = "scheduled"
+	// StatusQueued means the job is waiting for a worker
+	StatusQueued JobStatus = "queued"
+	// StatusProcessing means a worker is sending the job
+	StatusProcessing JobStatus = "processing"
+	// StatusSent means the job was delivered
+	StatusSent JobStatus = "sent"
+	// StatusRetrying means the job failed and is waiting for another attempt
+	StatusRetrying JobStatus = "retrying"
+	// StatusDeadLettered means the job failed permanently
+	StatusDeadLettered JobStatus = "dead_lettered"
+)
+
+// terminal reports whether no further transitions are expected for the status
+func (s JobStatus) terminal() bool {
+	return s == StatusSent || s == StatusDeadLettered
+}
+
+// JobStatusRecord is the latest known status of a job
+type JobStatusRecord struct {
+	ID        string    `json:"id"`
+	Status    JobStatus `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Provider is the email provider that delivered a sent job
+	Provider string `json:"provider,omitempty"`
+	// Recipients are the outcomes per recipient once a relay accepted the job
+	// for some recipients and rejected it for others
+	Recipients []models.RecipientDelivery `json:"recipients,omitempty"`
+}
+
+// Redacted returns a copy of the record with the relay's rejection of each
+// recipient hidden, as EmailJob.Redacted hides a job's errors
+func (r JobStatusRecord) Redacted() JobStatusRecord {
+	r.Recipients = models.RedactedDeliveries(r.Recipients)
+	return r
+}
+
+// statusEntry is a tracked status and when it stops being reported
+type statusEntry struct {
+	record    JobStatusRecord
+	expiresAt time.Time // zero while the job is still in flight
+}
+
+// statusTracker maps job IDs to their latest status. Terminal statuses expire
+// after ttl so memory stays bounded by the number of recent jobs.
+type statusTracker struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]statusEntry
+	lastSweep time.Time
+}
+
+// newStatusTracker creates a tracker that forgets finished jobs after ttl
+func newStatusTracker(ttl time.Duration) *statusTracker {
+	return &statusTracker{
+		ttl:     ttl,
+		entries: make(map[string]statusEntry),
+	}
+}
+
+// set records a status transition for the job with the given ID, and for a
+// sent job the provider that delivered it. Recipient outcomes are kept.
+func (t *statusTracker) set(id string, status JobStatus, provider string, now time.Time) {
+	if id == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	recipients := t.entries[id].record.Recipients
+	entry := statusEntry{record: JobStatusRecord{ID: id, Status: status, UpdatedAt: now, Provider: provider, Recipients: recipients}}
+	if status.terminal() {
+		entry.expiresAt = now.Add(t.ttl)
+	}
+	t.entries[id] = entry
+}
+
+// setDeliveries records the outcome per recipient of the job with the given
+// ID, if it is still tracked
+func (t *statusTracker) setDeliveries(id string, deliveries []models.RecipientDelivery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if entry, ok := t.entries[id]; ok {
+		entry.record.Recipients = deliveries
+		t.entries[id] = entry
+	}
+}
+
+// forget stops tracking the job with the given ID
+func (t *statusTracker) forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, id)
+}
+
+// get returns the status for the job with the given ID, if it's still known
+func (t *statusTracker) get(id string, now time.Time) (JobStatusRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[id]
+	if !ok || entry.expired(now) {
+		return JobStatusRecord{}, false
+	}
+	return entry.record, true
+}
+
+// sweep drops expired entries, at most once per ttl
+func (t *statusTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.ttl {
+		return
+	}
+	t.lastSweep = now
+
+	for id, entry := range t.entries {
+		if entry.expired(now) {
+			delete(t.entries, id)
+		}
+	}
+}
+
+// expired reports whether a terminal status has outlived its ttl
+func (e statusEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// JobStatus returns the latest status of the job with the given ID
+func (es *EmailService) JobStatus(id string) (JobStatusRecord, bool) {
+	return es.statuses.get(id, time.Now())
+}
+
+// setStatus records a lifecycle transition for job
+func (es *EmailService) setStatus(job models.EmailJob, status JobStatus) {
+	es.statuses.set(job.ID, status, "", time.Now())
+}
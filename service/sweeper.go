@@ -0,0 +1,173 @@
+package service
+
+import (
+	"log/slog"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// sweepLoop periodically re-enqueues dead letter jobs until shutdown
+func (es *EmailService) sweepLoop() {
+	defer es.wg.Done()
+
+	ticker := time.NewTicker(es.sweepInterval)
+	defer ticker.Stop()
+
+	slog.Info("Dead letter sweeper started", "interval", es.sweepInterval.String(), "max_attempts", es.sweepMaxAttempts)
+
+	for {
+		select {
+		case <-ticker.C:
+			es.sweepDeadLetters()
+		case <-es.shutdown:
+			slog.Debug("Dead letter sweeper shutting down")
+			return
+		}
+	}
+}
+
+// sweepDeadLetters gives every eligible dead letter job another full set of retries
+func (es *EmailService) sweepDeadLetters() {
+	es.sweepRuns.Inc()
+
+	es.deadLetterMaint.Lock()
+	defer es.deadLetterMaint.Unlock()
+
+	eligible := es.takeDeadLetters(func(job models.EmailJob) bool {
+		return !job.Heartbeat && job.SweepAttempts < es.sweepMaxAttempts
+	})
+	if len(eligible) == 0 {
+		return
+	}
+
+	var failed []models.EmailJob
+	for _, job := range eligible {
+		job.SweepAttempts++
+		job.Retries = 0
+
+		if err := es.requeue(job); err != nil {
+			job.SweepAttempts--
+			failed = append(failed, job)
+			continue
+		}
+		es.sweepRequeued.Inc()
+	}
+
+	if len(failed) > 0 {
+		es.restoreDeadLetters(failed)
+	}
+
+	slog.Info("Dead letter sweep finished", "requeued", len(eligible)-len(failed), "eligible", len(eligible))
+}
+
+// RetryTenantDeadLetters requeues the dead letter jobs submitted by tenant,
+// "" meaning jobs without a tenant, with a fresh set of retries
+func (es *EmailService) RetryTenantDeadLetters(tenant string) (requeued, failed int) {
+	return es.retryDeadLetters(func(job models.EmailJob) bool { return job.Tenant == tenant })
+}
+
+// retryDeadLetters requeues the dead letter jobs matching match with a fresh
+// set of retries. Jobs that can't be requeued, e.g. because the queue is full,
+// stay in the dead letter queue. Heartbeat jobs are never requeued.
+func (es *EmailService) retryDeadLetters(match func(models.EmailJob) bool) (requeued, failed int) {
+	es.deadLetterMaint.Lock()
+	defer es.deadLetterMaint.Unlock()
+
+	jobs := es.takeDeadLetters(func(job models.EmailJob) bool {
+		return !job.Heartbeat && match(job)
+	})
+
+	var remaining []models.EmailJob
+	for _, job := range jobs {
+		job.Retries = 0
+
+		if err := es.requeue(job); err != nil {
+			remaining = append(remaining, job)
+			continue
+		}
+		requeued++
+	}
+
+	if len(remaining) > 0 {
+		es.restoreDeadLetters(remaining)
+	}
+
+	slog.Info("Dead letter retry finished", "requeued", requeued, "total", len(jobs))
+	return requeued, len(remaining)
+}
+
+// PurgeTenantDeadLetters removes the dead letter jobs submitted by tenant, ""
+// meaning jobs without a tenant, and returns how many were removed
+func (es *EmailService) PurgeTenantDeadLetters(tenant string) int {
+	return es.purgeDeadLetters(func(job models.EmailJob) bool { return job.Tenant == tenant })
+}
+
+// purgeDeadLetters removes the dead letter jobs matching match, including from
+// the dead letter file when it is persisted, and returns how many were removed
+func (es *EmailService) purgeDeadLetters(match func(models.EmailJob) bool) int {
+	es.deadLetterMaint.Lock()
+	defer es.deadLetterMaint.Unlock()
+
+	jobs := es.takeDeadLetters(match)
+	for _, job := range jobs {
+		es.statuses.forget(job.ID)
+	}
+
+	slog.Info("Dead letter queue purged", "removed", len(jobs))
+	return len(jobs)
+}
+
+// takeDeadLetters removes and returns the dead letter jobs matching take.
+// Callers must hold deadLetterMaint and enqueue the result without holding
+// deadLetterLock, since enqueueing can wait on the enqueue lock.
+func (es *EmailService) takeDeadLetters(take func(models.EmailJob) bool) []models.EmailJob {
+	es.deadLetterLock.Lock()
+	defer es.deadLetterLock.Unlock()
+
+	var taken []models.EmailJob
+	kept := make([]models.EmailJob, 0) // an empty queue is listed as [], not null
+	for _, job := range es.deadLetterLog {
+		if take(job) {
+			taken = append(taken, job)
+		} else {
+			kept = append(kept, job)
+		}
+	}
+	if len(taken) > 0 {
+		es.deadLetterLog = kept
+		es.updateDeadLetterSize()
+		es.persistDeadLetterLog()
+	}
+	return taken
+}
+
+// requeue puts a job back on the main queue, bypassing submission throttles
+func (es *EmailService) requeue(job models.EmailJob) error {
+	// Whatever dead-lettered it last time no longer applies
+	job.Reason = ""
+
+	es.enqueueLock.RLock()
+	defer es.enqueueLock.RUnlock()
+
+	if es.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+	if es.scheduled(job) {
+		return es.scheduleJob(job)
+	}
+	return es.pushJob(job)
+}
+
+// restoreDeadLetters puts jobs back into the dead letter log without counting new failures
+func (es *EmailService) restoreDeadLetters(jobs []models.EmailJob) {
+	es.deadLetterLock.Lock()
+	defer es.deadLetterLock.Unlock()
+
+	es.deadLetterLog = append(es.deadLetterLog, jobs...)
+	es.updateDeadLetterSize()
+	es.persistDeadLetters(jobs...)
+	for _, job := range jobs {
+		es.setStatus(job, StatusDeadLettered)
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"email-queue-service/models"
+)
+
+// anyJob matches every dead letter job
+func anyJob(models.EmailJob) bool { return true }
+
+func TestRetryDeadLettersKeepsJobsTheQueueCantTake(t *testing.T) {
+	// Not started, so nothing drains the queue
+	es := newTestService(t, nil, map[string]string{"QUEUE_SIZE": "2"})
+	fillDeadLetters(es, 5)
+
+	requeued, failed := es.retryDeadLetters(anyJob)
+	if requeued != 2 || failed != 3 {
+		t.Errorf("retryDeadLetters = %d requeued, %d failed, want 2 and 3", requeued, failed)
+	}
+	if got := len(es.GetDeadLetterJobs()); got != 3 {
+		t.Errorf("dead letters = %d, want the 3 that didn't fit", got)
+	}
+	if got := es.queueDepth(); got != 2 {
+		t.Errorf("queue depth = %d, want 2", got)
+	}
+}
+
+func TestPurgeDeadLettersEmptiesQueueAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	es := newTestService(t, nil, map[string]string{"DLQ_FILE": path})
+	for _, id := range []string{"a", "b", "c"} {
+		es.moveToDeadLetter(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi"})
+	}
+	if ids := persistedIDs(t, path); len(ids) != 3 {
+		t.Fatalf("persisted %v before the purge, want 3 jobs", ids)
+	}
+
+	if removed := es.purgeDeadLetters(anyJob); removed != 3 {
+		t.Errorf("purgeDeadLetters = %d, want 3", removed)
+	}
+	if dead := es.GetDeadLetterJobs(); len(dead) != 0 {
+		t.Errorf("dead letters after the purge = %d, want none", len(dead))
+	}
+	if ids := persistedIDs(t, path); len(ids) != 0 {
+		t.Errorf("persisted %v after the purge, want none", ids)
+	}
+	if removed := es.purgeDeadLetters(anyJob); removed != 0 {
+		t.Errorf("second purgeDeadLetters = %d, want 0", removed)
+	}
+}
+
+func TestDeadLetterGaugeFollowsPurgeAndRequeue(t *testing.T) {
+	// Not started, so nothing drains the queue
+	es := newTestService(t, nil, map[string]string{"QUEUE_SIZE": "1"})
+	for _, id := range []string{"a", "b", "c"} {
+		es.moveToDeadLetter(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi"})
+	}
+	if got := metricValue(t, es, "email_dead_letter_current"); got != 3 {
+		t.Fatalf("email_dead_letter_current = %v, want 3", got)
+	}
+
+	if requeued, _ := es.retryDeadLetters(anyJob); requeued != 1 {
+		t.Fatalf("retryDeadLetters requeued %d, want 1", requeued)
+	}
+	if got := metricValue(t, es, "email_dead_letter_current"); got != 2 {
+		t.Errorf("email_dead_letter_current after a requeue = %v, want 2", got)
+	}
+
+	es.purgeDeadLetters(anyJob)
+	if got := metricValue(t, es, "email_dead_letter_current"); got != 0 {
+		t.Errorf("email_dead_letter_current after a purge = %v, want 0", got)
+	}
+	// The total only grows
+	if got := metricValue(t, es, "email_dead_letter_jobs_total"); got != 3 {
+		t.Errorf("email_dead_letter_jobs_total = %v, want 3", got)
+	}
+}
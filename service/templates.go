@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// maxTemplates caps how many templates can be registered at once
+const maxTemplates = 1000
+
+var (
+	// ErrTemplateNotFound is returned when a template ID isn't registered
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrInvalidTemplate is returned when a template doesn't parse
+	ErrInvalidTemplate = errors.New("invalid template")
+	// ErrTemplateData is returned when a template can't be rendered with the data given, e.g. a variable is missing
+	ErrTemplateData = errors.New("invalid template data")
+	// ErrTooManyTemplates is returned when registering a new template would exceed maxTemplates
+	ErrTooManyTemplates = errors.New("too many templates")
+)
+
+// RenderedEmail is a template rendered with a caller's data
+type RenderedEmail struct {
+	Subject string
+	Body    string
+	HTML    bool
+}
+
+// executor is satisfied by both text/template and html/template templates
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// compiledTemplate is a registered template, parsed once
+type compiledTemplate struct {
+	source  models.EmailTemplate
+	subject executor
+	body    executor
+}
+
+// templateRegistry holds the templates callers can send by ID
+type templateRegistry struct {
+	mu        sync.RWMutex
+	templates map[string]*compiledTemplate
+}
+
+// newTemplateRegistry creates an empty registry
+func newTemplateRegistry() *templateRegistry {
+	return &templateRegistry{templates: make(map[string]*compiledTemplate)}
+}
+
+// SaveTemplate registers tmpl, replacing any template with the same ID. The
+// subject is always plain text; an HTML body is parsed with html/template so
+// substituted values are escaped. A variable the data doesn't provide fails
+// rendering rather than coming out as "<no value>".
+func (es *EmailService) SaveTemplate(tmpl models.EmailTemplate) (models.EmailTemplate, error) {
+	subject, err := template.New("subject").Option("missingkey=error").Parse(tmpl.Subject)
+	if err != nil {
+		return models.EmailTemplate{}, fmt.Errorf("%w: subject: %v", ErrInvalidTemplate, err)
+	}
+
+	var body executor
+	if tmpl.HTML {
+		body, err = htmltemplate.New("body").Option("missingkey=error").Parse(tmpl.Body)
+	} else {
+		body, err = template.New("body").Option("missingkey=error").Parse(tmpl.Body)
+	}
+	if err != nil {
+		return models.EmailTemplate{}, fmt.Errorf("%w: body: %v", ErrInvalidTemplate, err)
+	}
+
+	tmpl.UpdatedAt = time.Now()
+	compiled := &compiledTemplate{source: tmpl, subject: subject, body: body}
+
+	es.templates.mu.Lock()
+	defer es.templates.mu.Unlock()
+
+	if _, exists := es.templates.templates[tmpl.ID]; !exists && len(es.templates.templates) >= maxTemplates {
+		return models.EmailTemplate{}, ErrTooManyTemplates
+	}
+	es.templates.templates[tmpl.ID] = compiled
+	return tmpl, nil
+}
+
+// Templates returns every registered template, ordered by ID
+func (es *EmailService) Templates() []models.EmailTemplate {
+	es.templates.mu.RLock()
+	defer es.templates.mu.RUnlock()
+
+	templates := make([]models.EmailTemplate, 0, len(es.templates.templates))
+	for _, compiled := range es.templates.templates {
+		templates = append(templates, compiled.source)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].ID < templates[j].ID
+	})
+	return templates
+}
+
+// RenderTemplate renders the template registered as id with data
+func (es *EmailService) RenderTemplate(id string, data map[string]string) (RenderedEmail, error) {
+	es.templates.mu.RLock()
+	compiled, ok := es.templates.templates[id]
+	es.templates.mu.RUnlock()
+	if !ok {
+		return RenderedEmail{}, ErrTemplateNotFound
+	}
+
+	subject, err := execute(compiled.subject, data)
+	if err != nil {
+		return RenderedEmail{}, fmt.Errorf("%w: subject: %v", ErrTemplateData, err)
+	}
+	body, err := execute(compiled.body, data)
+	if err != nil {
+		return RenderedEmail{}, fmt.Errorf("%w: body: %v", ErrTemplateData, err)
+	}
+
+	return RenderedEmail{Subject: subject, Body: body, HTML: compiled.source.HTML}, nil
+}
+
+// execute renders tmpl with data
+func execute(tmpl executor, data map[string]string) (string, error) {
+	// A nil map still makes missingkey=error report the missing variable
+	if data == nil {
+		data = map[string]string{}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
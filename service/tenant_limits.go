@@ -0,0 +1,165 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrTenantRateLimited is matched by a TenantRateLimitError
+var ErrTenantRateLimited = errors.New("tenant rate limit exceeded")
+
+// TenantRateLimitError is returned when a tenant submits emails faster than
+// its rate limit allows
+type TenantRateLimitError struct {
+	Tenant string
+	// Limit is the tenant's rate limit in emails per second
+	Limit float64
+}
+
+// Error implements error
+func (e *TenantRateLimitError) Error() string {
+	return fmt.Sprintf("%s (tenant %s, limit %g emails/s)", ErrTenantRateLimited, e.Tenant, e.Limit)
+}
+
+// Is makes errors.Is(err, ErrTenantRateLimited) match
+func (e *TenantRateLimitError) Is(target error) bool {
+	return target == ErrTenantRateLimited
+}
+
+// tenantLimiter keeps a token bucket per tenant, filled at the rate listed
+// for the tenant in a limits file or at a default rate otherwise
+type tenantLimiter struct {
+	path        string  // limits file; empty when every tenant gets the default
+	defaultRate float64 // emails per second; 0 is unlimited
+
+	mu        sync.Mutex
+	rates     map[string]float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newTenantLimiter creates a limiter reading per-tenant rates from path, if
+// set. Unlike a reload, a file that can't be read is an error.
+func newTenantLimiter(path string, defaultRate float64) (*tenantLimiter, error) {
+	l := &tenantLimiter{
+		path:        path,
+		defaultRate: defaultRate,
+		rates:       map[string]float64{},
+		buckets:     make(map[string]*tokenBucket),
+	}
+	if path != "" {
+		rates, err := loadTenantLimits(path)
+		if err != nil {
+			return nil, err
+		}
+		l.rates = rates
+	}
+	return l, nil
+}
+
+// reload rereads the limits file. If it can't be read the current limits
+// stay in force.
+func (l *tenantLimiter) reload() error {
+	if l.path == "" {
+		return nil
+	}
+	rates, err := loadTenantLimits(l.path)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rates = rates
+	// Buckets pick up a changed rate as they are next used
+	return nil
+}
+
+// take consumes a token for tenant only if one is available now, returning
+// the tenant's limit. Requests without a tenant aren't limited.
+func (l *tenantLimiter) take(tenant string, now time.Time) (float64, bool) {
+	if tenant == "" {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rate, ok := l.rates[tenant]
+	if !ok {
+		rate = l.defaultRate
+	}
+	if rate == 0 {
+		return 0, true
+	}
+
+	l.sweep(now)
+	bucket, ok := l.buckets[tenant]
+	if !ok || bucket.rate != rate {
+		bucket = newTokenBucket(rate, now)
+		l.buckets[tenant] = bucket
+	}
+	return rate, bucket.take(now)
+}
+
+// giveBack returns a token taken for tenant that wasn't used after all
+func (l *tenantLimiter) giveBack(tenant string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, ok := l.buckets[tenant]; ok {
+		bucket.giveBack(now)
+	}
+}
+
+// sweep drops buckets that have refilled completely, at most once per
+// interval, like the domain limiter. Callers must hold mu.
+func (l *tenantLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < domainBucketIdleSweep {
+		return
+	}
+	l.lastSweep = now
+
+	for tenant, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, tenant)
+		}
+	}
+}
+
+// loadTenantLimits reads a JSON object mapping tenants to emails per second,
+// e.g. {"acme": 50, "globex": 5}. A file that isn't such an object is an
+// error; an entry that isn't a non-negative number is logged and skipped, so
+// one typo doesn't cost every other tenant its limit.
+func loadTenantLimits(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read tenant limits file: %w", err)
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse tenant limits file %s: %w", path, err)
+	}
+
+	rates := make(map[string]float64, len(entries))
+	for tenant, raw := range entries {
+		var rate float64
+		switch err := json.Unmarshal(raw, &rate); {
+		case tenant == "":
+			slog.Warn("Skipping tenant limit without a tenant", "file", path)
+		case err != nil, rate < 0, math.IsInf(rate, 0):
+			slog.Warn("Skipping malformed tenant limit", "file", path, "tenant", tenant, "value", string(raw))
+		default:
+			rates[tenant] = rate
+		}
+	}
+	return rates, nil
+}
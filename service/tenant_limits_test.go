@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeTenantLimits writes a tenant limits file and returns its path
+func writeTenantLimits(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTenantLimitsSkipsMalformedEntries(t *testing.T) {
+	path := writeTenantLimits(t, `{"acme": 50, "globex": 0.5, "free": 0, "bad": "fast", "negative": -1, "": 3}`)
+
+	rates, err := loadTenantLimits(path)
+	if err != nil {
+		t.Fatalf("loadTenantLimits: %v", err)
+	}
+	want := map[string]float64{"acme": 50, "globex": 0.5, "free": 0}
+	if len(rates) != len(want) {
+		t.Fatalf("rates = %v, want %v", rates, want)
+	}
+	for tenant, rate := range want {
+		if rates[tenant] != rate {
+			t.Errorf("rates[%s] = %v, want %v", tenant, rates[tenant], rate)
+		}
+	}
+}
+
+func TestLoadTenantLimitsRejectsInvalidFiles(t *testing.T) {
+	for _, contents := range []string{`not json`, `[1, 2]`, `{"acme": 5`} {
+		if _, err := loadTenantLimits(writeTenantLimits(t, contents)); err == nil {
+			t.Errorf("loadTenantLimits(%q) succeeded", contents)
+		}
+	}
+	if _, err := loadTenantLimits(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loadTenantLimits of a missing file succeeded")
+	}
+}
+
+func TestTenantLimiterUsesFileThenDefault(t *testing.T) {
+	l, err := newTenantLimiter(writeTenantLimits(t, `{"acme": 3, "free": 0}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// acme gets its own burst of 3
+	for i := 0; i < 3; i++ {
+		if _, ok := l.take("acme", now); !ok {
+			t.Fatalf("acme take %d refused", i+1)
+		}
+	}
+	if limit, ok := l.take("acme", now); ok || limit != 3 {
+		t.Errorf("acme over its limit = %v, %v; want 3, false", limit, ok)
+	}
+
+	// Unlisted tenants get the default
+	if _, ok := l.take("globex", now); !ok {
+		t.Fatal("globex first take refused")
+	}
+	if limit, ok := l.take("globex", now); ok || limit != 1 {
+		t.Errorf("globex over the default = %v, %v; want 1, false", limit, ok)
+	}
+
+	// 0 in the file and no tenant at all are unlimited
+	for i := 0; i < 100; i++ {
+		if _, ok := l.take("free", now); !ok {
+			t.Fatal("unlimited tenant refused")
+		}
+		if _, ok := l.take("", now); !ok {
+			t.Fatal("request without a tenant refused")
+		}
+	}
+
+	// Tokens come back at the tenant's rate
+	if _, ok := l.take("globex", now.Add(time.Second)); !ok {
+		t.Error("globex still refused a second later")
+	}
+}
+
+func TestTenantLimiterReload(t *testing.T) {
+	path := writeTenantLimits(t, `{"acme": 1}`)
+	l, err := newTenantLimiter(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	l.take("acme", now)
+	if _, ok := l.take("acme", now); ok {
+		t.Fatal("acme not limited before reload")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"acme": 10}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if limit, ok := l.take("acme", now); !ok || limit != 10 {
+		t.Errorf("after reload take = %v, %v; want 10, true", limit, ok)
+	}
+
+	// A broken file keeps the limits already loaded
+	if err := os.WriteFile(path, []byte(`{"acme": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err == nil {
+		t.Error("reload of a broken file succeeded")
+	}
+	if limit, _ := l.take("acme", now); limit != 10 {
+		t.Errorf("limit after failed reload = %v, want 10", limit)
+	}
+}
+
+func TestTenantRateLimitRejectsSubmissions(t *testing.T) {
+	path := writeTenantLimits(t, `{"acme": 1}`)
+	es := newTestService(t, nil, map[string]string{"TENANT_LIMITS_FILE": path})
+
+	job := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Hi", Body: "Hi", Tenant: "acme"}
+	if err := es.EnqueueJob(job); err != nil {
+		t.Fatalf("first EnqueueJob: %v", err)
+	}
+
+	job.ID = "b"
+	err := es.EnqueueJob(job)
+	var limited *TenantRateLimitError
+	if !errors.As(err, &limited) || limited.Tenant != "acme" || limited.Limit != 1 {
+		t.Fatalf("second EnqueueJob error = %v, want acme's rate limit", err)
+	}
+	if !errors.Is(err, ErrTenantRateLimited) {
+		t.Error("error doesn't match ErrTenantRateLimited")
+	}
+
+	job.ID, job.Tenant = "c", "globex"
+	if err := es.EnqueueJob(job); err != nil {
+		t.Errorf("other tenant's EnqueueJob: %v", err)
+	}
+}
+
+func TestTenantIsChargedOnlyForAcceptedJobs(t *testing.T) {
+	path := writeTenantLimits(t, `{"acme": 1}`)
+	es := newTestService(t, nil, map[string]string{"TENANT_LIMITS_FILE": path, "MAX_SCHEDULED": "1", "DEDUPE_WINDOW": "1m"})
+
+	// Fill the schedule with another tenant's email
+	later := time.Now().Add(time.Hour)
+	if err := es.EnqueueJob(models.EmailJob{ID: "other", To: "other@example.com", Subject: "Hi", Body: "Hi", SendAt: later}); err != nil {
+		t.Fatalf("EnqueueJob other: %v", err)
+	}
+	scheduled := models.EmailJob{ID: "a", To: "user@example.com", Subject: "Later", Body: "Hi", Tenant: "acme", SendAt: later}
+	if err := es.EnqueueJob(scheduled); !errors.Is(err, ErrScheduleFull) {
+		t.Fatalf("scheduled EnqueueJob = %v, want ErrScheduleFull", err)
+	}
+
+	job := models.EmailJob{ID: "b", To: "user@example.com", Subject: "Hi", Body: "Hi", Tenant: "acme"}
+	if err := es.EnqueueJob(job); err != nil {
+		t.Fatalf("EnqueueJob after a rejected one: %v, want acme's token unspent", err)
+	}
+
+	// A suppressed duplicate doesn't reach the limit either
+	job.ID = "c"
+	var duplicate *DuplicateError
+	if err := es.EnqueueJob(job); !errors.As(err, &duplicate) {
+		t.Fatalf("duplicate EnqueueJob = %v, want a DuplicateError", err)
+	}
+}
+
+func TestInvalidTenantLimitsFileFailsStartup(t *testing.T) {
+	t.Setenv("TENANT_LIMITS_FILE", writeTenantLimits(t, `not json`))
+	if _, err := NewEmailService(config.LoadConfig(), nil, prometheus.NewRegistry()); err == nil {
+		t.Fatal("NewEmailService succeeded with a broken tenant limits file")
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subjectThrottle limits how many emails with the same subject go to the same
+// recipient within a sliding window
+type subjectThrottle struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	delay     bool // delay over-limit sends instead of dropping them
+	sends     map[string][]time.Time
+	lastSweep time.Time
+}
+
+// newSubjectThrottle creates a throttle; action is "drop" or "delay"
+func newSubjectThrottle(limit int, window time.Duration, action string) (*subjectThrottle, error) {
+	var delay bool
+	switch strings.ToLower(action) {
+	case "", "drop":
+	case "delay":
+		delay = true
+	default:
+		return nil, fmt.Errorf("unknown subject throttle action %q", action)
+	}
+
+	return &subjectThrottle{
+		limit:     limit,
+		window:    window,
+		delay:     delay,
+		sends:     make(map[string][]time.Time),
+		lastSweep: time.Now(),
+	}, nil
+}
+
+// throttleKey normalizes recipient and subject so trivial differences don't evade the limit
+func throttleKey(to, subject string) string {
+	return strings.ToLower(strings.TrimSpace(to)) + "\x00" + strings.ToLower(strings.Join(strings.Fields(subject), " "))
+}
+
+// reserve claims a send slot for key. It returns how long the send must wait
+// for a free slot, or false if the send is over the limit and should be dropped.
+// With allowDelay false, over-limit sends are always dropped.
+func (t *subjectThrottle) reserve(key string, now time.Time, allowDelay bool) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	// Timestamps are ascending and may include future slots reserved by delayed sends
+	sends := pruneBefore(t.sends[key], now.Add(-t.window))
+
+	at := now
+	if len(sends) >= t.limit {
+		if !t.delay || !allowDelay {
+			t.sends[key] = sends
+			return 0, false
+		}
+		if next := sends[len(sends)-t.limit].Add(t.window); next.After(at) {
+			at = next
+		}
+	}
+
+	t.sends[key] = append(sends, at)
+	return at.Sub(now), true
+}
+
+// sweep drops keys with no sends in the window, at most once per window
+func (t *subjectThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.window {
+		return
+	}
+	t.lastSweep = now
+
+	cutoff := now.Add(-t.window)
+	for key, sends := range t.sends {
+		if sends = pruneBefore(sends, cutoff); len(sends) == 0 {
+			delete(t.sends, key)
+		} else {
+			t.sends[key] = sends
+		}
+	}
+}
+
+// pruneBefore drops ascending timestamps that are not after cutoff
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// verifyBaseDelay and verifyMaxDelay bound the backoff between startup
+	// connection attempts
+	verifyBaseDelay = time.Second
+	verifyMaxDelay  = 30 * time.Second
+)
+
+// verifier is a sender or provider that can check it reaches its server
+// before the first email is sent
+type verifier interface {
+	Verify(ctx context.Context) error
+}
+
+// startupVerify controls the connection check run when the service starts
+type startupVerify struct {
+	attempts int
+	timeout  time.Duration // per attempt
+	backoff  BackoffStrategy
+}
+
+// enabled reports whether the sender should be checked at startup
+func (sv startupVerify) enabled() bool {
+	return sv.attempts > 0
+}
+
+// verifyLoop checks the sender can reach its server, retrying with backoff,
+// and reports the service ready once it can or once the attempts run out.
+// Giving up leaves sends to connect lazily as they would without the check,
+// so a relay that is down at deploy doesn't keep the service out of rotation.
+func (es *EmailService) verifyLoop() {
+	defer es.wg.Done()
+	defer es.awaitingSender.Store(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-es.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	v := es.sender.(verifier)
+	err := verifyWithRetry(ctx, v, es.verify)
+	switch {
+	case err == nil:
+		slog.Info("Email provider connection verified", "event", "sender_verified")
+	case ctx.Err() != nil:
+		slog.Debug("Email provider verification stopped by shutdown")
+	default:
+		slog.Error("Email provider unreachable at startup, sends will connect as they go", "event", "sender_unverified", "attempts", es.verify.attempts, "error", err)
+	}
+}
+
+// verifyWithRetry calls v.Verify up to sv.attempts times, waiting between
+// attempts, and returns the last error if none succeeded
+func verifyWithRetry(ctx context.Context, v verifier, sv startupVerify) error {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, sv.timeout)
+		err := v.Verify(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt >= sv.attempts {
+			return err
+		}
+
+		delay := sv.backoff.NextDelay(attempt)
+		slog.Warn("Email provider not reachable yet", "attempt", attempt, "retry_in", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Verify implements verifier. The providers that can be checked are tried in
+// order and one reaching its server is enough, since sends fail over to it.
+// Providers that can't be checked are skipped.
+func (s *CompositeSender) Verify(ctx context.Context) error {
+	var errs []error
+	for _, provider := range s.Providers {
+		v, ok := provider.(verifier)
+		if !ok {
+			continue
+		}
+		err := v.Verify(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"email-queue-service/models"
+)
+
+// vipList is the recipients whose emails always go to the high-priority
+// queue: those configured directly, plus those listed in a file that can be
+// reread
+type vipList struct {
+	path   string          // one address per line; empty without a file
+	static map[string]bool // from VIP_RECIPIENTS, lowercased
+
+	mu        sync.RWMutex
+	addresses map[string]bool // static and the file's, lowercased
+}
+
+// newVIPList creates a list of addresses and those in the file at path, if
+// set. Unlike a reload, a file that can't be read is an error.
+func newVIPList(addresses []string, path string) (*vipList, error) {
+	l := &vipList{path: path, static: make(map[string]bool, len(addresses))}
+	for _, addr := range addresses {
+		l.static[strings.ToLower(addr)] = true
+	}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload rereads the file. If it can't be read the current list stays.
+func (l *vipList) reload() error {
+	addresses := make(map[string]bool, len(l.static))
+	for addr := range l.static {
+		addresses[addr] = true
+	}
+	if l.path != "" {
+		listed, err := loadVIPFile(l.path)
+		if err != nil {
+			return err
+		}
+		for _, addr := range listed {
+			addresses[addr] = true
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.addresses = addresses
+	return nil
+}
+
+// match returns the first of job's recipients on the list, if any
+func (l *vipList) match(job models.EmailJob) (string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, recipient := range job.Recipients() {
+		if l.addresses[strings.ToLower(recipient)] {
+			return recipient, true
+		}
+	}
+	return "", false
+}
+
+// loadVIPFile reads one address per line, lowercased, skipping blank lines
+// and # comments
+func loadVIPFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read VIP recipients file: %w", err)
+	}
+
+	var addresses []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addresses = append(addresses, strings.ToLower(line))
+	}
+	return addresses, scanner.Err()
+}
+
+// boostVIP moves a job with a VIP recipient to the high-priority queue
+func (es *EmailService) boostVIP(job *models.EmailJob) {
+	if es.vips == nil || job.Heartbeat || job.Priority == models.PriorityHigh {
+		return
+	}
+	recipient, ok := es.vips.match(*job)
+	if !ok {
+		return
+	}
+
+	slog.Info("VIP recipient, boosting email to high priority", "event", "vip_boost", "job_id", job.ID, "recipient", recipient, "priority", job.Priority)
+	job.Priority = models.PriorityHigh
+	es.vipBoosted.Inc()
+}
+
+// ReloadVIPRecipients rereads the VIP recipients file. If it can't be read,
+// the list already loaded stays in force.
+func (es *EmailService) ReloadVIPRecipients() error {
+	if es.vips == nil {
+		return nil
+	}
+	return es.vips.reload()
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"email-queue-service/config"
+	"email-queue-service/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestVIPRecipientsLandInHighPriorityQueue(t *testing.T) {
+	es := newTestService(t, nil, map[string]string{"VIP_RECIPIENTS": "CEO@example.com"})
+
+	vip := models.EmailJob{ID: "vip", To: "ceo@Example.com", Subject: "Hi", Body: "Hi", Priority: models.PriorityLow}
+	if err := es.EnqueueJob(vip); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	other := models.EmailJob{ID: "other", To: "user@example.com", Subject: "Hi", Body: "Hi", Priority: models.PriorityLow}
+	if err := es.EnqueueJob(other); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+
+	lengths := es.jobQueue.laneLengths()
+	if lengths[priorityLane(models.PriorityHigh)] != 1 || lengths[priorityLane(models.PriorityLow)] != 1 {
+		t.Errorf("lane lengths = %v, want the VIP email high and the other low", lengths)
+	}
+}
+
+func TestVIPListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vips.txt")
+	if err := os.WriteFile(path, []byte("# executives\nceo@example.com\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newVIPList([]string{"cfo@example.com"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isVIP := func(to string) bool {
+		_, ok := l.match(models.EmailJob{To: to})
+		return ok
+	}
+	if !isVIP("ceo@example.com") || !isVIP("cfo@example.com") || isVIP("cto@example.com") {
+		t.Fatal("VIPs not matched from the file and the configured list")
+	}
+
+	if err := os.WriteFile(path, []byte("cto@example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if isVIP("ceo@example.com") || !isVIP("cto@example.com") || !isVIP("cfo@example.com") {
+		t.Error("reload didn't replace the file's VIPs")
+	}
+
+	// A missing file keeps the list already loaded
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err == nil {
+		t.Error("reload of a missing file succeeded")
+	}
+	if !isVIP("cto@example.com") {
+		t.Error("VIPs lost after a failed reload")
+	}
+}
+
+func TestMissingVIPRecipientsFileFailsStartup(t *testing.T) {
+	t.Setenv("VIP_RECIPIENTS_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	if _, err := NewEmailService(config.LoadConfig(), nil, prometheus.NewRegistry()); err == nil {
+		t.Fatal("NewEmailService succeeded with a missing VIP recipients file")
+	}
+}
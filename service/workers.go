@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"email-queue-service/models"
+)
+
+// ErrInvalidWorkerCount is returned when asked to run fewer than one worker
+var ErrInvalidWorkerCount = errors.New("worker count must be at least 1")
+
+// ErrNoGeneralWorkers is returned when asked to run no more workers than are
+// dedicated to high-priority jobs, which would leave the others unsent
+var ErrNoGeneralWorkers = errors.New("worker count must exceed HIGH_PRIORITY_WORKERS")
+
+// SetWorkerCount starts or stops workers until n are running. A stopped
+// worker finishes the job it is processing before it exits.
+func (es *EmailService) SetWorkerCount(n int) error {
+	if n < 1 {
+		return ErrInvalidWorkerCount
+	}
+	if n <= es.highWorkers {
+		return ErrNoGeneralWorkers
+	}
+
+	// Shutdown can't start waiting for the workers while one is being added
+	es.enqueueLock.RLock()
+	defer es.enqueueLock.RUnlock()
+
+	if es.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+
+	es.resizeWorkers(n)
+	return nil
+}
+
+// WorkerCount returns the number of running workers
+func (es *EmailService) WorkerCount() int {
+	es.workerLock.Lock()
+	defer es.workerLock.Unlock()
+
+	return len(es.workerStops)
+}
+
+// WorkerAssignment returns how the running workers are split between
+// priority tiers
+func (es *EmailService) WorkerAssignment() models.WorkerAssignment {
+	workers := es.WorkerCount()
+	high := min(es.highWorkers, workers)
+	return models.WorkerAssignment{
+		Workers:          workers,
+		HighPriorityOnly: high,
+		AllPriorities:    workers - high,
+	}
+}
+
+// resizeWorkers starts or stops workers until n are running. The first
+// HIGH_PRIORITY_WORKERS started take high-priority jobs only; workers are
+// stopped newest first, so those are the last to go. Callers must make sure
+// Shutdown hasn't started.
+func (es *EmailService) resizeWorkers(n int) {
+	es.workerLock.Lock()
+	defer es.workerLock.Unlock()
+
+	before := len(es.workerStops)
+	for len(es.workerStops) < n {
+		highOnly := len(es.workerStops) < es.highWorkers
+		stop := make(chan struct{})
+		es.workerStops = append(es.workerStops, stop)
+		es.nextWorkerID++
+
+		es.wg.Add(1)
+		go es.worker(es.nextWorkerID, stop, highOnly)
+	}
+	for len(es.workerStops) > n {
+		last := len(es.workerStops) - 1
+		close(es.workerStops[last])
+		es.workerStops = es.workerStops[:last]
+	}
+
+	es.workerCount.Set(float64(n))
+	if before != n {
+		slog.Info("Worker count changed", "from", before, "to", n)
+	}
+}
+
+// busyWorker is a job a worker is processing and when it started
+type busyWorker struct {
+	job   models.EmailJob
+	since time.Time
+}
+
+// markBusy records that a worker started processing job
+func (es *EmailService) markBusy(workerID int, job models.EmailJob) {
+	es.busyLock.Lock()
+	defer es.busyLock.Unlock()
+
+	es.busyWorkers[workerID] = busyWorker{job: job, since: time.Now()}
+}
+
+// markIdle records that a worker finished its job
+func (es *EmailService) markIdle(workerID int) {
+	es.busyLock.Lock()
+	defer es.busyLock.Unlock()
+
+	delete(es.busyWorkers, workerID)
+}
+
+// logStuckWorkers reports every worker still processing a job once Shutdown
+// has stopped waiting for them. Their jobs are abandoned where they are.
+func (es *EmailService) logStuckWorkers() {
+	es.busyLock.Lock()
+	defer es.busyLock.Unlock()
+
+	if len(es.busyWorkers) == 0 {
+		slog.Error("Background tasks failed to stop before the shutdown timeout", "timeout", es.shutdownTimeout.String())
+		return
+	}
+
+	ids := make([]int, 0, len(es.busyWorkers))
+	for id := range es.busyWorkers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		busy := es.busyWorkers[id]
+		slog.Error("Worker failed to stop before the shutdown timeout", "event", "worker_stuck", "worker_id", id, "job_id", busy.job.ID, "recipient", busy.job.To, "busy_for", time.Since(busy.since).Round(time.Millisecond).String())
+	}
+}
+
+// waitUntil waits for done to be closed until deadline, and reports whether it was
+func waitUntil(done <-chan struct{}, deadline time.Time) bool {
+	select {
+	case <-done:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"email-queue-service/models"
+)
+
+func TestDedicatedWorkersIgnoreLowPriorityJobs(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var sent []string
+	sender := senderFunc(func(ctx context.Context, job models.EmailJob) error {
+		mu.Lock()
+		sent = append(sent, job.ID)
+		mu.Unlock()
+		if job.ID == "low-1" {
+			<-release
+		}
+		return nil
+	})
+	sentIDs := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), sent...)
+	}
+
+	es := startTestService(t, sender, map[string]string{"WORKERS": "2", "HIGH_PRIORITY_WORKERS": "1"})
+	defer close(release)
+
+	enqueue := func(id, priority string) {
+		t.Helper()
+		if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi", Priority: priority}); err != nil {
+			t.Fatalf("EnqueueJob(%s): %v", id, err)
+		}
+	}
+
+	// The only general worker is now busy
+	enqueue("low-1", models.PriorityLow)
+	waitFor(t, "low-1 to be picked up", func() bool { return len(sentIDs()) == 1 })
+
+	enqueue("low-2", models.PriorityLow)
+	enqueue("normal-1", models.PriorityNormal)
+	enqueue("high-1", models.PriorityHigh)
+	waitFor(t, "high-1 to be sent by the dedicated worker", func() bool { return len(sentIDs()) == 2 })
+
+	// Give the dedicated worker a chance to wrongly take another job
+	time.Sleep(50 * time.Millisecond)
+	if got := sentIDs(); len(got) != 2 || got[1] != "high-1" {
+		t.Fatalf("sent %v while the general worker was busy, want [low-1 high-1]", got)
+	}
+
+	release <- struct{}{}
+	waitFor(t, "the remaining jobs", func() bool { return len(sentIDs()) == 4 })
+}
+
+func TestWorkerAssignment(t *testing.T) {
+	es := startTestService(t, senderFunc(func(context.Context, models.EmailJob) error { return nil }), map[string]string{"WORKERS": "3", "HIGH_PRIORITY_WORKERS": "1"})
+
+	want := models.WorkerAssignment{Workers: 3, HighPriorityOnly: 1, AllPriorities: 2}
+	if got := es.WorkerAssignment(); got != want {
+		t.Errorf("WorkerAssignment = %+v, want %+v", got, want)
+	}
+
+	if err := es.SetWorkerCount(1); !errors.Is(err, ErrNoGeneralWorkers) {
+		t.Errorf("SetWorkerCount(1) = %v, want ErrNoGeneralWorkers", err)
+	}
+	if err := es.SetWorkerCount(5); err != nil {
+		t.Fatalf("SetWorkerCount(5): %v", err)
+	}
+	want = models.WorkerAssignment{Workers: 5, HighPriorityOnly: 1, AllPriorities: 4}
+	if got := es.WorkerAssignment(); got != want {
+		t.Errorf("WorkerAssignment after resize = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetWorkerCountScalesParallelism(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	release := make(chan struct{})
+	es := startTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	}), nil)
+	sending := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return inFlight
+	}
+	enqueue := func(ids ...string) {
+		t.Helper()
+		for _, id := range ids {
+			if err := es.EnqueueJob(models.EmailJob{ID: id, To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+				t.Fatalf("EnqueueJob(%s): %v", id, err)
+			}
+		}
+	}
+
+	enqueue("a", "b", "c")
+	waitFor(t, "one send with one worker", func() bool { return sending() == 1 })
+
+	if err := es.SetWorkerCount(3); err != nil {
+		t.Fatalf("SetWorkerCount(3): %v", err)
+	}
+	waitFor(t, "three sends with three workers", func() bool { return sending() == 3 })
+	if got := metricValue(t, es, "email_workers"); got != 3 {
+		t.Errorf("email_workers = %v, want 3", got)
+	}
+
+	if err := es.SetWorkerCount(1); err != nil {
+		t.Fatalf("SetWorkerCount(1): %v", err)
+	}
+	// Stopped workers finish their job before exiting
+	close(release)
+	waitFor(t, "the extra workers to exit", func() bool { return es.workersAlive.Load() == 1 })
+	if got := es.WorkerCount(); got != 1 {
+		t.Errorf("WorkerCount = %d, want 1", got)
+	}
+
+	mu.Lock()
+	peak = 0
+	mu.Unlock()
+	enqueue("d", "e", "f")
+	waitFor(t, "the remaining jobs sent", func() bool {
+		record, ok := es.JobStatus("f")
+		return ok && record.Status == StatusSent
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 1 {
+		t.Errorf("peak parallel sends after scaling down = %d, want 1", peak)
+	}
+
+	if err := es.SetWorkerCount(0); !errors.Is(err, ErrInvalidWorkerCount) {
+		t.Errorf("SetWorkerCount(0) = %v, want ErrInvalidWorkerCount", err)
+	}
+}
+
+func TestShutdownReturnsWithinTimeoutDespiteStuckSender(t *testing.T) {
+	logs := captureLogs(t)
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	es := newTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		// Ignores cancellation, like a send stuck in a hung connection
+		close(sending)
+		<-release
+		return nil
+	}), map[string]string{"SHUTDOWN_TIMEOUT": "200ms"})
+	es.Start()
+
+	if err := es.EnqueueJob(models.EmailJob{ID: "stuck", To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	<-sending
+
+	start := time.Now()
+	es.Shutdown()
+	if took := time.Since(start); took < 200*time.Millisecond || took > 2*time.Second {
+		t.Errorf("Shutdown took %v, want about the 200ms timeout", took)
+	}
+
+	entry := logEvent(t, logs, "worker_stuck")
+	if entry["job_id"] != "stuck" || entry["worker_id"] != float64(1) {
+		t.Errorf("stuck worker logged as %v, want worker 1 on job stuck", entry)
+	}
+}
+
+func TestMaxInFlightBoundsConcurrentSends(t *testing.T) {
+	var running, peak, sent atomic.Int32
+	es := startTestService(t, senderFunc(func(context.Context, models.EmailJob) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		sent.Add(1)
+		return nil
+	}), map[string]string{"WORKERS": "8", "MAX_INFLIGHT": "2"})
+
+	const jobs = 40
+	for i := 0; i < jobs; i++ {
+		if err := es.EnqueueJob(models.EmailJob{ID: fmt.Sprintf("job-%d", i), To: "user@example.com", Subject: "Hi", Body: "Hi"}); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+	}
+
+	gaugePeak := 0.0
+	waitFor(t, "every job sent", func() bool {
+		gaugePeak = max(gaugePeak, metricValue(t, es, "email_sends_in_flight"))
+		return sent.Load() == jobs
+	})
+
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak concurrent sends = %d, want the bound of 2 reached and never exceeded", got)
+	}
+	if gaugePeak > 2 {
+		t.Errorf("email_sends_in_flight peaked at %v, want at most 2", gaugePeak)
+	}
+	waitFor(t, "in-flight gauge back to zero", func() bool {
+		return metricValue(t, es, "email_sends_in_flight") == 0
+	})
+}
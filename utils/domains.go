@@ -0,0 +1,58 @@
+package utils
+
+import "strings"
+
+// DomainFilter decides which recipient domains may be emailed. A pattern is
+// either a domain, which matches only that domain, or "*." followed by a
+// domain, which matches any of its subdomains but not the domain itself.
+type DomainFilter struct {
+	allow []string
+	block []string
+}
+
+// NewDomainFilter creates a filter that rejects domains matching a block
+// pattern and, when allow isn't empty, domains matching no allow pattern.
+// Blocking wins when a domain matches both.
+func NewDomainFilter(allow, block []string) *DomainFilter {
+	return &DomainFilter{
+		allow: normalizeDomainPatterns(allow),
+		block: normalizeDomainPatterns(block),
+	}
+}
+
+// Allowed reports whether email's domain may be emailed
+func (f *DomainFilter) Allowed(email string) bool {
+	domain := strings.TrimSuffix(emailDomain(email), ".")
+	if domain == "" {
+		return false
+	}
+	if matchesDomain(f.block, domain) {
+		return false
+	}
+	return len(f.allow) == 0 || matchesDomain(f.allow, domain)
+}
+
+// normalizeDomainPatterns lowercases patterns and drops trailing dots
+func normalizeDomainPatterns(patterns []string) []string {
+	normalized := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), "."); pattern != "" {
+			normalized = append(normalized, pattern)
+		}
+	}
+	return normalized
+}
+
+// matchesDomain reports whether domain matches any of patterns
+func matchesDomain(patterns []string, domain string) bool {
+	for _, pattern := range patterns {
+		if parent, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(domain, "."+parent) {
+				return true
+			}
+		} else if domain == pattern {
+			return true
+		}
+	}
+	return false
+}
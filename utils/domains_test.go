@@ -0,0 +1,35 @@
+package utils
+
+import "testing"
+
+func TestDomainFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		block []string
+		email string
+		want  bool
+	}{
+		{"no lists", nil, nil, "user@example.com", true},
+		{"blocked domain", nil, []string{"bad.example"}, "user@bad.example", false},
+		{"blocked case-insensitively", nil, []string{"Bad.Example."}, "user@BAD.example", false},
+		{"other domain not blocked", nil, []string{"bad.example"}, "user@good.example", true},
+		{"wildcard blocks subdomains", nil, []string{"*.bad.example"}, "user@mail.bad.example", false},
+		{"wildcard doesn't block the domain itself", nil, []string{"*.bad.example"}, "user@bad.example", true},
+		{"allowed domain", []string{"example.com"}, nil, "user@example.com", true},
+		{"unlisted domain in allow-only mode", []string{"example.com"}, nil, "user@gmail.com", false},
+		{"lookalike suffix not allowed", []string{"*.example.com"}, nil, "user@evilexample.com", false},
+		{"wildcard allows subdomains", []string{"*.example.com"}, nil, "user@staging.example.com", true},
+		{"blocked wins over allowed", []string{"*.example.com"}, []string{"spam.example.com"}, "user@spam.example.com", false},
+		{"allowed and not blocked", []string{"*.example.com"}, []string{"spam.example.com"}, "user@qa.example.com", true},
+		{"unlisted with both set", []string{"example.com"}, []string{"bad.example"}, "user@other.example", false},
+		{"no domain", nil, nil, "user", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewDomainFilter(tt.allow, tt.block).Allowed(tt.email); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.email, got, tt.want)
+			}
+		})
+	}
+}
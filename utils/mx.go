@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxMXCacheEntries caps how many domains the MX cache remembers
+const maxMXCacheEntries = 10000
+
+// MXResolver looks up a domain's mail exchangers; *net.Resolver satisfies it
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// InFlightGauge counts operations in progress; prometheus.Gauge satisfies it
+type InFlightGauge interface {
+	Inc()
+	Dec()
+}
+
+// MXChecker reports whether an address's domain has a mail exchanger,
+// caching the answers
+type MXChecker struct {
+	resolver MXResolver
+	timeout  time.Duration
+	ttl      time.Duration
+	slots    chan struct{} // one token per lookup in flight; nil when unlimited
+	inFlight InFlightGauge // nil when lookups aren't counted
+
+	mu    sync.Mutex
+	cache map[string]mxCacheEntry
+}
+
+// mxCacheEntry is a cached lookup result
+type mxCacheEntry struct {
+	hasMX   bool
+	expires time.Time
+}
+
+// NewMXChecker creates a checker that gives each lookup up to timeout and
+// remembers the answer for ttl. At most maxLookups lookups run at once, or
+// any number when it is 0, and inFlight, if not nil, counts them. A nil
+// resolver uses net.DefaultResolver.
+func NewMXChecker(resolver MXResolver, timeout, ttl time.Duration, maxLookups int, inFlight InFlightGauge) *MXChecker {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	c := &MXChecker{
+		resolver: resolver,
+		timeout:  timeout,
+		ttl:      ttl,
+		inFlight: inFlight,
+		cache:    make(map[string]mxCacheEntry),
+	}
+	if maxLookups > 0 {
+		c.slots = make(chan struct{}, maxLookups)
+	}
+	return c
+}
+
+// HasMX reports whether the domain of email accepts mail. A domain without MX
+// records, or with only a null MX (RFC 7505), doesn't. If the lookup itself
+// fails, e.g. times out, the address is given the benefit of the doubt and
+// the answer isn't cached. So is an address whose request gives up while
+// waiting for a lookup slot.
+func (c *MXChecker) HasMX(ctx context.Context, email string) bool {
+	domain := emailDomain(email)
+	if domain == "" {
+		return false
+	}
+
+	if hasMX, ok := c.cached(domain, time.Now()); ok {
+		return hasMX
+	}
+
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+			defer func() { <-c.slots }()
+		case <-ctx.Done():
+			return true
+		}
+		// Another request may have looked the domain up while this one waited
+		if hasMX, ok := c.cached(domain, time.Now()); ok {
+			return hasMX
+		}
+	}
+	if c.inFlight != nil {
+		c.inFlight.Inc()
+		defer c.inFlight.Dec()
+	}
+
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	records, err := c.resolver.LookupMX(ctx, domain)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return true
+	}
+
+	hasMX := false
+	for _, record := range records {
+		if record.Host != "." && record.Host != "" {
+			hasMX = true
+			break
+		}
+	}
+
+	c.store(domain, mxCacheEntry{hasMX: hasMX, expires: now.Add(c.ttl)}, now)
+	return hasMX
+}
+
+// cached returns the unexpired cached answer for domain, if any
+func (c *MXChecker) cached(domain string, now time.Time) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[domain]
+	if !ok || !now.Before(entry.expires) {
+		return false, false
+	}
+	return entry.hasMX, true
+}
+
+// store caches entry for domain. When the cache is full, expired entries are
+// dropped first, and everything if that isn't enough.
+func (c *MXChecker) store(domain string, entry mxCacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxMXCacheEntries {
+		for cached, old := range c.cache {
+			if !now.Before(old.expires) {
+				delete(c.cache, cached)
+			}
+		}
+		if len(c.cache) >= maxMXCacheEntries {
+			clear(c.cache)
+		}
+	}
+	c.cache[domain] = entry
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowResolver answers every lookup with an MX record after a delay,
+// recording how many lookups overlap
+type slowResolver struct {
+	delay   time.Duration
+	calls   atomic.Int32
+	current atomic.Int32
+	peak    atomic.Int32
+}
+
+func (r *slowResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.calls.Add(1)
+	n := r.current.Add(1)
+	defer r.current.Add(-1)
+	for {
+		peak := r.peak.Load()
+		if n <= peak || r.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	select {
+	case <-time.After(r.delay):
+		return []*net.MX{{Host: "mx." + name, Pref: 10}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// countingGauge is an InFlightGauge recording its highest value
+type countingGauge struct {
+	mu        sync.Mutex
+	value     int
+	highWater int
+}
+
+func (g *countingGauge) Inc() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value++
+	g.highWater = max(g.highWater, g.value)
+}
+
+func (g *countingGauge) Dec() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value--
+}
+
+// checkConcurrently looks up each address from its own goroutine
+func checkConcurrently(c *MXChecker, addrs []string) {
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.HasMX(context.Background(), addr)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMXCheckerCapsConcurrentLookups(t *testing.T) {
+	resolver := &slowResolver{delay: 20 * time.Millisecond}
+	gauge := &countingGauge{}
+	c := NewMXChecker(resolver, time.Second, time.Hour, 3, gauge)
+
+	addrs := make([]string, 20)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("user@domain%d.example", i)
+	}
+	checkConcurrently(c, addrs)
+
+	if got := resolver.calls.Load(); got != 20 {
+		t.Errorf("lookups = %d, want 20", got)
+	}
+	if got := resolver.peak.Load(); got != 3 {
+		t.Errorf("peak concurrent lookups = %d, want 3", got)
+	}
+	if gauge.highWater != 3 || gauge.value != 0 {
+		t.Errorf("gauge peaked at %d and ended at %d, want 3 and 0", gauge.highWater, gauge.value)
+	}
+}
+
+func TestMXCheckerWaitersReuseCachedAnswer(t *testing.T) {
+	resolver := &slowResolver{delay: 20 * time.Millisecond}
+	c := NewMXChecker(resolver, time.Second, time.Hour, 1, nil)
+
+	addrs := make([]string, 10)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("user%d@example.com", i)
+	}
+	checkConcurrently(c, addrs)
+
+	if got := resolver.calls.Load(); got != 1 {
+		t.Errorf("lookups = %d, want 1 for a single domain", got)
+	}
+}
+
+func TestMXCheckerGivesUpWaitingForSlot(t *testing.T) {
+	resolver := &slowResolver{delay: time.Second}
+	c := NewMXChecker(resolver, 2*time.Second, time.Hour, 1, nil)
+
+	go c.HasMX(context.Background(), "user@busy.example")
+	for resolver.current.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if !c.HasMX(ctx, "user@waiting.example") {
+		t.Error("HasMX = false after giving up on a slot, want the benefit of the doubt")
+	}
+	if got := resolver.calls.Load(); got != 1 {
+		t.Errorf("lookups = %d, want only the one holding the slot", got)
+	}
+}
+
+// mapResolver answers lookups from a fixed table of domains
+type mapResolver struct {
+	records map[string][]*net.MX
+	errs    map[string]error
+	calls   atomic.Int32
+}
+
+func (r *mapResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.calls.Add(1)
+	if err, ok := r.errs[name]; ok {
+		return nil, err
+	}
+	if records, ok := r.records[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestMXCheckerHasMX(t *testing.T) {
+	resolver := &mapResolver{
+		records: map[string][]*net.MX{
+			"example.com":  {{Host: "mx1.example.com.", Pref: 10}},
+			"null.example": {{Host: ".", Pref: 0}},
+		},
+		errs: map[string]error{
+			"slow.example": &net.DNSError{Err: "i/o timeout", Name: "slow.example", IsTimeout: true},
+		},
+	}
+	c := NewMXChecker(resolver, time.Second, time.Hour, 0, nil)
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"user@example.com", true},
+		{"user@EXAMPLE.com", true},
+		{"user@nowhere.example", false},
+		{"user@null.example", false},
+		// A failed lookup doesn't reject the address
+		{"user@slow.example", true},
+		{"no-domain", false},
+	}
+	for _, tt := range tests {
+		if got := c.HasMX(context.Background(), tt.email); got != tt.want {
+			t.Errorf("HasMX(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+
+	// Answers are cached per domain, failed lookups aren't
+	calls := resolver.calls.Load()
+	c.HasMX(context.Background(), "other@example.com")
+	c.HasMX(context.Background(), "other@nowhere.example")
+	if got := resolver.calls.Load(); got != calls {
+		t.Errorf("lookups for cached domains = %d, want none", got-calls)
+	}
+	c.HasMX(context.Background(), "other@slow.example")
+	if got := resolver.calls.Load(); got != calls+1 {
+		t.Errorf("lookups after a failed one = %d, want it looked up again", got-calls)
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// IdentifyingHeaders are headers that fingerprint the software or machine an
+// email was sent from. Privacy-focused deployments strip or reject them.
+var IdentifyingHeaders = []string{
+	"X-Mailer",
+	"User-Agent",
+	"X-Originating-Ip",
+	"X-Sender-Ip",
+	"X-Mimeole",
+	"X-Newsreader",
+}
+
+// IsIdentifyingHeader reports whether name, in any letter case, is one of IdentifyingHeaders
+func IsIdentifyingHeader(name string) bool {
+	canonical := textproto.CanonicalMIMEHeaderKey(name)
+	for _, header := range IdentifyingHeaders {
+		if canonical == header {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	imgTagPattern    = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	tinyDimension    = regexp.MustCompile(`(?i)\b(width|height)\s*=\s*["']?\s*[01](px)?\s*["'\s/>]`)
+	hiddenStyle      = regexp.MustCompile(`(?i)style\s*=\s*["'][^"']*(display\s*:\s*none|visibility\s*:\s*hidden|(width|height)\s*:\s*[01]px)`)
+	trackingSrcHints = []string{"/pixel", "/track", "/open", "beacon", "1x1"}
+)
+
+// ContainsTrackingPixel reports whether an HTML body references an image that looks like a tracking pixel
+func ContainsTrackingPixel(body string) bool {
+	for _, tag := range imgTagPattern.FindAllString(body, -1) {
+		if tinyDimension.MatchString(tag) || hiddenStyle.MatchString(tag) {
+			return true
+		}
+
+		lower := strings.ToLower(tag)
+		for _, hint := range trackingSrcHints {
+			if strings.Contains(lower, hint) {
+				return true
+			}
+		}
+	}
+	return false
+}
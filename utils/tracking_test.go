@@ -0,0 +1,42 @@
+package utils
+
+import "testing"
+
+func TestContainsTrackingPixel(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"plain text", "Hello there", false},
+		{"ordinary image", `<img src="https://example.com/logo.png" width="120" height="40">`, false},
+		{"one by one", `<img src="https://example.com/a.gif" width="1" height="1">`, true},
+		{"zero width in px", `<IMG SRC="x.gif" WIDTH='0px' />`, true},
+		{"hidden by style", `<img src="x.gif" style="display: none">`, true},
+		{"sized by style", `<img src="x.gif" style="width:1px;height:1px">`, true},
+		{"tracking path", `<img src="https://mail.example.com/track?id=42">`, true},
+		{"open path", `<img src="https://example.com/open/42.gif">`, true},
+		{"width ten is fine", `<img src="x.gif" width="10">`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContainsTrackingPixel(tt.body); got != tt.want {
+				t.Errorf("ContainsTrackingPixel(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsIdentifyingHeader(t *testing.T) {
+	for _, name := range []string{"X-Mailer", "x-mailer", "USER-AGENT", "X-Originating-IP", "x-sender-ip", "X-MimeOLE", "X-Newsreader"} {
+		if !IsIdentifyingHeader(name) {
+			t.Errorf("IsIdentifyingHeader(%q) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"List-Unsubscribe", "X-Campaign-ID", "X-Mailer-Extra", "Mailer"} {
+		if IsIdentifyingHeader(name) {
+			t.Errorf("IsIdentifyingHeader(%q) = true, want false", name)
+		}
+	}
+}
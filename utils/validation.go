@@ -1,11 +1,67 @@
 package utils
 
-import "regexp"
+import (
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
 
-// ValidateEmail performs basic email validation
+const (
+	// maxAddressLength is the longest address that fits in an SMTP path (RFC 5321)
+	maxAddressLength = 254
+	// maxDomainLength is the longest domain name
+	maxDomainLength = 253
+	// maxLabelLength is the longest label in a domain name
+	maxLabelLength = 63
+)
+
+// ValidateEmail reports whether email is a bare RFC 5322 address with a
+// deliverable domain. Quoted local parts are accepted; display names, angle
+// brackets and IP literal domains are not.
 func ValidateEmail(email string) bool {
-	// Simple regex for basic email validation
-	pattern := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
-	matched, _ := regexp.MatchString(pattern, email)
-	return matched
+	if email == "" || len(email) > maxAddressLength || strings.TrimSpace(email) != email || !utf8.ValidString(email) {
+		return false
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || strings.HasSuffix(email, ">") {
+		return false
+	}
+
+	return validDomain(emailDomain(addr.Address))
+}
+
+// emailDomain returns the lowercased domain of an address, or "" if there is none
+func emailDomain(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+// validDomain reports whether domain is a dotted host name such as example.com.
+// An all-numeric top-level label means an IP address, not a host name.
+func validDomain(domain string) bool {
+	if len(domain) > maxDomainLength || !strings.Contains(domain, ".") {
+		return false
+	}
+
+	labels := strings.Split(domain, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			// Non-ASCII letters are allowed for internationalized domains
+			if r != '-' && !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r < utf8.RuneSelf {
+				return false
+			}
+		}
+	}
+	return true
 }
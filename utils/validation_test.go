@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"user@example.com", true},
+		{"first.last+tag@mail.example.co.uk", true},
+		{`"john doe"@example.com`, true},
+		{"user@bücher.example", true},
+		{"o'brien@example.com", true},
+		{"", false},
+		{"user", false},
+		{"user@", false},
+		{"@example.com", false},
+		{"user@localhost", false},
+		{"user@example", false},
+		{"user@192.168.0.1", false},
+		{"user@[192.168.0.1]", false},
+		{"user@example..com", false},
+		{"user@-example.com", false},
+		{"user..name@example.com", false},
+		{"John <john@example.com>", false},
+		{"<john@example.com>", false},
+		{" user@example.com", false},
+		{"user@example.com\n", false},
+		{"user@exa_mple.com", false},
+		{strings.Repeat("a", 250) + "@example.com", false},
+		{"user@" + strings.Repeat("a", 64) + ".com", false},
+	}
+	for _, tt := range tests {
+		if got := ValidateEmail(tt.email); got != tt.want {
+			t.Errorf("ValidateEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}